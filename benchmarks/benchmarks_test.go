@@ -0,0 +1,174 @@
+package benchmarks
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rhettg/oxweb/oxweb"
+)
+
+func TestMain(m *testing.M) {
+	// Streams log every connection; keep benchmark output readable.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// corpus decodes a corpus as DataStream does, so values have the types
+// queries see.
+func corpus(b *testing.B, n int) []oxweb.JSONData {
+	events := make([]oxweb.JSONData, n)
+	for ndx, line := range CorpusLines(n, 1) {
+		if err := json.Unmarshal(line, &events[ndx]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return events
+}
+
+var statements = map[string]string{
+	"path":    `timing.total`,
+	"window":  `WindowAve(RollingWindow(latency, 1000))`,
+	"groupby": `GroupBy(GetDeep("host"), WindowPercentile(TimedWindow(latency, 60), 99))`,
+	"nested": `
+		GroupBy(
+			UrlPath(path),          # one group per endpoint
+			As(WindowAve(
+				TimedWindow(Convert(timing.db, "ms", "s"), 300)
+			), "db_seconds")
+		)`,
+}
+
+func BenchmarkParse(b *testing.B) {
+	for name, statement := range statements {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := oxweb.Parse(statement); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetDeep(b *testing.B) {
+	events := corpus(b, 1000)
+	for _, path := range []string{"host", "timing.total", "user.country", "calls.0.service"} {
+		b.Run(path, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				oxweb.GetDeep(path, events[i%len(events)])
+			}
+		})
+	}
+}
+
+// BenchmarkWindowPushEvict measures windows already holding thousands of
+// events, which the rolling window evicts one per push and the timed window
+// once they're a second old.
+func BenchmarkWindowPushEvict(b *testing.B) {
+	events := corpus(b, 10000)
+	for name, statement := range map[string]string{
+		"rolling": `WindowAve(RollingWindow(latency, 1000))`,
+		"timed":   `WindowAve(TimedWindow(latency, 1))`,
+	} {
+		b.Run(name, func(b *testing.B) {
+			window, err := oxweb.Parse(statement)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, event := range events {
+				window.Evaluate(event)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := window.Evaluate(events[i%len(events)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkQueryEvaluate(b *testing.B) {
+	events := corpus(b, 10000)
+	for name, statement := range statements {
+		b.Run(name, func(b *testing.B) {
+			field, err := oxweb.Parse(statement)
+			if err != nil {
+				b.Fatal(err)
+			}
+			query := oxweb.NewQuery([]oxweb.Expression{field}, nil)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := query.Evaluate(events[i%len(events)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			if errors := query.Stats()["errors"]; errors > 0 {
+				b.Fatalf("%d evaluation errors", errors)
+			}
+		})
+	}
+}
+
+// BenchmarkStreamFanOut measures a DataStream reading b.N events from a relay
+// and delivering each to 50 subscribers, from the socket to their channels.
+func BenchmarkStreamFanOut(b *testing.B) {
+	benchmarkFanOut(b, false)
+}
+
+// BenchmarkStreamFanOutBatched is BenchmarkStreamFanOut with subscribers
+// taking events in batches.
+func BenchmarkStreamFanOutBatched(b *testing.B) {
+	benchmarkFanOut(b, true)
+}
+
+func benchmarkFanOut(b *testing.B, batched bool) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer listener.Close()
+	relay := &Relay{Lines: CorpusLines(10000, 1), Count: b.N}
+
+	stream := oxweb.NewDataStream("web", listener.Addr().String())
+	done := make(chan struct{})
+	defer close(done)
+	for subscriber := 0; subscriber < 50; subscriber++ {
+		request := &oxweb.SubscribeRequest{DataChan: make(chan oxweb.JSONData, 1024)}
+		if batched {
+			request.BatchChan = make(chan []oxweb.JSONData, 1024/oxweb.DefaultBatchSize)
+		}
+		go func() {
+			for {
+				select {
+				case <-request.DataChan:
+				case <-request.BatchChan:
+				case <-done:
+					return
+				}
+			}
+		}()
+		stream.Subscribe(request)
+	}
+
+	// The stream connects on the first subscription, but the relay only
+	// starts sending once everyone's subscribed.
+	b.ReportAllocs()
+	b.ResetTimer()
+	go relay.Serve(listener)
+	for stream.Stats()["events"] < int64(b.N) {
+		time.Sleep(time.Millisecond)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(stream.Stats()["dropped"]), "dropped")
+}
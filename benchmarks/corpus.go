@@ -0,0 +1,122 @@
+// Package benchmarks has realistic event corpora and a fake relay serving
+// them, shared by the benchmarks in this directory and the oxload command,
+// so the hot path from stream to query can be measured and profiled:
+//
+//	go test -bench . -cpuprofile cpu.out ./benchmarks
+package benchmarks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+var (
+	hosts     = []string{"web1", "web2", "web3", "web4", "api1", "api2"}
+	paths     = []string{"/", "/search", "/biz/details", "/user/profile", "/api/v1/reviews", "/api/v1/photos"}
+	countries = []string{"US", "US", "US", "CA", "GB", "DE", "JP"}
+)
+
+// Corpus returns n web request events like those oxweb typically queries,
+// the same for the same seed: a status code, a latency with a long tail,
+// nested timing and user objects and an array of backend calls.
+func Corpus(n int, seed int64) []map[string]interface{} {
+	random := rand.New(rand.NewSource(seed))
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	events := make([]map[string]interface{}, n)
+	for ndx := range events {
+		status := 200.
+		switch roll := random.Float64(); {
+		case roll < 0.01:
+			status = 500
+		case roll < 0.05:
+			status = 404
+		case roll < 0.08:
+			status = 302
+		}
+		latency := math.Round(math.Exp(random.NormFloat64()*0.8+4)*100) / 100
+		calls := []interface{}{}
+		for call := random.Intn(4); call > 0; call-- {
+			calls = append(calls, map[string]interface{}{
+				"service": []string{"mysql", "memcache", "search"}[random.Intn(3)],
+				"ms":      math.Round(random.Float64()*latency*100) / 100,
+			})
+		}
+		events[ndx] = map[string]interface{}{
+			"unique_request_id": fmt.Sprintf("%016x", random.Int63()),
+			"time":              float64(start.Add(time.Duration(ndx)*10*time.Millisecond).UnixNano()) / 1e9,
+			"host":              hosts[random.Intn(len(hosts))],
+			"path":              paths[random.Intn(len(paths))],
+			"status":            status,
+			"latency":           latency,
+			"bytes":             float64(200 + random.Intn(64<<10)),
+			"timing":            map[string]interface{}{"total": latency, "db": math.Round(latency*random.Float64()*100) / 100},
+			"user":              map[string]interface{}{"id": float64(random.Intn(100000)), "country": countries[random.Intn(len(countries))]},
+			"calls":             calls,
+		}
+	}
+	return events
+}
+
+// CorpusLines returns Corpus encoded as lines of JSON, as a relay sends them.
+func CorpusLines(n int, seed int64) (lines [][]byte) {
+	for _, event := range Corpus(n, seed) {
+		line, _ := json.Marshal(event)
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// A Relay serves lines to oxweb streams with the version 0 protocol: each
+// connection sends the name of a stream on a line of its own, then receives
+// Lines in order, repeating them from the start as needed.
+type Relay struct {
+	Lines [][]byte
+	// Rate is how many lines per second to send each connection, or 0 for
+	// as fast as it'll take them.
+	Rate float64
+	// Count, if positive, is how many lines to send before hanging up.
+	Count int
+}
+
+// Serve accepts connections from listener until it's closed.
+func (r *Relay) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go r.serveConn(conn)
+	}
+}
+
+func (r *Relay) serveConn(conn net.Conn) {
+	defer conn.Close()
+	if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+		return
+	}
+
+	writer := bufio.NewWriterSize(conn, 64<<10)
+	defer writer.Flush()
+	start := time.Now()
+	for sent := 0; r.Count <= 0 || sent < r.Count; sent++ {
+		if r.Rate > 0 {
+			// Keep to the rate on average, sleeping whenever we're ahead.
+			due := start.Add(time.Duration(float64(sent) / r.Rate * float64(time.Second)))
+			if ahead := time.Until(due); ahead > 0 {
+				if err := writer.Flush(); err != nil {
+					return
+				}
+				time.Sleep(ahead)
+			}
+		}
+		writer.Write(r.Lines[sent%len(r.Lines)])
+		if err := writer.WriteByte('\n'); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// oxload is a fake relay serving a realistic stream of web request events,
+// to load test oxweb without production traffic:
+//
+//	oxload -rate 5000 &
+//	oxweb -relay localhost:3535
+//
+// Every stream name gets the same events, from a generated corpus or an
+// NDJSON capture given with -corpus.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"log"
+	"net"
+	"os"
+
+	"github.com/rhettg/oxweb/benchmarks"
+)
+
+var listen = flag.String("listen", ":3535", "Address to serve the relay protocol on")
+var rate = flag.Float64("rate", 1000, "Events per second sent to each connection, or 0 for as fast as possible")
+var events = flag.Int("events", 100000, "Events to generate, repeated as needed")
+var seed = flag.Int64("seed", 1, "Seed for the generated events")
+var corpusPath = flag.String("corpus", "", "NDJSON file of events to replay rather than generating them")
+
+func readCorpus(path string) (lines [][]byte, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, append([]byte{}, line...))
+		}
+	}
+	return lines, scanner.Err()
+}
+
+func main() {
+	flag.Parse()
+
+	relay := &benchmarks.Relay{Rate: *rate}
+	if *corpusPath != "" {
+		lines, err := readCorpus(*corpusPath)
+		if err != nil {
+			log.Fatal("Failed to read corpus: ", err)
+		}
+		if len(lines) == 0 {
+			log.Fatal("No events in ", *corpusPath)
+		}
+		relay.Lines = lines
+	} else {
+		relay.Lines = benchmarks.CorpusLines(*events, *seed)
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Serving %d events at %v per second on %s", len(relay.Lines), *rate, listener.Addr())
+	log.Fatal(relay.Serve(listener))
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"code.google.com/p/go.net/websocket"
     "github.com/rhettg/oxweb/oxweb"
 )
@@ -25,6 +31,15 @@ type LineReader interface {
 var (
 	streamHost    string
 	scribeStreams map[string]*oxweb.DataStream
+	mergedStreams = make(map[string]*oxweb.MergedStream)
+	resultStore   *oxweb.RingStore
+	queryHub      = oxweb.NewQueryHub()
+	schemas       = map[string]*oxweb.Schema{}
+	eventSchema   *oxweb.JSONSchema
+	deadLetters   = make(chan *oxweb.DeadLetter, 1024)
+	engine        = oxweb.NewEngine()
+	passwords     oxweb.PasswordChecker
+	redactKey     []byte
 )
 
 func init() {
@@ -43,30 +58,16 @@ func CreateTestDataStream(fileName string) io.Reader {
 
 func ServeWS(socket *websocket.Conn) {
 	jsonStream := oxweb.NewJSONConn(socket)
+	jsonStream.MaxConsecutiveErrors = *maxFrameErrors
 
-	/*
-			defer func() {
-		        if err := recover(); err != nil {
-		            log.Println("ServeWS failed:", err)
-		        }
-		    }()
-	*/
-
-	ServeStream(jsonStream)
-}
-
-func serveTCP(conn net.Conn) {
-	defer conn.Close()
-
-	defer func() {
-		if err := recover(); err != nil {
-			log.Println("serveTCP failed:", err)
-		}
-	}()
-
-	jsonStream := oxweb.NewJSONConn(conn)
-
-	ServeStream(jsonStream)
+	// Get our query from the client
+	query, err := jsonStream.ReadJSON()
+	if err != nil {
+		log.Printf("Failed to read from client", err)
+		return
+	}
+	principal := oxweb.RequestPrincipal(socket.Request(), passwords)
+	serveStream(oxweb.WithPrincipal(context.Background(), principal), query, jsonStream)
 }
 
 // type ScribeQuery struct {
@@ -75,73 +76,215 @@ func serveTCP(conn net.Conn) {
 //  logName string
 // }
 
-func ServeStream(stream *oxweb.JSONConn) {
-	// Get our query from the client
-	query, err := stream.ReadJSON()
-	if err != nil {
-		log.Printf("Failed to read from client", err)
+// ServeStream streams the results of a query from a TCP client, which
+// doesn't authenticate.
+func ServeStream(query oxweb.JSONData, stream *oxweb.JSONConn) {
+	serveStream(context.Background(), query, stream)
+}
+
+// serveStream streams the results of query on behalf of the principal in
+// ctx, recording its start and end in the audit log.
+func serveStream(ctx context.Context, query oxweb.JSONData, stream *oxweb.JSONConn) {
+	var err error
+
+	// Find the stream
+	logName, _ := query.(map[string]interface{})["logName"].(string)
+	if logName == "" {
+		stream.WriteError(fmt.Errorf("%w: Expected a query object with a logName", oxweb.ErrParse))
 		return
 	}
+	labels := map[string]string{}
+	if labelValues, ok := query.(map[string]interface{})["labels"].(map[string]interface{}); ok {
+		for key, value := range labelValues {
+			labels[key] = fmt.Sprint(value)
+		}
+	}
+	log.Printf("Subscribing to log %v [%v]", logName, oxweb.FormatLabels(labels))
 
-	// Find the stream
-	logName := query.(map[string]interface{})["logName"].(string)
-	log.Printf("Subscribing to log", logName)
+	var scribeStream oxweb.Source
+	if strings.Contains(logName, ",") {
+		scribeStream = MergedStreamByNames(logName)
+	} else {
+		scribeStream = StreamByName(logName)
+	}
 
-	scribeStream := StreamByName(logName)
+	// Clients asking for exactly the same results share one running query.
+	shareable := true
 
-	// Create a new channel to receive data on
-	dataChan := make(chan oxweb.JSONData, 16)
-	request := new(oxweb.SubscribeRequest)
-	request.DataChan = dataChan
-	scribeStream.SubscribeChan <- request
+	stages := []oxweb.Stage{}
+	if explodePath, ok := query.(map[string]interface{})["explode"].(string); ok {
+		explode, err := oxweb.NewExplode(explodePath)
+		if err != nil {
+			log.Printf("Couldn't explode %v: %v", explodePath, err)
+			return
+		}
+		stages = append(stages, explode)
+		shareable = false
+	}
 
-	defer func() { scribeStream.UnsubscribeChan <- request }()
+	// Statements are parsed with the query's nil handling.
+	propagateNulls, _ := query.(map[string]interface{})["propagateNulls"].(bool)
+	parseOptions := oxweb.ParseOptions{PropagateNulls: propagateNulls}
 
 	displayFields := []oxweb.Expression{}
+	fieldStatements := []string{}
 	for _, fieldValue := range query.(map[string]interface{})["fields"].([]interface{}) {
-		aggregator, err := oxweb.Parse(fieldValue.(string))
+		aggregator, err := oxweb.ParseWithOptions(fieldValue.(string), parseOptions)
 		if err != nil {
 			log.Printf("Couldn't parse expression %v: %v", fieldValue, err)
 		} else {
+			warnings, _ := oxweb.Lint(fieldValue.(string))
+			for _, warning := range warnings {
+				log.Printf("Warning for %v: %v", fieldValue, warning)
+			}
 			displayFields = append(displayFields, aggregator)
+			fieldStatements = append(fieldStatements, fieldValue.(string))
 			log.Printf("Parsed to aggregator: %v", aggregator.String())
 		}
 	}
 
 	filterPredicates := []oxweb.Expression{}
+	filterStatements := []string{}
 	for _, statement := range query.(map[string]interface{})["filters"].([]interface{}) {
 		log.Printf("Statement: ", statement)
-		expr, err := oxweb.Parse(statement.(string))
+		expr, err := oxweb.ParseWithOptions(statement.(string), parseOptions)
 		if err != nil {
 			log.Printf("Couldn't parse statement \"%s\": %v", statement, err)
 		} else {
 			filterPredicates = append(filterPredicates, expr)
+			filterStatements = append(filterStatements, statement.(string))
 		}
 	}
+	if pushdown, _ := query.(map[string]interface{})["pushdown"].(bool); pushdown && len(filterStatements) > 0 {
+		scribeStream = FilteredStream(logName, filterStatements)
+	}
 
-	for {
-		data := <-dataChan
+	if schema := schemas[logName]; schema != nil && schema.Strict {
+		if err := schema.Check(oxweb.QuerySpec{Fields: fieldStatements, Filters: filterStatements}); err != nil {
+			log.Printf("Bad query: %v", err)
+			return
+		}
+	}
 
-		if passes, err := oxweb.PassesAllFilters(data, filterPredicates); !passes {
-			if err != nil {
-				log.Printf("Got error evaluating predicates: %v", err)
-				// We have to quit here because if we have an error where our filters always fail like this
-				// we would be stuck in a endless loop and never close the connection out.
-				break
+	oxQuery := oxweb.NewQuery(displayFields, filterPredicates)
+	oxQuery.Labels = labels
+	policyName, _ := query.(map[string]interface{})["onError"].(string)
+	if policyName != "" {
+		oxQuery.ErrorPolicy, err = oxweb.ParseErrorPolicy(policyName)
+		if err != nil {
+			log.Printf("Bad query: %v", err)
+			return
+		}
+	}
+	oxQuery.PropagateNulls = propagateNulls
+	if threshold, ok := query.(map[string]interface{})["emitOnChange"].(map[string]interface{}); ok {
+		oxQuery.EmitOnChange = new(oxweb.ChangeThreshold)
+		oxQuery.EmitOnChange.Absolute, _ = threshold["absolute"].(float64)
+		oxQuery.EmitOnChange.Relative, _ = threshold["relative"].(float64)
+		shareable = false
+	}
+
+	var numberFormat *oxweb.NumberFormat
+	if formatValue, ok := query.(map[string]interface{})["numberFormat"]; ok {
+		numberFormat = new(oxweb.NumberFormat)
+		encoded, _ := json.Marshal(formatValue)
+		if err = json.Unmarshal(encoded, numberFormat); err == nil {
+			err = numberFormat.Validate()
+		}
+		if err != nil {
+			log.Printf("Bad number format: %v", err)
+			return
+		}
+	}
+
+	// Warm up windows from history before going live.
+	var history *os.File
+	if backfillName, ok := query.(map[string]interface{})["backfill"].(string); ok && *backfillDir != "" {
+		history, err = os.Open(filepath.Join(*backfillDir, filepath.Base(backfillName)))
+		if err != nil {
+			log.Printf("Couldn't open backfill %v: %v", backfillName, err)
+			return
+		}
+		defer history.Close()
+		log.Printf("Backfilling from %v", backfillName)
+		shareable = false
+	}
+
+	queryID := ""
+	if shareable {
+		spec := oxweb.QuerySpec{Source: logName, Fields: fieldStatements, Filters: filterStatements, OnError: policyName, PropagateNulls: oxQuery.PropagateNulls}
+		if queryID, err = spec.ID(); err != nil {
+			log.Printf("Bad query: %v", err)
+			return
+		}
+	}
+
+	// Additional destinations for the query's records.
+	sinks := []oxweb.Sink{}
+	if name, ok := query.(map[string]interface{})["name"].(string); ok {
+		sinks = append(sinks, resultStore.Sink(name))
+	}
+	if webhookURL, ok := query.(map[string]interface{})["webhook"].(string); ok {
+		sinks = append(sinks, withSinkWAL(oxweb.NewWebhookSink(webhookURL), webhookURL, fieldStatements, filterStatements))
+	}
+	if tcpAddr, ok := query.(map[string]interface{})["tcpSink"].(string); ok {
+		sinks = append(sinks, withSinkWAL(oxweb.NewTCPSink(tcpAddr), tcpAddr, fieldStatements, filterStatements))
+	}
+	for ndx, sink := range sinks {
+		sinks[ndx] = oxweb.WithNumberFormat(oxweb.WithLabels(sink, labels), numberFormat)
+	}
+	defer func() {
+		for _, sink := range sinks {
+			if err := sink.Close(); err != nil {
+				log.Printf("Failed to close sink: %v", err)
 			}
 		}
-		outputPairs := make([]interface{}, 0)
+	}()
 
-		for _, fieldValue := range displayFields {
-			result, err := fieldValue.Evaluate(data)
-			if err != nil {
-				log.Printf("Got error '%v' evaluating field '%v'", err, fieldValue)
+	newQuery := func() (*oxweb.Query, error) {
+		oxQuery.Errors = make(chan *oxweb.EvaluationError, 16)
+		go func() {
+			for evalErr := range oxQuery.Errors {
+				log.Printf("%v, for event %v", evalErr, evalErr.Event)
+			}
+		}()
+		return oxQuery, nil
+	}
+	var records <-chan []interface{}
+	var leave func()
+	if history != nil {
+		records, leave, err = queryHub.JoinBackfilled(scribeStream, stages, history, newQuery)
+	} else {
+		records, leave, err = queryHub.Join(queryID, scribeStream, stages, newQuery)
+	}
+	if err != nil {
+		log.Printf("Couldn't start query: %v", err)
+		return
+	}
+	defer leave()
+
+	spec := querySpec(query.(map[string]interface{}))
+	if spec.Name == "" {
+		spec.Name = logName
+	}
+	engine.Audit(ctx, oxweb.AuditCreate, spec.Name, &spec)
+	defer engine.Audit(ctx, oxweb.AuditDelete, spec.Name, nil)
+
+	for outputPairs := range records {
+		for _, sink := range sinks {
+			if err := sink.Write(outputPairs); err != nil {
+				log.Printf("Failed to deliver to sink: %v", err)
 			}
-			name := fieldValue.String()
-			outputPairs = append(outputPairs, []interface{}{name, result})
 		}
 
-		err := stream.WriteJSON(outputPairs)
+		var output oxweb.JSONData = outputPairs
+		if numberFormat != nil {
+			if output, err = numberFormat.Apply(outputPairs); err != nil {
+				log.Printf("Failed to format numbers: %v", err)
+				continue
+			}
+		}
+		err = stream.WriteJSON(output)
 		if err != nil {
 			log.Printf("Failed to write", err)
 			break
@@ -191,29 +334,128 @@ func ServeDataItemPage(writer http.ResponseWriter, request *http.Request) {
 	writer.Write(outputBytes)
 }
 
-func listenTCPClients() {
+// ServeDefinePage registers a user-defined function from the form values
+// name, params (comma separated) and body. See oxweb.Define. With -auth-file,
+// only authenticated users may define functions.
+func ServeDefinePage(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	principal := oxweb.RequestPrincipal(request, passwords)
+	if passwords != nil && principal == "" {
+		writer.Header().Set("WWW-Authenticate", `Basic realm="oxweb"`)
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
 
-	ipAddr, err := net.ResolveIPAddr("tcp4", "127.0.0.1")
+	name := request.FormValue("name")
+	params := []string{}
+	if paramStr := request.FormValue("params"); paramStr != "" {
+		params = strings.Split(paramStr, ",")
+	}
+
+	err := oxweb.Define(name, params, request.FormValue("body"))
 	if err != nil {
-		log.Fatal("Failed to resolve", err)
+		log.Printf("Failed to define %s: %v", name, err)
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte(err.Error() + "\n"))
+		return
 	}
+	log.Printf("Defined function %s(%s)", name, strings.Join(params, ","))
+	engine.Audit(oxweb.WithPrincipal(request.Context(), principal), oxweb.AuditDefine, name, nil)
+}
 
-	addr := net.TCPAddr{ipAddr.IP, 3535}
+// querySpec converts a query in the form clients send it to a QuerySpec.
+func querySpec(query map[string]interface{}) (spec oxweb.QuerySpec) {
+	spec.Name, _ = query["name"].(string)
+	spec.Source, _ = query["logName"].(string)
+	spec.OnError, _ = query["onError"].(string)
+	spec.PropagateNulls, _ = query["propagateNulls"].(bool)
+	fields, _ := query["fields"].([]interface{})
+	for _, field := range fields {
+		spec.Fields = append(spec.Fields, fmt.Sprint(field))
+	}
+	filters, _ := query["filters"].([]interface{})
+	for _, filter := range filters {
+		spec.Filters = append(spec.Filters, fmt.Sprint(filter))
+	}
+	return spec
+}
 
-	listener, err := net.ListenTCP("tcp4", &addr)
+// ServeExplainPage returns the plan for a query POSTed in the same form as
+// clients send it to /ws, without starting it.
+func ServeExplainPage(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		http.Error(writer, "POST a query to explain", http.StatusMethodNotAllowed)
+		return
+	}
+	var query map[string]interface{}
+	if err := json.NewDecoder(request.Body).Decode(&query); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rate := *explainRate
+	if rateValue := request.FormValue("rate"); rateValue != "" {
+		var err error
+		if rate, err = strconv.ParseFloat(rateValue, 64); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	plan, err := oxweb.Explain(querySpec(query), rate)
 	if err != nil {
-		log.Fatal("Failed to listen", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
 	}
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(plan); err != nil {
+		log.Println("Failed to write plan", err)
+	}
+}
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Fatal("Failed to accept", err)
-		}
+// streamStats returns the Stats of every open stream, keyed by stream name.
+func streamStats() map[string]oxweb.Stats {
+	health := make(map[string]oxweb.Stats)
+	for name, stream := range scribeStreams {
+		health[name] = stream.Stats()
+	}
+	return health
+}
+
+// ServeHealthPage reports the Stats of every open stream as JSON.
+func ServeHealthPage(writer http.ResponseWriter, request *http.Request) {
+	health := streamStats()
+
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(health); err != nil {
+		log.Println("Failed to write health", err)
+	}
+}
+
+func listenTCPClients() {
+	server := &oxweb.JSONServer{
+		Addr:                 "127.0.0.1:3535",
+		Handler:              ServeStream,
+		MaxConsecutiveErrors: *maxFrameErrors,
+	}
+	log.Fatal("Failed to serve TCP clients: ", server.ListenAndServe())
+}
 
-		//protoConn := textproto.NewConn(conn)
-		go serveTCP(conn)
+// listenQueryService serves the QueryService of proto/oxweb.proto to other
+// backend services, as line delimited JSON until it has a gRPC transport.
+func listenQueryService(addr string) {
+	service := oxweb.NewQueryService(engine)
+	service.Source = func(name string) (oxweb.Source, error) {
+		return StreamByName(name), nil
 	}
+	server := &oxweb.JSONServer{
+		Addr:                 addr,
+		Handler:              service.ServeJSON,
+		MaxConsecutiveErrors: *maxFrameErrors,
+	}
+	log.Fatal("Failed to serve the query service: ", server.ListenAndServe())
 }
 
 func StreamByName(name string) (stream *oxweb.DataStream) {
@@ -221,23 +463,301 @@ func StreamByName(name string) (stream *oxweb.DataStream) {
 		return stream
 	}
 
-	scribeStreams[name] = oxweb.NewDataStream(name, streamHost)
+	scribeStreams[name] = newStream(name)
 	return scribeStreams[name]
 }
 
+// newStream creates a stream configured by the command line flags.
+func newStream(name string) (stream *oxweb.DataStream) {
+	stream = oxweb.NewDataStream(name, streamHost)
+	if *handshake {
+		stream.EnableHandshake(0)
+	}
+	if *sampleRate < 1 || *adaptiveSampling {
+		if err := stream.SetSampling(*sampleRate, *adaptiveSampling); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *rateEvents > 0 || *rateBytes > 0 {
+		if err := stream.SetRateLimit(*rateEvents, *rateBytes, *rateBurst); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *checksums {
+		stream.VerifyChecksums()
+	}
+	if *heartbeat > 0 {
+		stream.EnableHeartbeat(*heartbeat)
+	}
+	if *exactNumbers {
+		stream.DecodeExactNumbers()
+	}
+	if *redactPaths != "" {
+		redact, err := oxweb.NewRedact(strings.Split(*redactPaths, ","), redactKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stream.AddStage(redact)
+	}
+	if eventSchema != nil {
+		validate, err := oxweb.NewValidate(eventSchema)
+		if err != nil {
+			log.Fatal(err)
+		}
+		validate.DeadLetters = deadLetters
+		stream.AddStage(validate)
+	}
+	if *shardKey != "" {
+		sharder, err := oxweb.NewSharder(*node, strings.Split(*nodes, ","), *shardKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stream.AddStage(sharder)
+	}
+	if *sequencePath != "" {
+		stream.DetectGaps(*sequencePath, func(gap oxweb.SequenceGap) {
+			log.Printf("Stream %s sequence gap: %v", name, gap)
+		})
+	}
+	if *timestampPath != "" {
+		stream.SetTimestampPath(*timestampPath)
+		if *reorderDelay > 0 {
+			reorder, err := oxweb.NewReorder(*timestampPath, *reorderDelay)
+			if err != nil {
+				log.Fatal(err)
+			}
+			stream.AddStage(reorder)
+		}
+	}
+	return stream
+}
+
+// sinkWALs are the -sink-wal logs streams are delivering through, which
+// mustn't be shared.
+var sinkWALs = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+type walPathSink struct {
+	oxweb.Sink
+	path string
+}
+
+func (s *walPathSink) Close() error {
+	defer func() {
+		sinkWALs.Lock()
+		delete(sinkWALs.paths, s.path)
+		sinkWALs.Unlock()
+	}()
+	return s.Sink.Close()
+}
+
+// withSinkWAL logs a sink's records to a WAL under -sink-wal, if given, so
+// records it fails to take are delivered later, even after a restart. The
+// WAL is named for the sink's target and the query, so the same query to the
+// same target picks up where it left off.
+func withSinkWAL(sink oxweb.Sink, target string, fields, filters []string) oxweb.Sink {
+	if *sinkWAL == "" {
+		return sink
+	}
+	digest := sha256.Sum256([]byte(target + "\n" + strings.Join(fields, "\n") + "\n\n" + strings.Join(filters, "\n")))
+	path := filepath.Join(*sinkWAL, hex.EncodeToString(digest[:8])+".wal")
+
+	sinkWALs.Lock()
+	defer sinkWALs.Unlock()
+	if sinkWALs.paths[path] {
+		log.Printf("Another stream is delivering the same query to %v; not logging this one's records", target)
+		return sink
+	}
+	durable, err := oxweb.WithWAL(sink, path)
+	if err != nil {
+		log.Printf("Couldn't open WAL for %v, records won't be logged: %v", target, err)
+		return sink
+	}
+	sinkWALs.paths[path] = true
+	return &walPathSink{durable, path}
+}
+
+// writeDeadLetters appends dead lettered events to sink, or logs them if sink
+// is nil.
+func writeDeadLetters(sink oxweb.Sink) {
+	for deadLetter := range deadLetters {
+		if sink == nil {
+			log.Printf("Dead letter: %v", deadLetter.Error)
+			continue
+		}
+		if err := sink.Write(deadLetter); err != nil {
+			log.Printf("Failed to write dead letter: %v", err)
+		}
+	}
+}
+
+// MergedStreamByNames merges the comma separated streams, e.g. the shards of
+// one log, in timestamp order when -timestamp and -reorder are given.
+func MergedStreamByNames(names string) (stream *oxweb.MergedStream) {
+	if stream, ok := mergedStreams[names]; ok {
+		return stream
+	}
+
+	sources := []oxweb.Source{}
+	for _, name := range strings.Split(names, ",") {
+		sources = append(sources, StreamByName(name))
+	}
+	stream = oxweb.NewMergedStream(sources...)
+	if *timestampPath != "" && *reorderDelay > 0 {
+		if err := stream.SortBy(*timestampPath, *reorderDelay); err != nil {
+			log.Fatal(err)
+		}
+	}
+	mergedStreams[names] = stream
+	return stream
+}
+
+// FilteredStream is a stream of its own for a query's filters, so they can be
+// pushed down to the relay. Queries with the same filters share it.
+func FilteredStream(name string, filters []string) (stream *oxweb.DataStream) {
+	key := name + "?" + strings.Join(filters, "&")
+	if stream, ok := scribeStreams[key]; ok {
+		return stream
+	}
+
+	stream = newStream(name)
+	if err := stream.SetFilters(filters); err != nil {
+		log.Printf("Couldn't push down filters %v: %v", filters, err)
+		return StreamByName(name)
+	}
+	scribeStreams[key] = stream
+	return stream
+}
+
 var aggregator = flag.String("e", "dev", "One of {dev, stagea, stagex, prod}")
+var relayAddr = flag.String("relay", "", "Address of the relay to stream from, overriding -e; e.g. an oxload load generator")
+var plugins = flag.String("plugins", "", "Comma separated list of expression plugin .so files to load")
+var timestampPath = flag.String("timestamp", "", "Path to each event's timestamp, used to report stream lag")
+var backfillDir = flag.String("backfill", "", "Directory of NDJSON captures queries may backfill from")
+var retention = flag.Duration("retention", time.Hour, "How long to keep named queries' results for /results")
+var dashboard = flag.Bool("dashboard", false, "Serve a standalone dashboard at /dashboard/")
+var handshake = flag.Bool("handshake", false, "Negotiate the protocol version with the relay; old relays don't support this")
+var sampleRate = flag.Float64("sample", 1, "Fraction of events to read from each stream")
+var adaptiveSampling = flag.Bool("adaptive", false, "Sample more heavily while subscribers can't keep up")
+var sequencePath = flag.String("sequence", "", "Path to each event's sequence number, to detect lost events")
+var exactNumbers = flag.Bool("exact-numbers", false, "Decode integers beyond 2^53, such as IDs and byte counters, without losing precision")
+var maxFrameErrors = flag.Int("max-frame-errors", oxweb.DefaultMaxConsecutiveErrors, "Bad lines in a row a client may send before it's disconnected; each is answered with an error")
+var heartbeat = flag.Duration("heartbeat", 0, "Exchange heartbeats with the relay this often, reconnecting when it's silent for 3; requires -handshake")
+var rateEvents = flag.Float64("rate-events", 0, "Most events per second to read from each stream, dropping the rest; 0 for no limit")
+var rateBytes = flag.Float64("rate-bytes", 0, "Most bytes per second to read from each stream, dropping the rest; 0 for no limit")
+var rateBurst = flag.Duration("rate-burst", time.Second, "How long each stream may go over -rate-events and -rate-bytes, if it's been under them")
+var checksums = flag.Bool("checksums", false, "Verify the CRC-32 checksum ending each line from the relay")
+var shardKey = flag.String("shard-key", "", "Only read this node's share of events, by hashing this path; requires -node and -nodes")
+var node = flag.String("node", "", "This process's name among -nodes")
+var nodes = flag.String("nodes", "", "Comma separated names of every node sharing the streams")
+var explain = flag.Bool("explain", false, "Print the plan for the field statements given as arguments, then exit")
+var explainRate = flag.Float64("explain-rate", 100, "Events per second assumed when estimating TimedWindow memory in plans")
+var reorderDelay = flag.Duration("reorder", 0, "Buffer events this long to put them in -timestamp order")
+var validatePath = flag.String("validate", "", "JSON Schema file every event must match; invalid events are dead lettered")
+var deadLetterPath = flag.String("dead-letters", "", "File to append dead lettered events to, rather than logging them")
+var redactPaths = flag.String("redact", "", "Comma separated paths masked in every event before it reaches queries")
+var redactKeyPath = flag.String("redact-key", "", "File holding a secret key of at least 16 bytes; redacted values are replaced by their HMAC-SHA256 under it rather than masked outright")
+var queryServiceAddr = flag.String("query-service", "", "Address to serve query management to other services on as line delimited JSON (not yet gRPC), e.g. 127.0.0.1:3536")
+var sinkWAL = flag.String("sink-wal", "", "Directory of write-ahead logs for webhook and TCP sinks, so records they fail to take are delivered later, even after a restart")
+var authFile = flag.String("auth-file", "", "File of user:sha256-hex-of-password lines; web clients authenticating with basic auth are named in the audit log, and /define requires it")
+var auditPath = flag.String("audit", "", "File to append an audit log of query starts, stops and function definitions to")
+var schemaPath = flag.String("schemas", "", "JSON file of event schemas by log name; queries on strict ones are type checked")
 
 func main() {
 	log.Println("Starting up")
 
 	flag.Parse()
+	if *plugins != "" {
+		for _, path := range strings.Split(*plugins, ",") {
+			if err := oxweb.LoadPlugin(path); err != nil {
+				log.Fatal(err)
+			}
+			log.Println("Loaded plugin", path)
+		}
+	}
+
+	if *schemaPath != "" {
+		var err error
+		if schemas, err = oxweb.LoadSchemas(*schemaPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *redactKeyPath != "" {
+		var err error
+		if redactKey, err = os.ReadFile(*redactKeyPath); err != nil {
+			log.Fatal(err)
+		}
+		redactKey = []byte(strings.TrimSpace(string(redactKey)))
+	}
+	if *authFile != "" {
+		var err error
+		if passwords, err = oxweb.LoadPasswords(*authFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *auditPath != "" {
+		sink, err := oxweb.NewFileSink(*auditPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		engine.SetAudit(sink)
+	}
+
+	if *validatePath != "" {
+		var err error
+		if eventSchema, err = oxweb.LoadJSONSchema(*validatePath); err != nil {
+			log.Fatal(err)
+		}
+		var sink oxweb.Sink
+		if *deadLetterPath != "" {
+			if sink, err = oxweb.NewFileSink(*deadLetterPath); err != nil {
+				log.Fatal(err)
+			}
+		}
+		go writeDeadLetters(sink)
+	}
+
+	if *explain {
+		plan, err := oxweb.Explain(oxweb.QuerySpec{Fields: flag.Args()}, *explainRate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		encoded, _ := json.MarshalIndent(plan, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+
+	resultStore = oxweb.NewRingStore(*retention, 10000)
+
 	streamHost = fmt.Sprintf("scribe-%s.local.yelpcorp.com:3535", *aggregator)
+	if *relayAddr != "" {
+		streamHost = *relayAddr
+	}
 	log.Println("Connecting to ", streamHost)
 
 	go listenTCPClients()
+	if *queryServiceAddr != "" {
+		go listenQueryService(*queryServiceAddr)
+	}
 
 	http.Handle("/", http.HandlerFunc(ServePage))
 	http.Handle("/lookup", http.HandlerFunc(ServeDataItemPage))
+	http.Handle("/define", http.HandlerFunc(ServeDefinePage))
+	http.Handle("/health", http.HandlerFunc(ServeHealthPage))
+	http.Handle("/explain", http.HandlerFunc(ServeExplainPage))
+	http.Handle("/results", resultStore)
+	if *dashboard {
+		http.Handle("/dashboard/", http.StripPrefix("/dashboard", &oxweb.Dashboard{
+			Streams:       streamStats,
+			Store:         resultStore,
+			WebSocketPath: "/ws",
+			ResultsPath:   "/results",
+			ExplainPath:   "/explain",
+		}))
+	}
 	http.Handle("/ws", websocket.Handler(ServeWS))
 
 	err := http.ListenAndServe(":8080", nil)
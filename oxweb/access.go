@@ -0,0 +1,105 @@
+package oxweb
+
+import (
+	"sort"
+	"strings"
+)
+
+// FieldAccess limits the parts of events a subscriber may see, enforcing a
+// data access policy whatever the subscriber's queries ask for. With Allow
+// set, only the values at those GetDeep paths are delivered, along with the
+// event's Envelope and sampling rate; the values at Deny paths are then
+// removed. Paths only reach through objects: an event with a denied value
+// inside an array is dropped, and allowed paths into arrays are ignored.
+// Events are copied rather than modified, as other subscribers may share
+// them.
+type FieldAccess struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Apply returns the part of data the subscriber may see, or false if it
+// can't be delivered at all.
+func (a *FieldAccess) Apply(data JSONData) (result JSONData, ok bool) {
+	object, isObject := data.(map[string]interface{})
+	if !isObject {
+		// Only objects have paths to allow.
+		return data, a.Allow == nil
+	}
+
+	if a.Allow != nil {
+		allowed := make(map[string]interface{}, len(a.Allow)+2)
+		if envelope, ok := object[MetaKey].(*Envelope); ok {
+			allowed[MetaKey] = envelope
+		}
+		if rate, ok := object[SampleRateKey]; ok {
+			allowed[SampleRateKey] = rate
+		}
+		// Deeper paths first, so an enclosing path allowed too replaces the
+		// objects created for them rather than having them copied into it.
+		paths := append([]string{}, a.Allow...)
+		sort.SliceStable(paths, func(i, j int) bool {
+			return strings.Count(paths[i], ".") > strings.Count(paths[j], ".")
+		})
+		for _, path := range paths {
+			copyPath(strings.Split(path, "."), object, allowed)
+		}
+		data = allowed
+	}
+
+	event := cowEvent{data: data}
+	for _, path := range a.Deny {
+		if _, ok := GetDeep(path, event.data); !ok {
+			continue
+		}
+		if !event.remove(path) {
+			return nil, false
+		}
+	}
+	return event.data, true
+}
+
+// copyPath copies the value at keys in from to the same place in to, creating
+// objects along the way.
+func copyPath(keys []string, from map[string]interface{}, to map[string]interface{}) {
+	value, ok := from[keys[0]]
+	if !ok {
+		return
+	}
+	if len(keys) == 1 {
+		to[keys[0]] = value
+		return
+	}
+	fromChild, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	toChild, ok := to[keys[0]].(map[string]interface{})
+	if !ok {
+		toChild = make(map[string]interface{})
+		to[keys[0]] = toChild
+	}
+	copyPath(keys[1:], fromChild, toChild)
+}
+
+// prepare turns events into what the subscriber receives, applying its Access,
+// copying for DeepCopy and then applying its Stages.
+func (request *SubscribeRequest) prepare(events []JSONData) []JSONData {
+	if request.Access != nil {
+		allowed := make([]JSONData, 0, len(events))
+		for _, event := range events {
+			if event, ok := request.Access.Apply(event); ok {
+				allowed = append(allowed, event)
+			}
+		}
+		events = allowed
+	}
+	if request.DeepCopy {
+		copied := make([]JSONData, len(events))
+		for ndx, event := range events {
+			copied[ndx] = deepCopy(event)
+		}
+		events = copied
+	}
+	return ApplyStages(events, request.Stages)
+}
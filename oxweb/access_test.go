@@ -0,0 +1,71 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var accessJSON = `{
+	"status": 200,
+	"user": {"id": 7, "email": "a@example.com", "address": {"city": "SF"}},
+	"items": [{"sku": "x", "card": "4111"}],
+	"_sample_rate": 0.5
+}`
+
+var accessTests = []struct {
+	access   FieldAccess
+	expected string
+}{
+	{FieldAccess{}, accessJSON},
+	{FieldAccess{Allow: []string{"status", "user.id", "missing.path"}}, `{"status": 200, "user": {"id": 7}, "_sample_rate": 0.5}`},
+	{FieldAccess{Allow: []string{"user.address.city", "user.id", "user"}}, `{"user": {"id": 7, "email": "a@example.com", "address": {"city": "SF"}}, "_sample_rate": 0.5}`},
+	{FieldAccess{Deny: []string{"user.email", "items"}}, `{"status": 200, "user": {"id": 7, "address": {"city": "SF"}}, "_sample_rate": 0.5}`},
+	{FieldAccess{Allow: []string{"user"}, Deny: []string{"user.address"}}, `{"user": {"id": 7, "email": "a@example.com"}, "_sample_rate": 0.5}`},
+	{FieldAccess{Deny: []string{"items.0.card"}}, ``},
+}
+
+func TestFieldAccess(t *testing.T) {
+	for _, test := range accessTests {
+		var event, original JSONData
+		json.Unmarshal([]byte(accessJSON), &event)
+		json.Unmarshal([]byte(accessJSON), &original)
+
+		result, ok := test.access.Apply(event)
+		if test.expected == "" {
+			if ok {
+				t.Errorf("For %+v, expected the event to be dropped, got %v", test.access, result)
+			}
+			continue
+		}
+		var expected JSONData
+		json.Unmarshal([]byte(test.expected), &expected)
+		if !ok || !reflect.DeepEqual(result, expected) {
+			t.Errorf("For %+v, expected %v, got %v", test.access, expected, result)
+		}
+		if !reflect.DeepEqual(event, original) {
+			t.Errorf("For %+v, the original event was modified: %v", test.access, event)
+		}
+	}
+}
+
+func TestSubscriberAccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.json")
+	os.WriteFile(path, []byte(`{"status": 200, "email": "a@example.com"}`+"\n"), 0644)
+	source := NewFileSource(path)
+	request := &SubscribeRequest{DataChan: make(chan JSONData, 1), Access: &FieldAccess{Deny: []string{"email"}}}
+	source.Subscribe(request)
+	defer source.Unsubscribe(request)
+
+	select {
+	case event := <-request.DataChan:
+		if _, ok := GetDeep("email", event); ok {
+			t.Errorf("Expected email to be withheld, got %v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the event")
+	}
+}
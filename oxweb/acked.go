@@ -0,0 +1,329 @@
+package oxweb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// AckedEvent is an event delivered by an AckedSubscriber. It must be
+// acknowledged with its Seq, or it's delivered again.
+type AckedEvent struct {
+	Seq  uint64
+	Data JSONData
+}
+
+// AckedSubscriber is a subscription with at-least-once delivery, for sinks
+// such as billing or audit that can't tolerate the usual drop-on-slow
+// behaviour. Events are sent on Events and stay in flight until acknowledged;
+// any not acknowledged within RetryAfter are sent again. Events that can't be
+// sent yet are buffered in memory, up to MaxBuffered, and then in a spill file
+// of at most MaxSpillBytes. Only once that's full are events dropped.
+//
+// Spilled events go through JSON, so values such as the Envelope under
+// MetaKey come back as plain maps.
+//
+//	subscriber, err := oxweb.NewAckedSubscriber("/var/spool/oxweb/billing.spill", 1<<30)
+//	stream.Subscribe(subscriber.Request())
+//	for event := range subscriber.Events {
+//		...
+//		subscriber.Ack(event.Seq)
+//	}
+type AckedSubscriber struct {
+	Events chan AckedEvent
+
+	// Set these before subscribing. Non-positive values are replaced with
+	// the defaults once events arrive.
+	MaxInFlight   int
+	MaxBuffered   int
+	MaxSpillBytes int64
+	RetryAfter    time.Duration
+
+	start sync.Once
+	wake  chan struct{}
+	done  chan struct{}
+
+	lock        sync.Mutex
+	nextSeq     uint64
+	inFlight    map[uint64]*inFlightEvent
+	buffered    []JSONData
+	spillPath   string
+	spillWriter *os.File
+	spillReader *bufio.Reader
+	spillFile   *os.File
+	spillBytes  int64
+	spilled     int
+	dropped     int64
+	redelivered int64
+	closed      bool
+}
+
+type inFlightEvent struct {
+	data   JSONData
+	sentAt time.Time
+}
+
+const (
+	defaultMaxInFlight = 64
+	defaultMaxBuffered = 1024
+	defaultRetryAfter  = 30 * time.Second
+)
+
+// NewAckedSubscriber creates a subscriber spilling to the file at spillPath,
+// which is truncated. By default 64 events may be in flight, 1024 are
+// buffered in memory and events are redelivered after 30 seconds.
+func NewAckedSubscriber(spillPath string, maxSpillBytes int64) (s *AckedSubscriber, err error) {
+	writer, err := os.OpenFile(spillPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := os.Open(spillPath)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	return &AckedSubscriber{
+		Events:        make(chan AckedEvent),
+		MaxInFlight:   defaultMaxInFlight,
+		MaxBuffered:   defaultMaxBuffered,
+		MaxSpillBytes: maxSpillBytes,
+		RetryAfter:    defaultRetryAfter,
+		wake:          make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		inFlight:      make(map[uint64]*inFlightEvent),
+		spillPath:     spillPath,
+		spillWriter:   writer,
+		spillFile:     reader,
+		spillReader:   bufio.NewReader(reader),
+	}, nil
+}
+
+// Request returns a SubscribeRequest delivering to s.
+func (s *AckedSubscriber) Request() *SubscribeRequest {
+	return &SubscribeRequest{Acked: s}
+}
+
+// Ack acknowledges a single event.
+func (s *AckedSubscriber) Ack(seq uint64) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.inFlight[seq]; !ok {
+		return fmt.Errorf("No event %d in flight", seq)
+	}
+	delete(s.inFlight, seq)
+	s.notify()
+	return nil
+}
+
+// AckThrough acknowledges every event in flight up to and including seq, for
+// subscribers that process events in batches.
+func (s *AckedSubscriber) AckThrough(seq uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for inFlightSeq := range s.inFlight {
+		if inFlightSeq <= seq {
+			delete(s.inFlight, inFlightSeq)
+		}
+	}
+	s.notify()
+}
+
+// Stats reports on delivery:
+//
+//	in_flight     events sent but not yet acknowledged
+//	buffered      events waiting in memory
+//	spilled       events waiting in the spill file
+//	spill_bytes   size of the spill file
+//	dropped       events dropped because the spill file was full
+//	redelivered   events sent again for want of an acknowledgement
+func (s *AckedSubscriber) Stats() Stats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return Stats{
+		"in_flight":   int64(len(s.inFlight)),
+		"buffered":    int64(len(s.buffered)),
+		"spilled":     int64(s.spilled),
+		"spill_bytes": s.spillBytes,
+		"dropped":     s.dropped,
+		"redelivered": s.redelivered,
+	}
+}
+
+// Close stops delivery and removes the spill file. Events not yet
+// acknowledged are lost, so unsubscribe and drain first.
+func (s *AckedSubscriber) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.spillWriter.Close()
+	s.spillFile.Close()
+	return os.Remove(s.spillPath)
+}
+
+// enqueue buffers data for delivery. Once anything has been spilled, later
+// events are spilled too so they stay in order.
+func (s *AckedSubscriber) enqueue(data JSONData) (err error) {
+	s.start.Do(func() {
+		s.clampSettings()
+		go s.run()
+	})
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrStreamClosed
+	}
+	defer s.notify()
+	if s.spilled == 0 && len(s.buffered) < s.MaxBuffered {
+		s.buffered = append(s.buffered, data)
+		return nil
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		s.dropped++
+		return err
+	}
+	encoded = append(encoded, '\n')
+	if s.spillBytes+int64(len(encoded)) > s.MaxSpillBytes {
+		s.dropped++
+		return fmt.Errorf("Spill file %v: %w", s.spillPath, ErrSpillFull)
+	}
+	if _, err := s.spillWriter.Write(encoded); err != nil {
+		s.dropped++
+		return err
+	}
+	s.spillBytes += int64(len(encoded))
+	s.spilled++
+	return nil
+}
+
+// clampSettings replaces settings that would stop delivery altogether, or
+// panic, with the defaults.
+func (s *AckedSubscriber) clampSettings() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.MaxInFlight <= 0 {
+		log.Printf("AckedSubscriber MaxInFlight %d isn't positive, using %d", s.MaxInFlight, defaultMaxInFlight)
+		s.MaxInFlight = defaultMaxInFlight
+	}
+	if s.MaxBuffered <= 0 {
+		log.Printf("AckedSubscriber MaxBuffered %d isn't positive, using %d", s.MaxBuffered, defaultMaxBuffered)
+		s.MaxBuffered = defaultMaxBuffered
+	}
+	if s.RetryAfter <= 0 {
+		log.Printf("AckedSubscriber RetryAfter %v isn't positive, using %v", s.RetryAfter, defaultRetryAfter)
+		s.RetryAfter = defaultRetryAfter
+	}
+}
+
+func (s *AckedSubscriber) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends events while there's room in flight, and redelivers those that
+// have waited too long for an acknowledgement.
+func (s *AckedSubscriber) run() {
+	ticker := time.NewTicker(s.RetryAfter / 2)
+	defer ticker.Stop()
+
+	for {
+		for {
+			event, ok := s.next()
+			if !ok {
+				break
+			}
+			select {
+			case s.Events <- event:
+			case <-s.done:
+				return
+			}
+		}
+
+		select {
+		case <-s.wake:
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// next picks the event to send next, if any: first an overdue redelivery,
+// then a new event if there's room in flight.
+func (s *AckedSubscriber) next() (event AckedEvent, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	for seq, inFlight := range s.inFlight {
+		if now.Sub(inFlight.sentAt) >= s.RetryAfter {
+			inFlight.sentAt = now
+			s.redelivered++
+			return AckedEvent{seq, inFlight.data}, true
+		}
+	}
+
+	if len(s.inFlight) >= s.MaxInFlight {
+		return event, false
+	}
+	if len(s.buffered) == 0 && s.spilled > 0 {
+		s.unspill()
+	}
+	if len(s.buffered) == 0 {
+		return event, false
+	}
+
+	data := s.buffered[0]
+	s.buffered = s.buffered[1:]
+	s.nextSeq++
+	s.inFlight[s.nextSeq] = &inFlightEvent{data, now}
+	return AckedEvent{s.nextSeq, data}, true
+}
+
+// unspill moves up to MaxBuffered events from the spill file into memory,
+// truncating the file once it's been read through.
+func (s *AckedSubscriber) unspill() {
+	for s.spilled > 0 && len(s.buffered) < s.MaxBuffered {
+		line, err := s.spillReader.ReadBytes('\n')
+		if err != nil {
+			// Only complete lines are counted, so this is a real I/O error.
+			// There's nothing to be done but lose what's left.
+			s.dropped += int64(s.spilled)
+			s.spilled = 0
+			break
+		}
+		s.spilled--
+
+		var data JSONData
+		if err := json.Unmarshal(line, &data); err != nil {
+			s.dropped++
+			continue
+		}
+		s.buffered = append(s.buffered, data)
+	}
+
+	if s.spilled == 0 {
+		s.spillWriter.Truncate(0)
+		s.spillFile.Seek(0, 0)
+		s.spillReader.Reset(s.spillFile)
+		s.spillBytes = 0
+	}
+}
@@ -0,0 +1,109 @@
+package oxweb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func receiveAcked(t *testing.T, s *AckedSubscriber) AckedEvent {
+	select {
+	case event := <-s.Events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+	return AckedEvent{}
+}
+
+func TestAckedSubscriber(t *testing.T) {
+	s, err := NewAckedSubscriber(filepath.Join(t.TempDir(), "spill"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.MaxInFlight = 1
+	s.MaxBuffered = 2
+	s.RetryAfter = 20 * time.Millisecond
+
+	for n := 1; n <= 5; n++ {
+		if err := s.enqueue(float64(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := s.Stats(); stats["spilled"] < 2 {
+		t.Errorf("Expected events to be spilled, got %v", stats)
+	}
+
+	first := receiveAcked(t, s)
+	if again := receiveAcked(t, s); again != first {
+		t.Errorf("Expected %v to be redelivered, got %v", first, again)
+	}
+	s.Ack(first.Seq)
+
+	received := []JSONData{first.Data}
+	for len(received) < 5 {
+		event := receiveAcked(t, s)
+		received = append(received, event.Data)
+		s.AckThrough(event.Seq)
+	}
+	for ndx, data := range received {
+		if data != float64(ndx+1) {
+			t.Fatalf("Expected events in order, got %v", received)
+		}
+	}
+	if stats := s.Stats(); stats["spill_bytes"] != 0 || stats["in_flight"] != 0 {
+		t.Errorf("Expected everything delivered, got %v", stats)
+	}
+}
+
+func TestAckedSubscriberSpillFull(t *testing.T) {
+	s, err := NewAckedSubscriber(filepath.Join(t.TempDir(), "spill"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.MaxInFlight = 1
+	s.MaxBuffered = 1
+
+	// One event in flight, one buffered and one spilled fill it up, whenever
+	// the first is sent.
+	var failures int64
+	for n := 1; n <= 5; n++ {
+		if err := s.enqueue(float64(n)); err != nil {
+			if !errors.Is(err, ErrSpillFull) {
+				t.Fatalf("Expected ErrSpillFull, got %v", err)
+			}
+			failures++
+		}
+	}
+	if failures < 2 {
+		t.Errorf("Expected the spill file to fill up, only %d events were refused", failures)
+	}
+	if dropped := s.Stats()["dropped"]; dropped != failures {
+		t.Errorf("Expected %d dropped, got %d", failures, dropped)
+	}
+}
+
+func TestAckedSubscriberClampsSettings(t *testing.T) {
+	s, err := NewAckedSubscriber(filepath.Join(t.TempDir(), "spill"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.MaxInFlight = 0
+	s.MaxBuffered = -1
+	s.RetryAfter = 0
+
+	// Would panic starting the retry ticker, or never deliver, unclamped.
+	if err := s.enqueue(1.); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveAcked(t, s); event.Data != 1. {
+		t.Errorf("Expected event 1, got %v", event)
+	}
+	if s.MaxInFlight != defaultMaxInFlight || s.MaxBuffered != defaultMaxBuffered || s.RetryAfter != defaultRetryAfter {
+		t.Errorf("Expected the defaults, got %d, %d, %v", s.MaxInFlight, s.MaxBuffered, s.RetryAfter)
+	}
+}
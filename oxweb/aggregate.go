@@ -2,7 +2,10 @@ package oxweb
 
 import (
 	"fmt"
+	"math"
+	"math/big"
 	"strconv"
+	"time"
 )
 
 /*
@@ -38,7 +41,7 @@ func (gd *GetDeepExpression) Evaluate(data JSONData) (result interface{}, err er
 		return nil, err
 	}
 	if key, ok := key.(string); key == "" || !ok {
-		return nil, fmt.Errorf("Expected non-empty string. Was type %T \"%v\"", key, key)
+		return nil, fmt.Errorf("%w: Expected non-empty string. Was type %T \"%v\"", ErrTypeMismatch, key, key)
 	}
 	result, _ = GetDeep(key.(string), data)
 	return
@@ -49,37 +52,39 @@ func (gd *GetDeepExpression) String() string {
 }
 
 /*
- * AsClause(expression, string) -> expression
+ * As(expression, "alias") -> expression
  *
- * Passes thru the result of the expression, but overrides String() to return the second argument.
+ * Passes thru the result of the expression, but names it alias wherever the
+ * field's name is used: in records, and so in sinks, CSV columns and metric
+ * names. The alias must be a literal string, so it's the same for every event,
+ * including before the first.
  */
 type AsClause struct {
-	expr        Expression
-	alias       Expression
-	aliasResult string
+	expr  Expression
+	alias string
 }
 
 func (a *AsClause) Setup(fname string, args []Expression) (err error) {
-	if len(args) != 2 {
-		return fmt.Errorf("As expects an Expression and a string")
+	if err = checkArity(fname, args, 2, 2); err != nil {
+		return err
+	}
+	literal, ok := args[1].(*Literal)
+	if !ok {
+		return literalArgError("As expects a non-empty literal string alias", args[1])
+	}
+	if a.alias, ok = literal.value.(string); !ok || a.alias == "" {
+		return fmt.Errorf("As expects a non-empty literal string alias, got %v", args[1])
 	}
 	a.expr = args[0]
-	a.alias = args[1]
 	return nil
 }
 
 func (e *AsClause) Evaluate(data JSONData) (result interface{}, err error) {
-	// Store the result of evaluating the alias, so it can be used by String() 
-	// This may prove to be unwise, but errors evaluating the alias are ignored,
-	// 'cause there's not much we can do about them.
-	aliasResult, _ := e.alias.Evaluate(data)
-	e.aliasResult, _ = aliasResult.(string)
-
 	return e.expr.Evaluate(data)
 }
 
 func (e *AsClause) String() (result string) {
-	return e.aliasResult
+	return e.alias
 }
 
 /*
@@ -90,6 +95,7 @@ type ArithmeticOperator struct {
 	expr1 Expression
 	expr2 Expression
 	fname string
+	nulls bool
 }
 
 var arithmeticOperators = map[string](func(a, b float64) float64){
@@ -111,27 +117,173 @@ func (o *ArithmeticOperator) Setup(fname string, args []Expression) (err error)
 	return nil
 }
 
+func (o *ArithmeticOperator) propagateNulls() {
+	o.nulls = true
+}
+
 func (o *ArithmeticOperator) Evaluate(data JSONData) (result interface{}, err error) {
 	val1, err1 := o.expr1.Evaluate(data)
 	val2, err2 := o.expr2.Evaluate(data)
 	if err1 != nil {
-		return nil, fmt.Errorf("Expression 1 could not be evaluated, %v", err2)
+		return nil, fmt.Errorf("Expression 1 could not be evaluated, %w", err1)
 	}
 	if err2 != nil {
-		return nil, fmt.Errorf("Expression 1 could not be evaluated, %v", err2)
+		return nil, fmt.Errorf("Expression 2 could not be evaluated, %w", err2)
 	}
-	val1, ok1 := val1.(float64)
-	val2, ok2 := val2.(float64)
-	if !ok1 {
-		return nil, fmt.Errorf("Subtract expects a float64, Expression 1 was type %T, val %v", val1, val1)
+	if (val1 == nil || val2 == nil) && o.nulls {
+		return nil, nil
 	}
-	if !ok2 {
-		return nil, fmt.Errorf("Subtract expects a float64, Expression 2 was type %T, val %v", val2, val2)
+
+	// Integers too big for a float64, from DecodeExact, are worked out
+	// exactly, as long as the result fits in 64 bits. Quotients are floats
+	// anyway.
+	if o.fname != "Divide" && (isWideInteger(val1) || isWideInteger(val2)) {
+		if int1, int2 := bigInteger(val1), bigInteger(val2); int1 != nil && int2 != nil {
+			return smallInteger(integerOperators[o.fname](new(big.Int), int1, int2))
+		}
+	}
+	number1, err := floatOperand(val1, o.fname != "Divide")
+	if err != nil {
+		return nil, fmt.Errorf("%v expression 1: %w", o.fname, err)
+	}
+	number2, err := floatOperand(val2, o.fname != "Divide")
+	if err != nil {
+		return nil, fmt.Errorf("%v expression 2: %w", o.fname, err)
 	}
 
-	return arithmeticOperators[o.fname](val1.(float64), val2.(float64)), nil
+	return arithmeticOperators[o.fname](number1, number2), nil
+}
+
+var integerOperators = map[string](func(z, x, y *big.Int) *big.Int){
+	"Add":      (*big.Int).Add,
+	"Subtract": (*big.Int).Sub,
+	"Multiply": (*big.Int).Mul,
 }
 
 func (o *ArithmeticOperator) String() string {
 	return fmt.Sprintf("%v(%v,%v)", o.fname, o.expr1, o.expr2)
 }
+
+// toFloat converts the numeric types expressions produce (int literals,
+// float64 JSON numbers and DecodeExact's big integers, approximately) to a
+// float64.
+func toFloat(value interface{}) (f float64, ok bool) {
+	switch value := value.(type) {
+	case int:
+		return float64(value), true
+	case float64:
+		return value, true
+	case int64:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	case time.Duration:
+		return value.Seconds(), true
+	case ByteSize:
+		return float64(value), true
+	}
+	return 0, false
+}
+
+/*
+ * TimeDecayedAve(expr, halfLifeSeconds) -> float64
+ *
+ * An average in which each value's weight halves every halfLifeSeconds, so
+ * recent events count for more than old ones. Only a running sum and weight
+ * are kept, however many events arrive.
+ */
+type TimeDecayedAve struct {
+	expr        Expression
+	halfLife    Expression
+	weightedSum float64
+	weight      float64
+	last        time.Time
+	timeSource  func() time.Time
+}
+
+func (t *TimeDecayedAve) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 {
+		return fmt.Errorf("TimeDecayedAve expects an expression and a half-life in seconds")
+	}
+	t.expr, t.halfLife = args[0], args[1]
+	if t.timeSource == nil {
+		t.timeSource = time.Now
+	}
+	return nil
+}
+
+func (t *TimeDecayedAve) Evaluate(data JSONData) (result interface{}, err error) {
+	halfLife, err := evaluateSeconds(t.halfLife, data)
+	if err != nil {
+		return nil, err
+	}
+	value, err := t.expr.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if value != nil {
+		v, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%w: TimeDecayedAve expects a float64, got %T, %v", ErrTypeMismatch, value, value)
+		}
+		now := t.timeSource()
+		if !t.last.IsZero() {
+			decay := math.Pow(0.5, now.Sub(t.last).Seconds()/halfLife.Seconds())
+			t.weightedSum *= decay
+			t.weight *= decay
+		}
+		t.weightedSum += v
+		t.weight++
+		t.last = now
+	}
+
+	if t.weight == 0 {
+		return nil, fmt.Errorf("%w: TimeDecayedAve has no values", ErrWindowEmpty)
+	}
+	return t.weightedSum / t.weight, nil
+}
+
+func (t *TimeDecayedAve) String() string {
+	return fmt.Sprintf("TimeDecayedAve(%v,%v)", t.expr, t.halfLife)
+}
+
+// toTime converts an event timestamp to a time.Time. Numbers are taken as
+// Unix seconds (with any fraction), strings as RFC 3339.
+func toTime(value interface{}) (t time.Time, ok bool) {
+	switch value := value.(type) {
+	case time.Time:
+		return value, true
+	case float64:
+		sec, frac := math.Modf(value)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))), true
+	case int:
+		return time.Unix(int64(value), 0), true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		return t, err == nil
+	}
+	return t, false
+}
+
+/*
+ * Count() -> int
+ *
+ * The number of events evaluated so far, this one included.
+ */
+type Count struct {
+	count int
+}
+
+func (c *Count) Setup(fname string, args []Expression) (err error) {
+	return checkArity(fname, args, 0, 0)
+}
+
+func (c *Count) Evaluate(data JSONData) (result interface{}, err error) {
+	c.count++
+	return c.count, nil
+}
+
+func (c *Count) String() string {
+	return "Count()"
+}
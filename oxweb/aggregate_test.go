@@ -0,0 +1,75 @@
+package oxweb
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+type timeDecayedAveTest struct {
+	statement string
+	events    []timedEvent
+	expected  float64
+	err       error
+}
+
+var timeDecayedAveTests = []timeDecayedAveTest{
+	timeDecayedAveTest{"TimeDecayedAve(v, 10)", []timedEvent{{0, `{"v": 4}`}}, 4, nil},
+	// An event one half-life old has half the weight: (10*0.5 + 4) / 1.5
+	timeDecayedAveTest{"TimeDecayedAve(v, 10)", []timedEvent{{0, `{"v": 10}`}, {10, `{"v": 4}`}}, 6, nil},
+	timeDecayedAveTest{"TimeDecayedAve(v, 10)", []timedEvent{{0, `{"v": 10}`}, {0, `{"v": 4}`}}, 7, nil},
+	timeDecayedAveTest{"TimeDecayedAve(v, 10)", []timedEvent{{0, `{"v": 10}`}, {1000, `{"v": 4}`}}, 4, nil},
+	// Nils don't count, however much later they come.
+	timeDecayedAveTest{"TimeDecayedAve(v, 10)", []timedEvent{{0, `{"v": 10}`}, {100, `{}`}}, 10, nil},
+	timeDecayedAveTest{"TimeDecayedAve(v, 10)", []timedEvent{{0, `{}`}}, 0, ErrWindowEmpty},
+	timeDecayedAveTest{"TimeDecayedAve(v, 10)", []timedEvent{{0, `{"v": "4"}`}}, 0, ErrTypeMismatch},
+	timeDecayedAveTest{"TimeDecayedAve(v, 0)", []timedEvent{{0, `{"v": 4}`}}, 0, ErrTypeMismatch},
+}
+
+func TestTimeDecayedAve(t *testing.T) {
+	for _, test := range timeDecayedAveTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ave := expr.(*TimeDecayedAve)
+		result, err := evaluateTimed(t, expr, func(now func() time.Time) { ave.timeSource = now }, test.events)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v of %v: expected error %v, got %v", test.statement, test.events, test.err, err)
+		}
+		if test.err != nil {
+			continue
+		}
+		if average, ok := result.(float64); !ok || math.Abs(average-test.expected) > 1e-9 {
+			t.Errorf("%v of %v: expected %v, got %v", test.statement, test.events, test.expected, result)
+		}
+	}
+
+	if _, err := Parse("TimeDecayedAve(v)"); err == nil {
+		t.Error("Expected an error without a half-life")
+	}
+}
+
+func TestArithmeticOperatorErrors(t *testing.T) {
+	event := map[string]interface{}{"n": 1.}
+	for _, test := range []struct {
+		statement string
+		which     string
+	}{
+		{"Add(UrlHost(n), 1)", "Expression 1"},
+		{"Add(1, UrlHost(n))", "Expression 2"},
+	} {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = expr.Evaluate(event)
+		if !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("For %v, expected ErrTypeMismatch, got %v", test.statement, err)
+		} else if !strings.HasPrefix(err.Error(), test.which) {
+			t.Errorf("For %v, expected the error to blame %v, got %v", test.statement, test.which, err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package oxweb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// An Alert is raised by an Alerter when a query's condition becomes true, and
+// raised again with Resolved set once it's false.
+type Alert struct {
+	// Key identifies the alert for deduplication, both by the Alerter and by
+	// notifiers that support it.
+	Key      string
+	Summary  string
+	Resolved bool
+	// Fields of the record that raised the alert, by name.
+	Fields map[string]interface{}
+}
+
+// A Notifier delivers alerts somewhere people will see them.
+type Notifier interface {
+	Notify(alert *Alert) error
+}
+
+// Alerter is a Sink raising alerts from query records. A record fires when its
+// condition field is true. Each record's key and summary come from
+// text/templates over its fields, as with TemplateSink; records sharing a key
+// notify once when they start firing and once when they stop.
+type Alerter struct {
+	Condition string
+	Notifiers []Notifier
+
+	summary *template.Template
+	key     *template.Template
+
+	lock   sync.Mutex
+	firing map[string]bool
+}
+
+func NewAlerter(condition, summary, key string, notifiers ...Notifier) (a *Alerter, err error) {
+	a = &Alerter{Condition: condition, Notifiers: notifiers, firing: make(map[string]bool)}
+	if a.summary, err = template.New("summary").Parse(summary); err != nil {
+		return nil, err
+	}
+	if a.key, err = template.New("key").Parse(key); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Alerter) Write(record JSONData) (err error) {
+	fields, err := recordFields(record)
+	if err != nil {
+		return err
+	}
+	firing, ok := fields[a.Condition].(bool)
+	if !ok {
+		return fmt.Errorf("%w: Expected a boolean %v field, got %T", ErrTypeMismatch, a.Condition, fields[a.Condition])
+	}
+
+	alert := &Alert{Resolved: !firing, Fields: fields}
+	if alert.Key, err = executeTemplate(a.key, fields); err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	changed := a.firing[alert.Key] != firing
+	if firing {
+		a.firing[alert.Key] = true
+	} else {
+		delete(a.firing, alert.Key)
+	}
+	a.lock.Unlock()
+	if !changed {
+		return nil
+	}
+
+	if alert.Summary, err = executeTemplate(a.summary, fields); err != nil {
+		return err
+	}
+	return a.notify(alert)
+}
+
+// notify tries every notifier, returning the first error.
+func (a *Alerter) notify(alert *Alert) (err error) {
+	for _, notifier := range a.Notifiers {
+		if notifyErr := notifier.Notify(alert); notifyErr != nil && err == nil {
+			err = notifyErr
+		}
+	}
+	return err
+}
+
+func (a *Alerter) Close() error {
+	return nil
+}
+
+func executeTemplate(tmpl *template.Template, data interface{}) (result string, err error) {
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// SlackNotifier posts alerts to a Slack incoming webhook.
+type SlackNotifier struct {
+	URL  string
+	sink *WebhookSink
+}
+
+func NewSlackNotifier(url string) *SlackNotifier {
+	return &SlackNotifier{URL: url, sink: NewWebhookSink(url)}
+}
+
+func (n *SlackNotifier) Notify(alert *Alert) error {
+	text := ":rotating_light: " + alert.Summary
+	if alert.Resolved {
+		text = ":white_check_mark: Resolved: " + alert.Summary
+	}
+	return n.sink.Write(map[string]interface{}{"text": text})
+}
+
+// PagerDutyEventsURL is the PagerDuty Events API v2 endpoint.
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutyNotifier triggers and resolves PagerDuty incidents through the
+// Events API, using the alert's Key as the dedup_key so repeats collapse into
+// one incident.
+type PagerDutyNotifier struct {
+	RoutingKey string
+	Source     string
+	Severity   string
+	sink       *WebhookSink
+}
+
+func NewPagerDutyNotifier(routingKey string) *PagerDutyNotifier {
+	return &PagerDutyNotifier{
+		RoutingKey: routingKey,
+		Source:     "oxweb",
+		Severity:   "error",
+		sink:       NewWebhookSink(PagerDutyEventsURL),
+	}
+}
+
+func (n *PagerDutyNotifier) Notify(alert *Alert) error {
+	action := "trigger"
+	if alert.Resolved {
+		action = "resolve"
+	}
+	return n.sink.Write(map[string]interface{}{
+		"routing_key":  n.RoutingKey,
+		"event_action": action,
+		"dedup_key":    alert.Key,
+		"payload": map[string]interface{}{
+			"summary":        alert.Summary,
+			"source":         n.Source,
+			"severity":       n.Severity,
+			"custom_details": alert.Fields,
+		},
+	})
+}
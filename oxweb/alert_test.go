@@ -0,0 +1,91 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type recordingNotifier struct {
+	alerts []*Alert
+}
+
+func (n *recordingNotifier) Notify(alert *Alert) error {
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func alertRecord(host string, down bool) JSONData {
+	return []interface{}{
+		[]interface{}{"host", host},
+		[]interface{}{"down", down},
+	}
+}
+
+func TestAlerterDeduplicates(t *testing.T) {
+	notifier := new(recordingNotifier)
+	alerter, err := NewAlerter("down", "{{.host}} is down", "down-{{.host}}", notifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alerter.Write(alertRecord("web1", false))
+	alerter.Write(alertRecord("web1", true))
+	alerter.Write(alertRecord("web2", true))
+	alerter.Write(alertRecord("web1", true))
+	alerter.Write(alertRecord("web1", false))
+
+	if len(notifier.alerts) != 3 {
+		t.Fatalf("Expected 3 notifications, got %d", len(notifier.alerts))
+	}
+	last := notifier.alerts[2]
+	if last.Key != "down-web1" || !last.Resolved || last.Summary != "web1 is down" {
+		t.Errorf("Expected web1 to resolve, got %+v", last)
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var message map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&message)
+	}))
+	defer server.Close()
+
+	notifier := NewSlackNotifier(server.URL)
+	if err := notifier.Notify(&Alert{Summary: "web1 is down"}); err != nil {
+		t.Fatal(err)
+	}
+	if message["text"] != ":rotating_light: web1 is down" {
+		t.Errorf("Unexpected message %v", message)
+	}
+}
+
+func TestSMTPNotifierMessage(t *testing.T) {
+	notifier, err := NewSMTPNotifier("mail.example.com:587", "oxweb@example.com", []string{"ops@example.com"},
+		"{{.Summary}}", "Host: {{.Fields.host}}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := &Alert{Summary: "web1 is down", Resolved: true, Fields: map[string]interface{}{"host": "web1"}}
+	message, err := notifier.message(alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "From: oxweb@example.com\r\nTo: ops@example.com\r\nSubject: Resolved: web1 is down\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n\r\nHost: web1\r\n"
+	if string(message) != expected {
+		t.Errorf("Expected %q, got %q", expected, message)
+	}
+	// A field can't smuggle in headers of its own.
+	alert = &Alert{Summary: "web1\r\nBcc: thief@example.com\rX-Evil: 1", Fields: map[string]interface{}{}}
+	if message, _ = notifier.message(alert); !strings.Contains(string(message), "Subject: web1 Bcc: thief@example.com X-Evil: 1\r\n") {
+		t.Errorf("Expected line breaks folded out of the subject, got %q", message)
+	}
+	alert = &Alert{Summary: "café down", Fields: map[string]interface{}{}}
+	if message, _ = notifier.message(alert); !strings.Contains(string(message), "Subject: =?utf-8?q?caf=C3=A9_down?=\r\n") {
+		t.Errorf("Expected a Q encoded subject, got %q", message)
+	}
+}
@@ -0,0 +1,234 @@
+package oxweb
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+func evaluateArray(expr Expression, data JSONData) (array []interface{}, err error) {
+	value, err := expr.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	array, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: Expected an array, got %T, %v", ErrTypeMismatch, value, value)
+	}
+	return array, nil
+}
+
+var arrayAggregates = map[string](func(values []float64) interface{}){
+	"ArraySum": func(values []float64) interface{} {
+		sum := 0.
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	"ArrayAvg": func(values []float64) interface{} {
+		if len(values) == 0 {
+			return nil
+		}
+		sum := 0.
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	"ArrayMax": func(values []float64) interface{} {
+		if len(values) == 0 {
+			return nil
+		}
+		max := math.Inf(-1)
+		for _, v := range values {
+			max = math.Max(max, v)
+		}
+		return max
+	},
+	"ArrayMin": func(values []float64) interface{} {
+		if len(values) == 0 {
+			return nil
+		}
+		min := math.Inf(1)
+		for _, v := range values {
+			min = math.Min(min, v)
+		}
+		return min
+	},
+}
+
+/*
+ * ArraySum(array) -> float64
+ * ArrayAvg(array) -> float64
+ * ArrayMax(array) -> float64
+ * ArrayMin(array) -> float64
+ *
+ * Aggregates the numeric elements of an array within a single event, e.g.
+ * ArraySum(ArrayMap(order.items, price)). Avg, Max and Min of an empty array are nil.
+ */
+type ArrayAggregate struct {
+	expr  Expression
+	fname string
+}
+
+func (a *ArrayAggregate) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("%v expects one argument, an expression evaluating to an array of numbers", fname)
+	}
+	if _, ok := arrayAggregates[fname]; !ok {
+		return fmt.Errorf("%v is not a supported ArrayAggregate", fname)
+	}
+	a.expr = args[0]
+	a.fname = fname
+	return nil
+}
+
+func (a *ArrayAggregate) Evaluate(data JSONData) (result interface{}, err error) {
+	array, err := evaluateArray(a.expr, data)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]float64, 0, len(array))
+	for ndx, element := range array {
+		value, ok := toFloat(element)
+		if !ok {
+			return nil, fmt.Errorf("%w: %v expects an array of numbers, element %d was type %T, val %v", ErrTypeMismatch, a.fname, ndx, element, element)
+		}
+		values = append(values, value)
+	}
+	return arrayAggregates[a.fname](values), nil
+}
+
+func (a *ArrayAggregate) String() string {
+	return fmt.Sprintf("%v(%v)", a.fname, a.expr)
+}
+
+/*
+ * Sum(expr, ...) -> float64
+ * Max(expr, ...) -> float64
+ * Min(expr, ...) -> float64
+ *
+ * Aggregates any number of numbers within a single event, like the Array
+ * aggregates do an array's elements, e.g. Max(timing.db, timing.cache,
+ * timing.render). Nil values, as from missing fields, are skipped unless the
+ * query propagates nulls; Max and Min of nothing but nils are nil.
+ */
+type ArgAggregate struct {
+	args  []Expression
+	fname string
+	nulls bool
+}
+
+var argAggregates = map[string]string{"Sum": "ArraySum", "Max": "ArrayMax", "Min": "ArrayMin"}
+
+func (a *ArgAggregate) Setup(fname string, args []Expression) (err error) {
+	if _, ok := argAggregates[fname]; !ok {
+		return fmt.Errorf("%v is not a supported ArgAggregate", fname)
+	}
+	if err = checkArity(fname, args, 1, variadic); err != nil {
+		return err
+	}
+	a.args = args
+	a.fname = fname
+	return nil
+}
+
+func (a *ArgAggregate) propagateNulls() {
+	a.nulls = true
+}
+
+func (a *ArgAggregate) Evaluate(data JSONData) (result interface{}, err error) {
+	values := make([]float64, 0, len(a.args))
+	for ndx, arg := range a.args {
+		value, err := arg.Evaluate(data)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			if a.nulls {
+				return nil, nil
+			}
+			continue
+		}
+		number, ok := toFloat(value)
+		if !ok {
+			return nil, fmt.Errorf("%w: %v expects numbers, argument %d was type %T, val %v", ErrTypeMismatch, a.fname, ndx+1, value, value)
+		}
+		values = append(values, number)
+	}
+	return arrayAggregates[argAggregates[a.fname]](values), nil
+}
+
+func (a *ArgAggregate) String() string {
+	args := make([]string, len(a.args))
+	for ndx, arg := range a.args {
+		args[ndx] = arg.String()
+	}
+	return fmt.Sprintf("%v(%v)", a.fname, strings.Join(args, ","))
+}
+
+/*
+ * ArrayLen(array) -> int
+ */
+type ArrayLen struct {
+	expr Expression
+}
+
+func (a *ArrayLen) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("ArrayLen expects one argument, an expression evaluating to an array")
+	}
+	a.expr = args[0]
+	return nil
+}
+
+func (a *ArrayLen) Evaluate(data JSONData) (result interface{}, err error) {
+	array, err := evaluateArray(a.expr, data)
+	if err != nil {
+		return nil, err
+	}
+	return len(array), nil
+}
+
+func (a *ArrayLen) String() string {
+	return fmt.Sprintf("ArrayLen(%v)", a.expr)
+}
+
+/*
+ * ArrayMap(array, expr) -> []interface{}
+ *
+ * Evaluates expr once per element of the array, with the element standing in
+ * for the event, so ArrayMap(items, price) returns the price of every item.
+ */
+type ArrayMap struct {
+	array Expression
+	expr  Expression
+}
+
+func (a *ArrayMap) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 {
+		return fmt.Errorf("ArrayMap expects an array expression and an expression to evaluate for each element")
+	}
+	a.array, a.expr = args[0], args[1]
+	return nil
+}
+
+func (a *ArrayMap) Evaluate(data JSONData) (result interface{}, err error) {
+	array, err := evaluateArray(a.array, data)
+	if err != nil {
+		return nil, err
+	}
+	mapped := make([]interface{}, len(array))
+	for ndx, element := range array {
+		mapped[ndx], err = a.expr.Evaluate(element)
+		if err != nil {
+			return nil, fmt.Errorf("ArrayMap element %d: %w", ndx, err)
+		}
+	}
+	return mapped, nil
+}
+
+func (a *ArrayMap) String() string {
+	return fmt.Sprintf("ArrayMap(%v,%v)", a.array, a.expr)
+}
@@ -0,0 +1,105 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var argAggregateTests = []struct {
+	statement string
+	result    interface{}
+	ok        bool
+}{
+	{"Sum(a, b, c)", 6., true},
+	{"Max(a, b, c)", 3., true},
+	{"Min(c, b, a)", 1., true},
+	{"Max(a)", 1., true},
+	{"Sum(a, 2, 3.5)", 6.5, true},
+	{"Max(a, missing, c)", 3., true},
+	{"Min(missing)", nil, true},
+	{"Sum(missing)", 0., true},
+	{"Max(a, name)", nil, false},
+}
+
+func TestArgAggregate(t *testing.T) {
+	var event JSONData
+	json.Unmarshal([]byte(`{"a": 1, "b": 2, "c": 3, "name": "x"}`), &event)
+	for _, test := range argAggregateTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatalf("Couldn't parse %v: %v", test.statement, err)
+		}
+		result, err := expr.Evaluate(event)
+		if test.ok != (err == nil) {
+			t.Errorf("For %v, expected ok = %t, but err was %v", test.statement, test.ok, err)
+		}
+		if result != test.result {
+			t.Errorf("For %v, expected %v, got %v", test.statement, test.result, result)
+		}
+	}
+
+	if _, err := Parse("Max()"); err == nil {
+		t.Errorf("Expected an arity error")
+	}
+	if expr, _ := Parse("Max(a, Sum(b, c))"); expr.String() != "Max(a,Sum(b,c))" {
+		t.Errorf("Unexpected expression %v", expr)
+	}
+	sum, _ := ParseWithOptions("Sum(a, missing)", ParseOptions{PropagateNulls: true})
+	if result, err := sum.Evaluate(event); result != nil || err != nil {
+		t.Errorf("Expected nil propagated, got %v, %v", result, err)
+	}
+}
+
+var arrayTests = []struct {
+	statement string
+	result    interface{}
+	err       error
+}{
+	{"ArraySum(prices)", 6., nil},
+	{"ArrayAvg(prices)", 2., nil},
+	{"ArrayMax(prices)", 3., nil},
+	{"ArrayMin(prices)", 1., nil},
+	{"ArrayLen(prices)", 3, nil},
+	{"ArraySum(empty)", 0., nil},
+	{"ArrayAvg(empty)", nil, nil},
+	{"ArrayMax(empty)", nil, nil},
+	{"ArrayLen(empty)", 0, nil},
+	{"ArrayMap(items, price)", []interface{}{2., 4.5, nil}, nil},
+	{"ArrayLen(ArrayMap(items, sku))", 3, nil},
+	{"ArraySum(mixed)", nil, ErrTypeMismatch},
+	{"ArraySum(ArrayMap(items, price))", nil, ErrTypeMismatch},
+	// Numbers that aren't float64s, such as ArrayLen's ints, count too.
+	{"ArraySum(ArrayMap(items, ArrayLen(tags)))", 3., nil},
+	{"ArrayMax(ArrayMap(items, ArrayLen(tags)))", 2., nil},
+	{"ArrayLen(name)", nil, ErrTypeMismatch},
+	// Errors for an element keep their type.
+	{"ArrayMap(items, Multiply(price, 2))", nil, ErrTypeMismatch},
+}
+
+func TestArrayAggregates(t *testing.T) {
+	var event JSONData
+	json.Unmarshal([]byte(`{"prices": [1, 2, 3], "empty": [], "mixed": [1, "2"], "name": "x",
+		"items": [{"sku": "a", "price": 2, "tags": ["x"]}, {"sku": "b", "price": 4.5, "tags": ["x", "y"]},
+		{"sku": "c", "tags": []}]}`), &event)
+	for _, test := range arrayTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatalf("Couldn't parse %v: %v", test.statement, err)
+		}
+		result, err := expr.Evaluate(event)
+		if test.err != nil && !errors.Is(err, test.err) || test.err == nil && err != nil {
+			t.Errorf("For %v, expected error %v, got %v", test.statement, test.err, err)
+		}
+		if !reflect.DeepEqual(result, test.result) {
+			t.Errorf("For %v, expected %v, got %v", test.statement, test.result, result)
+		}
+	}
+
+	for _, statement := range []string{"ArraySum()", "ArraySum(a, b)", "ArrayLen()", "ArrayMap(items)"} {
+		if _, err := Parse(statement); err == nil {
+			t.Errorf("Expected an error parsing %v", statement)
+		}
+	}
+}
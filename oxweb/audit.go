@@ -0,0 +1,147 @@
+package oxweb
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditCreate  = "create"
+	AuditModify  = "modify"
+	AuditDelete  = "delete"
+	AuditPause   = "pause"
+	AuditResume  = "resume"
+	AuditRestore = "restore"
+	// AuditDefine records a user-defined function, named as the query.
+	AuditDefine = "define"
+)
+
+// AuditEvent records a change to an Engine's queries: what was done, to which
+// query, when and by whom.
+type AuditEvent struct {
+	Time      time.Time
+	Principal string
+	Action    string
+	Query     string
+	// The query's new spec, for creates, modifies and restores.
+	Spec *QuerySpec
+}
+
+// Record turns the event into a record of [name, value] pairs, as written to
+// sinks.
+func (a *AuditEvent) Record() []interface{} {
+	record := []interface{}{
+		[]interface{}{"time", a.Time.UTC().Format(time.RFC3339Nano)},
+		[]interface{}{"principal", a.Principal},
+		[]interface{}{"action", a.Action},
+		[]interface{}{"query", a.Query},
+	}
+	if a.Spec != nil {
+		record = append(record, []interface{}{"spec", a.Spec})
+	}
+	return record
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context naming who is acting, for the audit log.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Principal returns who ctx says is acting, or "" if it doesn't say.
+func Principal(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// A PasswordChecker reports whether password is user's.
+type PasswordChecker func(user, password string) bool
+
+// LoadPasswords reads a file of user:digest lines, where digest is the hex
+// SHA-256 of the user's password, and returns a PasswordChecker for them. A
+// plain digest is only as strong as the password, so give each user a long
+// random one, as for an API token. Blank lines and lines starting with # are
+// skipped.
+func LoadPasswords(path string) (check PasswordChecker, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	digests := make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, digest, found := strings.Cut(text, ":")
+		decoded, err := hex.DecodeString(digest)
+		if !found || user == "" || err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("%w: %v line %d isn't user:sha256 hex digest", ErrParse, path, line)
+		}
+		digests[user] = decoded
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return func(user, password string) bool {
+		expected, ok := digests[user]
+		digest := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(digest[:], expected) == 1 && ok
+	}, nil
+}
+
+// RequestPrincipal is the user an HTTP request authenticated as, by basic
+// auth with a password check accepts, or "" if it didn't authenticate or
+// check is nil. Headers naming a user, such as X-Remote-User, aren't trusted,
+// as any client can set them.
+func RequestPrincipal(request *http.Request, check PasswordChecker) string {
+	user, password, ok := request.BasicAuth()
+	if !ok || check == nil || !check(user, password) {
+		return ""
+	}
+	return user
+}
+
+// SetAudit has the Engine write an AuditEvent record to sink for every change
+// to its queries. Changes made without a context, through Add rather than
+// AddContext and so on, are recorded with an empty principal.
+func (e *Engine) SetAudit(sink Sink) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.audit = sink
+}
+
+// Audit records a change the Engine doesn't make itself, such as a query
+// streamed straight to a client or a function definition, in its audit log.
+func (e *Engine) Audit(ctx context.Context, action string, name string, spec *QuerySpec) {
+	e.record(ctx, action, name, spec)
+}
+
+func (e *Engine) record(ctx context.Context, action string, name string, spec *QuerySpec) {
+	e.lock.Lock()
+	sink := e.audit
+	e.lock.Unlock()
+	if sink == nil {
+		return
+	}
+
+	event := &AuditEvent{time.Now(), Principal(ctx), action, name, spec}
+	if err := sink.Write(event.Record()); err != nil {
+		log.Printf("Failed to write audit event %v of %v: %v", action, name, err)
+	}
+}
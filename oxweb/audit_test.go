@@ -0,0 +1,73 @@
+package oxweb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEngineAudit(t *testing.T) {
+	sink := new(recordingSink)
+	engine := NewEngine()
+	engine.SetAudit(sink)
+	ctx := WithPrincipal(context.Background(), "alice")
+
+	spec := QuerySpec{Name: "latency", Source: "ranger", Fields: []string{"latency"}}
+	engine.AddContext(ctx, spec)
+	engine.AddContext(ctx, spec)
+	engine.PauseContext(ctx, "latency", false)
+	engine.ResumeContext(ctx, "latency")
+	engine.Remove("latency")
+	engine.Remove("latency")
+
+	expected := []struct{ action, principal string }{
+		{AuditCreate, "alice"}, {AuditModify, "alice"}, {AuditPause, "alice"}, {AuditResume, "alice"}, {AuditDelete, ""},
+	}
+	if len(sink.records) != len(expected) {
+		t.Fatalf("Expected %d audit events, got %v", len(expected), sink.records)
+	}
+	for ndx, record := range sink.records {
+		fields, _ := recordFields(record)
+		if fields["action"] != expected[ndx].action || fields["principal"] != expected[ndx].principal || fields["query"] != "latency" {
+			t.Errorf("Expected %v, got %v", expected[ndx], fields)
+		}
+		if _, hasSpec := fields["spec"]; hasSpec != (ndx < 2) {
+			t.Errorf("Unexpected spec in %v", fields)
+		}
+	}
+}
+
+func TestRequestPrincipal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwords")
+	digest := sha256.Sum256([]byte("secret"))
+	os.WriteFile(path, []byte("# users\nalice:"+hex.EncodeToString(digest[:])+"\n"), 0600)
+	check, err := LoadPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest("POST", "/", nil)
+	request.Header.Set("X-Remote-User", "bob")
+	if principal := RequestPrincipal(request, check); principal != "" {
+		t.Errorf("Expected X-Remote-User to be ignored, got %v", principal)
+	}
+	request.SetBasicAuth("alice", "wrong")
+	if principal := RequestPrincipal(request, check); principal != "" {
+		t.Errorf("Expected a wrong password to be rejected, got %v", principal)
+	}
+	request.SetBasicAuth("mallory", "secret")
+	if principal := RequestPrincipal(request, check); principal != "" {
+		t.Errorf("Expected an unknown user to be rejected, got %v", principal)
+	}
+	request.SetBasicAuth("alice", "secret")
+	if principal := RequestPrincipal(request, check); principal != "alice" {
+		t.Errorf("Expected alice, got %v", principal)
+	}
+	if principal := RequestPrincipal(request, nil); principal != "" {
+		t.Errorf("Expected no principal without a check, got %v", principal)
+	}
+}
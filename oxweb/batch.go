@@ -0,0 +1,88 @@
+package oxweb
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBatchSize is the most events in a batch when BatchSize isn't set.
+	DefaultBatchSize = 100
+	// DefaultBatchDelay is how long a batch waits to fill when BatchDelay
+	// isn't set.
+	DefaultBatchDelay = 10 * time.Millisecond
+)
+
+// batcher collects a subscriber's events into batches for BatchChan.
+type batcher struct {
+	lock    sync.Mutex
+	events  []JSONData
+	started time.Time
+	timer   *time.Timer
+}
+
+// addToBatch adds events to the subscriber's batch, sending it on BatchChan
+// once it has BatchSize events, or once its first event is BatchDelay old.
+// Sends never block; onDrop is called with the number of events in each
+// batch dropped because BatchChan was full.
+func (request *SubscribeRequest) addToBatch(events []JSONData, onDrop func(events int)) {
+	b := &request.batch
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	size := request.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	for _, event := range events {
+		if len(b.events) == 0 {
+			request.startBatch(size, onDrop)
+		}
+		b.events = append(b.events, event)
+		if len(b.events) >= size {
+			request.sendBatch(onDrop)
+		}
+	}
+}
+
+// startBatch starts the timer sending the batch if it isn't filled first. The
+// batcher must be locked.
+func (request *SubscribeRequest) startBatch(size int, onDrop func(events int)) {
+	b := &request.batch
+	delay := request.BatchDelay
+	if delay <= 0 {
+		delay = DefaultBatchDelay
+	}
+	b.events = make([]JSONData, 0, size)
+	b.started = time.Now()
+	if b.timer != nil {
+		b.timer.Reset(delay)
+		return
+	}
+	b.timer = time.AfterFunc(delay, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		// The timer may have been meant for a batch that filled since.
+		if len(b.events) > 0 && !time.Now().Before(b.started.Add(delay)) {
+			request.sendBatch(onDrop)
+		}
+	})
+}
+
+// sendBatch sends the batch, whether or not it's full, and starts another.
+// The batcher must be locked.
+func (request *SubscribeRequest) sendBatch(onDrop func(events int)) {
+	b := &request.batch
+	b.timer.Stop()
+	// The subscriber owns the batch once it's sent.
+	events := b.events
+	b.events = nil
+
+	select {
+	case request.BatchChan <- events:
+	default:
+		log.Printf("Dropping a batch of %d events to channel %d", len(events), request.id)
+		onDrop(len(events))
+	}
+}
@@ -0,0 +1,52 @@
+package oxweb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatches(t *testing.T) {
+	request := &SubscribeRequest{BatchChan: make(chan []JSONData, 1), BatchSize: 3, BatchDelay: 20 * time.Millisecond}
+	dropped := 0
+	onDrop := func(events int) { dropped += events }
+
+	request.addToBatch([]JSONData{1., 2., 3., 4.}, onDrop)
+	if batch := <-request.BatchChan; len(batch) != 3 || batch[2] != 3. {
+		t.Errorf("Expected a full batch of 3 straight away, got %v", batch)
+	}
+	select {
+	case batch := <-request.BatchChan:
+		if len(batch) != 1 || batch[0] != 4. {
+			t.Errorf("Expected the rest once the delay passed, got %v", batch)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a partial batch")
+	}
+
+	// A subscriber not keeping up loses whole batches.
+	request.addToBatch([]JSONData{5., 6., 7., 8., 9., 10.}, onDrop)
+	if dropped != 3 {
+		t.Errorf("Expected 3 events dropped, got %d", dropped)
+	}
+}
+
+func TestQueryHubBatches(t *testing.T) {
+	source := &fakeSource{make(chan *SubscribeRequest, 1), make(chan bool, 1)}
+	hub := NewQueryHub()
+	records, leave, err := hub.Join("", source, nil, func() (*Query, error) {
+		expr, err := Parse("latency")
+		return NewQuery([]Expression{expr}, nil), err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leave()
+
+	request := <-source.subscribed
+	request.BatchChan <- []JSONData{map[string]interface{}{"latency": 1.}, map[string]interface{}{"latency": 2.}}
+	for _, latency := range []float64{1, 2} {
+		if record := receiveRecord(t, records); record[0].([]interface{})[1] != latency {
+			t.Errorf("Expected latency %v, got %v", latency, record)
+		}
+	}
+}
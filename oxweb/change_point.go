@@ -0,0 +1,109 @@
+package oxweb
+
+import (
+	"fmt"
+	"math"
+)
+
+// ChangePointSlack is the CUSUM allowance, in standard deviations, that a
+// value may drift from the baseline mean without accumulating evidence.
+const ChangePointSlack = 0.5
+
+/*
+ * ChangePoint(expr, window int, sensitivity float64) -> bool
+ *
+ * Two-sided CUSUM change detection. The mean and standard deviation of the
+ * last window values form a baseline; each new value's deviation from it (in
+ * standard deviations, less a small slack) is accumulated, and true is returned
+ * once the accumulated shift up or down exceeds sensitivity. Lower sensitivity
+ * detects smaller shifts sooner at the cost of more false alarms; 4 or 5 is a
+ * reasonable start. Nothing is detected until the baseline has filled, and
+ * after firing the baseline is relearned from new values.
+ */
+type ChangePoint struct {
+	expr        Expression
+	window      Expression
+	sensitivity Expression
+
+	baseline []float64
+	next     int
+	sum      float64
+	sumSq    float64
+	high     float64
+	low      float64
+}
+
+func (c *ChangePoint) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 3 {
+		return fmt.Errorf("ChangePoint expects an expression, a window size and a sensitivity")
+	}
+	c.expr, c.window, c.sensitivity = args[0], args[1], args[2]
+	return nil
+}
+
+func (c *ChangePoint) Evaluate(data JSONData) (result interface{}, err error) {
+	window, err := evaluatePositiveInt(c.window, data, "window size")
+	if err != nil {
+		return false, err
+	}
+	sensitivity, err := c.sensitivity.Evaluate(data)
+	if err != nil {
+		return false, err
+	}
+	threshold, ok := toFloat(sensitivity)
+	if !ok || threshold <= 0 {
+		return false, fmt.Errorf("%w: ChangePoint expects a positive sensitivity. Got a %T, %v", ErrTypeMismatch, sensitivity, sensitivity)
+	}
+	value, err := c.expr.Evaluate(data)
+	if err != nil || value == nil {
+		return false, err
+	}
+	x, ok := value.(float64)
+	if !ok {
+		return false, fmt.Errorf("%w: ChangePoint expects a float64, got %T, %v", ErrTypeMismatch, value, value)
+	}
+
+	// Until the baseline window has filled there isn't a reliable estimate
+	// of the distribution to compare against.
+	changed := false
+	if n := float64(len(c.baseline)); len(c.baseline) >= window && n >= 2 {
+		mean := c.sum / n
+		stddev := math.Sqrt(math.Max(c.sumSq/n-mean*mean, 0))
+		if stddev > 0 {
+			z := (x - mean) / stddev
+			c.high = math.Max(0, c.high+z-ChangePointSlack)
+			c.low = math.Max(0, c.low-z-ChangePointSlack)
+			changed = c.high > threshold || c.low > threshold
+		}
+	}
+	if changed {
+		c.reset()
+	}
+	c.addBaseline(x, window)
+	return changed, nil
+}
+
+func (c *ChangePoint) addBaseline(x float64, window int) {
+	if len(c.baseline) < window {
+		c.baseline = append(c.baseline, x)
+	} else {
+		old := c.baseline[c.next%len(c.baseline)]
+		c.sum -= old
+		c.sumSq -= old * old
+		c.baseline[c.next%len(c.baseline)] = x
+		c.next++
+	}
+	c.sum += x
+	c.sumSq += x * x
+}
+
+func (c *ChangePoint) reset() {
+	c.baseline = nil
+	c.next = 0
+	c.sum, c.sumSq = 0, 0
+	c.high, c.low = 0, 0
+}
+
+func (c *ChangePoint) String() string {
+	return fmt.Sprintf("ChangePoint(%v,%v,%v)", c.expr, c.window, c.sensitivity)
+}
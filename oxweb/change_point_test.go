@@ -0,0 +1,58 @@
+package oxweb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type changePointTest struct {
+	values []interface{}
+	// Indexes of the values ChangePoint fired on.
+	fired []int
+}
+
+var changePointTests = []changePointTest{
+	// Noise about a steady mean never accumulates.
+	changePointTest{[]interface{}{9., 11., 9., 11., 9., 11., 9., 11., 9., 11., 9., 11.}, []int{}},
+	// A jump of 5 standard deviations, up or down, fires at once.
+	changePointTest{[]interface{}{9., 11., 9., 11., 15.}, []int{4}},
+	changePointTest{[]interface{}{9., 11., 9., 11., 5.}, []int{4}},
+	// A smaller shift takes more evidence.
+	changePointTest{[]interface{}{9., 11., 9., 11., 13., 14.}, []int{5}},
+	// Nothing until the baseline has filled.
+	changePointTest{[]interface{}{9., 11., 100.}, []int{}},
+	// Nils are skipped, and the baseline is relearned after firing.
+	changePointTest{[]interface{}{9., nil, 11., 9., 11., 15., 16., 14., 16., 14., 50.}, []int{5, 10}},
+}
+
+func TestChangePoint(t *testing.T) {
+	for _, test := range changePointTests {
+		expr, err := Parse("ChangePoint(v, 4, 4)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fired := []int{}
+		for ndx, value := range test.values {
+			result, err := expr.Evaluate(map[string]interface{}{"v": value})
+			if err != nil {
+				t.Fatalf("%v: %v", test.values, err)
+			}
+			if result == true {
+				fired = append(fired, ndx)
+			}
+		}
+		if !reflect.DeepEqual(fired, test.fired) {
+			t.Errorf("For %v, expected to fire on %v, fired on %v", test.values, test.fired, fired)
+		}
+	}
+
+	expr, _ := Parse("ChangePoint(v, 4, 4)")
+	if _, err := expr.Evaluate(map[string]interface{}{"v": "1"}); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected a type mismatch for a string, got %v", err)
+	}
+	expr, _ = Parse("ChangePoint(v, 4, 0)")
+	if _, err := expr.Evaluate(map[string]interface{}{"v": 1.}); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected a type mismatch for a zero sensitivity, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package oxweb
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strconv"
+)
+
+// Frames with checksums end in a tab and the CRC-32 (IEEE) of the rest of the
+// line as 8 hex digits, so truncated or mangled lines can be told apart from
+// ones that merely fail to decode.
+const checksumSuffixLen = 9
+
+// AppendChecksum adds the checksum suffix to a line, without its newline.
+func AppendChecksum(line []byte) []byte {
+	return append(line, fmt.Sprintf("\t%08x", crc32.ChecksumIEEE(line))...)
+}
+
+// VerifyChecksum checks a line's checksum suffix and returns the line
+// without it.
+func VerifyChecksum(line []byte) (payload []byte, err error) {
+	if len(line) < checksumSuffixLen || line[len(line)-checksumSuffixLen] != '\t' {
+		return nil, fmt.Errorf("%w: Line has no checksum", ErrChecksum)
+	}
+	payload = line[:len(line)-checksumSuffixLen]
+	expected, err := strconv.ParseUint(string(line[len(line)-checksumSuffixLen+1:]), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: Bad checksum %q", ErrChecksum, line[len(line)-checksumSuffixLen+1:])
+	}
+	if actual := crc32.ChecksumIEEE(payload); actual != uint32(expected) {
+		return nil, fmt.Errorf("%w: Checksum %08x doesn't match line's %08x", ErrChecksum, expected, actual)
+	}
+	return payload, nil
+}
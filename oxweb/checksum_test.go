@@ -0,0 +1,25 @@
+package oxweb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	line := AppendChecksum([]byte(`{"n": 1}`))
+	payload, err := VerifyChecksum(line)
+	if err != nil || string(payload) != `{"n": 1}` {
+		t.Errorf("Expected the line back, got %q, %v", payload, err)
+	}
+
+	for _, corrupt := range []string{
+		`{"n": 1}`,
+		string(line[:5]) + string(line[6:]),
+		`{"n": 2}` + string(line[len(line)-9:]),
+		`{"n": 1}` + "\tzzzzzzzz",
+	} {
+		if _, err := VerifyChecksum([]byte(corrupt)); !errors.Is(err, ErrChecksum) {
+			t.Errorf("Expected ErrChecksum for %q, got %v", corrupt, err)
+		}
+	}
+}
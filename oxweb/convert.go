@@ -0,0 +1,182 @@
+package oxweb
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// unit is a unit of measure: its kind, so only like units are converted, and
+// how many of the kind's base unit it's worth.
+type unit struct {
+	kind  string
+	scale float64
+}
+
+var units = map[string]unit{
+	"ns":  {"time", 1e-9},
+	"us":  {"time", 1e-6},
+	"ms":  {"time", 1e-3},
+	"s":   {"time", 1},
+	"m":   {"time", 60},
+	"min": {"time", 60},
+	"h":   {"time", 3600},
+	"d":   {"time", 86400},
+
+	"B":   {"bytes", 1},
+	"KB":  {"bytes", 1e3},
+	"MB":  {"bytes", 1e6},
+	"GB":  {"bytes", 1e9},
+	"TB":  {"bytes", 1e12},
+	"KiB": {"bytes", 1 << 10},
+	"MiB": {"bytes", 1 << 20},
+	"GiB": {"bytes", 1 << 30},
+	"TiB": {"bytes", 1 << 40},
+
+	"ratio":   {"percent", 1},
+	"percent": {"percent", 0.01},
+	"%":       {"percent", 0.01},
+}
+
+// ByteSize is a number of bytes, the value of a size literal such as 10MB or
+// 4KiB. Like a time.Duration from a duration literal, it's a number wherever
+// one's expected, and Convert knows what unit it's in.
+type ByteSize int64
+
+func (b ByteSize) String() string {
+	return strconv.FormatInt(int64(b), 10) + "B"
+}
+
+var quantityPattern = regexp.MustCompile(`^(-?[0-9]+(?:\.[0-9]+)?)([A-Za-z]+)$`)
+
+// parseQuantity parses a duration literal, e.g. 250ms, 1h30m or 2d, as a
+// time.Duration, or a size literal, e.g. 10MB or 4KiB, as a ByteSize.
+func parseQuantity(literal string) (value interface{}, ok bool) {
+	if d, err := time.ParseDuration(literal); err == nil {
+		return d, true
+	}
+	match := quantityPattern.FindStringSubmatch(literal)
+	if match == nil {
+		return nil, false
+	}
+	number, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return nil, false
+	}
+	u, ok := units[match[2]]
+	switch {
+	case !ok:
+		return nil, false
+	case u.kind == "time":
+		// Units time.ParseDuration doesn't know, days and min.
+		return time.Duration(number * u.scale * float64(time.Second)), true
+	case u.kind == "bytes":
+		bytes := number * u.scale
+		if bytes != math.Trunc(bytes) || math.Abs(bytes) >= math.MaxInt64 {
+			return nil, false
+		}
+		return ByteSize(bytes), true
+	}
+	return nil, false
+}
+
+// quantityUnit returns the unit a duration or size is in, as toFloat
+// converts it: seconds or bytes.
+func quantityUnit(value interface{}) (name string, ok bool) {
+	switch value.(type) {
+	case time.Duration:
+		return "s", true
+	case ByteSize:
+		return "B", true
+	}
+	return "", false
+}
+
+func lookupUnit(name interface{}) (u unit, err error) {
+	s, ok := name.(string)
+	if !ok {
+		return u, fmt.Errorf("%w: Expected a unit name, got %T, %v", ErrTypeMismatch, name, name)
+	}
+	if u, ok = units[s]; !ok {
+		return u, fmt.Errorf("%v is not a supported unit", s)
+	}
+	return u, nil
+}
+
+/*
+ * Convert(expr, fromUnit, toUnit) -> float64
+ * Convert(quantity, toUnit) -> float64
+ *
+ * Converts a number between units of time (ns, us, ms, s, m, h, d), bytes (B,
+ * KB, MB, GB, TB and KiB, MiB, GiB, TiB) or percent (ratio, percent or %), so
+ * fields reported in different units by different services can be compared.
+ * e.g. Convert(latency_ms, "ms", "s")
+ *
+ * Durations and sizes, such as 5m or 10MB, already know their unit, so only
+ * the unit to convert to is given. e.g. Convert(5m, "s")
+ */
+type Convert struct {
+	expr Expression
+	from Expression
+	to   Expression
+}
+
+func (c *Convert) Setup(fname string, args []Expression) (err error) {
+	switch len(args) {
+	case 2:
+		c.expr, c.to = args[0], args[1]
+	case 3:
+		c.expr, c.from, c.to = args[0], args[1], args[2]
+	default:
+		return fmt.Errorf("Convert expects an expression, the unit it's in and the unit to convert it to")
+	}
+	return nil
+}
+
+func (c *Convert) Evaluate(data JSONData) (result interface{}, err error) {
+	value, err := c.expr.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	number, ok := toFloat(value)
+	if !ok {
+		return nil, fmt.Errorf("%w: Convert expects a number, got %T, %v", ErrTypeMismatch, value, value)
+	}
+
+	var fromName interface{}
+	if c.from == nil {
+		if fromName, ok = quantityUnit(value); !ok {
+			return nil, fmt.Errorf("%w: Convert expects the unit %v is in", ErrTypeMismatch, value)
+		}
+	} else if fromName, err = c.from.Evaluate(data); err != nil {
+		return nil, err
+	}
+	from, err := lookupUnit(fromName)
+	if err != nil {
+		return nil, err
+	}
+	toName, err := c.to.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	to, err := lookupUnit(toName)
+	if err != nil {
+		return nil, err
+	}
+	if from.kind != to.kind {
+		return nil, fmt.Errorf("Can't Convert %v (%v) to %v (%v)", fromName, from.kind, toName, to.kind)
+	}
+	return number * from.scale / to.scale, nil
+}
+
+func (c *Convert) String() string {
+	if c.from == nil {
+		return fmt.Sprintf("Convert(%v,%v)", c.expr, c.to)
+	}
+	return fmt.Sprintf("Convert(%v,%v,%v)", c.expr, c.from, c.to)
+}
@@ -0,0 +1,106 @@
+package oxweb
+
+import (
+	"testing"
+	"time"
+)
+
+var convertTests = []struct {
+	statement string
+	expected  interface{}
+	ok        bool
+}{
+	{`Convert(latency, "ms", "s")`, 1.5, true},
+	{`Convert(2, "h", "min")`, 120., true},
+	{`Convert(size, "B", "KiB")`, 2., true},
+	{`Convert(3, "GiB", "MiB")`, 3072., true},
+	{`Convert(0.25, "ratio", "%")`, 25., true},
+	{`Convert(missing, "ms", "s")`, nil, true},
+	{`Convert(latency, "ms", "KiB")`, nil, false},
+	{`Convert(latency, "ms", "fortnights")`, nil, false},
+	{`Convert(host, "ms", "s")`, nil, false},
+	{`Convert(250ms, "s")`, 0.25, true},
+	{`Convert(2d, "h")`, 48., true},
+	{`Convert(4KiB, "B")`, 4096., true},
+	{`Convert(10MB, "KB")`, 10000., true},
+	{`Convert(10MB, "s")`, nil, false},
+	{`Convert(latency, "s")`, nil, false},
+}
+
+func TestConvert(t *testing.T) {
+	data := map[string]interface{}{"latency": 1500., "size": 2048., "host": "web1"}
+	for _, test := range convertTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatalf("For statement %q, unexpected parse error %v", test.statement, err)
+		}
+		result, err := expr.Evaluate(data)
+		if test.ok != (err == nil) {
+			t.Errorf("For statement %q, unexpected error %v", test.statement, err)
+			continue
+		}
+		if test.ok && result != test.expected {
+			t.Errorf("For statement %q, expected %v, got %v", test.statement, test.expected, result)
+		}
+	}
+}
+
+var quantityTests = []struct {
+	literal  string
+	expected interface{}
+}{
+	{"250ms", 250 * time.Millisecond},
+	{"5m", 5 * time.Minute},
+	{"1h30m", 90 * time.Minute},
+	{"2d", 48 * time.Hour},
+	{"5min", 5 * time.Minute},
+	{"-5m", -5 * time.Minute},
+	{"10MB", ByteSize(10000000)},
+	{"4KiB", ByteSize(4096)},
+	{"1.5KiB", ByteSize(1536)},
+	{"0.5B", nil},
+	{"5fortnights", nil},
+	{"MB", nil},
+}
+
+func TestParseQuantity(t *testing.T) {
+	for _, test := range quantityTests {
+		l, err := ParseLiteral(test.literal)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("For %q, expected an error, got %T, %v", test.literal, l.value, l.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("For %q, unexpected error %v", test.literal, err)
+			continue
+		}
+		if l.value != test.expected {
+			t.Errorf("For %q, expected %v, got %v", test.literal, test.expected, l.value)
+		}
+
+		// Literals print as something that parses back to the same value.
+		again, err := ParseLiteral(l.String())
+		if err != nil || again.value != l.value {
+			t.Errorf("For %q, %v parsed back as %v, %v", test.literal, l, again, err)
+		}
+	}
+}
+
+func TestQuantitiesAsNumbers(t *testing.T) {
+	for statement, expected := range map[string]interface{}{
+		`Add(latency, 250ms)`: 1.75,
+		`Max(latency, 1m)`:    60.,
+		`Sum(size, 1KiB)`:     3072.,
+	} {
+		expr, err := Parse(statement)
+		if err != nil {
+			t.Fatalf("For statement %q, unexpected parse error %v", statement, err)
+		}
+		result, err := expr.Evaluate(map[string]interface{}{"latency": 1.5, "size": 2048.})
+		if err != nil || result != expected {
+			t.Errorf("For statement %q, expected %v, got %v, %v", statement, expected, result, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package oxweb
+
+import (
+	"strings"
+)
+
+// deepCopy returns a copy of data sharing nothing modifiable with it.
+func deepCopy(data JSONData) JSONData {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			copied[k] = deepCopy(v)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for ndx, v := range value {
+			copied[ndx] = deepCopy(v)
+		}
+		return copied
+	case *Envelope:
+		copied := *value
+		return &copied
+	}
+	return data
+}
+
+// cowEvent edits an event copy-on-write, so the common case of a subscriber
+// seeing the event unchanged costs nothing and editing several paths copies
+// each map along them once. Maps are copied the first time a path through
+// them is edited; everything else stays shared with the original event, which
+// is never modified.
+type cowEvent struct {
+	data JSONData
+	// The paths of the maps already copied, "" for the top level.
+	copied map[string]bool
+}
+
+// own returns a map at prefix that's safe to modify, copying it if it's still
+// shared with the original event.
+func (c *cowEvent) own(prefix string, object map[string]interface{}) map[string]interface{} {
+	if c.copied[prefix] {
+		return object
+	}
+	copied := make(map[string]interface{}, len(object))
+	for k, v := range object {
+		copied[k] = v
+	}
+	if c.copied == nil {
+		c.copied = make(map[string]bool)
+	}
+	c.copied[prefix] = true
+	return copied
+}
+
+// edit finds the map holding the last key of path, copying the maps leading
+// to it, and passes it to change. Returns false, leaving the event as it was,
+// if path doesn't lead through objects.
+func (c *cowEvent) edit(path string, change func(object map[string]interface{}, key string)) bool {
+	keys := strings.Split(path, ".")
+
+	// Check the whole path first so a failed edit copies nothing.
+	step := c.data
+	for _, key := range keys[:len(keys)-1] {
+		object, ok := step.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		step = object[key]
+	}
+	if _, ok := step.(map[string]interface{}); !ok {
+		return false
+	}
+
+	root := c.own("", c.data.(map[string]interface{}))
+	c.data = root
+	object, prefix := root, ""
+	for _, key := range keys[:len(keys)-1] {
+		prefix = joinPath(prefix, key)
+		child := c.own(prefix, object[key].(map[string]interface{}))
+		object[key] = child
+		object = child
+	}
+	change(object, keys[len(keys)-1])
+	return true
+}
+
+// set sets the value at the GetDeep path, which must lead through objects.
+func (c *cowEvent) set(path string, value interface{}) bool {
+	return c.edit(path, func(object map[string]interface{}, key string) { object[key] = value })
+}
+
+// remove deletes the value at the GetDeep path, which must lead through
+// objects.
+func (c *cowEvent) remove(path string) bool {
+	return c.edit(path, func(object map[string]interface{}, key string) { delete(object, key) })
+}
@@ -0,0 +1,106 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCOWEvent(t *testing.T) {
+	var original, event JSONData
+	json.Unmarshal([]byte(accessJSON), &original)
+	json.Unmarshal([]byte(accessJSON), &event)
+
+	cow := &cowEvent{data: event}
+	if !cow.set("user.email", "x") || !cow.remove("user.id") || !cow.set("status", 500.) {
+		t.Fatalf("Expected the edits to succeed")
+	}
+	if cow.set("items.0.card", "x") || cow.remove("status.code") {
+		t.Errorf("Expected edits through arrays and values to fail")
+	}
+	if !reflect.DeepEqual(event, original) {
+		t.Errorf("The original event was modified: %v", event)
+	}
+	if len(cow.copied) != 2 {
+		t.Errorf("Expected the top level and user to be copied once each, got %v", cow.copied)
+	}
+	user := cow.data.(map[string]interface{})["user"].(map[string]interface{})
+	if user["email"] != "x" || user["id"] != nil || cow.data.(map[string]interface{})["status"] != 500. {
+		t.Errorf("Unexpected result %v", cow.data)
+	}
+
+	// Untouched parts are still shared.
+	address := user["address"].(map[string]interface{})
+	address["city"] = "LA"
+	if city, _ := GetDeep("user.address.city", event); city != "LA" {
+		t.Errorf("Expected user.address to be shared with the original")
+	}
+}
+
+// benchmarkSubscribers delivers an event to 50 subscribers the way a
+// DataStream does.
+func benchmarkSubscribers(b *testing.B, newRequest func() *SubscribeRequest) {
+	var event JSONData
+	json.Unmarshal([]byte(accessJSON), &event)
+	requests := make([]*SubscribeRequest, 50)
+	for ndx := range requests {
+		requests[ndx] = newRequest()
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, request := range requests {
+			if len(request.prepare([]JSONData{event})) != 1 {
+				b.Fatal("Expected the event to be delivered")
+			}
+		}
+	}
+}
+
+func BenchmarkDeliver50Subscribers(b *testing.B) {
+	redact, _ := NewRedact([]string{"user.email", "user.address.city"}, nil)
+	for _, bench := range []struct {
+		name    string
+		request func() *SubscribeRequest
+	}{
+		{"shared", func() *SubscribeRequest { return &SubscribeRequest{} }},
+		{"deny", func() *SubscribeRequest {
+			return &SubscribeRequest{Access: &FieldAccess{Deny: []string{"user.email", "user.address"}}}
+		}},
+		{"deny-absent", func() *SubscribeRequest {
+			return &SubscribeRequest{Access: &FieldAccess{Deny: []string{"user.phone"}}}
+		}},
+		{"allow", func() *SubscribeRequest {
+			return &SubscribeRequest{Access: &FieldAccess{Allow: []string{"status", "user.id"}}}
+		}},
+		{"redact", func() *SubscribeRequest { return &SubscribeRequest{Stages: []Stage{redact}} }},
+		{"deep-copy", func() *SubscribeRequest { return &SubscribeRequest{DeepCopy: true} }},
+	} {
+		b.Run(bench.name, func(b *testing.B) { benchmarkSubscribers(b, bench.request) })
+	}
+}
+
+func TestSubscriberDeepCopy(t *testing.T) {
+	var event JSONData
+	json.Unmarshal([]byte(accessJSON), &event)
+	event.(map[string]interface{})[MetaKey] = &Envelope{Source: "web"}
+
+	request := &SubscribeRequest{DeepCopy: true}
+	copied := request.prepare([]JSONData{event})[0]
+	if !reflect.DeepEqual(copied, event) {
+		t.Fatalf("Expected an equal copy, got %v", copied)
+	}
+	copied.(map[string]interface{})["user"].(map[string]interface{})["id"] = 8.
+	copied.(map[string]interface{})["items"].([]interface{})[0] = nil
+	copied.(map[string]interface{})[MetaKey].(*Envelope).Source = "api"
+	if id, _ := GetDeep("user.id", event); id != 7. {
+		t.Errorf("Modifying the copy modified the original: %v", event)
+	}
+	if item, _ := GetDeep("items.0", event); item == nil {
+		t.Errorf("Modifying the copy modified the original: %v", event)
+	}
+	if envelope, _ := eventEnvelope(event); envelope.Source != "web" {
+		t.Errorf("Modifying the copy's envelope modified the original")
+	}
+}
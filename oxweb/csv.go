@@ -0,0 +1,198 @@
+package oxweb
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CSVEncoder writes query records as CSV or TSV rows, with a header row taken
+// from the first record's field names (As aliases or expressions). Object
+// values are flattened into one column per GetDeep path, so {"p50": 1,
+// "p95": 2} under "latency" becomes columns latency.p50 and latency.p95.
+// Arrays are written as JSON. Later records fill the same columns; values for
+// columns the first record didn't have are dropped.
+//
+// Given the query's ResultSchema with SetSchema, the header is written
+// straight away instead, so even a query emitting nothing has one, as long as
+// every field is a number, string or bool. Fields that may be objects need a
+// record to find their columns.
+//
+// CSVEncoder is a Sink, so it can be used wherever emissions are delivered.
+type CSVEncoder struct {
+	writer  *csv.Writer
+	columns []string
+}
+
+func NewCSVEncoder(w io.Writer) *CSVEncoder {
+	return &CSVEncoder{writer: csv.NewWriter(w)}
+}
+
+func NewTSVEncoder(w io.Writer) *CSVEncoder {
+	e := NewCSVEncoder(w)
+	e.writer.Comma = '\t'
+	return e
+}
+
+// SetSchema writes the header for records with the given fields, if they're
+// all of scalar types; see CSVEncoder. It must be called before Write.
+func (e *CSVEncoder) SetSchema(fields []ResultField) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	columns := make([]string, len(fields))
+	for ndx, field := range fields {
+		switch field.Type {
+		case TypeNumber, TypeString, TypeBool:
+			columns[ndx] = field.Name
+		default:
+			return nil
+		}
+	}
+	e.columns = columns
+	if err := e.writer.Write(e.columns); err != nil {
+		return err
+	}
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+func (e *CSVEncoder) Write(record JSONData) (err error) {
+	row, err := flattenRecord(record)
+	if err != nil {
+		return err
+	}
+
+	if e.columns == nil {
+		for column := range row {
+			e.columns = append(e.columns, column)
+		}
+		sortColumns(e.columns, record)
+		if err := e.writer.Write(e.columns); err != nil {
+			return err
+		}
+	}
+
+	fields := make([]string, len(e.columns))
+	for ndx, column := range e.columns {
+		fields[ndx] = row[column]
+	}
+	if err := e.writer.Write(fields); err != nil {
+		return err
+	}
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+func (e *CSVEncoder) Close() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// flattenRecord turns a record of [name, value] pairs into column values.
+func flattenRecord(record JSONData) (row map[string]string, err error) {
+	fields, err := recordFields(record)
+	if err != nil {
+		return nil, err
+	}
+	row = make(map[string]string)
+	for name, value := range fields {
+		value, err := normalizeJSON(value)
+		if err != nil {
+			return nil, err
+		}
+		flattenValue(name, value, row)
+	}
+	return row, nil
+}
+
+func flattenValue(prefix string, value interface{}, row map[string]string) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, element := range value {
+			flattenValue(prefix+"."+key, element, row)
+		}
+	case nil:
+		row[prefix] = ""
+	case string:
+		row[prefix] = value
+	case float64:
+		row[prefix] = strconv.FormatFloat(value, 'g', -1, 64)
+	case bool:
+		row[prefix] = strconv.FormatBool(value)
+	default:
+		encoded, _ := json.Marshal(value)
+		row[prefix] = string(encoded)
+	}
+}
+
+// normalizeJSON converts value to the generic types encoding/json decodes to,
+// so results like GroupResult can be flattened like any other object.
+func normalizeJSON(value interface{}) (normalized interface{}, err error) {
+	if isGenericJSON(value) {
+		return value, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(encoded, &normalized)
+	return normalized, err
+}
+
+// isGenericJSON reports whether value is made only of the types encoding/json
+// decodes to, json.Numbers, as NumberFormat leaves them, or DecodeExact's
+// integers.
+func isGenericJSON(value interface{}) bool {
+	switch value := value.(type) {
+	case nil, string, float64, bool, json.Number, int64, uint64:
+		return true
+	case []interface{}:
+		for _, element := range value {
+			if !isGenericJSON(element) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		for _, element := range value {
+			if !isGenericJSON(element) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// sortColumns orders columns by the position of their field in the record,
+// then by path within the field.
+func sortColumns(columns []string, record JSONData) {
+	position := make(map[string]int)
+	for ndx, pair := range record.([]interface{}) {
+		position[fmt.Sprint(pair.([]interface{})[0])] = ndx
+	}
+	fieldOf := func(column string) int {
+		for prefix := column; ; {
+			if ndx, ok := position[prefix]; ok {
+				return ndx
+			}
+			dot := strings.LastIndex(prefix, ".")
+			if dot < 0 {
+				return len(position)
+			}
+			prefix = prefix[:dot]
+		}
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		fi, fj := fieldOf(columns[i]), fieldOf(columns[j])
+		if fi != fj {
+			return fi < fj
+		}
+		return columns[i] < columns[j]
+	})
+}
@@ -0,0 +1,41 @@
+package oxweb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCSVEncoder(t *testing.T) {
+	var out bytes.Buffer
+	encoder := NewTSVEncoder(&out)
+
+	records := []JSONData{
+		[]interface{}{
+			[]interface{}{"host", "web1"},
+			[]interface{}{"latency", map[string]interface{}{"p95": 12.5, "p50": 3.}},
+			[]interface{}{"codes", []interface{}{200., 404.}},
+		},
+		[]interface{}{
+			[]interface{}{"host", "web2"},
+			[]interface{}{"latency", map[string]interface{}{"p50": 4.}},
+			[]interface{}{"codes", nil},
+		},
+	}
+	for _, record := range records {
+		if err := encoder.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	encoder.Close()
+
+	expected := "host\tlatency.p50\tlatency.p95\tcodes\n" +
+		"web1\t3\t12.5\t[200,404]\n" +
+		"web2\t4\t\t\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	if err := encoder.Write("not a record"); err == nil {
+		t.Errorf("Expected an error writing something other than a record")
+	}
+}
@@ -0,0 +1,184 @@
+package oxweb
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+/*
+ * Total(expr) -> float64
+ *
+ * The sum of the value over every event so far. Nil values, as from missing
+ * fields, are skipped.
+ */
+type Total struct {
+	expr Expression
+	sum  compensatedSum
+}
+
+func (t *Total) Setup(fname string, args []Expression) (err error) {
+	if err = checkArity(fname, args, 1, 1); err != nil {
+		return err
+	}
+	t.expr = args[0]
+	return nil
+}
+
+func (t *Total) Evaluate(data JSONData) (result interface{}, err error) {
+	value, err := t.expr.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		v, ok := toFloat(value)
+		if !ok {
+			return nil, fmt.Errorf("%w: Total expects a number, got %T, %v", ErrTypeMismatch, value, value)
+		}
+		t.sum.Add(v)
+	}
+	return t.sum.Value(), nil
+}
+
+func (t *Total) String() string {
+	return fmt.Sprintf("Total(%v)", t.expr)
+}
+
+// distinctPrecision is log2 of the number of HyperLogLog registers, giving a
+// standard error of 1.04/sqrt(4096), about 1.6%, in 4KB per aggregate.
+const distinctPrecision = 12
+
+/*
+ * CountDistinct(expr) -> int
+ *
+ * An estimate of the number of distinct values seen so far, by HyperLogLog.
+ * Memory is fixed however many values there are; small counts are close to
+ * exact and large ones within a few percent. Nil values aren't counted.
+ */
+type CountDistinct struct {
+	expr      Expression
+	registers []uint8
+}
+
+func (c *CountDistinct) Setup(fname string, args []Expression) (err error) {
+	if err = checkArity(fname, args, 1, 1); err != nil {
+		return err
+	}
+	c.expr = args[0]
+	c.registers = make([]uint8, 1<<distinctPrecision)
+	return nil
+}
+
+func (c *CountDistinct) Evaluate(data JSONData) (result interface{}, err error) {
+	value, err := c.expr.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		c.add(hashKey(value))
+	}
+	return c.estimate(), nil
+}
+
+func (c *CountDistinct) add(key string) {
+	digest := fnv.New64a()
+	digest.Write([]byte(key))
+	h := mix64(digest.Sum64())
+	register := h >> (64 - distinctPrecision)
+	rank := uint8(bits.LeadingZeros64(h<<distinctPrecision|1<<(distinctPrecision-1)) + 1)
+	if rank > c.registers[register] {
+		c.registers[register] = rank
+	}
+}
+
+// estimate is the HyperLogLog estimate, switching to linear counting of the
+// empty registers while there are enough of them to be more accurate.
+func (c *CountDistinct) estimate() int {
+	m := float64(len(c.registers))
+	sum, empty := 0., 0
+	for _, rank := range c.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			empty++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && empty > 0 {
+		estimate = m * math.Log(m/float64(empty))
+	}
+	return int(math.Round(estimate))
+}
+
+// mix64 spreads FNV's bits over the whole word, as HyperLogLog takes the
+// register from the top bits and FNV's top bits vary little between short,
+// similar keys.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func (c *CountDistinct) String() string {
+	return fmt.Sprintf("CountDistinct(%v)", c.expr)
+}
+
+/*
+ * Percentile(expr, p) -> float64
+ *
+ * The pth percentile (0 to 100) of every value so far, e.g. Percentile(latency,
+ * 99). Values are counted in the same log-linear histogram as
+ * WindowPercentile's "hdr" strategy, so memory is proportional to the range of
+ * values rather than their number and the relative error is under 1%.
+ */
+type Percentile struct {
+	expr      Expression
+	p         Expression
+	histogram *histogramPercentiles
+}
+
+func (p *Percentile) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 {
+		return fmt.Errorf("Percentile expects an expression and a percentile")
+	}
+	p.expr, p.p = args[0], args[1]
+	p.histogram = newHistogramPercentiles()
+	return nil
+}
+
+func (p *Percentile) Evaluate(data JSONData) (result interface{}, err error) {
+	value, err := p.expr.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		v, ok := toFloat(value)
+		if !ok {
+			return nil, fmt.Errorf("%w: Percentile expects a number, got %T, %v", ErrTypeMismatch, value, value)
+		}
+		p.histogram.Add(v)
+	}
+	return p.quantile(data)
+}
+
+func (p *Percentile) quantile(data JSONData) (result interface{}, err error) {
+	percent, err := p.p.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	percentile, ok := toFloat(percent)
+	if !ok || percentile < 0 || percentile > 100 {
+		return nil, fmt.Errorf("%w: Percentile expects a percentile between 0 and 100. Got a %T, %v", ErrTypeMismatch, percent, percent)
+	}
+	if p.histogram.Count() == 0 {
+		return nil, fmt.Errorf("%w: Percentile has no values", ErrWindowEmpty)
+	}
+	return p.histogram.Quantile(percentile / 100), nil
+}
+
+func (p *Percentile) String() string {
+	return fmt.Sprintf("Percentile(%v,%v)", p.expr, p.p)
+}
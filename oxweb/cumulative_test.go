@@ -0,0 +1,68 @@
+package oxweb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestTotal(t *testing.T) {
+	total, _ := Parse("Total(v)")
+	var result interface{}
+	for _, event := range []JSONData{
+		map[string]interface{}{"v": 1.5},
+		map[string]interface{}{},
+		map[string]interface{}{"v": 2.},
+	} {
+		result, _ = total.Evaluate(event)
+	}
+	if result != 3.5 {
+		t.Errorf("Expected 3.5, got %v", result)
+	}
+	if _, err := total.Evaluate(map[string]interface{}{"v": "x"}); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected ErrTypeMismatch for a string, got %v", err)
+	}
+}
+
+type countDistinctTest struct {
+	distinct int
+	// tolerance is the relative error allowed.
+	tolerance float64
+}
+
+var countDistinctTests = []countDistinctTest{
+	countDistinctTest{0, 0},
+	countDistinctTest{10, 0},
+	countDistinctTest{1000, 0.02},
+	countDistinctTest{100000, 0.05},
+}
+
+func TestCountDistinct(t *testing.T) {
+	for _, test := range countDistinctTests {
+		distinct, _ := Parse("CountDistinct(id)")
+		result, _ := distinct.Evaluate(map[string]interface{}{})
+		for repeat := 0; repeat < 2; repeat++ {
+			for ndx := 0; ndx < test.distinct; ndx++ {
+				result, _ = distinct.Evaluate(map[string]interface{}{"id": fmt.Sprintf("user%d", ndx)})
+			}
+		}
+		if math.Abs(float64(result.(int)-test.distinct)) > test.tolerance*float64(test.distinct) {
+			t.Errorf("Expected about %d distinct values, got %v", test.distinct, result)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	percentile, _ := Parse("Percentile(v, 50)")
+	if _, err := percentile.Evaluate(map[string]interface{}{}); !errors.Is(err, ErrWindowEmpty) {
+		t.Errorf("Expected ErrWindowEmpty before any values, got %v", err)
+	}
+	var result interface{}
+	for v := 1; v <= 99; v++ {
+		result, _ = percentile.Evaluate(map[string]interface{}{"v": float64(v)})
+	}
+	if math.Abs(result.(float64)-50) > 0.5 {
+		t.Errorf("Expected a median of about 50, got %v", result)
+	}
+}
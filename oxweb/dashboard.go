@@ -0,0 +1,210 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+)
+
+// Dashboard is an http.Handler serving a minimal standalone UI: the streams
+// being read with their Stats, the named queries in Store, and a composer for
+// running a query and charting its results live over WebSocketPath. Mount it
+// under a prefix with http.StripPrefix.
+type Dashboard struct {
+	// Streams returns each open stream's Stats, by name.
+	Streams       func() map[string]Stats
+	Store         *RingStore
+	WebSocketPath string
+	// ResultsPath is where Store's range endpoint is served, for charting a
+	// named query's history.
+	ResultsPath string
+	// ExplainPath, if set, is where a query's Plan is served, whose Results
+	// pick the field charted. Otherwise the first numeric value is.
+	ExplainPath string
+}
+
+func (d *Dashboard) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	switch request.URL.Path {
+	case "", "/":
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		dashboardTemplate.Execute(writer, d)
+	case "/status":
+		status := map[string]interface{}{"streams": map[string]Stats{}, "queries": []string{}}
+		if d.Streams != nil {
+			status["streams"] = d.Streams()
+		}
+		if d.Store != nil {
+			queries := d.Store.Queries()
+			sort.Strings(queries)
+			status["queries"] = queries
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(status)
+	default:
+		http.NotFound(writer, request)
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>oxweb</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 8px; text-align: left; }
+textarea { width: 40em; height: 4em; }
+canvas { border: 1px solid #ccc; }
+#log { font-family: monospace; white-space: pre; height: 12em; overflow: auto; }
+</style>
+</head>
+<body>
+<h1>oxweb</h1>
+
+<h2>Streams</h2>
+<table id="streams"></table>
+
+<h2>Queries</h2>
+<ul id="queries"></ul>
+
+<h2>Run a query</h2>
+<p>Stream <input id="logName" value="ranger"></p>
+<p>Fields, one per line<br><textarea id="fields"></textarea></p>
+<p>Filters, one per line<br><textarea id="filters"></textarea></p>
+<p><button id="run">Run</button> <button id="stop">Stop</button></p>
+<canvas id="chart" width="800" height="200"></canvas>
+<div id="log"></div>
+
+<script>
+var wsPath = {{.WebSocketPath}};
+var resultsPath = {{.ResultsPath}};
+var explainPath = {{.ExplainPath}};
+var points = [];
+var socket = null;
+// The index of the field to chart, from the query's result schema.
+var plotField = -1;
+
+function lines(id) {
+	return document.getElementById(id).value.split("\n").filter(function(l) { return l.trim() != ""; });
+}
+
+// Plot the number field of each record, or failing that the first numeric
+// value.
+function plot(record) {
+	if (plotField >= 0) {
+		if (plotField < record.length && typeof record[plotField][1] == "number") {
+			points.push(record[plotField][1]);
+		}
+	} else {
+		for (var i = 0; i < record.length; i++) {
+			if (typeof record[i][1] == "number") {
+				points.push(record[i][1]);
+				break;
+			}
+		}
+	}
+	points = points.slice(-400);
+
+	var canvas = document.getElementById("chart");
+	var ctx = canvas.getContext("2d");
+	ctx.clearRect(0, 0, canvas.width, canvas.height);
+	var min = Math.min.apply(null, points), max = Math.max.apply(null, points);
+	var scale = max > min ? (canvas.height - 10) / (max - min) : 1;
+	ctx.beginPath();
+	points.forEach(function(v, x) {
+		var y = canvas.height - 5 - (v - min) * scale;
+		x = x * canvas.width / 400;
+		if (x == 0) { ctx.moveTo(x, y); } else { ctx.lineTo(x, y); }
+	});
+	ctx.stroke();
+}
+
+function show(record) {
+	var log = document.getElementById("log");
+	log.textContent = JSON.stringify(record) + "\n" + log.textContent.slice(0, 20000);
+	plot(record);
+}
+
+function stop() {
+	if (socket) { socket.close(); socket = null; }
+}
+
+// Find the first field the query's result schema says is a number.
+function choosePlotField(fields, filters) {
+	plotField = -1;
+	if (!explainPath) {
+		return;
+	}
+	fetch(explainPath, {method: "POST", body: JSON.stringify({fields: fields, filters: filters})})
+		.then(function(response) { return response.json(); })
+		.then(function(plan) {
+			for (var i = 0; i < plan.results.length; i++) {
+				if (plan.results[i].type == "number") {
+					plotField = i;
+					return;
+				}
+			}
+		});
+}
+
+document.getElementById("run").onclick = function() {
+	stop();
+	points = [];
+	var fields = lines("fields"), filters = lines("filters");
+	choosePlotField(fields, filters);
+	var scheme = location.protocol == "https:" ? "wss://" : "ws://";
+	socket = new WebSocket(scheme + location.host + wsPath);
+	socket.onopen = function() {
+		socket.send(JSON.stringify({
+			logName: document.getElementById("logName").value,
+			fields: fields,
+			filters: filters
+		}) + "\n");
+	};
+	socket.onmessage = function(event) {
+		event.data.split("\n").forEach(function(line) {
+			if (line != "") { show(JSON.parse(line)); }
+		});
+	};
+};
+document.getElementById("stop").onclick = stop;
+
+function history(name) {
+	stop();
+	points = [];
+	plotField = -1;
+	fetch(resultsPath + "?query=" + encodeURIComponent(name))
+		.then(function(response) { return response.json(); })
+		.then(function(stored) { stored.forEach(function(s) { show(s.record); }); });
+}
+
+function refresh() {
+	fetch("status").then(function(response) { return response.json(); }).then(function(status) {
+		var rows = "<tr><th>Stream</th><th>Events</th><th>Subscribers</th><th>Dropped</th><th>Lag (ms)</th></tr>";
+		Object.keys(status.streams).sort().forEach(function(name) {
+			var s = status.streams[name];
+			rows += "<tr><td>" + name + "</td><td>" + s.events + "</td><td>" + s.subscribers +
+				"</td><td>" + s.dropped + "</td><td>" + (s.lag_ms === undefined ? "" : s.lag_ms) + "</td></tr>";
+		});
+		document.getElementById("streams").innerHTML = rows;
+
+		var queries = document.getElementById("queries");
+		queries.innerHTML = "";
+		status.queries.forEach(function(name) {
+			var item = document.createElement("li");
+			var link = document.createElement("a");
+			link.href = "#";
+			link.textContent = name;
+			link.onclick = function() { history(name); return false; };
+			item.appendChild(link);
+			queries.appendChild(item);
+		});
+	});
+}
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`))
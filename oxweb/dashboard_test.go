@@ -0,0 +1,37 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	store := NewRingStore(time.Hour, 10)
+	store.Add("p95", 1.)
+	dashboard := &Dashboard{
+		Streams:       func() map[string]Stats { return map[string]Stats{"ranger": {"events": 3}} },
+		Store:         store,
+		WebSocketPath: "/ws",
+		ResultsPath:   "/results",
+	}
+
+	response := httptest.NewRecorder()
+	dashboard.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(response.Body.String(), `var wsPath = "/ws";`) {
+		t.Errorf("Expected the page to use the WebSocket path")
+	}
+
+	response = httptest.NewRecorder()
+	dashboard.ServeHTTP(response, httptest.NewRequest("GET", "/status", nil))
+	var status struct {
+		Streams map[string]Stats
+		Queries []string
+	}
+	json.NewDecoder(response.Body).Decode(&status)
+	if status.Streams["ranger"]["events"] != 3 || len(status.Queries) != 1 || status.Queries[0] != "p95" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
@@ -3,15 +3,79 @@ package oxweb
 import (
 	"bufio"
 	"container/list"
-	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// A SubscribeRequest subscribes to a Source's events. Events delivered to
+// DataChan are shared with every other subscriber, so they must be treated
+// as read-only: a subscriber that modifies one corrupts it for the rest.
+// Stages follow this rule by copying what they change; see setDeep. Set
+// DeepCopy for a private copy of each event that's safe to modify.
 type SubscribeRequest struct {
 	DataChan chan JSONData
 	id       int
+
+	// Stages applied to events before they are sent to this subscriber only.
+	Stages []Stage
+
+	// If set, events are handed to Acked rather than DataChan, so they're
+	// never dropped for being slow. See AckedSubscriber.
+	Acked *AckedSubscriber
+
+	// If set, limits the parts of events this subscriber sees, before its
+	// Stages are applied.
+	Access *FieldAccess
+
+	// If set, each event is deep copied for this subscriber before its
+	// Stages are applied.
+	DeepCopy bool
+
+	// If set, events are delivered in batches on BatchChan rather than one
+	// at a time on DataChan, saving a channel send and a wakeup for most of
+	// them. A batch is sent once it has BatchSize events, or once its first
+	// is BatchDelay old, and dropped whole if BatchChan is full. Sources
+	// other than this package's may not batch and use DataChan regardless.
+	BatchChan  chan []JSONData
+	BatchSize  int
+	BatchDelay time.Duration
+	batch      batcher
+
+	paused atomic.Bool
+}
+
+// deliver hands a source's events to the subscriber, after prepare: to Acked
+// if it's set, in batches on BatchChan if that's set, or else on DataChan.
+// Sends never block; onDrop is called with the number of events dropped for
+// the subscriber not keeping up.
+func (request *SubscribeRequest) deliver(events []JSONData, onDrop func(events int)) {
+	events = request.prepare(events)
+	switch {
+	case request.Acked != nil:
+		for _, event := range events {
+			if err := request.Acked.enqueue(event); err != nil {
+				log.Printf("Dropping data to channel %d: %v", request.id, err)
+				onDrop(1)
+			}
+		}
+	case request.BatchChan != nil:
+		request.addToBatch(events, onDrop)
+	default:
+		for _, event := range events {
+			// We don't want to be blocking waiting on the channel, if it can't keep up we'll drop the data.
+			select {
+			case request.DataChan <- event:
+			default:
+				log.Println("Dropping data to channel", request.id)
+				onDrop(1)
+			}
+		}
+	}
 }
 
 type DataStream struct {
@@ -29,7 +93,57 @@ type DataStream struct {
 	SubscribeChan   chan *SubscribeRequest
 	UnsubscribeChan chan *SubscribeRequest
 
-	allChannels [](chan JSONData)
+	allSubscribers []*SubscribeRequest
+	// Held while changing allSubscribers or running events through stages
+	// to them, which happens on the read loop and on expiry ticks.
+	deliverLock sync.Mutex
+	// How often stages release events held too long; see ExpiringStage.
+	expiryInterval time.Duration
+
+	// Stages applied to every event before it is delivered to any subscriber.
+	stagesLock sync.Mutex
+	stages     []Stage
+
+	statsLock      sync.Mutex
+	events         int64
+	decodeErrors   int64
+	corruptFrames  int64
+	truncatedLines int64
+	dropped        int64
+	// allSubscribers belongs to the acceptChannels goroutine, so Stats
+	// counts them here.
+	subscribers   int64
+	timestampPath string
+	lastEventTime time.Time
+
+	// Sequence number of the last event read, for its Envelope.
+	seq int64
+
+	gapDetector *GapDetector
+
+	// Whether lines carry checksums to verify.
+	checksums bool
+	// The heartbeat interval we offer, and how long the relay may go quiet
+	// on this connection before it's presumed dead, if heartbeats were
+	// agreed. Closing heartbeatDone stops ours.
+	heartbeat     time.Duration
+	silenceLimit  time.Duration
+	heartbeatDone chan struct{}
+	deadPeers     int64
+	// Whether to decode events with DecodeExact.
+	exactNumbers bool
+
+	// The handshake we offer, or nil for the version 0 protocol, and what was
+	// agreed with the relay.
+	protocolLock sync.Mutex
+	hello        *Hello
+	agreed       Hello
+	filters      []string
+	localFilter  *FilterStage
+	sampler      *Sampler
+
+	// Caps what's read from the relay, if set.
+	limiter *RateLimiter
 }
 
 func NewDataStream(name string, connectString string) (stream *DataStream) {
@@ -41,7 +155,8 @@ func NewDataStream(name string, connectString string) (stream *DataStream) {
 	stream.dataCacheKey = "unique_request_id"
 	stream.SubscribeChan = make(chan *SubscribeRequest)
 	stream.UnsubscribeChan = make(chan *SubscribeRequest)
-	stream.allChannels = make([](chan JSONData), 0, 64)
+	stream.allSubscribers = make([]*SubscribeRequest, 0, 64)
+	stream.expiryInterval = 250 * time.Millisecond
 
 	stream.dataCache = make(map[string]*JSONData, 64)
 
@@ -51,6 +166,117 @@ func NewDataStream(name string, connectString string) (stream *DataStream) {
 	return
 }
 
+// SetTimestampPath sets the GetDeep path of each event's timestamp, which is
+// used to report how far behind the stream is. See Stats.
+func (stream *DataStream) SetTimestampPath(path string) {
+	stream.statsLock.Lock()
+	defer stream.statsLock.Unlock()
+	stream.timestampPath = path
+}
+
+// DetectGaps checks the sequence numbers events carry at path for missing and
+// duplicated ranges, reporting them in Stats and to onGap, if it isn't nil.
+func (stream *DataStream) DetectGaps(path string, onGap func(gap SequenceGap)) {
+	detector := NewGapDetector(path)
+	detector.OnGap = onGap
+
+	stream.statsLock.Lock()
+	defer stream.statsLock.Unlock()
+	stream.gapDetector = detector
+}
+
+func (stream *DataStream) detector() *GapDetector {
+	stream.statsLock.Lock()
+	defer stream.statsLock.Unlock()
+	return stream.gapDetector
+}
+
+// Stats reports:
+//
+//	events          events decoded from the stream
+//	decode_errors   lines that couldn't be decoded
+//	corrupt_frames  lines with a missing or wrong checksum; see VerifyChecksums
+//	truncated_lines lines too long to read
+//	dropped         events dropped because a subscriber wasn't keeping up
+//	dead_peers      connections the relay went silent on, which were replaced; see EnableHeartbeat
+//	subscribers     current number of subscribers
+//
+// and with SetRateLimit:
+//
+//	throttled       events dropped for being over the rate limits
+//
+// and when sampling:
+//
+//	sample_rate_ppm the effective sampling rate, in parts per million
+//
+// and with DetectGaps, those of GapDetector.Stats, and, once a timestamp path is set and an event carrying one has been seen:
+//
+//	event_time    the latest event timestamp, in Unix milliseconds
+//	lag_ms        wall clock time minus event_time
+func (stream *DataStream) Stats() Stats {
+	stream.statsLock.Lock()
+	defer stream.statsLock.Unlock()
+
+	stats := Stats{
+		"events":          stream.events,
+		"decode_errors":   stream.decodeErrors,
+		"corrupt_frames":  stream.corruptFrames,
+		"truncated_lines": stream.truncatedLines,
+		"dropped":         stream.dropped,
+		"dead_peers":      stream.deadPeers,
+		"subscribers":     stream.subscribers,
+	}
+	if stream.gapDetector != nil {
+		for name, value := range stream.gapDetector.Stats() {
+			stats[name] = value
+		}
+	}
+	if stream.limiter != nil {
+		stats["throttled"] = stream.limiter.Throttled()
+	}
+	if stream.sampler != nil {
+		stats["sample_rate_ppm"] = int64(stream.sampler.Rate() * 1e6)
+	}
+	if !stream.lastEventTime.IsZero() {
+		stats["event_time"] = stream.lastEventTime.UnixNano() / int64(time.Millisecond)
+		stats["lag_ms"] = int64(time.Since(stream.lastEventTime) / time.Millisecond)
+	}
+	return stats
+}
+
+// recordEvent updates stats for a decoded event.
+func (stream *DataStream) recordEvent(data JSONData) {
+	stream.statsLock.Lock()
+	defer stream.statsLock.Unlock()
+	stream.events++
+	if stream.timestampPath == "" {
+		return
+	}
+	if value, ok := GetDeep(stream.timestampPath, data); ok {
+		if eventTime, ok := toTime(value); ok && eventTime.After(stream.lastEventTime) {
+			stream.lastEventTime = eventTime
+		}
+	}
+}
+
+func (stream *DataStream) countStat(stat *int64) {
+	stream.statsLock.Lock()
+	defer stream.statsLock.Unlock()
+	*stat++
+}
+
+// droppedEvents counts events a subscriber wasn't keeping up with.
+func (stream *DataStream) droppedEvents(events int) {
+	stream.statsLock.Lock()
+	stream.dropped += int64(events)
+	stream.statsLock.Unlock()
+	if stream.sampler != nil {
+		for i := 0; i < events; i++ {
+			stream.sampler.Dropped()
+		}
+	}
+}
+
 func (stream *DataStream) acceptChannels() {
 	for {
 
@@ -64,30 +290,63 @@ func (stream *DataStream) acceptChannels() {
 }
 
 func (stream *DataStream) subscribe(request *SubscribeRequest) {
+	stream.deliverLock.Lock()
 	request.id = -1
-	for ndx, value := range stream.allChannels {
+	for ndx, value := range stream.allSubscribers {
 		if value == nil {
-			stream.allChannels[ndx] = request.DataChan
+			stream.allSubscribers[ndx] = request
 			request.id = ndx
 			break
 		}
 	}
 	if request.id < 0 {
-		stream.allChannels = append(stream.allChannels, request.DataChan)
-		request.id = (len(stream.allChannels) - 1)
+		stream.allSubscribers = append(stream.allSubscribers, request)
+		request.id = (len(stream.allSubscribers) - 1)
 	}
+	stream.deliverLock.Unlock()
 	log.Printf("Adding new channel %d to data stream", request.id, stream.name)
+	stream.countStat(&stream.subscribers)
 
 	// If we are not yet streaming data, we should be
 	if stream.ioStream == nil {
-		stream.createIOStream()
+		if err := stream.createIOStream(); err != nil {
+			log.Fatal(err)
+		}
 		go stream.streamData()
 	}
 }
 
 func (stream *DataStream) unsubscribe(request *SubscribeRequest) {
 	log.Println("Dropping channel", request.id)
-	stream.allChannels[request.id] = nil
+	stream.deliverLock.Lock()
+	defer stream.deliverLock.Unlock()
+	if stream.allSubscribers[request.id] == nil {
+		return
+	}
+	if stream.subscriberCount() == 1 {
+		// The last subscriber gets whatever stages were still holding.
+		if events := FlushStages(stream.streamStages()); len(events) > 0 {
+			request.deliver(events, stream.droppedEvents)
+		}
+	}
+	stream.allSubscribers[request.id] = nil
+	stream.statsLock.Lock()
+	stream.subscribers--
+	stream.statsLock.Unlock()
+}
+
+// AddStage appends a Stage applied to every event read from the stream,
+// before it reaches subscribers.
+func (stream *DataStream) AddStage(stage Stage) {
+	stream.stagesLock.Lock()
+	defer stream.stagesLock.Unlock()
+	stream.stages = append(stream.stages, stage)
+}
+
+func (stream *DataStream) streamStages() []Stage {
+	stream.stagesLock.Lock()
+	defer stream.stagesLock.Unlock()
+	return stream.stages
 }
 
 func (stream *DataStream) cacheData(data *JSONData) {
@@ -124,9 +383,25 @@ func (stream *DataStream) LookupData(key string) *JSONData {
 }
 
 func (stream *DataStream) streamData() {
+	// Whichever way the loop ends, our heartbeats end with it.
+	defer stream.stopHeartbeats()
+	expiring := make(chan struct{})
+	defer close(expiring)
+	go stream.expireStages(expiring)
+	// Bound once, rather than for every event.
+	dropped := stream.droppedEvents
 	for {
+		if stream.silenceLimit > 0 {
+			stream.rawStream.(net.Conn).SetReadDeadline(time.Now().Add(stream.silenceLimit))
+		}
 		line, isPrefix, err := stream.ioStream.ReadLine()
 		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() && stream.silenceLimit > 0 {
+				log.Printf("Relay for %s silent for %v, reconnecting", stream.name, stream.silenceLimit)
+				stream.countStat(&stream.deadPeers)
+				stream.reconnect()
+				continue
+			}
 			if err == io.EOF {
 				break
 			}
@@ -135,59 +410,320 @@ func (stream *DataStream) streamData() {
 		}
 		if isPrefix {
 			log.Printf("PREFIX!! Skipping line.")
+			stream.countStat(&stream.truncatedLines)
+			continue
+		}
+		if len(line) == 0 {
+			// A heartbeat
+			continue
+		}
+		if stream.limiter != nil && !stream.limiter.Allow(len(line)) {
+			// Dropped before decoding, which is most of the cost of an event.
 			continue
 		}
+		if stream.checksums {
+			line, err = VerifyChecksum(line)
+			if err != nil {
+				log.Printf("Corrupt frame: %v", err)
+				stream.countStat(&stream.corruptFrames)
+				continue
+			}
+		}
 
 		// We have fairly reliable looking chunk of data, try to decode it
-		var data JSONData
-		err = json.Unmarshal(line, &data)
+		data, err := decodeEvent(line, stream.exactNumbers)
 		if err != nil {
 			log.Printf("Failure to decode: %s", err)
 			log.Println(string(line))
 			log.Println()
+			stream.countStat(&stream.decodeErrors)
 			continue
 		}
+		stream.seq++
+		attachEnvelope(data, &Envelope{time.Now(), stream.name, len(line), stream.seq})
+		stream.recordEvent(data)
+		if detector := stream.detector(); detector != nil {
+			detector.Observe(data)
+		}
 
 		// Add to our cache
 		// Currently disabled due to memory leaks
 		//stream.cacheData(&data)
 
 		// Now deliver this fine chunk of ranger data to each of our listeners
-		sent := false
-		for ndx, dataChannel := range stream.allChannels {
-			if dataChannel != nil {
-				// We don't want to be blocking waiting on the channel, if it can't keep up we'll drop the data.
-				select {
-				case dataChannel <- data:
-				default:
-					log.Println("Dropping data to channel", ndx)
-				}
-				sent = true
-			}
-		}
+		stream.deliverLock.Lock()
+		sent := stream.deliver(ApplyStages([]JSONData{data}, stream.streamStages()), dropped)
+		stream.deliverLock.Unlock()
 		/* There are no dataChannel's left open, we can close the stream */
 		if !sent {
 			log.Printf("Closing data stream for %s", stream.name)
+			stream.stopHeartbeats()
 			stream.rawStream.Close()
 			stream.rawStream = nil
 			stream.ioStream = nil
 			break
 		}
 	}
+	stream.deliverLock.Lock()
+	stream.deliver(FlushStages(stream.streamStages()), dropped)
+	stream.deliverLock.Unlock()
 	log.Printf("All done with data stream %s", stream.name)
 }
 
-func (stream *DataStream) createIOStream() {
-	conn, err := net.Dial("tcp4", stream.connectString)
+// deliver hands events to every subscriber, reporting whether there are any
+// left. Callers hold deliverLock.
+func (stream *DataStream) deliver(events []JSONData, dropped func(events int)) (sent bool) {
+	for _, subscriber := range stream.allSubscribers {
+		if subscriber == nil {
+			continue
+		}
+		sent = true
+		if subscriber.Paused() || len(events) == 0 {
+			// Paused subscribers still keep the stream open.
+			continue
+		}
+		subscriber.deliver(events, dropped)
+	}
+	return sent
+}
+
+func (stream *DataStream) subscriberCount() (count int) {
+	for _, subscriber := range stream.allSubscribers {
+		if subscriber != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// expireStages delivers what stages release as they expire events, until
+// done is closed.
+func (stream *DataStream) expireStages(done <-chan struct{}) {
+	ticker := time.NewTicker(stream.expiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			stream.deliverLock.Lock()
+			if events := ExpireStages(stream.streamStages()); len(events) > 0 {
+				stream.deliver(events, stream.droppedEvents)
+			}
+			stream.deliverLock.Unlock()
+		case <-done:
+			return
+		}
+	}
+}
+
+// EnableHandshake makes the stream open its connection with the version 1
+// handshake, offering capabilities, rather than just sending its name. Only
+// use it with relays that understand the handshake; see ProtocolVersion.
+func (stream *DataStream) EnableHandshake(capabilities Capability) {
+	stream.protocolLock.Lock()
+	defer stream.protocolLock.Unlock()
+	stream.hello = &Hello{Stream: stream.name, Version: ProtocolVersion, Capabilities: capabilities}
+	if len(stream.filters) > 0 {
+		stream.hello.Capabilities |= CapFilterPushdown
+		stream.hello.Filters = stream.filters
+	}
+	if stream.sampler != nil {
+		stream.hello.Capabilities |= CapSampling
+		stream.hello.SampleRate = stream.sampler.Rate()
+	}
+	if stream.checksums {
+		stream.hello.Capabilities |= CapChecksum
+	}
+	if stream.heartbeat > 0 {
+		stream.hello.Capabilities |= CapHeartbeat
+		stream.hello.Heartbeat = stream.heartbeat.Seconds()
+	}
+}
+
+// SetFilters restricts the stream to events passing every filter. They're
+// pushed down to the relay when it supports CapFilterPushdown, saving the
+// bandwidth of events nobody wants, and applied locally otherwise. As the
+// filters apply to every subscriber, streams with filters are best kept for
+// a single query. Must be called before the stream connects.
+func (stream *DataStream) SetFilters(filters []string) (err error) {
+	for _, filter := range filters {
+		if _, err := Parse(filter); err != nil {
+			return err
+		}
+	}
+
+	stream.protocolLock.Lock()
+	defer stream.protocolLock.Unlock()
+	stream.filters = filters
+	if stream.hello != nil {
+		stream.hello.Capabilities |= CapFilterPushdown
+		stream.hello.Filters = filters
+	}
+	return nil
+}
+
+// SetSampling makes the stream keep only a fraction rate of events, recording
+// the rate with each event under SampleRateKey. The relay is asked to do the
+// sampling when it supports CapSampling. With adaptive set, the stream samples
+// more heavily while subscribers are dropping events; see Sampler. Must be
+// called before the stream connects.
+func (stream *DataStream) SetSampling(rate float64, adaptive bool) (err error) {
+	sampler, err := NewSampler(rate)
 	if err != nil {
-		log.Fatal("Failed to open", err)
+		return err
 	}
+	sampler.Adaptive = adaptive
 
-	_, err = conn.Write([]uint8(stream.name + "\n"))
+	stream.protocolLock.Lock()
+	stream.sampler = sampler
+	if stream.hello != nil {
+		stream.hello.Capabilities |= CapSampling
+		stream.hello.SampleRate = rate
+	}
+	stream.protocolLock.Unlock()
+
+	stream.stagesLock.Lock()
+	defer stream.stagesLock.Unlock()
+	stream.stages = append([]Stage{sampler}, stream.stages...)
+	return nil
+}
+
+// SetRateLimit caps the events and bytes per second read from the relay,
+// dropping and counting what's over; see RateLimiter. Either limit may be 0
+// for none. Must be called before the stream connects.
+func (stream *DataStream) SetRateLimit(eventsPerSecond, bytesPerSecond float64, burst time.Duration) (err error) {
+	limiter, err := NewRateLimiter(eventsPerSecond, bytesPerSecond, burst)
 	if err != nil {
-		log.Fatal("Failed to send cmd", err)
+		return err
+	}
+	stream.limiter = limiter
+	return nil
+}
+
+// VerifyChecksums makes the stream expect every line to end in a checksum,
+// dropping and counting lines where it's missing or wrong. With the
+// handshake, CapChecksum is offered, and checksums are only expected if the
+// relay agrees. Must be called before the stream connects.
+func (stream *DataStream) VerifyChecksums() {
+	stream.protocolLock.Lock()
+	defer stream.protocolLock.Unlock()
+	stream.checksums = true
+	if stream.hello != nil {
+		stream.hello.Capabilities |= CapChecksum
+	}
+}
+
+// EnableHeartbeat offers the relay CapHeartbeat in the handshake, so both
+// sides send a heartbeat every interval. If the relay agrees, a connection
+// it's been silent on for HeartbeatMisses intervals, as a half open
+// connection would be, is presumed dead and replaced. Only has an effect
+// with EnableHandshake, and must be called before the stream connects.
+func (stream *DataStream) EnableHeartbeat(interval time.Duration) {
+	stream.protocolLock.Lock()
+	defer stream.protocolLock.Unlock()
+	stream.heartbeat = interval
+	if stream.hello != nil {
+		stream.hello.Capabilities |= CapHeartbeat
+		stream.hello.Heartbeat = interval.Seconds()
+	}
+}
+
+// DecodeExactNumbers decodes events with DecodeExact, so integers beyond 2^53
+// keep every digit. Must be called before the stream connects.
+func (stream *DataStream) DecodeExactNumbers() {
+	stream.exactNumbers = true
+}
+
+// Protocol returns what was agreed with the relay. Its Version is 0 until the
+// stream connects, and for relays without the handshake.
+func (stream *DataStream) Protocol() Hello {
+	stream.protocolLock.Lock()
+	defer stream.protocolLock.Unlock()
+	return stream.agreed
+}
+
+func (stream *DataStream) createIOStream() (err error) {
+	conn, err := net.Dial("tcp4", stream.connectString)
+	if err != nil {
+		return fmt.Errorf("Failed to open %v: %w", stream.connectString, err)
 	}
 
 	stream.rawStream = conn
 	stream.ioStream = bufio.NewReaderSize(conn, 1024*32)
+
+	stream.protocolLock.Lock()
+	defer stream.protocolLock.Unlock()
+	if stream.hello == nil {
+		_, err = conn.Write([]uint8(stream.name + "\n"))
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("Failed to send cmd: %w", err)
+		}
+	} else {
+		stream.agreed, err = ClientHandshake(conn, stream.ioStream, *stream.hello)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("Failed handshake: %w", err)
+		}
+		log.Printf("Stream %s speaking protocol version %d with %v", stream.name, stream.agreed.Version, stream.agreed.Capabilities)
+		stream.checksums = stream.checksums && stream.agreed.Capabilities&CapChecksum != 0
+	}
+
+	stream.silenceLimit = 0
+	if interval := heartbeatInterval(stream.agreed); interval > 0 {
+		stream.silenceLimit = HeartbeatMisses * interval
+		stream.heartbeatDone = make(chan struct{})
+		go SendHeartbeats(conn, interval, stream.heartbeatDone)
+	}
+
+	if len(stream.filters) > 0 && stream.agreed.Capabilities&CapFilterPushdown == 0 {
+		stream.applyFiltersLocally()
+	}
+	if stream.sampler != nil && stream.agreed.Capabilities&CapSampling != 0 {
+		stream.sampler.SetUpstream(stream.agreed.SampleRate)
+	}
+	return nil
+}
+
+// stopHeartbeats stops the heartbeats for the current connection, if any.
+func (stream *DataStream) stopHeartbeats() {
+	if stream.heartbeatDone != nil {
+		close(stream.heartbeatDone)
+		stream.heartbeatDone = nil
+	}
+}
+
+// reconnect replaces a dead connection to the relay, retrying with backoff
+// until it succeeds.
+func (stream *DataStream) reconnect() {
+	stream.rawStream.Close()
+	stream.stopHeartbeats()
+	for backoff := 100 * time.Millisecond; ; backoff *= 2 {
+		err := stream.createIOStream()
+		if err == nil {
+			return
+		}
+		if backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+		log.Printf("Reconnecting %s failed, retrying in %v: %v", stream.name, backoff, err)
+		time.Sleep(backoff)
+	}
+}
+
+// applyFiltersLocally filters events ahead of every other stage, for relays
+// that can't do it for us.
+func (stream *DataStream) applyFiltersLocally() {
+	if stream.localFilter != nil {
+		// Already applied on an earlier connection.
+		return
+	}
+	stream.localFilter = new(FilterStage)
+	for _, statement := range stream.filters {
+		expr, _ := Parse(statement)
+		stream.localFilter.Filters = append(stream.localFilter.Filters, expr)
+	}
+
+	stream.stagesLock.Lock()
+	defer stream.stagesLock.Unlock()
+	stream.stages = append([]Stage{stream.localFilter}, stream.stages...)
 }
@@ -0,0 +1,150 @@
+package oxweb
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDataStreamLag(t *testing.T) {
+	stream := &DataStream{}
+	stream.SetTimestampPath("time")
+
+	eventTime := time.Now().Add(-time.Minute)
+	stream.recordEvent(map[string]interface{}{"time": float64(eventTime.Unix())})
+	stream.recordEvent(map[string]interface{}{"time": eventTime.Add(-time.Hour).Format(time.RFC3339)})
+	stream.recordEvent(map[string]interface{}{"other": 1.0})
+
+	stats := stream.Stats()
+	if stats["events"] != 3 {
+		t.Errorf("Expected 3 events, got %d", stats["events"])
+	}
+	if stats["event_time"] != eventTime.Unix()*1000 {
+		t.Errorf("Expected the latest event time %d, got %d", eventTime.Unix()*1000, stats["event_time"])
+	}
+	if lag := stats["lag_ms"]; lag < 60000 || lag > 62000 {
+		t.Errorf("Expected lag of about a minute, got %dms", lag)
+	}
+}
+
+func TestDataStreamSubscriberCount(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	stream := NewDataStream("ranger", listener.Addr().String())
+	done := make(chan bool)
+	go func() {
+		// Stats is safe alongside subscribing; go test -race checks.
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				stream.Stats()
+			}
+		}
+	}()
+	requests := []*SubscribeRequest{}
+	for i := 0; i < 3; i++ {
+		request := &SubscribeRequest{DataChan: make(chan JSONData, 1)}
+		stream.Subscribe(request)
+		requests = append(requests, request)
+	}
+	stream.Unsubscribe(requests[0])
+	stream.Unsubscribe(requests[0])
+	close(done)
+
+	deadline := time.Now().Add(time.Second)
+	for stream.Stats()["subscribers"] != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if subscribers := stream.Stats()["subscribers"]; subscribers != 2 {
+		t.Errorf("Expected 2 subscribers, got %d", subscribers)
+	}
+}
+
+// reorderRelay serves each connection the given lines after reading its
+// stream name, then goes quiet.
+func reorderRelay(t *testing.T, lines string) net.Listener {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			bufio.NewReader(conn).ReadString('\n')
+			conn.Write([]byte(lines))
+		}
+	}()
+	return listener
+}
+
+func receiveEvents(t *testing.T, request *SubscribeRequest, expected ...float64) {
+	t.Helper()
+	for _, value := range expected {
+		select {
+		case event := <-request.DataChan:
+			if got, _ := GetDeep("t", event); got != value {
+				t.Errorf("Expected event %v, got %v", value, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for event %v", value)
+		}
+	}
+}
+
+func TestDataStreamExpire(t *testing.T) {
+	listener := reorderRelay(t, "{\"t\": 1}\n{\"t\": 2}\n")
+	defer listener.Close()
+	stream := NewDataStream("ranger", listener.Addr().String())
+	reorder, _ := NewReorder("t", 20*time.Millisecond)
+	stream.AddStage(reorder)
+	stream.expiryInterval = time.Millisecond
+
+	request := &SubscribeRequest{DataChan: make(chan JSONData, 2)}
+	stream.Subscribe(request)
+	// 2 releases 1; the relay going quiet must not hold 2 forever.
+	receiveEvents(t, request, 1, 2)
+	stream.Unsubscribe(request)
+}
+
+func TestDataStreamFlushOnUnsubscribe(t *testing.T) {
+	listener := reorderRelay(t, "{\"t\": 2}\n{\"t\": 1}\n")
+	defer listener.Close()
+	stream := NewDataStream("ranger", listener.Addr().String())
+	reorder, _ := NewReorder("t", time.Hour)
+	stream.AddStage(reorder)
+
+	request := &SubscribeRequest{DataChan: make(chan JSONData, 2)}
+	stream.Subscribe(request)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		stream.deliverLock.Lock()
+		buffered := reorder.Stats()["buffered"]
+		stream.deliverLock.Unlock()
+		if buffered == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stream.Unsubscribe(request)
+	receiveEvents(t, request, 1, 2)
+}
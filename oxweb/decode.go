@@ -0,0 +1,89 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+)
+
+// Decode unmarshals an event into target, a pointer to a value such as a
+// struct with json tags, for Go code preferring static types to GetDeep. A
+// struct field tagged `json:"_meta"` of type *Envelope receives the event's
+// Envelope.
+func Decode(data JSONData, target interface{}) (err error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, target)
+}
+
+// A Subscription delivers a Source's events decoded into T. See Subscribe.
+type Subscription[T any] struct {
+	// C receives each event that decodes. It's closed by Unsubscribe.
+	C <-chan T
+
+	source       Source
+	request      *SubscribeRequest
+	done         chan struct{}
+	once         sync.Once
+	decodeErrors atomic.Int64
+}
+
+// Subscribe subscribes to source with request, decoding each event into a T
+// with Decode, e.g.
+//
+//	sub := oxweb.Subscribe[Request](stream, &oxweb.SubscribeRequest{})
+//	defer sub.Unsubscribe()
+//	for request := range sub.C {
+//		...
+//	}
+//
+// A DataChan is created for request if it doesn't have one; Acked requests
+// aren't supported. Events that don't decode are skipped and counted in
+// Stats.
+func Subscribe[T any](source Source, request *SubscribeRequest) *Subscription[T] {
+	if request.DataChan == nil {
+		request.DataChan = make(chan JSONData, 64)
+	}
+	events := make(chan T, cap(request.DataChan))
+	s := &Subscription[T]{C: events, source: source, request: request, done: make(chan struct{})}
+	go s.decode(events)
+	source.Subscribe(request)
+	return s
+}
+
+func (s *Subscription[T]) decode(events chan<- T) {
+	defer close(events)
+	for {
+		select {
+		case data := <-s.request.DataChan:
+			var event T
+			if err := Decode(data, &event); err != nil {
+				s.decodeErrors.Add(1)
+				continue
+			}
+			select {
+			case events <- event:
+			case <-s.done:
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Unsubscribe stops the subscription and closes C.
+func (s *Subscription[T]) Unsubscribe() {
+	s.once.Do(func() {
+		s.source.Unsubscribe(s.request)
+		close(s.done)
+	})
+}
+
+// Stats reports decode_errors, the number of events that couldn't be
+// decoded into a T.
+func (s *Subscription[T]) Stats() Stats {
+	return Stats{"decode_errors": s.decodeErrors.Load()}
+}
@@ -0,0 +1,59 @@
+package oxweb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type decodedRequest struct {
+	Status int       `json:"status"`
+	Host   string    `json:"host"`
+	Meta   *Envelope `json:"_meta"`
+}
+
+func TestDecode(t *testing.T) {
+	var request decodedRequest
+	event := map[string]interface{}{"status": 200., "host": "web1", MetaKey: &Envelope{Source: "web", Seq: 3}}
+	if err := Decode(event, &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.Status != 200 || request.Host != "web1" || request.Meta == nil || request.Meta.Seq != 3 {
+		t.Errorf("Unexpected decoding %+v", request)
+	}
+	if err := Decode(map[string]interface{}{"status": "ok"}, &request); err == nil {
+		t.Errorf("Expected a string status not to decode")
+	}
+}
+
+func TestSubscribeDecoded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.json")
+	os.WriteFile(path, []byte("{\"status\": 200}\n{\"status\": \"bad\"}\n{\"status\": 404}\n"), 0644)
+
+	sub := Subscribe[decodedRequest](NewFileSource(path), &SubscribeRequest{})
+	for _, expected := range []int{200, 404} {
+		select {
+		case request := <-sub.C:
+			if request.Status != expected {
+				t.Errorf("Expected status %v, got %+v", expected, request)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for status %v", expected)
+		}
+	}
+	if errors := sub.Stats()["decode_errors"]; errors != 1 {
+		t.Errorf("Expected 1 decode error, got %v", errors)
+	}
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	select {
+	case _, ok := <-sub.C:
+		if ok {
+			t.Errorf("Expected C to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for C to close")
+	}
+}
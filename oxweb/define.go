@@ -0,0 +1,124 @@
+package oxweb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type definition struct {
+	name   string
+	params []string
+	body   string
+}
+
+var functionNameRe = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+var (
+	definitionsLock sync.RWMutex
+	definitions     = make(map[string]*definition)
+)
+
+// Define registers a reusable query fragment. Once defined, name can be called
+// like any built-in function, and each argument expression is substituted
+// wherever the matching parameter name appears in body. For example:
+//
+//	Define("Elapsed", []string{"start", "end"}, "Subtract(end,start)")
+//
+// makes "Elapsed(timing.begin,timing.finish)" a valid expression. Redefining
+// an existing name replaces it for expressions parsed afterwards. Built-in and
+// Register()'d functions can't be redefined, and a definition can't call
+// itself, directly or through others; that's reported when it's parsed.
+func Define(name string, params []string, body string) (err error) {
+	if !functionNameRe.MatchString(name) {
+		return fmt.Errorf("\"%v\" is not a valid function name", name)
+	}
+	if builtin(name) != nil || lookupRegistered(name) != nil {
+		return fmt.Errorf("%v is already a function, and can't be redefined", name)
+	}
+	seen := make(map[string]bool)
+	for _, param := range params {
+		if !functionNameRe.MatchString(param) {
+			return fmt.Errorf("\"%v\" is not a valid parameter name", param)
+		}
+		if seen[param] {
+			return fmt.Errorf("Parameter \"%v\" is declared more than once", param)
+		}
+		seen[param] = true
+	}
+	if strings.TrimSpace(body) == "" {
+		return fmt.Errorf("Define expects a non-empty body for %v", name)
+	}
+
+	definitionsLock.Lock()
+	defer definitionsLock.Unlock()
+	definitions[name] = &definition{name, params, body}
+	return nil
+}
+
+// Undefine removes a function registered with Define.
+func Undefine(name string) {
+	definitionsLock.Lock()
+	defer definitionsLock.Unlock()
+	delete(definitions, name)
+}
+
+func lookupDefinition(name string) *definition {
+	definitionsLock.RLock()
+	defer definitionsLock.RUnlock()
+	return definitions[name]
+}
+
+/*
+ * <name>(args...) -> interface{}
+ *
+ * An invocation of a function registered with Define(). The body is parsed
+ * afresh for every invocation so stateful expressions (windows) are not shared.
+ */
+type DefinedFunction struct {
+	definition *definition
+	args       []Expression
+	body       Expression
+	// The scope it's called from.
+	outer *parseScope
+}
+
+func (f *DefinedFunction) Setup(fname string, args []Expression) (err error) {
+	if len(args) != len(f.definition.params) {
+		return fmt.Errorf("%v expects %d arguments (%v), got %d", fname, len(f.definition.params), strings.Join(f.definition.params, ", "), len(args))
+	}
+	scope := &parseScope{params: make(map[string]Expression, len(args))}
+	if f.outer != nil {
+		scope.options = f.outer.options
+		scope.expanding = f.outer.expanding
+	}
+	for _, name := range scope.expanding {
+		if name == f.definition.name {
+			return fmt.Errorf("%w: %v calls itself, through %v", ErrParse, fname, strings.Join(append(scope.expanding, fname), " -> "))
+		}
+	}
+	// Copied, so sibling calls don't share the slice.
+	scope.expanding = append(scope.expanding[:len(scope.expanding):len(scope.expanding)], f.definition.name)
+	for ndx, param := range f.definition.params {
+		scope.params[param] = args[ndx]
+	}
+	f.body, err = parse(f.definition.body, scope)
+	if err != nil {
+		return fmt.Errorf("Couldn't parse body of %v: %w", fname, err)
+	}
+	f.args = args
+	return nil
+}
+
+func (f *DefinedFunction) Evaluate(data JSONData) (result interface{}, err error) {
+	return f.body.Evaluate(data)
+}
+
+func (f *DefinedFunction) String() string {
+	args := make([]string, len(f.args))
+	for ndx, arg := range f.args {
+		args[ndx] = arg.String()
+	}
+	return fmt.Sprintf("%v(%v)", f.definition.name, strings.Join(args, ","))
+}
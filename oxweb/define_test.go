@@ -0,0 +1,73 @@
+package oxweb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDefine(t *testing.T) {
+	if err := Define("Elapsed", []string{"start", "end"}, "Subtract(end,start)"); err != nil {
+		t.Fatal(err)
+	}
+	defer Undefine("Elapsed")
+
+	expr, err := Parse("Elapsed(begin,finish)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := expr.Evaluate(map[string]interface{}{"begin": 2., "finish": 5.}); err != nil || result != 3. {
+		t.Errorf("Expected 3, got %v, %v", result, err)
+	}
+	// A definition may call another any number of times.
+	if _, err := Parse("Add(Elapsed(a,b),Elapsed(b,c))"); err != nil {
+		t.Errorf("Expected sibling calls to parse, got %v", err)
+	}
+}
+
+type recursionTest struct {
+	definitions map[string]string
+	statement   string
+}
+
+var recursionTests = []recursionTest{
+	recursionTest{map[string]string{"Loop": "Loop(x)"}, "Loop(a)"},
+	recursionTest{map[string]string{"Ping": "Pong(x)", "Pong": "Add(x,Ping(x))"}, "Ping(a)"},
+	recursionTest{map[string]string{"Loop": "Add(Loop(x),Loop(x))"}, "Loop(a)"},
+}
+
+func TestDefineRecursion(t *testing.T) {
+	for _, test := range recursionTests {
+		for name, body := range test.definitions {
+			if err := Define(name, []string{"x"}, body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := Parse(test.statement); !errors.Is(err, ErrParse) {
+			t.Errorf("Expected ErrParse for %v with %v, got %v", test.statement, test.definitions, err)
+		}
+		for name := range test.definitions {
+			Undefine(name)
+		}
+	}
+}
+
+func TestDefineBuiltin(t *testing.T) {
+	Register("TestDefined", func() Expression { return new(Literal) })
+	unregisterAfter(t, "TestDefined")
+	for _, name := range []string{"WindowAve", "Add", "TestDefined"} {
+		if err := Define(name, []string{"x"}, "x"); err == nil {
+			Undefine(name)
+			t.Errorf("Expected %v not to be redefined", name)
+		}
+	}
+}
+
+func TestDefineBodyError(t *testing.T) {
+	if err := Define("Broken", nil, "Nonexistent(a)"); err != nil {
+		t.Fatal(err)
+	}
+	defer Undefine("Broken")
+	if _, err := Parse("Broken()"); !errors.Is(err, ErrParse) {
+		t.Errorf("Expected the body's ErrParse, got %v", err)
+	}
+}
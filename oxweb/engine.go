@@ -0,0 +1,578 @@
+package oxweb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// A QuerySpec is everything needed to recreate a query.
+type QuerySpec struct {
+	Name    string   `json:"name"`
+	Source  string   `json:"source"`
+	Fields  []string `json:"fields"`
+	Filters []string `json:"filters"`
+	// OnError is one of "emit", "skip" or "abort", the default; see
+	// ParseErrorPolicy.
+	OnError string `json:"onError,omitempty"`
+	// PropagateNulls is the query's PropagateNulls.
+	PropagateNulls bool `json:"propagateNulls,omitempty"`
+	// Labels are the query's Labels. Like Name, they don't affect its ID.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ID identifies what the query computes, ignoring its Name: specs with the
+// same source, error policy, nil propagation and canonically formatted
+// fields and filters share an ID. Filter order counts, since filters stop at
+// the first that fails and some, like EveryNth, keep state.
+func (spec QuerySpec) ID() (id string, err error) {
+	canonical := QuerySpec{Source: spec.Source, OnError: spec.OnError, PropagateNulls: spec.PropagateNulls}
+	if canonical.OnError == "" {
+		canonical.OnError = "abort"
+	}
+	for _, statement := range spec.Fields {
+		formatted, err := Format(statement)
+		if err != nil {
+			return "", err
+		}
+		canonical.Fields = append(canonical.Fields, formatted)
+	}
+	for _, statement := range spec.Filters {
+		formatted, err := Format(statement)
+		if err != nil {
+			return "", err
+		}
+		canonical.Filters = append(canonical.Filters, formatted)
+	}
+
+	encoded, err := json.Marshal(canonical)
+	if err != nil {
+		return "", err
+	}
+	return hashID(string(encoded)), nil
+}
+
+// Engine manages a set of named queries so their state can be checkpointed
+// to disk and restored after a restart. Evaluate queries through the Engine
+// so checkpoints never see a query part way through an event.
+//
+// Queries with the same ID, even under different names, share one Query and
+// so one set of window state. Feed events with Dispatch, which evaluates
+// each shared query once per event; calling Evaluate for every name would
+// count events once per name.
+type Engine struct {
+	lock    sync.Mutex
+	queries map[string]*engineQuery
+	specs   map[string]QuerySpec
+	byID    map[string]*engineQuery
+	sources map[string]Source
+	schemas map[string]*Schema
+	audit   Sink
+	// Paused names, and whether each asked to freeze.
+	paused map[string]bool
+}
+
+type engineQuery struct {
+	lock  sync.Mutex
+	id    string
+	spec  QuerySpec
+	query *Query
+	// How many names share the query.
+	refs int
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		queries: make(map[string]*engineQuery),
+		specs:   make(map[string]QuerySpec),
+		byID:    make(map[string]*engineQuery),
+		sources: make(map[string]Source),
+		schemas: make(map[string]*Schema),
+		paused:  make(map[string]bool),
+	}
+}
+
+// AddSource names a source queries read from. The positions of Seekable
+// sources are checkpointed, so add sources before calling Restore.
+func (e *Engine) AddSource(name string, source Source) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.sources[name] = source
+}
+
+func (e *Engine) source(name string) Source {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.sources[name]
+}
+
+// SetSchema declares the types of a source's events. Queries on the source
+// added afterwards are checked against a Strict schema.
+func (e *Engine) SetSchema(source string, schema *Schema) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.schemas[source] = schema
+}
+
+// Add parses and starts tracking a query, replacing any of the same name. If
+// a query with the same ID is already running, spec shares it and the
+// existing Query is returned.
+func (e *Engine) Add(spec QuerySpec) (query *Query, err error) {
+	return e.AddContext(context.Background(), spec)
+}
+
+// AddContext is Add, auditing the change as made by ctx's Principal.
+func (e *Engine) AddContext(ctx context.Context, spec QuerySpec) (query *Query, err error) {
+	action := AuditCreate
+	e.lock.Lock()
+	if _, exists := e.queries[spec.Name]; exists {
+		action = AuditModify
+	}
+	e.lock.Unlock()
+
+	if query, err = e.add(spec); err != nil {
+		return nil, err
+	}
+	e.record(ctx, action, spec.Name, &spec)
+	return query, nil
+}
+
+func (e *Engine) add(spec QuerySpec) (query *Query, err error) {
+	id, err := spec.ID()
+	if err != nil {
+		return nil, err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if schema := e.schemas[spec.Source]; schema != nil && schema.Strict {
+		if err := schema.Check(spec); err != nil {
+			return nil, err
+		}
+	}
+	e.remove(spec.Name)
+	if q, ok := e.byID[id]; ok {
+		q.refs++
+		e.queries[spec.Name] = q
+		e.specs[spec.Name] = spec
+		e.syncPause(q)
+		return q.query, nil
+	}
+
+	options := ParseOptions{PropagateNulls: spec.PropagateNulls}
+	fields := make([]Expression, 0, len(spec.Fields))
+	for _, statement := range spec.Fields {
+		expr, err := ParseWithOptions(statement, options)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, expr)
+	}
+	filters := make([]Expression, 0, len(spec.Filters))
+	for _, statement := range spec.Filters {
+		expr, err := ParseWithOptions(statement, options)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, expr)
+	}
+	query = NewQuery(fields, filters)
+	query.Labels = spec.Labels
+	query.PropagateNulls = spec.PropagateNulls
+	if spec.OnError != "" {
+		if query.ErrorPolicy, err = ParseErrorPolicy(spec.OnError); err != nil {
+			return nil, err
+		}
+	}
+
+	q := &engineQuery{id: id, spec: spec, query: query, refs: 1}
+	e.queries[spec.Name] = q
+	e.specs[spec.Name] = spec
+	e.byID[id] = q
+	return query, nil
+}
+
+func (e *Engine) Remove(name string) {
+	e.RemoveContext(context.Background(), name)
+}
+
+// RemoveContext is Remove, auditing the change as made by ctx's Principal.
+func (e *Engine) RemoveContext(ctx context.Context, name string) {
+	e.lock.Lock()
+	_, exists := e.queries[name]
+	e.remove(name)
+	e.lock.Unlock()
+
+	if exists {
+		e.record(ctx, AuditDelete, name, nil)
+	}
+}
+
+func (e *Engine) remove(name string) {
+	q, ok := e.queries[name]
+	if !ok {
+		return
+	}
+	delete(e.queries, name)
+	delete(e.specs, name)
+	delete(e.paused, name)
+	if q.refs--; q.refs == 0 {
+		delete(e.byID, q.id)
+	} else {
+		e.syncPause(q)
+	}
+}
+
+// Pause stops the named query evaluating events without forgetting it; see
+// Query.Pause. A query shared with other names keeps evaluating for them, and
+// the paused name just stops getting records; the Query itself pauses, and
+// freezes if every name asked to, once all its names are paused.
+func (e *Engine) Pause(name string, freeze bool) (err error) {
+	return e.PauseContext(context.Background(), name, freeze)
+}
+
+// PauseContext is Pause, auditing the change as made by ctx's Principal.
+func (e *Engine) PauseContext(ctx context.Context, name string, freeze bool) (err error) {
+	e.lock.Lock()
+	q, found := e.queries[name]
+	if found {
+		e.paused[name] = freeze
+		e.syncPause(q)
+	}
+	e.lock.Unlock()
+	if !found {
+		return fmt.Errorf("No query named %v", name)
+	}
+	e.record(ctx, AuditPause, name, nil)
+	return nil
+}
+
+func (e *Engine) Resume(name string) (err error) {
+	return e.ResumeContext(context.Background(), name)
+}
+
+// ResumeContext is Resume, auditing the change as made by ctx's Principal.
+func (e *Engine) ResumeContext(ctx context.Context, name string) (err error) {
+	e.lock.Lock()
+	q, found := e.queries[name]
+	if found {
+		delete(e.paused, name)
+		e.syncPause(q)
+	}
+	e.lock.Unlock()
+	if !found {
+		return fmt.Errorf("No query named %v", name)
+	}
+	e.record(ctx, AuditResume, name, nil)
+	return nil
+}
+
+// syncPause pauses q once every name sharing it is paused, and resumes it
+// while any isn't.
+func (e *Engine) syncPause(q *engineQuery) {
+	all, freeze := true, true
+	for name, other := range e.queries {
+		if other == q {
+			paused, isPaused := e.paused[name]
+			all = all && isPaused
+			freeze = freeze && paused
+		}
+	}
+	if all {
+		q.query.Pause(freeze)
+	} else {
+		q.query.Resume()
+	}
+}
+
+// Stats reports each query's Stats, by name. Shared queries report the same
+// numbers under each of their names.
+func (e *Engine) Stats() map[string]Stats {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	stats := make(map[string]Stats, len(e.queries))
+	for name, q := range e.queries {
+		stats[name] = q.query.Stats()
+	}
+	return stats
+}
+
+// StatsByLabel totals query Stats by the value of a label, e.g. "team", to
+// attribute load. Queries without the label are totalled under "". Each
+// shared query is counted once, under the labels of its first name.
+func (e *Engine) StatsByLabel(label string) map[string]Stats {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	totals := make(map[string]Stats)
+	for _, q := range e.byID {
+		value := q.spec.Labels[label]
+		if totals[value] == nil {
+			totals[value] = make(Stats)
+		}
+		for stat, count := range q.query.Stats() {
+			totals[value][stat] += count
+		}
+	}
+	return totals
+}
+
+// Specs lists the queries, by name.
+func (e *Engine) Specs() []QuerySpec {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	specs := make([]QuerySpec, 0, len(e.specs))
+	for _, spec := range e.specs {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// Spec returns the named query's spec, if there is one.
+func (e *Engine) Spec(name string) (spec QuerySpec, ok bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	spec, ok = e.specs[name]
+	return spec, ok
+}
+
+// Evaluate runs the named query against an event; see Query.Evaluate.
+func (e *Engine) Evaluate(name string, data JSONData) (record []interface{}, ok bool, err error) {
+	e.lock.Lock()
+	q, found := e.queries[name]
+	_, paused := e.paused[name]
+	e.lock.Unlock()
+	if !found {
+		return nil, false, fmt.Errorf("No query named %v", name)
+	}
+	if paused {
+		return nil, false, nil
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.query.Evaluate(data)
+}
+
+// Dispatch evaluates every query reading from source against an event,
+// evaluating shared queries only once. It returns the records emitted, by
+// query name, and the first error from an AbortOnError query.
+func (e *Engine) Dispatch(source string, data JSONData) (records map[string][]interface{}, err error) {
+	e.lock.Lock()
+	names := make(map[*engineQuery][]string)
+	for name, q := range e.queries {
+		if _, paused := e.paused[name]; !paused && e.specs[name].Source == source {
+			names[q] = append(names[q], name)
+		}
+	}
+	e.lock.Unlock()
+
+	records = make(map[string][]interface{})
+	for q, queryNames := range names {
+		q.lock.Lock()
+		record, ok, evalErr := q.query.Evaluate(data)
+		q.lock.Unlock()
+		if evalErr != nil && err == nil {
+			err = evalErr
+		}
+		if !ok {
+			continue
+		}
+		for _, name := range queryNames {
+			records[name] = record
+		}
+	}
+	return records, err
+}
+
+// checkpointFile is the name of the checkpoint within its directory.
+const checkpointFile = "checkpoint.json"
+
+type checkpoint struct {
+	Queries []queryCheckpoint `json:"queries"`
+	// Positions of Seekable sources, by name.
+	Positions map[string]int64 `json:"positions,omitempty"`
+}
+
+type queryCheckpoint struct {
+	Spec QuerySpec `json:"spec"`
+	// States of Mergeable fields, by field index.
+	States map[int]json.RawMessage `json:"states,omitempty"`
+	// Elements of window aggregates' windows, by field index.
+	Windows map[int]windowState `json:"windows,omitempty"`
+}
+
+// mergeableField returns the Mergeable aggregate at the top of a field, if
+// there is one, looking through As.
+func mergeableField(expr Expression) (m Mergeable, ok bool) {
+	m, ok = unwrapAs(expr).(Mergeable)
+	return m, ok
+}
+
+// windowField returns the window of the window aggregate at the top of a
+// field, if there is one, looking through As.
+func windowField(expr Expression) (w checkpointedWindow, ok bool) {
+	aggregate, ok := unwrapAs(expr).(windowAggregate)
+	if !ok {
+		return nil, false
+	}
+	w, ok = aggregate.aggregatedWindow().(checkpointedWindow)
+	return w, ok
+}
+
+// unwrapAs returns the expression an As names, or expr itself.
+func unwrapAs(expr Expression) Expression {
+	if as, isAs := expr.(*AsClause); isAs {
+		return as.expr
+	}
+	return expr
+}
+
+// Checkpoint writes every query, the state of its Mergeable fields, the
+// elements of its window aggregates' windows and the positions of Seekable
+// sources to dir. The checkpoint is replaced atomically, so a crash part way
+// through leaves the previous one intact.
+//
+// Only fields at the top of a query are saved, through As: aggregates inside
+// GroupBy, WindowTrend and other stateful expressions, such as Sequence,
+// start empty again on Restore.
+//
+// Positions are taken before query state, so events arriving during a
+// checkpoint may be counted twice after a restore, but never lost.
+func (e *Engine) Checkpoint(dir string) (err error) {
+	saved := checkpoint{Positions: make(map[string]int64)}
+
+	e.lock.Lock()
+	for name, source := range e.sources {
+		if seekable, ok := source.(Seekable); ok {
+			saved.Positions[name] = seekable.Position()
+		}
+	}
+	names := make([]string, 0, len(e.queries))
+	for name := range e.queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	queries := make([]*engineQuery, len(names))
+	specs := make([]QuerySpec, len(names))
+	for ndx, name := range names {
+		queries[ndx], specs[ndx] = e.queries[name], e.specs[name]
+	}
+	e.lock.Unlock()
+
+	// Shared queries have their state saved with the first name only, or
+	// Restore would merge it in once per name.
+	stored := make(map[*engineQuery]bool)
+	for ndx, q := range queries {
+		entry := queryCheckpoint{Spec: specs[ndx], States: make(map[int]json.RawMessage), Windows: make(map[int]windowState)}
+		if stored[q] {
+			saved.Queries = append(saved.Queries, entry)
+			continue
+		}
+		stored[q] = true
+		q.lock.Lock()
+		for ndx, field := range q.query.Fields {
+			if m, ok := mergeableField(field); ok {
+				state, err := m.State()
+				if err != nil {
+					q.lock.Unlock()
+					return fmt.Errorf("Checkpointing %v: %w", q.spec.Name, err)
+				}
+				entry.States[ndx] = state
+			}
+			if w, ok := windowField(field); ok {
+				entry.Windows[ndx] = w.saveState()
+			}
+		}
+		q.lock.Unlock()
+		saved.Queries = append(saved.Queries, entry)
+	}
+
+	encoded, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, checkpointFile), encoded)
+}
+
+// Restore adds the queries in dir's checkpoint, with their saved state, and
+// seeks the Engine's sources to their saved positions.
+func (e *Engine) Restore(dir string) (err error) {
+	encoded, err := os.ReadFile(filepath.Join(dir, checkpointFile))
+	if err != nil {
+		return err
+	}
+	var saved checkpoint
+	if err := json.Unmarshal(encoded, &saved); err != nil {
+		return fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	e.lock.Lock()
+	for name, position := range saved.Positions {
+		if seekable, ok := e.sources[name].(Seekable); ok {
+			if err := seekable.SeekTo(position); err != nil {
+				e.lock.Unlock()
+				return fmt.Errorf("Restoring %v position: %w", name, err)
+			}
+		}
+	}
+	e.lock.Unlock()
+
+	for _, entry := range saved.Queries {
+		query, err := e.add(entry.Spec)
+		if err != nil {
+			return fmt.Errorf("Restoring %v: %w", entry.Spec.Name, err)
+		}
+		for ndx, state := range entry.States {
+			if ndx >= len(query.Fields) {
+				continue
+			}
+			if m, ok := mergeableField(query.Fields[ndx]); ok {
+				if err := m.Merge(state); err != nil {
+					return fmt.Errorf("Restoring %v: %w", entry.Spec.Name, err)
+				}
+			}
+		}
+		for ndx, state := range entry.Windows {
+			if ndx >= len(query.Fields) {
+				continue
+			}
+			if w, ok := windowField(query.Fields[ndx]); ok {
+				if err := w.restoreState(state); err != nil {
+					return fmt.Errorf("Restoring %v window: %w", entry.Spec.Name, err)
+				}
+			}
+		}
+		e.record(context.Background(), AuditRestore, entry.Spec.Name, &entry.Spec)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path.
+func writeFileAtomic(path string, data []byte) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,222 @@
+package oxweb
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEngineCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	spec := QuerySpec{Name: "latency", Source: "ranger", Fields: []string{"TimeDecayedAve(latency, 60)"}, OnError: "skip"}
+
+	engine := NewEngine()
+	if _, err := engine.Add(spec); err != nil {
+		t.Fatal(err)
+	}
+	for _, latency := range []float64{10, 20} {
+		engine.Evaluate("latency", map[string]interface{}{"latency": latency})
+	}
+	if err := engine.Checkpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewEngine()
+	if err := restored.Restore(dir); err != nil {
+		t.Fatal(err)
+	}
+	if specs := restored.Specs(); len(specs) != 1 || specs[0].Source != "ranger" || specs[0].OnError != "skip" {
+		t.Errorf("Expected the query spec back, got %+v", specs)
+	}
+
+	// Without the restored state the average would be 30.
+	record, _, _ := restored.Evaluate("latency", map[string]interface{}{"latency": 30.})
+	if value := record[0].([]interface{})[1].(float64); value < 19.9 || value > 20.1 {
+		t.Errorf("Expected the average to continue from the checkpoint at about 20, got %v", value)
+	}
+}
+
+func TestEngineCheckpointWindows(t *testing.T) {
+	dir := t.TempDir()
+	spec := QuerySpec{Name: "latency", Source: "ranger", Fields: []string{
+		"WindowAve(RollingWindow(latency, 3))",
+		`As(WindowPercentile(TimedWindow(latency, 60), 50), "p50")`,
+		"WindowCorrelation(RollingWindow(Pair(latency, latency), 10))",
+	}}
+
+	engine := NewEngine()
+	if _, err := engine.Add(spec); err != nil {
+		t.Fatal(err)
+	}
+	for _, latency := range []float64{10, 20, 60} {
+		engine.Evaluate("latency", map[string]interface{}{"latency": latency})
+	}
+	if err := engine.Checkpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewEngine()
+	if err := restored.Restore(dir); err != nil {
+		t.Fatal(err)
+	}
+	// Empty windows would give 30, 30 and an error.
+	record, _, err := restored.Evaluate("latency", map[string]interface{}{"latency": 30.})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{110. / 3, 25., 1.}
+	for ndx, value := range expected {
+		if got := record[ndx].([]interface{})[1]; got != value {
+			t.Errorf("Expected %v to continue from the checkpoint with %v, got %v", spec.Fields[ndx], value, got)
+		}
+	}
+}
+
+func TestEngineCheckpointPositions(t *testing.T) {
+	dir := t.TempDir()
+	engine := NewEngine()
+	source := NewFileSource(filepath.Join(dir, "capture.json"))
+	source.SeekTo(42)
+	engine.AddSource("capture", source)
+	if err := engine.Checkpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewEngine()
+	restoredSource := NewFileSource(filepath.Join(dir, "capture.json"))
+	restored.AddSource("capture", restoredSource)
+	if err := restored.Restore(dir); err != nil {
+		t.Fatal(err)
+	}
+	if position := restoredSource.Position(); position != 42 {
+		t.Errorf("Expected the source to resume at 42, got %d", position)
+	}
+}
+
+func TestQuerySpecID(t *testing.T) {
+	a := QuerySpec{Name: "a", Source: "ranger", Fields: []string{"Add(x,y)"}, Filters: []string{"GetDeep('p')", "q"}}
+	b := QuerySpec{Name: "b", Source: "ranger", Fields: []string{"Add( x, y ) # total"}, Filters: []string{`GetDeep("p")`, "q"}, OnError: "abort"}
+	c := QuerySpec{Name: "a", Source: "ranger", Fields: []string{"Add(y,x)"}, Filters: []string{"GetDeep('p')", "q"}}
+
+	idA, err := a.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idB, _ := b.ID(); idA != idB {
+		t.Errorf("Expected equivalent specs to share an ID, got %v and %v", idA, idB)
+	}
+	if idC, _ := c.ID(); idA == idC {
+		t.Errorf("Expected different fields to give different IDs")
+	}
+
+	// Filters short circuit, and EveryNth only counts the events it sees.
+	sampled := QuerySpec{Source: "ranger", Fields: []string{"x"}, Filters: []string{"EveryNth(10)", `GetDeep("error")`}}
+	reversed := QuerySpec{Source: "ranger", Fields: []string{"x"}, Filters: []string{`GetDeep("error")`, "EveryNth(10)"}}
+	idSampled, _ := sampled.ID()
+	if idReversed, _ := reversed.ID(); idSampled == idReversed {
+		t.Errorf("Expected filters in a different order to give different IDs")
+	}
+}
+
+func TestEngineSharesQueries(t *testing.T) {
+	engine := NewEngine()
+	first, err := engine.Add(QuerySpec{Name: "alice", Source: "ranger", Fields: []string{"TimeDecayedAve(latency, 60)"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := engine.Add(QuerySpec{Name: "bob", Source: "ranger", Fields: []string{"TimeDecayedAve( latency , 60 )"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatal("Expected identical queries to be shared")
+	}
+
+	for _, latency := range []float64{10, 20} {
+		records, err := engine.Dispatch("ranger", map[string]interface{}{"latency": latency})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 2 {
+			t.Fatalf("Expected records for both names, got %v", records)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := engine.Checkpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewEngine()
+	if err := restored.Restore(dir); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := restored.Dispatch("ranger", map[string]interface{}{"latency": 30.})
+	if value := records["bob"][0].([]interface{})[1].(float64); value < 19.9 || value > 20.1 {
+		t.Errorf("Expected shared state to be restored once, averaging about 20, got %v", value)
+	}
+
+	engine.Remove("alice")
+	if specs := engine.Specs(); len(specs) != 1 || specs[0].Name != "bob" {
+		t.Errorf("Expected only bob left, got %+v", specs)
+	}
+	if third, _ := engine.Add(QuerySpec{Name: "carol", Source: "ranger", Fields: []string{"TimeDecayedAve(latency,60)"}}); third != first {
+		t.Error("Expected carol to share bob's query")
+	}
+}
+
+func TestEnginePause(t *testing.T) {
+	engine := NewEngine()
+	engine.Add(QuerySpec{Name: "latency", Source: "ranger", Fields: []string{"latency"}})
+	if err := engine.Pause("latency", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := engine.Evaluate("latency", map[string]interface{}{"latency": 1.}); ok {
+		t.Error("Expected no record while paused")
+	}
+	engine.Resume("latency")
+	if _, ok, _ := engine.Evaluate("latency", map[string]interface{}{"latency": 1.}); !ok {
+		t.Error("Expected a record once resumed")
+	}
+	if err := engine.Pause("missing", false); err == nil {
+		t.Error("Expected an error pausing a missing query")
+	}
+}
+
+func TestEnginePauseShared(t *testing.T) {
+	engine := NewEngine()
+	engine.Add(QuerySpec{Name: "alice", Source: "ranger", Fields: []string{"latency"}})
+	query, _ := engine.Add(QuerySpec{Name: "bob", Source: "ranger", Fields: []string{"latency"}})
+	engine.Pause("alice", false)
+	if query.Paused() {
+		t.Error("Expected the shared query to keep running for bob")
+	}
+
+	records, _ := engine.Dispatch("ranger", map[string]interface{}{"latency": 1.})
+	if len(records["alice"]) != 0 || len(records["bob"]) != 1 {
+		t.Errorf("Expected a record for bob only, got %v", records)
+	}
+
+	engine.Pause("bob", false)
+	if !query.Paused() {
+		t.Error("Expected the query paused once both names are")
+	}
+	engine.Resume("alice")
+	if query.Paused() {
+		t.Error("Expected the query running again for alice")
+	}
+}
+
+func TestEngineStatsByLabel(t *testing.T) {
+	engine := NewEngine()
+	engine.Add(QuerySpec{Name: "a", Source: "ranger", Fields: []string{"x"}, Labels: map[string]string{"team": "web"}})
+	engine.Add(QuerySpec{Name: "b", Source: "ranger", Fields: []string{"y"}, Labels: map[string]string{"team": "web"}})
+	engine.Add(QuerySpec{Name: "c", Source: "ranger", Fields: []string{"z"}})
+	engine.Dispatch("ranger", map[string]interface{}{"x": 1.})
+
+	totals := engine.StatsByLabel("team")
+	if totals["web"]["events"] != 2 || totals[""]["events"] != 1 {
+		t.Errorf("Unexpected totals %v", totals)
+	}
+	if specs := engine.Specs(); specs[0].Labels["team"] != "web" {
+		t.Errorf("Expected labels in the spec list, got %+v", specs)
+	}
+}
@@ -0,0 +1,95 @@
+package oxweb
+
+import (
+	"fmt"
+	"time"
+)
+
+// MetaKey is where a DataStream attaches each event's Envelope. Only JSON
+// object events get one, and only if the producer doesn't use the key itself.
+const MetaKey = "_meta"
+
+// An Envelope describes how an event arrived, as opposed to what it says.
+type Envelope struct {
+	// RecvTime is when the event was read from the stream.
+	RecvTime time.Time `json:"recv_time"`
+	// Source names the stream it was read from.
+	Source string `json:"source"`
+	// Size is the event's encoded size in bytes.
+	Size int `json:"size"`
+	// Seq counts the events read from the stream, from 1.
+	Seq int64 `json:"seq"`
+}
+
+// attachEnvelope sets an object event's Envelope. An event that already has a
+// MetaKey of its own keeps it, and gets no Envelope. Envelopes aren't
+// modified once attached, so the subscribers sharing an event can share it.
+func attachEnvelope(data JSONData, envelope *Envelope) {
+	object, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if _, taken := object[MetaKey]; !taken {
+		object[MetaKey] = envelope
+	}
+}
+
+// eventEnvelope returns data's Envelope, if it has one.
+func eventEnvelope(data JSONData) (envelope *Envelope, ok bool) {
+	object, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	envelope, ok = object[MetaKey].(*Envelope)
+	return envelope, ok
+}
+
+/*
+ * Meta(name) -> value
+ *
+ * Returns a field of the event's Envelope: "recv_time" (Unix seconds),
+ * "source", "size" (bytes), "seq", or "sample_rate" (the fraction of events
+ * this one was sampled from, 1 if none). The envelope fields are nil for
+ * events without one. Subtract(Meta("recv_time"), time) is the ingest lag.
+ */
+type Meta struct {
+	name string
+}
+
+var metaFields = map[string]func(data JSONData, envelope *Envelope) interface{}{
+	"recv_time": func(_ JSONData, e *Envelope) interface{} {
+		return float64(e.RecvTime.UnixNano()) / float64(time.Second)
+	},
+	"source":      func(_ JSONData, e *Envelope) interface{} { return e.Source },
+	"size":        func(_ JSONData, e *Envelope) interface{} { return float64(e.Size) },
+	"seq":         func(_ JSONData, e *Envelope) interface{} { return float64(e.Seq) },
+	"sample_rate": func(data JSONData, _ *Envelope) interface{} { return eventSampleRate(data) },
+}
+
+func (m *Meta) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("Meta expects a single field name")
+	}
+	literal, ok := args[0].(*Literal)
+	if !ok {
+		return literalArgError("Meta expects a literal field name", args[0])
+	}
+	name, ok := literal.value.(string)
+	if !ok || metaFields[name] == nil {
+		return fmt.Errorf("Meta doesn't know the field %v", literal.value)
+	}
+	m.name = name
+	return nil
+}
+
+func (m *Meta) Evaluate(data JSONData) (result interface{}, err error) {
+	envelope, ok := eventEnvelope(data)
+	if !ok && m.name != "sample_rate" {
+		return nil, nil
+	}
+	return metaFields[m.name](data, envelope), nil
+}
+
+func (m *Meta) String() string {
+	return fmt.Sprintf("Meta(%v)", m.name)
+}
@@ -0,0 +1,55 @@
+package oxweb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeta(t *testing.T) {
+	event := map[string]interface{}{
+		"n":     1.,
+		MetaKey: &Envelope{time.Unix(100, 500000000), "ranger", 42, 7},
+	}
+
+	for name, expected := range map[string]interface{}{
+		"recv_time":   100.5,
+		"source":      "ranger",
+		"size":        42.,
+		"seq":         7.,
+		"sample_rate": 1.,
+	} {
+		meta := new(Meta)
+		if err := meta.Setup("Meta", []Expression{&Literal{name}}); err != nil {
+			t.Fatal(err)
+		}
+		if value, _ := meta.Evaluate(event); value != expected {
+			t.Errorf("Expected Meta(%v) to be %v, got %v", name, expected, value)
+		}
+	}
+
+	meta := new(Meta)
+	meta.Setup("Meta", []Expression{&Literal{"seq"}})
+	if value, _ := meta.Evaluate(map[string]interface{}{"n": 1.}); value != nil {
+		t.Errorf("Expected nil without an envelope, got %v", value)
+	}
+	if err := new(Meta).Setup("Meta", []Expression{&Literal{"bogus"}}); err == nil {
+		t.Errorf("Expected an error for an unknown field")
+	}
+}
+
+func TestAttachEnvelope(t *testing.T) {
+	envelope := &Envelope{Source: "ranger"}
+	event := map[string]interface{}{"n": 1.}
+	attachEnvelope(event, envelope)
+	if attached, _ := eventEnvelope(event); attached != envelope {
+		t.Errorf("Expected the envelope attached, got %v", event)
+	}
+
+	// A producer's own _meta is left alone.
+	own := map[string]interface{}{"team": "web"}
+	event = map[string]interface{}{MetaKey: own}
+	attachEnvelope(event, envelope)
+	if _, ok := eventEnvelope(event); ok || event[MetaKey].(map[string]interface{})["team"] != "web" {
+		t.Errorf("Expected the producer's _meta to be kept, got %v", event)
+	}
+}
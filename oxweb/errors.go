@@ -0,0 +1,40 @@
+package oxweb
+
+import (
+	"errors"
+)
+
+// Classes of error returned by this package. Errors are wrapped with more
+// detail, so test for them with errors.Is, e.g.
+//
+//	if errors.Is(err, oxweb.ErrWindowEmpty) { ... }
+var (
+	// ErrParse is returned when a statement can't be parsed, including when
+	// a function is given the wrong number or kind of arguments.
+	ErrParse = errors.New("parse error")
+
+	// ErrTypeMismatch is returned when an expression evaluates to a value
+	// of the wrong type for its use, e.g. a string passed to Add().
+	ErrTypeMismatch = errors.New("type mismatch")
+
+	// ErrWindowEmpty is returned by window aggregates that don't yet have
+	// enough values to produce a result.
+	ErrWindowEmpty = errors.New("window empty")
+
+	// ErrStreamClosed is returned when reading from a closed connection.
+	ErrStreamClosed = errors.New("stream closed")
+
+	// ErrDecode is returned when a line of input isn't valid JSON.
+	ErrDecode = errors.New("decode error")
+
+	// ErrChecksum is returned when a line's checksum is missing or wrong.
+	ErrChecksum = errors.New("checksum error")
+
+	// ErrSpillFull is returned when an acknowledged subscriber's spill file
+	// has reached its limit and an event had to be dropped.
+	ErrSpillFull = errors.New("spill full")
+
+	// ErrServerClosed is returned by a JSONServer's Serve once it's shut
+	// down.
+	ErrServerClosed = errors.New("server closed")
+)
@@ -0,0 +1,104 @@
+package oxweb
+
+import (
+	"fmt"
+	"sort"
+)
+
+type exemplar struct {
+	event JSONData
+	rank  float64
+}
+
+/*
+ * Exemplars(aggregateExpr, n int [, "recent"|"max"|"min", rankExpr]) -> {"value": ..., "exemplars": [...]}
+ *
+ * Passes through the value of aggregateExpr along with up to n of the raw
+ * events that were fed to it, so responders can see concrete offending
+ * requests. By default the n most recent events are kept; with "max" or "min"
+ * the n events with the largest or smallest rankExpr seen so far are kept instead.
+ */
+type Exemplars struct {
+	expr      Expression
+	n         Expression
+	mode      string
+	rank      Expression
+	exemplars []exemplar
+}
+
+func (e *Exemplars) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 && len(args) != 4 {
+		return fmt.Errorf("Exemplars expects an expression, a number of events, and optionally \"recent\", \"max\" or \"min\" with a ranking expression")
+	}
+	e.expr, e.n = args[0], args[1]
+	e.mode = "recent"
+	if len(args) == 4 {
+		mode, err := args[2].Evaluate(nil)
+		if err != nil {
+			return err
+		}
+		if mode != "recent" && mode != "max" && mode != "min" {
+			return fmt.Errorf("Exemplars mode must be \"recent\", \"max\" or \"min\", got %v", mode)
+		}
+		e.mode = mode.(string)
+		e.rank = args[3]
+	}
+	return nil
+}
+
+func (e *Exemplars) Evaluate(data JSONData) (result interface{}, err error) {
+	n, err := evaluatePositiveInt(e.n, data, "number of exemplars")
+	if err != nil {
+		return nil, err
+	}
+	value, err := e.expr.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = e.retain(data, n); err != nil {
+		return nil, err
+	}
+
+	events := make([]JSONData, len(e.exemplars))
+	for ndx, ex := range e.exemplars {
+		events[ndx] = ex.event
+	}
+	return map[string]interface{}{
+		"value":     value,
+		"exemplars": events,
+	}, nil
+}
+
+func (e *Exemplars) retain(data JSONData, n int) (err error) {
+	if e.mode == "recent" {
+		e.exemplars = append(e.exemplars, exemplar{event: data})
+		if len(e.exemplars) > n {
+			e.exemplars = e.exemplars[len(e.exemplars)-n:]
+		}
+		return nil
+	}
+
+	value, err := e.rank.Evaluate(data)
+	if err != nil {
+		return err
+	}
+	rank, ok := toFloat(value)
+	if !ok {
+		// Events without a rank can't be extreme.
+		return nil
+	}
+	if e.mode == "min" {
+		rank = -rank
+	}
+	e.exemplars = append(e.exemplars, exemplar{data, rank})
+	sort.SliceStable(e.exemplars, func(i, j int) bool { return e.exemplars[i].rank > e.exemplars[j].rank })
+	if len(e.exemplars) > n {
+		e.exemplars = e.exemplars[:n]
+	}
+	return nil
+}
+
+func (e *Exemplars) String() string {
+	// Exemplars only adds to the payload, so it's named after what it wraps.
+	return e.expr.String()
+}
@@ -0,0 +1,59 @@
+package oxweb
+
+import (
+	"reflect"
+	"testing"
+)
+
+type exemplarsTest struct {
+	statement string
+	latencies []interface{}
+	// The latencies of the exemplars, in order.
+	expected []interface{}
+}
+
+var exemplarsTests = []exemplarsTest{
+	exemplarsTest{"Exemplars(Count(), 2)", []interface{}{5., 1., 9., 3.}, []interface{}{9., 3.}},
+	exemplarsTest{"Exemplars(Count(), 5)", []interface{}{5., 1.}, []interface{}{5., 1.}},
+	exemplarsTest{`Exemplars(Count(), 2, "max", latency)`, []interface{}{5., 1., 9., 3.}, []interface{}{9., 5.}},
+	exemplarsTest{`Exemplars(Count(), 2, "min", latency)`, []interface{}{5., 1., 9., 3.}, []interface{}{1., 3.}},
+	// Ties keep the earlier event, and events without a rank are never kept.
+	exemplarsTest{`Exemplars(Count(), 2, "max", latency)`, []interface{}{nil, 5., 5., 5.}, []interface{}{5., 5.}},
+	exemplarsTest{`Exemplars(Count(), 2, "max", latency)`, []interface{}{nil, "slow"}, []interface{}{}},
+}
+
+func TestExemplars(t *testing.T) {
+	for _, test := range exemplarsTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result interface{}
+		for _, latency := range test.latencies {
+			if result, err = expr.Evaluate(map[string]interface{}{"latency": latency}); err != nil {
+				t.Fatalf("%v: %v", test.statement, err)
+			}
+		}
+
+		payload := result.(map[string]interface{})
+		if payload["value"] != len(test.latencies) {
+			t.Errorf("%v: expected the Count passed through, got %v", test.statement, payload["value"])
+		}
+		latencies := []interface{}{}
+		for _, event := range payload["exemplars"].([]JSONData) {
+			latencies = append(latencies, event.(map[string]interface{})["latency"])
+		}
+		if !reflect.DeepEqual(latencies, test.expected) {
+			t.Errorf("%v of %v: expected exemplars %v, got %v", test.statement, test.latencies, test.expected, latencies)
+		}
+	}
+
+	for _, statement := range []string{"Exemplars(Count())", `Exemplars(Count(), 2, "median", latency)`, `Exemplars(Count(), 2, "max")`} {
+		if _, err := Parse(statement); err == nil {
+			t.Errorf("Expected an error parsing %v", statement)
+		}
+	}
+	if expr, _ := Parse("Exemplars(Count(), 2)"); expr.String() != "Count()" {
+		t.Errorf("Expected Exemplars named after its aggregate, got %v", expr)
+	}
+}
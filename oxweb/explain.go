@@ -0,0 +1,193 @@
+package oxweb
+
+import (
+	"sort"
+	"strings"
+)
+
+// Rough memory used by each element of a window, for Explain: a list element
+// holding a boxed value, plus a timestamp for TimedWindow.
+const (
+	rollingElementBytes = 64
+	timedElementBytes   = 88
+)
+
+// A Plan describes what running a query would involve, without running it;
+// see Explain.
+type Plan struct {
+	Fields  []*PlanNode `json:"fields"`
+	Filters []*PlanNode `json:"filters"`
+	// The records' fields; see Query.ResultSchema.
+	Results []ResultField `json:"results"`
+	// Paths read from each event.
+	Paths []string `json:"paths"`
+	// Windows that will be created, and their estimated memory use.
+	Windows        []*WindowPlan `json:"windows"`
+	EstimatedBytes int64         `json:"estimatedBytes"`
+	Optimizations  []string      `json:"optimizations"`
+	// What Lint has to say about the statements.
+	Warnings []Warning `json:"warnings"`
+}
+
+// A PlanNode is one node of a statement's parsed tree.
+type PlanNode struct {
+	// One of "call", "literal" or "path".
+	Kind string `json:"kind"`
+	// The function name, the literal as Format writes it, or the path.
+	Text string      `json:"text"`
+	Args []*PlanNode `json:"args,omitempty"`
+}
+
+// A WindowPlan is a window a query will create.
+type WindowPlan struct {
+	Window string `json:"window"`
+	// Copies of the window, one per group under GroupBy, at most.
+	Copies int `json:"copies"`
+	// The most elements each copy holds, or 0 if the size isn't a literal.
+	Elements       int   `json:"elements"`
+	EstimatedBytes int64 `json:"estimatedBytes"`
+}
+
+// Explain parses a query and describes how it would run: the parsed tree of
+// each statement, the fields of its records, the paths read from events, the windows created with an
+// estimate of their memory, any optimizations that apply and any Warnings
+// from Lint. Memory for
+// TimedWindows depends on the event rate, so it's estimated at
+// eventsPerSecond.
+func Explain(spec QuerySpec, eventsPerSecond float64) (plan *Plan, err error) {
+	plan = &Plan{Fields: []*PlanNode{}, Filters: []*PlanNode{}, Results: []ResultField{}, Paths: []string{}, Windows: []*WindowPlan{}, Optimizations: []string{}, Warnings: []Warning{}}
+	paths := make(map[string]bool)
+	sampled := false
+
+	explainStatements := func(statements []string) (nodes []*PlanNode, err error) {
+		nodes = []*PlanNode{}
+		for _, statement := range statements {
+			_, warnings, err := ParseWithWarnings(statement)
+			if err != nil {
+				return nil, err
+			}
+			plan.Warnings = append(plan.Warnings, warnings...)
+			syntax := parseSyntax(strings.TrimSpace(stripComments(statement)))
+			nodes = append(nodes, plan.explain(syntax, 1, eventsPerSecond, paths, &sampled))
+		}
+		return nodes, nil
+	}
+	if plan.Fields, err = explainStatements(spec.Fields); err != nil {
+		return nil, err
+	}
+	for _, statement := range spec.Fields {
+		expr, _ := Parse(statement)
+		plan.Results = append(plan.Results, ResultField{Name: expr.String(), Type: resultType(expr)})
+	}
+	if plan.Filters, err = explainStatements(spec.Filters); err != nil {
+		return nil, err
+	}
+
+	for path := range paths {
+		plan.Paths = append(plan.Paths, path)
+	}
+	sort.Strings(plan.Paths)
+	for _, window := range plan.Windows {
+		plan.EstimatedBytes += window.EstimatedBytes
+	}
+	if len(spec.Filters) > 0 {
+		plan.Optimizations = append(plan.Optimizations, "Filters run first: fields are only evaluated for events passing every filter")
+	}
+	if sampled {
+		plan.Optimizations = append(plan.Optimizations, "Sampling: only a fraction of events are evaluated; ScaledCount and ScaledSum correct for it")
+	}
+	return plan, nil
+}
+
+// explain converts a syntax tree to PlanNodes, noting paths and windows along
+// the way. copies is how many copies GroupBys above will make of the node.
+func (plan *Plan) explain(syntax *syntaxNode, copies int, eventsPerSecond float64, paths map[string]bool, sampled *bool) *PlanNode {
+	switch {
+	case syntax.literal:
+		return &PlanNode{Kind: "literal", Text: syntax.text}
+	case !syntax.call:
+		paths[syntax.text] = true
+		return &PlanNode{Kind: "path", Text: syntax.text}
+	}
+
+	node := &PlanNode{Kind: "call", Text: syntax.text}
+	switch syntax.text {
+	case "GetDeep":
+		if len(syntax.args) == 1 {
+			if path, ok := syntax.args[0].value.(string); ok {
+				paths[path] = true
+			}
+		}
+	case "RandomSample", "EveryNth":
+		*sampled = true
+	case "RollingWindow", "TimedWindow":
+		plan.Windows = append(plan.Windows, explainWindow(syntax, copies, eventsPerSecond))
+	}
+
+	for ndx, arg := range syntax.args {
+		node.Args = append(node.Args, plan.explain(arg, argCopies(syntax, ndx, copies), eventsPerSecond, paths, sampled))
+	}
+	return node
+}
+
+// argCopies is how many copies of a call's ndx'th argument there will be,
+// given copies of the call: GroupBy makes one of its aggregate per group.
+func argCopies(syntax *syntaxNode, ndx int, copies int) int {
+	if syntax.text != "GroupBy" || ndx != 1 {
+		return copies
+	}
+	if len(syntax.args) == 3 {
+		if maxKeys, ok := syntax.args[2].value.(int); ok {
+			return copies * maxKeys
+		}
+	}
+	return copies * GroupByMaxKeys
+}
+
+func explainWindow(syntax *syntaxNode, copies int, eventsPerSecond float64) *WindowPlan {
+	window := &WindowPlan{Window: syntax.oneLine(), Copies: copies}
+	if len(syntax.args) < 2 {
+		return window
+	}
+	size, ok := toFloat(syntax.args[1].value)
+	if !ok {
+		return window
+	}
+
+	elementBytes := int64(rollingElementBytes)
+	if syntax.text == "TimedWindow" {
+		size *= eventsPerSecond
+		elementBytes = timedElementBytes
+	}
+	window.Elements = int(size)
+	window.EstimatedBytes = int64(window.Elements) * int64(copies) * elementBytes
+	return window
+}
+
+// Explain explains spec as Explain does, also noting if it would share a
+// running query.
+func (e *Engine) Explain(spec QuerySpec, eventsPerSecond float64) (plan *Plan, err error) {
+	if plan, err = Explain(spec, eventsPerSecond); err != nil {
+		return nil, err
+	}
+	id, err := spec.ID()
+	if err != nil {
+		return nil, err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	names := []string{}
+	for name, q := range e.queries {
+		if q.id == id && name != spec.Name {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		plan.Optimizations = append(plan.Optimizations, "Shared: reuses the running query "+strings.Join(names, ", ")+" and its window state")
+		// Shared windows already exist.
+		plan.EstimatedBytes = 0
+	}
+	return plan, nil
+}
@@ -0,0 +1,70 @@
+package oxweb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	spec := QuerySpec{
+		Fields: []string{
+			"WindowAve(RollingWindow(latency, 100))",
+			`GroupBy(host, WindowAve(TimedWindow(GetDeep("timing.total"), 60)), 10)`,
+		},
+		Filters: []string{"RandomSample(0.1)"},
+	}
+	plan, err := Explain(spec, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"host", "latency", "timing.total"}; !reflect.DeepEqual(plan.Paths, expected) {
+		t.Errorf("Expected paths %v, got %v", expected, plan.Paths)
+	}
+	if len(plan.Windows) != 2 {
+		t.Fatalf("Expected 2 windows, got %v", plan.Windows)
+	}
+	rolling, timed := plan.Windows[0], plan.Windows[1]
+	if rolling.Window != "RollingWindow(latency, 100)" || rolling.Copies != 1 || rolling.Elements != 100 || rolling.EstimatedBytes != 100*rollingElementBytes {
+		t.Errorf("Unexpected rolling window plan %+v", rolling)
+	}
+	if timed.Copies != 10 || timed.Elements != 600 || timed.EstimatedBytes != 10*600*timedElementBytes {
+		t.Errorf("Unexpected timed window plan %+v", timed)
+	}
+	if plan.EstimatedBytes != rolling.EstimatedBytes+timed.EstimatedBytes {
+		t.Errorf("Expected the total of the windows, got %d", plan.EstimatedBytes)
+	}
+	if len(plan.Warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", plan.Warnings)
+	}
+	if len(plan.Optimizations) != 2 {
+		t.Errorf("Expected filter and sampling optimizations, got %v", plan.Optimizations)
+	}
+
+	field := plan.Fields[0]
+	if field.Kind != "call" || field.Text != "WindowAve" || field.Args[0].Args[1].Kind != "literal" || field.Args[0].Args[0].Kind != "path" {
+		t.Errorf("Unexpected tree %+v", field)
+	}
+}
+
+func TestExplainInvalid(t *testing.T) {
+	if _, err := Explain(QuerySpec{Fields: []string{"WindowAve()"}}, 1); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func TestEngineExplainShared(t *testing.T) {
+	engine := NewEngine()
+	spec := QuerySpec{Name: "a", Source: "ranger", Fields: []string{"WindowAve(RollingWindow(latency, 100))"}}
+	engine.Add(spec)
+
+	spec.Name = "b"
+	plan, err := engine.Explain(spec, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Optimizations) != 1 || !strings.Contains(plan.Optimizations[0], "running query a") || plan.EstimatedBytes != 0 {
+		t.Errorf("Expected the plan to share query a, got %+v", plan)
+	}
+}
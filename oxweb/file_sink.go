@@ -0,0 +1,141 @@
+package oxweb
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileSink appends emissions to a file as newline delimited JSON. The file is
+// rotated once it grows past MaxSize bytes or has been open for MaxAge, by
+// renaming it with a timestamp suffix; with Gzip set, rotated files are
+// compressed. Zero MaxSize or MaxAge disables that trigger.
+type FileSink struct {
+	Path    string
+	MaxSize int64
+	MaxAge  time.Duration
+	Gzip    bool
+
+	lock   sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func NewFileSink(path string) (s *FileSink, err error) {
+	s = &FileSink{Path: path}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) Write(record JSONData) (err error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.size > 0 && s.shouldRotate(int64(len(line))) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *FileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
+func (s *FileSink) shouldRotate(next int64) bool {
+	if s.MaxSize > 0 && s.size+next > s.MaxSize {
+		return true
+	}
+	return s.MaxAge > 0 && time.Since(s.opened) >= s.MaxAge
+}
+
+func (s *FileSink) open() (err error) {
+	s.file, err = os.OpenFile(s.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		s.file.Close()
+		return err
+	}
+	s.size = info.Size()
+	s.opened = time.Now()
+	return nil
+}
+
+func (s *FileSink) rotate() (err error) {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	rotated := s.rotatedPath()
+	if err := os.Rename(s.Path, rotated); err != nil {
+		return err
+	}
+	if s.Gzip {
+		if err := gzipFile(rotated); err != nil {
+			return err
+		}
+	}
+	return s.open()
+}
+
+// rotatedPath picks a name for the current file that isn't already taken.
+func (s *FileSink) rotatedPath() string {
+	base := fmt.Sprintf("%s.%s", s.Path, time.Now().Format("20060102-150405"))
+	path := base
+	for n := 1; ; n++ {
+		_, err := os.Stat(path)
+		_, gzErr := os.Stat(path + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(gzErr) {
+			return path
+		}
+		path = fmt.Sprintf("%s.%d", base, n)
+	}
+}
+
+// gzipFile replaces path with path.gz.
+func gzipFile(path string) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(out)
+	if _, err := io.Copy(writer, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,41 @@
+package oxweb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSinkRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "results.json")
+
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.MaxSize = 20
+	sink.Gzip = true
+	for _, record := range []string{"first record", "second record", "third"} {
+		if err := sink.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+
+	current, _ := os.ReadFile(path)
+	if string(current) != "\"third\"\n" {
+		t.Errorf("Expected only the last record in the current file, got %q", current)
+	}
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "results.json.*"))
+	if len(rotated) != 2 {
+		t.Fatalf("Expected 2 rotated files, got %v", rotated)
+	}
+	for _, name := range rotated {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Errorf("Expected rotated file %v to be gzipped", name)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package oxweb
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Seekable source can report how far it has read and resume from there,
+// so a restored checkpoint carries on exactly where it left off. Positions
+// are opaque to everything but the source that produced them. (It's SeekTo
+// rather than Seek to stay clear of io.Seeker's signature.)
+type Seekable interface {
+	Position() int64
+	SeekTo(position int64) error
+}
+
+var _ Seekable = new(FileSource)
+
+// FileSource reads newline delimited JSON events from a file, such as a
+// capture of a stream. Its position is the byte offset of the next line to
+// read. With Follow set it waits for more lines at the end of the file, like
+// tail -f; otherwise it stops there.
+type FileSource struct {
+	Path   string
+	Follow bool
+	// ExactNumbers decodes events with DecodeExact.
+	ExactNumbers bool
+
+	lock        sync.Mutex
+	position    int64
+	subscribers map[*SubscribeRequest]bool
+	reading     bool
+	dropped     atomic.Int64
+}
+
+var _ Source = new(FileSource)
+
+func NewFileSource(path string) *FileSource {
+	return &FileSource{Path: path, subscribers: make(map[*SubscribeRequest]bool)}
+}
+
+func (s *FileSource) Position() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.position
+}
+
+// SeekTo sets where reading resumes. It takes effect the next time reading
+// starts, i.e. when the source gains its first subscriber.
+func (s *FileSource) SeekTo(position int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.position = position
+	return nil
+}
+
+func (s *FileSource) Subscribe(request *SubscribeRequest) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subscribers[request] = true
+	if !s.reading {
+		s.reading = true
+		go s.read(s.position)
+	}
+}
+
+func (s *FileSource) Unsubscribe(request *SubscribeRequest) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.subscribers, request)
+}
+
+func (s *FileSource) read(position int64) {
+	defer func() {
+		s.lock.Lock()
+		s.reading = false
+		s.lock.Unlock()
+	}()
+
+	file, err := os.Open(s.Path)
+	if err != nil {
+		log.Printf("Failed to open %v: %v", s.Path, err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Seek(position, io.SeekStart); err != nil {
+		log.Printf("Failed to seek %v: %v", s.Path, err)
+		return
+	}
+
+	reader := bufio.NewReader(file)
+	pending := []byte{}
+	for {
+		chunk, err := reader.ReadBytes('\n')
+		pending = append(pending, chunk...)
+		if err == io.EOF {
+			if !s.Follow {
+				// Deliver a final line without a newline.
+				if len(pending) > 0 {
+					s.deliver(pending)
+				}
+				return
+			}
+			// Keep any partial line until the rest of it is written.
+			if !s.subscribed() {
+				return
+			}
+			time.Sleep(200 * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed reading %v: %v", s.Path, err)
+			return
+		}
+
+		if !s.deliver(pending) {
+			return
+		}
+		pending = []byte{}
+	}
+}
+
+func (s *FileSource) subscribed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.subscribers) > 0
+}
+
+// deliver sends a line's event to every subscriber and advances the position
+// past it. Returns false once there's nobody left to deliver to.
+func (s *FileSource) deliver(line []byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.subscribers) == 0 {
+		return false
+	}
+	s.position += int64(len(line))
+
+	data, err := decodeEvent(line, s.ExactNumbers)
+	if err != nil {
+		return true
+	}
+	for subscriber := range s.subscribers {
+		subscriber.deliver([]JSONData{data}, s.countDropped)
+	}
+	return true
+}
+
+// Stats reports "dropped", events dropped because a subscriber wasn't keeping
+// up.
+func (s *FileSource) Stats() Stats {
+	return Stats{"dropped": s.dropped.Load()}
+}
+
+func (s *FileSource) countDropped(events int) {
+	s.dropped.Add(int64(events))
+}
@@ -0,0 +1,65 @@
+package oxweb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSourceResumes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.json")
+	os.WriteFile(path, []byte("{\"n\": 1}\n{\"n\": 2}\nnot json\n{\"n\": 3}"), 0644)
+
+	source := NewFileSource(path)
+	request := &SubscribeRequest{DataChan: make(chan JSONData, 8)}
+	source.Subscribe(request)
+
+	receive := func(expected float64) {
+		select {
+		case event := <-request.DataChan:
+			if value, _ := GetDeep("n", event); value != expected {
+				t.Errorf("Expected event %v, got %v", expected, value)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for event %v", expected)
+		}
+	}
+	receive(1)
+	receive(2)
+	receive(3)
+
+	// A fresh source seeked to after the first line starts at the second.
+	source = NewFileSource(path)
+	source.SeekTo(9)
+	request = &SubscribeRequest{DataChan: make(chan JSONData, 8)}
+	source.Subscribe(request)
+	receive(2)
+	receive(3)
+	deadline := time.Now().Add(time.Second)
+	for source.Position() != 35 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if position := source.Position(); position != 35 {
+		t.Errorf("Expected to end at the end of the file, 35, got %d", position)
+	}
+}
+
+func TestFileSourceAcked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.json")
+	os.WriteFile(path, []byte("{\"n\": 1}\n{\"n\": 2}\n"), 0644)
+
+	acked, err := NewAckedSubscriber(filepath.Join(t.TempDir(), "spill"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acked.Close()
+	NewFileSource(path).Subscribe(acked.Request())
+	for _, expected := range []float64{1, 2} {
+		event := receiveAcked(t, acked)
+		if value, _ := GetDeep("n", event.Data); value != expected {
+			t.Errorf("Expected event %v, got %v", expected, value)
+		}
+		acked.Ack(event.Seq)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func PassesAllFilters(line JSONData, filters []Expression) (result bool, err error) {
@@ -16,7 +17,7 @@ func PassesAllFilters(line JSONData, filters []Expression) (result bool, err err
 		}
 		passes, ok := passes.(bool)
 		if !ok {
-			return false, fmt.Errorf("Expected a boolean for %T, got %T", filter, passes)
+			return false, fmt.Errorf("%w: Expected a boolean for %T, got %T", ErrTypeMismatch, filter, passes)
 		}
 		if !passes.(bool) {
 			return false, nil
@@ -48,11 +49,20 @@ func (f *RandomSample) Evaluate(data JSONData) (result interface{}, err error) {
 		return false, err
 	}
 	if sampleRate, ok := sampleRate.(float64); !ok {
-		return false, fmt.Errorf("RandomSample takes a single argument, a float between 0 and 1. Got %v", sampleRate)
+		return false, fmt.Errorf("%w: RandomSample takes a single argument, a float between 0 and 1. Got %v", ErrTypeMismatch, sampleRate)
 	}
 	return rand.Float64() < sampleRate.(float64), nil
 }
 
+func (f *RandomSample) SampleRate(data JSONData) (rate float64, ok bool) {
+	sampleRate, err := f.rate.Evaluate(data)
+	if err != nil {
+		return 0, false
+	}
+	rate, ok = sampleRate.(float64)
+	return rate, ok && rate > 0
+}
+
 func (f *RandomSample) String() string {
 	return fmt.Sprintf("RandomSample(%v)", f.rate)
 }
@@ -81,7 +91,7 @@ func (f *EveryNth) Evaluate(data JSONData) (result interface{}, err error) {
 		return false, err
 	}
 	if rate, ok := rate.(int); !ok {
-		return false, fmt.Errorf("RandomSample takes a single argument, a positive integer. Got %v", rate)
+		return false, fmt.Errorf("%w: RandomSample takes a single argument, a positive integer. Got %v", ErrTypeMismatch, rate)
 	}
 	f.counter++
 	if f.counter >= rate.(int) {
@@ -91,10 +101,73 @@ func (f *EveryNth) Evaluate(data JSONData) (result interface{}, err error) {
 	return false, nil
 }
 
+func (f *EveryNth) SampleRate(data JSONData) (rate float64, ok bool) {
+	n, err := f.rate.Evaluate(data)
+	if err != nil {
+		return 0, false
+	}
+	if n, ok := n.(int); ok && n > 0 {
+		return 1 / float64(n), true
+	}
+	return 0, false
+}
+
 func (f *EveryNth) String() string {
 	return fmt.Sprintf("EveryNth(%v)", f.rate)
 }
 
+/*
+ * Suppress(bool, forSeconds)
+ *
+ * Returns true only once the inner condition has been continuously true for
+ * forSeconds, so a noisy condition flapping between true and false doesn't
+ * trigger an alert each time it flips.
+ */
+type Suppress struct {
+	condition Expression
+	duration  Expression
+	trueSince time.Time
+	// Replaceable in tests.
+	timeSource func() time.Time
+}
+
+func (f *Suppress) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 {
+		return fmt.Errorf("Suppress takes two arguments, a boolean expression and a number of seconds")
+	}
+	f.condition = args[0]
+	f.duration = args[1]
+	if f.timeSource == nil {
+		f.timeSource = time.Now
+	}
+	return
+}
+
+func (f *Suppress) Evaluate(data JSONData) (result interface{}, err error) {
+	duration, err := evaluateSeconds(f.duration, data)
+	if err != nil {
+		return false, err
+	}
+	condition, err := evaluateBool(f.condition, data)
+	if err != nil {
+		return false, err
+	}
+	if !condition {
+		f.trueSince = time.Time{}
+		return false, nil
+	}
+
+	now := f.timeSource()
+	if f.trueSince.IsZero() {
+		f.trueSince = now
+	}
+	return now.Sub(f.trueSince) >= duration, nil
+}
+
+func (f *Suppress) String() string {
+	return fmt.Sprintf("Suppress(%v,%v)", f.condition, f.duration)
+}
+
 /*
  * Comparison Filter
  * 
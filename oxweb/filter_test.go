@@ -0,0 +1,59 @@
+package oxweb
+
+import (
+	"testing"
+	"time"
+)
+
+type suppressTest struct {
+	events   []timedEvent
+	expected []bool
+}
+
+var suppressTests = []suppressTest{
+	// True for the whole 10s.
+	suppressTest{[]timedEvent{
+		{0, `{"high": true}`},
+		{5, `{"high": true}`},
+		{10, `{"high": true}`},
+		{11, `{"high": true}`},
+	}, []bool{false, false, true, true}},
+	// Flapping never stays true long enough.
+	suppressTest{[]timedEvent{
+		{0, `{"high": true}`},
+		{6, `{"high": false}`},
+		{8, `{"high": true}`},
+		{14, `{"high": false}`},
+		{16, `{"high": true}`},
+	}, []bool{false, false, false, false, false}},
+	// Going false resets the clock, even once it has fired.
+	suppressTest{[]timedEvent{
+		{0, `{"high": true}`},
+		{10, `{"high": true}`},
+		{11, `{"high": false}`},
+		{12, `{"high": true}`},
+		{21, `{"high": true}`},
+		{22, `{"high": true}`},
+	}, []bool{false, true, false, false, false, true}},
+}
+
+func TestSuppress(t *testing.T) {
+	for _, test := range suppressTests {
+		expr, err := Parse("Suppress(high, 10)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		suppress := expr.(*Suppress)
+		for ndx, expected := range test.expected {
+			result, err := evaluateTimed(t, expr, func(now func() time.Time) { suppress.timeSource = now }, test.events[ndx:ndx+1])
+			if err != nil || result != expected {
+				t.Errorf("For %v, expected event %d to give %v, got %v, %v", test.events, ndx, expected, result, err)
+			}
+		}
+	}
+
+	expr, _ := Parse("Suppress(high, 10)")
+	if _, err := expr.Evaluate(map[string]interface{}{"high": "yes"}); err == nil {
+		t.Error("Expected an error for a condition that isn't a boolean")
+	}
+}
@@ -0,0 +1,130 @@
+package oxweb
+
+import (
+	"fmt"
+)
+
+// Default smoothing factors for Forecast's level, trend and seasonal components.
+const (
+	ForecastAlpha = 0.5
+	ForecastBeta  = 0.1
+	ForecastGamma = 0.1
+)
+
+func evaluatePositiveInt(expr Expression, data JSONData, what string) (n int, err error) {
+	value, err := expr.Evaluate(data)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := value.(int)
+	if !ok || n <= 0 {
+		return 0, fmt.Errorf("%w: Expected a positive int %v. Got a %T, %v", ErrTypeMismatch, what, value, value)
+	}
+	return n, nil
+}
+
+/*
+ * Forecast(expr, seasonLength int, horizon int [, alpha, beta, gamma]) -> float64
+ *
+ * Additive Holt-Winters smoothing of a numeric series. Each evaluation feeds
+ * the value in and returns the value predicted horizon events ahead, so alert
+ * thresholds can be set relative to what was expected. Seasons are measured in
+ * events (e.g. 24 for hourly samples with a daily cycle). Until a full season
+ * has been seen there is nothing to forecast from and an error is returned.
+ */
+type Forecast struct {
+	expr         Expression
+	seasonLength Expression
+	horizon      Expression
+	alpha        float64
+	beta         float64
+	gamma        float64
+
+	history  []float64
+	level    float64
+	trend    float64
+	seasonal []float64
+	t        int
+}
+
+func (f *Forecast) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 3 && len(args) != 6 {
+		return fmt.Errorf("Forecast expects an expression, a season length and a horizon, optionally followed by alpha, beta and gamma")
+	}
+	f.expr, f.seasonLength, f.horizon = args[0], args[1], args[2]
+	f.alpha, f.beta, f.gamma = ForecastAlpha, ForecastBeta, ForecastGamma
+	if len(args) == 6 {
+		factors := []*float64{&f.alpha, &f.beta, &f.gamma}
+		for ndx, arg := range args[3:] {
+			value, err := arg.Evaluate(nil)
+			factor, ok := value.(float64)
+			if err != nil || !ok || factor < 0 || factor > 1 {
+				return fmt.Errorf("Forecast smoothing factors must be floats between 0 and 1. Got %v", arg)
+			}
+			*factors[ndx] = factor
+		}
+	}
+	return nil
+}
+
+func (f *Forecast) Evaluate(data JSONData) (result interface{}, err error) {
+	seasonLength, err := evaluatePositiveInt(f.seasonLength, data, "season length")
+	if err != nil {
+		return nil, err
+	}
+	horizon, err := evaluatePositiveInt(f.horizon, data, "horizon")
+	if err != nil {
+		return nil, err
+	}
+	value, err := f.expr.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if value != nil {
+		x, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%w: Forecast expects a float64, got %T, %v", ErrTypeMismatch, value, value)
+		}
+		f.update(x, seasonLength)
+	}
+
+	if f.seasonal == nil {
+		return nil, fmt.Errorf("%w: Forecast needs a full season of %d values, has %d", ErrWindowEmpty, seasonLength, len(f.history))
+	}
+	m := len(f.seasonal)
+	return f.level + float64(horizon)*f.trend + f.seasonal[(f.t+horizon-1)%m], nil
+}
+
+func (f *Forecast) update(x float64, seasonLength int) {
+	if f.seasonal == nil {
+		// Initialize from the first season: level is its mean, seasonal
+		// components are each value's offset from it.
+		f.history = append(f.history, x)
+		if len(f.history) < seasonLength {
+			return
+		}
+		for _, h := range f.history {
+			f.level += h
+		}
+		f.level /= float64(len(f.history))
+		f.seasonal = make([]float64, len(f.history))
+		for ndx, h := range f.history {
+			f.seasonal[ndx] = h - f.level
+		}
+		f.t = len(f.history)
+		f.history = nil
+		return
+	}
+
+	s := f.t % len(f.seasonal)
+	lastLevel := f.level
+	f.level = f.alpha*(x-f.seasonal[s]) + (1-f.alpha)*(f.level+f.trend)
+	f.trend = f.beta*(f.level-lastLevel) + (1-f.beta)*f.trend
+	f.seasonal[s] = f.gamma*(x-f.level) + (1-f.gamma)*f.seasonal[s]
+	f.t++
+}
+
+func (f *Forecast) String() string {
+	return fmt.Sprintf("Forecast(%v,%v,%v)", f.expr, f.seasonLength, f.horizon)
+}
@@ -0,0 +1,57 @@
+package oxweb
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+type forecastTest struct {
+	statement string
+	values    []interface{}
+	expected  float64
+	err       error
+}
+
+var forecastTests = []forecastTest{
+	// A repeating season is forecast exactly.
+	forecastTest{"Forecast(v, 3, 1)", []interface{}{1., 5., 3.}, 1, nil},
+	forecastTest{"Forecast(v, 3, 1)", []interface{}{1., 5., 3., 1., 5., 3., 1.}, 5, nil},
+	forecastTest{"Forecast(v, 3, 2)", []interface{}{1., 5., 3.}, 5, nil},
+	forecastTest{"Forecast(v, 1, 5)", []interface{}{4., 4., 4.}, 4, nil},
+	// Nils are skipped.
+	forecastTest{"Forecast(v, 3, 1)", []interface{}{1., nil, 5., 3.}, 1, nil},
+	forecastTest{"Forecast(v, 3, 1)", []interface{}{1., 5.}, 0, ErrWindowEmpty},
+	forecastTest{"Forecast(v, 0, 1)", []interface{}{1.}, 0, ErrTypeMismatch},
+	forecastTest{"Forecast(v, 3, 1.5)", []interface{}{1.}, 0, ErrTypeMismatch},
+	forecastTest{"Forecast(v, 3, 1)", []interface{}{"1"}, 0, ErrTypeMismatch},
+}
+
+func TestForecast(t *testing.T) {
+	for _, test := range forecastTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only the last evaluation counts; the first season has no forecast.
+		var result interface{}
+		for _, value := range test.values {
+			result, err = expr.Evaluate(map[string]interface{}{"v": value})
+		}
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%v of %v: expected %v, got %v", test.statement, test.values, test.err, err)
+			}
+			continue
+		}
+		if forecast, ok := result.(float64); err != nil || !ok || math.Abs(forecast-test.expected) > 1e-9 {
+			t.Errorf("%v of %v: expected %v, got %v, %v", test.statement, test.values, test.expected, result, err)
+		}
+	}
+
+	for _, statement := range []string{"Forecast(v, 3)", "Forecast(v, 3, 1, 1.5, 0.1, 0.1)", "Forecast(v, 3, 1, 0.5)"} {
+		if _, err := Parse(statement); err == nil {
+			t.Errorf("Expected an error parsing %v", statement)
+		}
+	}
+}
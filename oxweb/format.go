@@ -0,0 +1,134 @@
+package oxweb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatWidth is the line length FormatIndent keeps calls within where it
+// can, counting each indent as its length in bytes.
+const FormatWidth = 80
+
+// Format returns statement in canonical form, so stored queries can be
+// normalized and compared: one space after each comma and none elsewhere,
+// comments removed, strings double quoted, floats written with a decimal
+// point (so they stay floats) and JSON literals compacted with sorted keys.
+// Formatting the result again returns it unchanged. Statements that don't
+// parse return the parse error.
+func Format(statement string) (formatted string, err error) {
+	return FormatIndent(statement, "")
+}
+
+// FormatIndent formats like Format, but breaks calls too long for FormatWidth
+// over several lines, one argument per line indented by indent:
+//
+//	GroupBy(
+//		status,
+//		WindowPercentile(TimedWindow(GetDeep("timing.total"), 300), 99.0)
+//	)
+//
+// With an empty indent everything stays on one line.
+func FormatIndent(statement string, indent string) (formatted string, err error) {
+	if _, err := Parse(statement); err != nil {
+		return "", err
+	}
+	return parseSyntax(strings.TrimSpace(stripComments(statement))).format(indent, 0), nil
+}
+
+// A syntaxNode is a statement as written, which Expressions don't keep: their
+// String() is for naming results and doesn't round trip.
+type syntaxNode struct {
+	// The function name for calls, otherwise the canonical text of a literal
+	// or GetDeep path.
+	text string
+	call bool
+	args []*syntaxNode
+
+	// For literals, their value.
+	literal bool
+	value   interface{}
+}
+
+func parseSyntax(statement string) *syntaxNode {
+	if literal, err := ParseLiteral(statement); err == nil {
+		return &syntaxNode{text: formatLiteral(literal.value), literal: true, value: literal.value}
+	}
+	fname, args, err := ParseString(statement)
+	if err != nil {
+		return &syntaxNode{text: statement}
+	}
+	node := &syntaxNode{text: fname, call: true}
+	for _, arg := range args {
+		node.args = append(node.args, parseSyntax(arg))
+	}
+	return node
+}
+
+func formatLiteral(value interface{}) string {
+	switch value := value.(type) {
+	case int:
+		return strconv.Itoa(value)
+	case float64:
+		formatted := strconv.FormatFloat(value, 'g', -1, 64)
+		if !strings.ContainsAny(formatted, ".eEnN") {
+			formatted += ".0"
+		}
+		return formatted
+	case string:
+		return strconv.Quote(value)
+	case time.Duration, ByteSize:
+		return fmt.Sprint(value)
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+func (n *syntaxNode) oneLine() string {
+	if !n.call {
+		return n.text
+	}
+	args := make([]string, len(n.args))
+	for ndx, arg := range n.args {
+		args[ndx] = arg.oneLine()
+	}
+	return n.text + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (n *syntaxNode) format(indent string, depth int) string {
+	line := n.oneLine()
+	if indent == "" || len(n.args) == 0 || len(indent)*depth+len(line) <= FormatWidth {
+		return line
+	}
+
+	var out strings.Builder
+	out.WriteString(n.text + "(\n")
+	for ndx, arg := range n.args {
+		out.WriteString(strings.Repeat(indent, depth+1) + arg.format(indent, depth+1))
+		if ndx < len(n.args)-1 {
+			out.WriteString(",")
+		}
+		out.WriteString("\n")
+	}
+	out.WriteString(strings.Repeat(indent, depth) + ")")
+	return out.String()
+}
+
+// StatementID identifies a statement by the SHA-256 of its canonical Format,
+// so statements differing only in spacing, comments or quoting share an ID.
+func StatementID(statement string) (id string, err error) {
+	formatted, err := Format(statement)
+	if err != nil {
+		return "", err
+	}
+	return hashID(formatted), nil
+}
+
+func hashID(text string) string {
+	digest := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(digest[:])
+}
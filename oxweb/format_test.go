@@ -0,0 +1,68 @@
+package oxweb
+
+import (
+	"testing"
+)
+
+var formatTests = []struct {
+	statement string
+	formatted string
+}{
+	{"a", "a"},
+	{"  timing.total ", "timing.total"},
+	{"Add(a,b)", "Add(a, b)"},
+	{"Add( a ,\n\tb ) # sum", "Add(a, b)"},
+	{"Multiply(a,2.0)", "Multiply(a, 2.0)"},
+	{"Multiply(a,2.50)", "Multiply(a, 2.5)"},
+	{"Multiply(a,1e21)", "Multiply(a, 1e+21)"},
+	{"RollingWindow(a,10)", "RollingWindow(a, 10)"},
+	{"GetDeep('b')", `GetDeep("b")`},
+	{"GetDeep(`a\"b`)", `GetDeep("a\"b")`},
+	{`Bucket(a,[10,1,100])`, "Bucket(a, [10,1,100])"},
+	{`Object("b",b,"a",a)`, `Object("b", b, "a", a)`},
+	{`As(Add(a,Subtract(b,c)),"total")`, `As(Add(a, Subtract(b, c)), "total")`},
+}
+
+func TestFormat(t *testing.T) {
+	for _, test := range formatTests {
+		formatted, err := Format(test.statement)
+		if err != nil {
+			t.Errorf("For statement %q, unexpected error %v", test.statement, err)
+			continue
+		}
+		if formatted != test.formatted {
+			t.Errorf("For statement %q, expected %q, got %q", test.statement, test.formatted, formatted)
+		}
+		if again, _ := Format(formatted); again != formatted {
+			t.Errorf("Formatting %q again gave %q", formatted, again)
+		}
+	}
+}
+
+func TestFormatIndent(t *testing.T) {
+	statement := `GroupBy(status, WindowPercentile(TimedWindow(GetDeep("timing.total"), 300), 99.0), Add(aaaaaaaaaaaaaaaaaaaaaaaaaaaa, bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb))`
+	expected := `GroupBy(
+  status,
+  WindowPercentile(TimedWindow(GetDeep("timing.total"), 300), 99.0),
+  Add(
+    aaaaaaaaaaaaaaaaaaaaaaaaaaaa,
+    bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb
+  )
+)`
+	formatted, err := FormatIndent(statement, "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatted != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, formatted)
+	}
+	if again, _ := FormatIndent(formatted, "  "); again != formatted {
+		t.Errorf("Formatting again gave:\n%s", again)
+	}
+}
+
+func TestFormatInvalid(t *testing.T) {
+	if _, err := Format("Add(a)"); err == nil {
+		t.Error("Expected an error")
+	}
+}
@@ -0,0 +1,167 @@
+package oxweb
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// evaluateSeconds evaluates a positive number of seconds, or a duration, as a
+// Duration.
+func evaluateSeconds(expr Expression, data JSONData) (d time.Duration, err error) {
+	value, err := expr.Evaluate(data)
+	if err != nil {
+		return 0, err
+	}
+	var seconds float64
+	switch value := value.(type) {
+	case int:
+		seconds = float64(value)
+	case float64:
+		seconds = value
+	case time.Duration:
+		seconds = value.Seconds()
+	default:
+		return 0, fmt.Errorf("%w: Expected a number of seconds. Got a %T, %v", ErrTypeMismatch, value, value)
+	}
+	if seconds <= 0 {
+		return 0, fmt.Errorf("%w: Expected a positive number of seconds. Got %v", ErrTypeMismatch, seconds)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// evaluateBool evaluates a filter expression, which must produce a boolean.
+func evaluateBool(expr Expression, data JSONData) (result bool, err error) {
+	value, err := expr.Evaluate(data)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: Expected a boolean for %v, got %T", ErrTypeMismatch, expr, value)
+	}
+	return result, nil
+}
+
+// mapKey makes an evaluated value safe to use as a map key; JSON objects and
+// arrays aren't comparable so they're keyed by their text.
+func mapKey(value interface{}) interface{} {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return fmt.Sprintf("%v", value)
+	}
+	return value
+}
+
+type funnelSession struct {
+	key   interface{}
+	start time.Time
+	step  int
+}
+
+/*
+ * Funnel(keyExpr, step1Filter, step2Filter, ..., windowSeconds) -> []int
+ *
+ * Tracks each key (e.g. a user id) through an ordered series of steps. A key
+ * enters the funnel on an event matching step1Filter and advances one step
+ * each time an event for it matches the next filter, as long as that happens
+ * within windowSeconds of entering. Returns the number of keys currently in
+ * the window that have completed each step.
+ */
+type Funnel struct {
+	key      Expression
+	steps    []Expression
+	window   Expression
+	sessions map[interface{}]*list.Element
+	order    list.List
+	// Replaceable in tests.
+	timeSource func() time.Time
+}
+
+func (f *Funnel) Setup(fname string, args []Expression) (err error) {
+	if len(args) < 3 {
+		return fmt.Errorf("Funnel expects a key expression, at least one step filter and a window size in seconds")
+	}
+	f.key = args[0]
+	f.steps = args[1 : len(args)-1]
+	f.window = args[len(args)-1]
+	f.sessions = make(map[interface{}]*list.Element)
+	f.order.Init()
+	if f.timeSource == nil {
+		f.timeSource = time.Now
+	}
+	return nil
+}
+
+func (f *Funnel) Evaluate(data JSONData) (result interface{}, err error) {
+	window, err := evaluateSeconds(f.window, data)
+	if err != nil {
+		return nil, err
+	}
+	now := f.timeSource()
+	f.expire(now.Add(-window))
+
+	key, err := f.key.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		if err = f.advance(mapKey(key), data, now); err != nil {
+			return nil, err
+		}
+	}
+
+	counts := make([]int, len(f.steps))
+	for elem := f.order.Front(); elem != nil; elem = elem.Next() {
+		for step := 0; step < elem.Value.(*funnelSession).step; step++ {
+			counts[step]++
+		}
+	}
+	return counts, nil
+}
+
+func (f *Funnel) advance(key interface{}, data JSONData, now time.Time) (err error) {
+	elem, ok := f.sessions[key]
+	if !ok {
+		entered, err := evaluateBool(f.steps[0], data)
+		if err != nil || !entered {
+			return err
+		}
+		f.sessions[key] = f.order.PushBack(&funnelSession{key, now, 1})
+		return nil
+	}
+
+	session := elem.Value.(*funnelSession)
+	if session.step >= len(f.steps) {
+		return nil
+	}
+	matched, err := evaluateBool(f.steps[session.step], data)
+	if err != nil {
+		return err
+	}
+	if matched {
+		session.step++
+	}
+	return nil
+}
+
+// expire drops sessions that entered the funnel before windowStart.
+func (f *Funnel) expire(windowStart time.Time) {
+	for {
+		front := f.order.Front()
+		if front == nil || !front.Value.(*funnelSession).start.Before(windowStart) {
+			return
+		}
+		delete(f.sessions, front.Value.(*funnelSession).key)
+		f.order.Remove(front)
+	}
+}
+
+func (f *Funnel) String() string {
+	steps := make([]string, len(f.steps))
+	for ndx, step := range f.steps {
+		steps[ndx] = step.String()
+	}
+	return fmt.Sprintf("Funnel(%v,%v,%v)", f.key, strings.Join(steps, ","), f.window)
+}
@@ -0,0 +1,93 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// timedEvent is an event for a stateful expression, seconds after the test
+// starts.
+type timedEvent struct {
+	at   int
+	data string
+}
+
+type funnelTest struct {
+	events   []timedEvent
+	expected []int
+}
+
+var funnelTests = []funnelTest{
+	// One user through every step.
+	funnelTest{[]timedEvent{
+		{0, `{"user": "a", "view": true, "cart": false, "buy": false}`},
+		{1, `{"user": "a", "view": false, "cart": true, "buy": false}`},
+		{2, `{"user": "a", "view": false, "cart": false, "buy": true}`},
+	}, []int{1, 1, 1}},
+	// Users drop out at different steps.
+	funnelTest{[]timedEvent{
+		{0, `{"user": "a", "view": true, "cart": false, "buy": false}`},
+		{1, `{"user": "b", "view": true, "cart": false, "buy": false}`},
+		{2, `{"user": "b", "view": false, "cart": true, "buy": false}`},
+	}, []int{2, 1, 0}},
+	// Steps out of order don't count, and a repeated step doesn't advance.
+	funnelTest{[]timedEvent{
+		{0, `{"user": "a", "view": false, "cart": true, "buy": false}`},
+		{1, `{"user": "a", "view": true, "cart": false, "buy": false}`},
+		{2, `{"user": "a", "view": true, "cart": false, "buy": false}`},
+		{3, `{"user": "a", "view": false, "cart": false, "buy": true}`},
+	}, []int{1, 0, 0}},
+	// a entered more than the window ago, so has left the funnel.
+	funnelTest{[]timedEvent{
+		{0, `{"user": "a", "view": true, "cart": false, "buy": false}`},
+		{30, `{"user": "a", "view": false, "cart": true, "buy": false}`},
+		{50, `{"user": "b", "view": true, "cart": false, "buy": false}`},
+		{70, `{"user": "c", "view": false, "cart": false, "buy": false}`},
+	}, []int{1, 0, 0}},
+	// Events without a key are ignored.
+	funnelTest{[]timedEvent{
+		{0, `{"view": true, "cart": false, "buy": false}`},
+	}, []int{0, 0, 0}},
+}
+
+// evaluateTimed feeds events through expr with its clock set by set, returning
+// the last result.
+func evaluateTimed(t *testing.T, expr Expression, set func(now func() time.Time), events []timedEvent) (result interface{}, err error) {
+	now := time.Unix(1000, 0)
+	set(func() time.Time { return now })
+	for _, event := range events {
+		var data JSONData
+		if err := json.Unmarshal([]byte(event.data), &data); err != nil {
+			t.Fatal(err)
+		}
+		now = time.Unix(1000+int64(event.at), 0)
+		if result, err = expr.Evaluate(data); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func TestFunnel(t *testing.T) {
+	for _, test := range funnelTests {
+		expr, err := Parse("Funnel(user, view, cart, buy, 60)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		funnel := expr.(*Funnel)
+		result, err := evaluateTimed(t, expr, func(now func() time.Time) { funnel.timeSource = now }, test.events)
+		if err != nil || !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("For %v, expected %v, got %v, %v", test.events, test.expected, result, err)
+		}
+	}
+
+	if _, err := Parse("Funnel(user, 60)"); err == nil {
+		t.Error("Expected an error for a Funnel without steps")
+	}
+	expr, _ := Parse("Funnel(user, view, 60)")
+	if _, err := expr.Evaluate(map[string]interface{}{"user": "a", "view": 1.}); err == nil {
+		t.Error("Expected an error for a step that isn't a boolean")
+	}
+}
@@ -0,0 +1,99 @@
+package oxweb
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A SequenceGap is a range of sequence numbers, From to To inclusive, that
+// were either missing from a stream or seen more than once.
+type SequenceGap struct {
+	From      int64
+	To        int64
+	Duplicate bool
+}
+
+func (g SequenceGap) String() string {
+	kind := "missing"
+	if g.Duplicate {
+		kind = "duplicated"
+	}
+	if g.From == g.To {
+		return fmt.Sprintf("%s %d", kind, g.From)
+	}
+	return fmt.Sprintf("%s %d-%d", kind, g.From, g.To)
+}
+
+// GapDetector watches the sequence numbers events carry at Path, expecting
+// each to be one more than the last. A jump forward is reported as a missing
+// range; a number at or below the last is reported as a duplicate, since
+// sequences are assumed not to be reordered. Events without a number are
+// ignored.
+type GapDetector struct {
+	Path string
+	// OnGap, if set, is called with each gap as it's found.
+	OnGap func(gap SequenceGap)
+
+	lock       sync.Mutex
+	started    bool
+	last       int64
+	missing    int64
+	duplicates int64
+	gaps       int64
+}
+
+func NewGapDetector(path string) *GapDetector {
+	return &GapDetector{Path: path}
+}
+
+// Observe checks an event's sequence number.
+func (d *GapDetector) Observe(data JSONData) {
+	value, ok := GetDeep(d.Path, data)
+	if !ok {
+		return
+	}
+	number, ok := toFloat(value)
+	if !ok {
+		return
+	}
+	seq := int64(number)
+
+	d.lock.Lock()
+	var gap *SequenceGap
+	switch {
+	case !d.started:
+		d.started = true
+		d.last = seq
+	case seq > d.last+1:
+		gap = &SequenceGap{From: d.last + 1, To: seq - 1}
+		d.missing += seq - d.last - 1
+		d.last = seq
+	case seq <= d.last:
+		gap = &SequenceGap{From: seq, To: seq, Duplicate: true}
+		d.duplicates++
+	default:
+		d.last = seq
+	}
+	if gap != nil {
+		d.gaps++
+	}
+	onGap := d.OnGap
+	d.lock.Unlock()
+
+	if gap != nil && onGap != nil {
+		onGap(*gap)
+	}
+}
+
+// Stats reports the number of sequence numbers missing and duplicated, and
+// how many gaps they came in.
+func (d *GapDetector) Stats() Stats {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return Stats{
+		"seq_missing":    d.missing,
+		"seq_duplicates": d.duplicates,
+		"seq_gaps":       d.gaps,
+		"seq_last":       d.last,
+	}
+}
@@ -0,0 +1,25 @@
+package oxweb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGapDetector(t *testing.T) {
+	gaps := []string{}
+	detector := NewGapDetector("seq")
+	detector.OnGap = func(gap SequenceGap) { gaps = append(gaps, gap.String()) }
+
+	for _, seq := range []float64{5, 6, 9, 10, 10, 11, 12} {
+		detector.Observe(map[string]interface{}{"seq": seq})
+	}
+	detector.Observe(map[string]interface{}{"other": 1.})
+
+	if expected := []string{"missing 7-8", "duplicated 10"}; !reflect.DeepEqual(gaps, expected) {
+		t.Errorf("Expected gaps %v, got %v", expected, gaps)
+	}
+	stats := detector.Stats()
+	if stats["seq_missing"] != 2 || stats["seq_duplicates"] != 1 || stats["seq_gaps"] != 2 || stats["seq_last"] != 12 {
+		t.Errorf("Unexpected stats %v", stats)
+	}
+}
@@ -1,9 +1,12 @@
 package oxweb
 
 import (
+	"fmt"
 	"log"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type JSONData interface{}
@@ -23,26 +26,104 @@ func GetDeep(key string, data JSONData) (dataStep interface{}, ok bool) {
 			continue
 
 		case []interface{}:
+			array := dataStep.([]interface{})
 			arrayIndex, err := strconv.Atoi(subKey)
-			if err != nil {
+			if err != nil || arrayIndex < 0 || arrayIndex >= len(array) {
 				return nil, false
 			}
-			defer func() {
-				if e := recover(); e != nil {
-					dataStep = nil
-					ok = false
-				}
-			}()
-			dataStep = dataStep.([]interface{})[arrayIndex]
+			dataStep = array[arrayIndex]
 			continue
 		default:
-			log.Println("don't know how to handle this type: %T", dataStep)
+			log.Printf("don't know how to handle this type: %T", dataStep)
 			return nil, false
 		}
 	}
 	return dataStep, true
 }
 
+// Typed accessors for Go code reading events, e.g. from a subscription's
+// DataChan. ok is false when there's no value at path (or it's null); err is
+// set when there is one but it's of the wrong type.
+
+// GetString returns the string at path.
+func GetString(path string, data JSONData) (value string, ok bool, err error) {
+	raw, ok := GetDeep(path, data)
+	if !ok || raw == nil {
+		return "", false, nil
+	}
+	if value, ok = raw.(string); !ok {
+		return "", true, fmt.Errorf("%w: Expected a string at %v, got %T", ErrTypeMismatch, path, raw)
+	}
+	return value, true, nil
+}
+
+// GetFloat returns the number at path.
+func GetFloat(path string, data JSONData) (value float64, ok bool, err error) {
+	raw, ok := GetDeep(path, data)
+	if !ok || raw == nil {
+		return 0, false, nil
+	}
+	if value, ok = toFloat(raw); !ok {
+		return 0, true, fmt.Errorf("%w: Expected a number at %v, got %T", ErrTypeMismatch, path, raw)
+	}
+	return value, true, nil
+}
+
+// GetInt returns the number at path, which must be a whole number.
+func GetInt(path string, data JSONData) (value int, ok bool, err error) {
+	// DecodeExact's integers are converted exactly, if they fit.
+	raw, _ := GetDeep(path, data)
+	if integer := bigInteger(raw); isWideInteger(raw) {
+		if !integer.IsInt64() || int64(int(integer.Int64())) != integer.Int64() {
+			return 0, true, fmt.Errorf("%w: Expected an int at %v, got %v", ErrTypeMismatch, path, raw)
+		}
+		return int(integer.Int64()), true, nil
+	}
+	f, ok, err := GetFloat(path, data)
+	if !ok || err != nil {
+		return 0, ok, err
+	}
+	if f != math.Trunc(f) || f >= math.MaxInt64 || f < math.MinInt64 {
+		return 0, true, fmt.Errorf("%w: Expected an int at %v, got %v", ErrTypeMismatch, path, f)
+	}
+	return int(f), true, nil
+}
+
+// GetBool returns the boolean at path.
+func GetBool(path string, data JSONData) (value bool, ok bool, err error) {
+	raw, ok := GetDeep(path, data)
+	if !ok || raw == nil {
+		return false, false, nil
+	}
+	if value, ok = raw.(bool); !ok {
+		return false, true, fmt.Errorf("%w: Expected a boolean at %v, got %T", ErrTypeMismatch, path, raw)
+	}
+	return value, true, nil
+}
+
+// GetTime returns the time at path: a string in the time.Parse layout given,
+// or with an empty layout, Unix seconds or an RFC 3339 string.
+func GetTime(path string, layout string, data JSONData) (value time.Time, ok bool, err error) {
+	raw, ok := GetDeep(path, data)
+	if !ok || raw == nil {
+		return value, false, nil
+	}
+	if layout == "" {
+		if value, ok = toTime(raw); !ok {
+			return value, true, fmt.Errorf("%w: Expected a timestamp at %v, got %T, %v", ErrTypeMismatch, path, raw, raw)
+		}
+		return value, true, nil
+	}
+	s, isString := raw.(string)
+	if !isString {
+		return value, true, fmt.Errorf("%w: Expected a time string at %v, got %T", ErrTypeMismatch, path, raw)
+	}
+	if value, err = time.Parse(layout, s); err != nil {
+		return value, true, fmt.Errorf("%w: %v", ErrTypeMismatch, err)
+	}
+	return value, true, nil
+}
+
 /*
  * GetDeepExpr
 type GetDeepExpr struct {
@@ -2,10 +2,16 @@ package oxweb
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
+	"log"
+	"math"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 type getDeepTest struct {
@@ -25,6 +31,7 @@ var getDeepTests = []getDeepTest{
 	//	getDeepTest{"array", [2]float64{2., 3.}, true},
 	getDeepTest{"array.1", 3., true},
 	getDeepTest{"array.foo", nil, false},
+	getDeepTest{"array.-1", nil, false},
 }
 
 var jsonString = `{
@@ -58,3 +65,74 @@ func TestGetDeep(t *testing.T) {
 		}
 	}
 }
+
+func TestTypedAccessors(t *testing.T) {
+	var fixture JSONData
+	json.Unmarshal([]byte(`{"s": "foo", "f": 1.5, "i": 3, "b": true, "null": null,
+		"ts": 1700000000, "iso": "2023-11-14T22:13:20Z", "day": "2023-11-14"}`), &fixture)
+
+	if value, ok, err := GetString("s", fixture); value != "foo" || !ok || err != nil {
+		t.Errorf("GetString: %v, %t, %v", value, ok, err)
+	}
+	if _, ok, err := GetString("f", fixture); !ok || err == nil {
+		t.Errorf("Expected GetString of a number to fail")
+	}
+	if _, ok, err := GetString("null", fixture); ok || err != nil {
+		t.Errorf("Expected null to be missing")
+	}
+	if value, ok, err := GetFloat("f", fixture); value != 1.5 || !ok || err != nil {
+		t.Errorf("GetFloat: %v, %t, %v", value, ok, err)
+	}
+	if value, ok, err := GetInt("i", fixture); value != 3 || !ok || err != nil {
+		t.Errorf("GetInt: %v, %t, %v", value, ok, err)
+	}
+	if _, _, err := GetInt("f", fixture); err == nil {
+		t.Errorf("Expected GetInt of 1.5 to fail")
+	}
+	// 2^63 as a float64 is MaxInt64 rounded up, just out of range.
+	if _, _, err := GetInt("big", map[string]interface{}{"big": math.Ldexp(1, 63)}); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected GetInt of 2^63 to fail, got %v", err)
+	}
+	if value, _, err := GetInt("small", map[string]interface{}{"small": -math.Ldexp(1, 63)}); value != math.MinInt64 || err != nil {
+		t.Errorf("Expected GetInt of -2^63 to fit, got %v, %v", value, err)
+	}
+	if value, ok, err := GetBool("b", fixture); !value || !ok || err != nil {
+		t.Errorf("GetBool: %v, %t, %v", value, ok, err)
+	}
+	if _, ok, err := GetBool("missing", fixture); ok || err != nil {
+		t.Errorf("Expected missing to be missing")
+	}
+
+	expected := time.Unix(1700000000, 0)
+	for _, path := range []string{"ts", "iso"} {
+		if value, ok, err := GetTime(path, "", fixture); !value.Equal(expected) || !ok || err != nil {
+			t.Errorf("GetTime(%v): %v, %t, %v", path, value, ok, err)
+		}
+	}
+	if value, _, err := GetTime("day", "2006-01-02", fixture); value.Day() != 14 || err != nil {
+		t.Errorf("GetTime with a layout: %v, %v", value, err)
+	}
+	if _, _, err := GetTime("s", "2006-01-02", fixture); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected a type mismatch, got %v", err)
+	}
+}
+
+func FuzzGetDeep(f *testing.F) {
+	for _, test := range getDeepTests {
+		f.Add(test.key)
+	}
+	for _, key := range []string{"", ".", "array.-1", "array.99999999999999999999", "c..d", "array.1.x"} {
+		f.Add(key)
+	}
+	var fixture JSONData
+	json.Unmarshal([]byte(jsonString), &fixture)
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	f.Fuzz(func(t *testing.T, key string) {
+		value, ok := GetDeep(key, fixture)
+		if !ok && value != nil {
+			t.Errorf("For key %q, expected no value with ok = false, got %v", key, value)
+		}
+	})
+}
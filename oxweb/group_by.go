@@ -0,0 +1,255 @@
+package oxweb
+
+import (
+	"fmt"
+	"sort"
+)
+
+// GroupValue is the current value of a single group's aggregate, along with
+// the number of events that have been evaluated for the group.
+type GroupValue struct {
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+}
+
+// GroupResult is what GroupBy evaluates to: every group's latest value keyed
+// by the group key. It serializes as a single JSON object, e.g.
+//
+//	{"web1": {"value": 12.5, "count": 40}, "web2": {"value": 9.1, "count": 38}}
+type GroupResult map[string]GroupValue
+
+// GroupByMaxKeys is the default cap on the number of groups a GroupBy keeps.
+const GroupByMaxKeys = 10000
+
+// OverflowGroupKey is the group that events are aggregated into once a
+// GroupBy has reached its key cap.
+const OverflowGroupKey = "other"
+
+type group struct {
+	aggregate Expression
+	value     interface{}
+	count     int
+}
+
+/*
+ * GroupBy(keyExpr, aggregateExpr [, maxKeys int]) -> GroupResult
+ *
+ * Evaluates a separate copy of aggregateExpr for each distinct value of keyExpr,
+ * e.g. GroupBy(host, WindowAve(RollingWindow(latency, 100))) keeps a window per
+ * host. Events whose key is nil are ignored.
+ *
+ * At most maxKeys groups (GroupByMaxKeys by default) are kept. Once the cap is
+ * reached, events for new keys are aggregated together in the "other" group,
+ * so a high-cardinality key degrades into an aggregate rather than using
+ * unbounded memory.
+ */
+type GroupBy struct {
+	key          Expression
+	aggregate    Expression
+	maxKeys      Expression
+	newAggregate func() (Expression, error)
+	groups       map[string]*group
+}
+
+func (g *GroupBy) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 && len(args) != 3 {
+		return fmt.Errorf("GroupBy expects a key expression, an aggregate expression and optionally a maximum number of keys")
+	}
+	if g.newAggregate == nil {
+		return fmt.Errorf("GroupBy must be created by Parse")
+	}
+	g.key, g.aggregate = args[0], args[1]
+	if len(args) == 3 {
+		g.maxKeys = args[2]
+	}
+	g.groups = make(map[string]*group)
+	return nil
+}
+
+func (g *GroupBy) Evaluate(data JSONData) (result interface{}, err error) {
+	key, err := g.key.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	var maxKeys interface{} = GroupByMaxKeys
+	if g.maxKeys != nil {
+		if maxKeys, err = g.maxKeys.Evaluate(data); err != nil {
+			return nil, err
+		}
+	}
+	if maxKeys, ok := maxKeys.(int); !ok || maxKeys <= 0 {
+		return nil, fmt.Errorf("%w: GroupBy expects a positive int maximum number of keys. Got a %T, %v", ErrTypeMismatch, maxKeys, maxKeys)
+	}
+
+	if key != nil {
+		groupKey := hashKey(key)
+		if _, ok := g.groups[groupKey]; !ok && g.keyCount() >= maxKeys.(int) {
+			groupKey = OverflowGroupKey
+		}
+		err = g.evaluateGroup(groupKey, data)
+	}
+
+	groupResult := make(GroupResult, len(g.groups))
+	for key, grp := range g.groups {
+		groupResult[key] = GroupValue{grp.value, grp.count}
+	}
+	return groupResult, err
+}
+
+// keyCount is the number of groups counting towards the key cap.
+func (g *GroupBy) keyCount() int {
+	if _, ok := g.groups[OverflowGroupKey]; ok {
+		return len(g.groups) - 1
+	}
+	return len(g.groups)
+}
+
+func (g *GroupBy) evaluateGroup(key string, data JSONData) (err error) {
+	grp, ok := g.groups[key]
+	if !ok {
+		aggregate, err := g.newAggregate()
+		if err != nil {
+			return err
+		}
+		grp = &group{aggregate: aggregate}
+		g.groups[key] = grp
+	}
+
+	grp.count++
+	value, err := grp.aggregate.Evaluate(data)
+	if err != nil {
+		return fmt.Errorf("Group %v: %w", key, err)
+	}
+	grp.value = value
+	return nil
+}
+
+func (g *GroupBy) String() string {
+	if g.maxKeys != nil {
+		return fmt.Sprintf("GroupBy(%v,%v,%v)", g.key, g.aggregate, g.maxKeys)
+	}
+	return fmt.Sprintf("GroupBy(%v,%v)", g.key, g.aggregate)
+}
+
+// GroupEntry is a single group of an ordered GroupResult.
+type GroupEntry struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+}
+
+/*
+ * OrderBy(groupResult [, "desc"|"asc"]) -> []GroupEntry
+ *
+ * Sorts the groups of a GroupBy by value, largest first unless "asc" is given.
+ * Groups without a numeric value sort last.
+ */
+type OrderBy struct {
+	expr      Expression
+	direction Expression
+}
+
+func (o *OrderBy) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("OrderBy expects a GroupBy expression and optionally \"asc\" or \"desc\"")
+	}
+	o.expr = args[0]
+	o.direction = &Literal{"desc"}
+	if len(args) == 2 {
+		o.direction = args[1]
+	}
+	return nil
+}
+
+func (o *OrderBy) Evaluate(data JSONData) (result interface{}, err error) {
+	value, err := o.expr.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	groups, ok := value.(GroupResult)
+	if !ok {
+		return nil, fmt.Errorf("%w: OrderBy expects a GroupBy result, got %T", ErrTypeMismatch, value)
+	}
+	direction, err := o.direction.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	if direction != "asc" && direction != "desc" {
+		return nil, fmt.Errorf("OrderBy direction must be \"asc\" or \"desc\", got %v", direction)
+	}
+
+	entries := make([]GroupEntry, 0, len(groups))
+	for key, group := range groups {
+		entries = append(entries, GroupEntry{key, group.Value, group.Count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		vi, iok := toFloat(entries[i].Value)
+		vj, jok := toFloat(entries[j].Value)
+		switch {
+		case iok != jok:
+			return iok
+		case !iok || vi == vj:
+			return entries[i].Key < entries[j].Key
+		case direction == "asc":
+			return vi < vj
+		}
+		return vi > vj
+	})
+	return entries, nil
+}
+
+func (o *OrderBy) String() string {
+	return fmt.Sprintf("OrderBy(%v,%v)", o.expr, o.direction)
+}
+
+/*
+ * Limit(expr, int) -> []GroupEntry or []interface{}
+ *
+ * Keeps only the first n elements of an ordered result or array, e.g.
+ * Limit(OrderBy(GroupBy(host, ...)), 10) for the top 10 hosts.
+ */
+type Limit struct {
+	expr  Expression
+	limit Expression
+}
+
+func (l *Limit) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 {
+		return fmt.Errorf("Limit expects an expression and a non-negative int limit")
+	}
+	l.expr, l.limit = args[0], args[1]
+	return nil
+}
+
+func (l *Limit) Evaluate(data JSONData) (result interface{}, err error) {
+	value, err := l.expr.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := l.limit.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := limit.(int)
+	if !ok || n < 0 {
+		return nil, fmt.Errorf("%w: Limit expects a non-negative int limit. Got a %T, %v", ErrTypeMismatch, limit, limit)
+	}
+
+	switch value := value.(type) {
+	case []GroupEntry:
+		if len(value) > n {
+			return value[:n], nil
+		}
+		return value, nil
+	case []interface{}:
+		if len(value) > n {
+			return value[:n], nil
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("%w: Limit expects an ordered result or an array, got %T", ErrTypeMismatch, value)
+}
+
+func (l *Limit) String() string {
+	return fmt.Sprintf("Limit(%v,%v)", l.expr, l.limit)
+}
@@ -0,0 +1,155 @@
+package oxweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGroupByOverflow(t *testing.T) {
+	groupBy := &GroupBy{newAggregate: func() (Expression, error) { return NewGetDeepExpression("v") }}
+	key, _ := NewGetDeepExpression("k")
+	value, _ := NewGetDeepExpression("v")
+	if err := groupBy.Setup("GroupBy", []Expression{key, value, &Literal{2}}); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	var result interface{}
+	for _, k := range []string{"a", "b", "c", "a", "d"} {
+		var err error
+		result, err = groupBy.Evaluate(map[string]interface{}{"k": k, "v": k})
+		if err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+	}
+
+	groups := result.(GroupResult)
+	if len(groups) != 3 {
+		t.Errorf("Expected groups a, b and other, got %v", groups)
+	}
+	if groups["a"].Count != 2 {
+		t.Errorf("Expected 2 events for a, got %v", groups["a"])
+	}
+	if other := groups[OverflowGroupKey]; other.Count != 2 || other.Value != "d" {
+		t.Errorf("Expected c and d in the overflow group, got %v", other)
+	}
+}
+
+type groupByTest struct {
+	statement string
+	// The host and latency of each event.
+	events   [][2]interface{}
+	expected GroupResult
+}
+
+var groupByTests = []groupByTest{
+	groupByTest{"GroupBy(host, WindowAve(RollingWindow(latency, 2)))",
+		[][2]interface{}{{"web1", 1.}, {"web2", 10.}, {"web1", 3.}, {"web1", 5.}},
+		GroupResult{"web1": {Value: 4., Count: 3}, "web2": {Value: 10., Count: 1}}},
+	groupByTest{"GroupBy(host, Count())",
+		[][2]interface{}{{"web1", 1.}, {"web2", 1.}, {"web1", 1.}},
+		GroupResult{"web1": {Value: 2, Count: 2}, "web2": {Value: 1, Count: 1}}},
+	// Nil keys are ignored, and other keys group by their text.
+	groupByTest{"GroupBy(host, Count())",
+		[][2]interface{}{{nil, 1.}, {200., 1.}, {"200", 1.}},
+		GroupResult{"200": {Value: 2, Count: 2}}},
+}
+
+func TestGroupBy(t *testing.T) {
+	for _, test := range groupByTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result interface{}
+		for _, event := range test.events {
+			if result, err = expr.Evaluate(map[string]interface{}{"host": event[0], "latency": event[1]}); err != nil {
+				t.Fatalf("%v: %v", test.statement, err)
+			}
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%v of %v: expected %v, got %v", test.statement, test.events, test.expected, result)
+		}
+	}
+
+	// Emitted as one object, which CSV flattens to a column per group.
+	groups := GroupResult{"web1": {Value: 4., Count: 3}}
+	if encoded, _ := json.Marshal(groups); string(encoded) != `{"web1":{"value":4,"count":3}}` {
+		t.Errorf("Unexpected encoding %s", encoded)
+	}
+	var out bytes.Buffer
+	encoder := NewCSVEncoder(&out)
+	encoder.Write([]interface{}{[]interface{}{"latency", groups}})
+	encoder.Close()
+	if expected := "latency.web1.count,latency.web1.value\n3,4\n"; out.String() != expected {
+		t.Errorf("Expected CSV %q, got %q", expected, out.String())
+	}
+
+	expr, _ := Parse("GroupBy(host, WindowAve(RollingWindow(latency, 2)))")
+	if _, err := expr.Evaluate(map[string]interface{}{"host": "web1", "latency": "slow"}); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected a group's error wrapped, got %v", err)
+	}
+}
+
+type orderByTest struct {
+	statement string
+	// The key and value of each event.
+	events   [][2]interface{}
+	expected []string
+}
+
+var orderByTests = []orderByTest{
+	orderByTest{"OrderBy(GroupBy(k, v))", [][2]interface{}{{"a", 1.}, {"b", 3.}, {"c", 2.}}, []string{"b", "c", "a"}},
+	orderByTest{`OrderBy(GroupBy(k, v), "asc")`, [][2]interface{}{{"a", 1.}, {"b", 3.}, {"c", 2.}}, []string{"a", "c", "b"}},
+	// Ints, like Count's, sort as numbers.
+	orderByTest{"OrderBy(GroupBy(k, Count()))", [][2]interface{}{{"a", 0.}, {"b", 0.}, {"b", 0.}}, []string{"b", "a"}},
+	// Ties and values that aren't numbers go by key, non-numbers last.
+	orderByTest{"OrderBy(GroupBy(k, v))", [][2]interface{}{{"d", "x"}, {"c", 1.}, {"b", nil}, {"a", 1.}}, []string{"a", "c", "b", "d"}},
+	orderByTest{"Limit(OrderBy(GroupBy(k, v)), 2)", [][2]interface{}{{"a", 1.}, {"b", 3.}, {"c", 2.}}, []string{"b", "c"}},
+	orderByTest{"Limit(OrderBy(GroupBy(k, v)), 5)", [][2]interface{}{{"a", 1.}, {"b", 3.}}, []string{"b", "a"}},
+	orderByTest{"Limit(OrderBy(GroupBy(k, v)), 0)", [][2]interface{}{{"a", 1.}}, []string{}},
+}
+
+func TestOrderBy(t *testing.T) {
+	for _, test := range orderByTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result interface{}
+		for _, event := range test.events {
+			if result, err = expr.Evaluate(map[string]interface{}{"k": event[0], "v": event[1]}); err != nil {
+				t.Fatalf("%v: %v", test.statement, err)
+			}
+		}
+		keys := []string{}
+		for _, entry := range result.([]GroupEntry) {
+			keys = append(keys, entry.Key)
+		}
+		if !reflect.DeepEqual(keys, test.expected) {
+			t.Errorf("%v of %v: expected %v, got %v", test.statement, test.events, test.expected, keys)
+		}
+	}
+
+	data := map[string]interface{}{"k": "a", "v": 1., "list": []interface{}{1., 2., 3.}}
+	expr, _ := Parse("Limit(list, 2)")
+	if result, err := expr.Evaluate(data); err != nil || !reflect.DeepEqual(result, []interface{}{1., 2.}) {
+		t.Errorf("Expected Limit to truncate an array, got %v, %v", result, err)
+	}
+	for _, statement := range []string{`OrderBy(GroupBy(k, v), "up")`, "OrderBy(v)", "Limit(v, 2)", "Limit(list, -1)", `Limit(list, "2")`} {
+		expr, err := Parse(statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := expr.Evaluate(data); err == nil {
+			t.Errorf("Expected an error evaluating %v", statement)
+		}
+	}
+	expr, _ = Parse(`Limit(list, "2")`)
+	if _, err := expr.Evaluate(data); !errors.Is(err, ErrTypeMismatch) || !strings.Contains(err.Error(), "Got a string, 2") {
+		t.Errorf("Expected the limit's own type reported, got %v", err)
+	}
+}
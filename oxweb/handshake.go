@@ -0,0 +1,148 @@
+package oxweb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ProtocolVersion is the newest stream protocol this package speaks.
+//
+// Version 0 is the original protocol: the client sends the stream name on a
+// line of its own and the relay starts sending events. From version 1, the
+// client instead sends a Hello as a line of JSON, the relay answers with its
+// own, and both sides use the lower version and the capabilities they share.
+// Relays that predate the handshake would take the Hello for a stream name,
+// so it's only sent to relays known to support it.
+const ProtocolVersion = 1
+
+// Capability is a bitmap of optional protocol features.
+type Capability uint32
+
+const (
+	// CapGzip compresses events after the handshake.
+	CapGzip Capability = 1 << iota
+	// CapMsgpack encodes events as MessagePack rather than JSON.
+	CapMsgpack
+	// CapFilterPushdown lets the client send a filter the relay applies
+	// before sending events.
+	CapFilterPushdown
+	// CapSampling lets the client ask the relay to sample events.
+	CapSampling
+	// CapChecksum appends a checksum to every line; see AppendChecksum.
+	CapChecksum
+	// CapHeartbeat has both sides send a heartbeat whenever they'd otherwise
+	// be quiet, so either can tell a dead connection from a quiet one; see
+	// SendHeartbeats.
+	CapHeartbeat
+)
+
+var capabilityNames = []string{"gzip", "msgpack", "filter", "sampling", "checksum", "heartbeat"}
+
+func (c Capability) String() string {
+	names := []string{}
+	for bit, name := range capabilityNames {
+		if c&(1<<uint(bit)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Hello is what each side of the stream handshake sends.
+type Hello struct {
+	// Stream names the stream the client wants. Relays leave it empty.
+	Stream       string     `json:"stream,omitempty"`
+	Version      int        `json:"version"`
+	Capabilities Capability `json:"capabilities"`
+	// Filters, offered with CapFilterPushdown, are expressions every event
+	// must pass for the relay to send it.
+	Filters []string `json:"filters,omitempty"`
+	// SampleRate, offered with CapSampling, is the fraction of events the
+	// client wants sent.
+	SampleRate float64 `json:"sampleRate,omitempty"`
+	// Heartbeat, offered with CapHeartbeat, is the most seconds the side
+	// sending it wants to go without hearing from the other.
+	Heartbeat float64 `json:"heartbeat,omitempty"`
+}
+
+// Negotiate combines the hellos of both sides into what they'll actually use.
+func Negotiate(local, remote Hello) (agreed Hello) {
+	agreed = local
+	if remote.Version < agreed.Version {
+		agreed.Version = remote.Version
+	}
+	agreed.Capabilities &= remote.Capabilities
+	if agreed.Capabilities&CapFilterPushdown == 0 {
+		agreed.Filters = nil
+	} else if len(remote.Filters) > 0 {
+		agreed.Filters = remote.Filters
+	}
+	if agreed.Capabilities&CapSampling == 0 {
+		agreed.SampleRate = 0
+	} else if remote.SampleRate > 0 {
+		agreed.SampleRate = remote.SampleRate
+	}
+	// Heartbeats come as often as the less patient side wants them.
+	if agreed.Capabilities&CapHeartbeat == 0 {
+		agreed.Heartbeat = 0
+	} else if remote.Heartbeat > 0 && (agreed.Heartbeat == 0 || remote.Heartbeat < agreed.Heartbeat) {
+		agreed.Heartbeat = remote.Heartbeat
+	}
+	return agreed
+}
+
+// ClientHandshake sends hello to a relay and reads its answer, returning what
+// was agreed.
+func ClientHandshake(writer io.Writer, reader *bufio.Reader, hello Hello) (agreed Hello, err error) {
+	if err := writeHello(writer, hello); err != nil {
+		return agreed, err
+	}
+	remote, err := readHello(reader)
+	if err != nil {
+		return agreed, err
+	}
+	return Negotiate(hello, remote), nil
+}
+
+// AcceptHandshake is the relay side of the handshake: it reads the client's
+// Hello, answers with supported and returns what was agreed, including the
+// stream the client asked for and any filters it pushed down.
+func AcceptHandshake(writer io.Writer, reader *bufio.Reader, supported Hello) (agreed Hello, err error) {
+	remote, err := readHello(reader)
+	if err != nil {
+		return agreed, err
+	}
+	supported.Stream = ""
+	if err := writeHello(writer, supported); err != nil {
+		return agreed, err
+	}
+	agreed = Negotiate(supported, remote)
+	agreed.Stream = remote.Stream
+	return agreed, nil
+}
+
+func writeHello(writer io.Writer, hello Hello) (err error) {
+	encoded, err := json.Marshal(hello)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(append(encoded, '\n'))
+	return err
+}
+
+func readHello(reader *bufio.Reader) (hello Hello, err error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return hello, fmt.Errorf("Reading handshake: %w", err)
+	}
+	if err := json.Unmarshal(line, &hello); err != nil {
+		return hello, fmt.Errorf("%w: Bad handshake %q: %w", ErrDecode, line, err)
+	}
+	if hello.Version < 1 {
+		return hello, fmt.Errorf("Handshake from protocol version %d", hello.Version)
+	}
+	return hello, nil
+}
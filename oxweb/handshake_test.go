@@ -0,0 +1,85 @@
+package oxweb
+
+import (
+	"bufio"
+	"net"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	client, relay := net.Pipe()
+	defer client.Close()
+	defer relay.Close()
+
+	relayAgreed := make(chan Hello, 1)
+	go func() {
+		agreed, err := AcceptHandshake(relay, bufio.NewReader(relay), Hello{Version: 2, Capabilities: CapGzip | CapFilterPushdown})
+		if err != nil {
+			t.Error(err)
+		}
+		relayAgreed <- agreed
+	}()
+
+	agreed, err := ClientHandshake(client, bufio.NewReader(client), Hello{Stream: "ranger", Version: 1, Capabilities: CapFilterPushdown | CapSampling})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agreed.Version != 1 || agreed.Capabilities != CapFilterPushdown {
+		t.Errorf("Client expected version 1 with filter, got %d with %v", agreed.Version, agreed.Capabilities)
+	}
+	if relay := <-relayAgreed; relay.Version != 1 || relay.Capabilities != CapFilterPushdown || relay.Stream != "ranger" {
+		t.Errorf("Relay expected version 1 with filter for ranger, got %+v", relay)
+	}
+}
+
+type pushdownTest struct {
+	relayCapabilities Capability
+	relayFilters      []string
+	delivered         []float64
+}
+
+var pushdownTests = []pushdownTest{
+	// The relay can't filter, so the stream does.
+	pushdownTest{0, nil, []float64{2}},
+	// The relay takes the filter; we trust it to have applied it.
+	pushdownTest{CapFilterPushdown, []string{"keep"}, []float64{1, 2}},
+}
+
+func TestFilterPushdown(t *testing.T) {
+	for _, test := range pushdownTests {
+		listener, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		relayAgreed := make(chan Hello, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			agreed, _ := AcceptHandshake(conn, bufio.NewReader(conn), Hello{Version: 1, Capabilities: test.relayCapabilities})
+			relayAgreed <- agreed
+			conn.Write([]byte(`{"keep": false, "n": 1}` + "\n" + `{"keep": true, "n": 2}` + "\n"))
+		}()
+
+		stream := NewDataStream("ranger", listener.Addr().String())
+		stream.EnableHandshake(0)
+		if err := stream.SetFilters([]string{"keep"}); err != nil {
+			t.Fatal(err)
+		}
+		dataChan := make(chan JSONData, 4)
+		stream.SubscribeChan <- &SubscribeRequest{DataChan: dataChan}
+
+		if agreed := <-relayAgreed; len(agreed.Filters) != len(test.relayFilters) {
+			t.Errorf("Expected the relay to get filters %v, got %v", test.relayFilters, agreed.Filters)
+		}
+		for _, n := range test.delivered {
+			if value, _ := GetDeep("n", <-dataChan); value != n {
+				t.Errorf("With relay capabilities %v, expected event %v, got %v", test.relayCapabilities, n, value)
+			}
+		}
+		listener.Close()
+	}
+}
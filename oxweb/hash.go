@@ -0,0 +1,109 @@
+package oxweb
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/fnv"
+)
+
+var hashAlgorithms = map[string](func() hash.Hash){
+	"md5":  md5.New,
+	"sha1": sha1.New,
+	"fnv":  func() hash.Hash { return fnv.New64a() },
+}
+
+// hashKey is the text that gets hashed for a value. Strings hash as
+// themselves so results match hashes computed outside of oxweb.
+func hashKey(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+/*
+ * Hash(expr, "md5"|"sha1"|"fnv") -> string
+ *
+ * Returns the hex digest of the value, useful for anonymizing identifiers.
+ */
+type HashExpression struct {
+	expr      Expression
+	algorithm Expression
+}
+
+func (h *HashExpression) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 {
+		return fmt.Errorf("Hash expects an expression and an algorithm name (md5, sha1 or fnv)")
+	}
+	h.expr, h.algorithm = args[0], args[1]
+	return nil
+}
+
+func (h *HashExpression) Evaluate(data JSONData) (result interface{}, err error) {
+	value, err := h.expr.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	algorithm, err := h.algorithm.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	newHash, ok := hashAlgorithms[hashKey(algorithm)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v is not a supported Hash algorithm", ErrTypeMismatch, algorithm)
+	}
+	digest := newHash()
+	digest.Write([]byte(hashKey(value)))
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+func (h *HashExpression) String() string {
+	return fmt.Sprintf("Hash(%v,%v)", h.expr, h.algorithm)
+}
+
+/*
+ * Bucket(expr, int) -> int
+ *
+ * Deterministically assigns the value to one of n buckets, numbered 0 to n-1,
+ * e.g. for experiment bucketing. The same value always lands in the same bucket.
+ */
+type Bucket struct {
+	expr    Expression
+	buckets Expression
+}
+
+func (b *Bucket) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 {
+		return fmt.Errorf("Bucket expects an expression and a positive int number of buckets")
+	}
+	b.expr, b.buckets = args[0], args[1]
+	return nil
+}
+
+func (b *Bucket) Evaluate(data JSONData) (result interface{}, err error) {
+	value, err := b.expr.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	buckets, err := evaluateWindowSize(b.buckets, data, "Bucket expects a positive int number of buckets")
+	if err != nil {
+		return nil, err
+	}
+
+	digest := fnv.New64a()
+	digest.Write([]byte(hashKey(value)))
+	return int(digest.Sum64() % uint64(buckets)), nil
+}
+
+func (b *Bucket) String() string {
+	return fmt.Sprintf("Bucket(%v,%v)", b.expr, b.buckets)
+}
@@ -0,0 +1,56 @@
+package oxweb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var hashTests = []struct {
+	statement string
+	result    interface{}
+	ok        bool
+}{
+	// Digests match those computed outside of oxweb.
+	{`Hash(user, "md5")`, "6384e2b2184bcbf58eccf10ca7a6563c", true},
+	{`Hash(user, "sha1")`, "522b276a356bdf39013dfabea2cd43e141ecc9e8", true},
+	{`Hash(user, "fnv")`, "508b2abb65a03907", true},
+	{`Hash(id, "md5")`, "a1d0c6e83f027327d8461063f4ac58a6", true},
+	{`Hash(missing, "md5")`, nil, true},
+	{`Hash(user, "crc32")`, nil, false},
+	{"Bucket(user, 10)", 3, true},
+	{"Bucket(id, 10)", 1, true},
+	{"Bucket(user, 1)", 0, true},
+	{"Bucket(missing, 10)", nil, true},
+	// Counts from JSON arrive as floats.
+	{"Bucket(user, buckets)", 3, true},
+	{"Bucket(user, 2.5)", nil, false},
+	{"Bucket(user, 0)", nil, false},
+	{`Bucket(user, "10")`, nil, false},
+}
+
+func TestHashAndBucket(t *testing.T) {
+	event := map[string]interface{}{"user": "alice", "id": 42., "buckets": 10.}
+	for _, test := range hashTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatalf("Couldn't parse %v: %v", test.statement, err)
+		}
+		result, err := expr.Evaluate(event)
+		if test.ok != (err == nil) {
+			t.Errorf("For %v, expected ok = %t, but err was %v", test.statement, test.ok, err)
+		}
+		if result != test.result {
+			t.Errorf("For %v, expected %v, got %v", test.statement, test.result, result)
+		}
+	}
+
+	expr, _ := Parse(`Bucket(user, "10")`)
+	if _, err := expr.Evaluate(event); !errors.Is(err, ErrTypeMismatch) || !strings.Contains(err.Error(), "Got a string, 10") {
+		t.Errorf("Expected the bucket count's own type reported, got %v", err)
+	}
+	expr, _ = Parse(`Hash(user, "crc32")`)
+	if _, err := expr.Evaluate(event); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected ErrTypeMismatch for an unsupported algorithm, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package oxweb
+
+import (
+	"io"
+	"time"
+)
+
+// HeartbeatMisses is how many heartbeat intervals can pass without hearing
+// from the other side before it's presumed dead.
+const HeartbeatMisses = 3
+
+// A heartbeat is an empty line, which readers of either side skip.
+var heartbeatLine = []byte("\n")
+
+// SendHeartbeats writes a heartbeat to writer every interval until done is
+// closed or a write fails, returning the error. writer must be safe to write
+// to alongside whatever else is writing to it, as a net.Conn is when each
+// line is written with one Write.
+func SendHeartbeats(writer io.Writer, interval time.Duration, done <-chan struct{}) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := writer.Write(heartbeatLine); err != nil {
+				return err
+			}
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// heartbeatInterval returns the agreed interval between heartbeats, or 0
+// if they weren't agreed.
+func heartbeatInterval(agreed Hello) time.Duration {
+	if agreed.Capabilities&CapHeartbeat == 0 {
+		return 0
+	}
+	return time.Duration(agreed.Heartbeat * float64(time.Second))
+}
@@ -0,0 +1,124 @@
+package oxweb
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNegotiateHeartbeat(t *testing.T) {
+	local := Hello{Version: 1, Capabilities: CapHeartbeat, Heartbeat: 5}
+	if agreed := Negotiate(local, Hello{Version: 1, Capabilities: CapHeartbeat, Heartbeat: 2}); agreed.Heartbeat != 2 {
+		t.Errorf("Expected the shorter interval, got %v", agreed.Heartbeat)
+	}
+	if agreed := Negotiate(local, Hello{Version: 1, Capabilities: CapHeartbeat}); agreed.Heartbeat != 5 {
+		t.Errorf("Expected our interval, got %v", agreed.Heartbeat)
+	}
+	if agreed := Negotiate(local, Hello{Version: 1, Heartbeat: 2}); agreed.Heartbeat != 0 || heartbeatInterval(agreed) != 0 {
+		t.Errorf("Expected no heartbeat without the capability, got %v", agreed.Heartbeat)
+	}
+}
+
+// A relay that stops talking without hanging up, as one whose host has gone
+// away does, is replaced.
+func TestDeadPeer(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	heartbeats := make(chan bool, 1)
+	go func() {
+		for n := 1; ; n++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			AcceptHandshake(conn, reader, Hello{Version: 1, Capabilities: CapHeartbeat, Heartbeat: 0.02})
+			conn.Write([]byte(`{"n": ` + strconv.Itoa(n) + "}\n"))
+			if n == 1 {
+				// Go quiet, but note the stream's heartbeats.
+				go func() {
+					line, err := reader.ReadString('\n')
+					heartbeats <- err == nil && line == "\n"
+				}()
+			}
+		}
+	}()
+
+	stream := NewDataStream("ranger", listener.Addr().String())
+	stream.EnableHandshake(0)
+	stream.EnableHeartbeat(time.Second)
+	dataChan := make(chan JSONData, 4)
+	stream.SubscribeChan <- &SubscribeRequest{DataChan: dataChan}
+
+	for n := 1.; n <= 2; n++ {
+		select {
+		case data := <-dataChan:
+			if value, _ := GetDeep("n", data); value != n {
+				t.Errorf("Expected event %v, got %v", n, value)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for event %v", n)
+		}
+	}
+	if !<-heartbeats {
+		t.Error("Expected the relay to get an empty line as a heartbeat")
+	}
+	if dead := stream.Stats()["dead_peers"]; dead != 1 {
+		t.Errorf("Expected 1 dead peer, got %d", dead)
+	}
+}
+
+// A relay that hangs up stops the stream's heartbeats with it.
+func TestHeartbeatsStopOnEOF(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	stopped := make(chan bool, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		AcceptHandshake(conn, reader, Hello{Version: 1, Capabilities: CapHeartbeat, Heartbeat: 0.02})
+		conn.Write([]byte(`{"n": 1}` + "\n"))
+		conn.(*net.TCPConn).CloseWrite()
+
+		// Drain whatever was sent before the stream saw us go, then listen
+		// for several intervals.
+		time.Sleep(100 * time.Millisecond)
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		for _, err := reader.ReadString('\n'); err == nil; _, err = reader.ReadString('\n') {
+		}
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err = reader.ReadString('\n')
+		netErr, ok := err.(net.Error)
+		stopped <- ok && netErr.Timeout()
+	}()
+
+	stream := NewDataStream("ranger", listener.Addr().String())
+	stream.EnableHandshake(0)
+	stream.EnableHeartbeat(time.Second)
+	dataChan := make(chan JSONData, 4)
+	stream.SubscribeChan <- &SubscribeRequest{DataChan: dataChan}
+
+	select {
+	case <-dataChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+	if !<-stopped {
+		t.Error("Expected no heartbeats once the relay hung up")
+	}
+}
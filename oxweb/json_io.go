@@ -2,13 +2,44 @@ package oxweb
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
+	"sync"
 )
 
+// DefaultMaxConsecutiveErrors is how many bad frames in a row a JSONConn
+// answers with an ErrorFrame before giving up on the client.
+const DefaultMaxConsecutiveErrors = 5
+
+// MaxFrameBytes is the longest line a JSONConn reads. Longer lines are
+// skipped, as a bad frame.
+const MaxFrameBytes = 1 << 20
+
+// JSONConn reads and writes newline delimited JSON. A line that isn't JSON
+// doesn't end the connection: the client is sent an ErrorFrame and the next
+// line is read, until MaxConsecutiveErrors bad lines arrive in a row. Empty
+// lines are ignored. Writes are safe from any number of goroutines.
 type JSONConn struct {
-	bufConn *bufio.ReadWriter
+	bufConn   *bufio.ReadWriter
+	lines     int64
+	writeLock sync.Mutex
+
+	MaxConsecutiveErrors int
+}
+
+// ErrorFrame is written back to the client for each line that couldn't be
+// read, so it hears about its mistake rather than waiting for an answer.
+type ErrorFrame struct {
+	// The class of error, e.g. "decode error".
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	// The line the error is about, counting from 1, if any.
+	Line int64 `json:"line,omitempty"`
 }
 
 func NewJSONConn(conn io.ReadWriter) *JSONConn {
@@ -17,28 +48,81 @@ func NewJSONConn(conn io.ReadWriter) *JSONConn {
 
 	bufConn := bufio.NewReadWriter(reader, writer)
 
-	return &JSONConn{bufConn}
+	return &JSONConn{bufConn: bufConn, MaxConsecutiveErrors: DefaultMaxConsecutiveErrors}
 }
 
-func (jsonConn *JSONConn) ReadJSON() (data JSONData, err error) {
-	// Get our query from the client
-	input, _, err := jsonConn.bufConn.ReadLine()
-	if err != nil {
-		log.Fatal("Failed to read from client", err)
-		return nil, err
+// readLine reads the next whole line, however it's split across the buffer.
+// A line longer than MaxFrameBytes is read to its end and discarded, so
+// reading carries on from the line after it.
+func (jsonConn *JSONConn) readLine() (line []byte, err error) {
+	tooLong := false
+	for {
+		chunk, isPrefix, err := jsonConn.bufConn.ReadLine()
+		if err == io.EOF {
+			return nil, ErrStreamClosed
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrStreamClosed, err)
+		}
+		if len(line)+len(chunk) > MaxFrameBytes {
+			tooLong, line = true, nil
+		} else if !tooLong {
+			line = append(line, chunk...)
+		}
+		if !isPrefix {
+			break
+		}
 	}
+	jsonConn.lines++
+	if tooLong {
+		return nil, fmt.Errorf("%w: Line is longer than %d bytes", ErrDecode, MaxFrameBytes)
+	}
+	return line, nil
+}
 
-	log.Println("Found: ", string(input))
+// ReadJSON reads the next JSON value from the client, answering any bad
+// lines before it with an ErrorFrame. After MaxConsecutiveErrors bad lines
+// in a row it returns an ErrDecode, and the connection should be closed.
+func (jsonConn *JSONConn) ReadJSON() (data JSONData, err error) {
+	for bad := 0; ; {
+		input, err := jsonConn.readLine()
+		if err == nil {
+			if len(bytes.TrimSpace(input)) == 0 {
+				continue
+			}
+			log.Println("Found: ", string(input))
+			if err = json.Unmarshal(input, &data); err == nil {
+				return data, nil
+			}
+			err = fmt.Errorf("%w: %w", ErrDecode, err)
+		}
+		if !errors.Is(err, ErrDecode) {
+			return nil, err
+		}
 
-	// Parse the query
-	var parsedInput JSONData
-	err = json.Unmarshal(input, &parsedInput)
-	if err != nil {
-		log.Printf("Failure to decode: %s %s", input, err)
-		return nil, err
+		log.Printf("Failure to decode line %d: %v", jsonConn.lines, err)
+		if writeErr := jsonConn.WriteError(err); writeErr != nil {
+			return nil, writeErr
+		}
+		if bad++; bad >= jsonConn.MaxConsecutiveErrors {
+			return nil, fmt.Errorf("Giving up after %d bad lines in a row: %w", bad, err)
+		}
 	}
+}
+
+// WriteError answers the line last read with an ErrorFrame describing err.
+func (jsonConn *JSONConn) WriteError(err error) error {
+	return jsonConn.WriteJSON(ErrorFrame{Error: errorClass(err), Message: err.Error(), Line: jsonConn.lines})
+}
 
-	return parsedInput, nil
+// errorClass is the class of err reported in an ErrorFrame.
+func errorClass(err error) string {
+	for _, class := range []error{ErrDecode, ErrParse, ErrTypeMismatch} {
+		if errors.Is(err, class) {
+			return class.Error()
+		}
+	}
+	return "error"
 }
 
 func (jsonConn *JSONConn) WriteJSON(data JSONData) (err error) {
@@ -48,7 +132,12 @@ func (jsonConn *JSONConn) WriteJSON(data JSONData) (err error) {
 		return err
 	}
 
+	jsonConn.writeLock.Lock()
+	defer jsonConn.writeLock.Unlock()
 	_, err = jsonConn.bufConn.WriteString(string(outputBytes) + "\n")
+	if errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("%w: %w", ErrStreamClosed, err)
+	}
 	if err != nil {
 		return
 	}
@@ -59,5 +148,8 @@ func (jsonConn *JSONConn) WriteJSON(data JSONData) (err error) {
 	// }
 
 	err = jsonConn.bufConn.Flush()
+	if errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("%w: %w", ErrStreamClosed, err)
+	}
 	return
 }
@@ -0,0 +1,65 @@
+package oxweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fakeConn reads input and records what's written back.
+func fakeConn(input string) (conn *JSONConn, written *bytes.Buffer) {
+	written = new(bytes.Buffer)
+	return NewJSONConn(struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(input), written}), written
+}
+
+func errorFrames(t *testing.T, written *bytes.Buffer) (frames []ErrorFrame) {
+	for _, line := range strings.Split(strings.TrimSpace(written.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var frame ErrorFrame
+		if err := json.Unmarshal([]byte(line), &frame); err != nil {
+			t.Fatalf("Couldn't decode %q: %v", line, err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func TestReadJSONRecovers(t *testing.T) {
+	long := strings.Repeat("x", MaxFrameBytes+10)
+	conn, written := fakeConn("not json\n\n" + long + "\n{\"a\": 1}\n")
+	data, err := conn.ReadJSON()
+	if err != nil || data.(map[string]interface{})["a"] != 1. {
+		t.Fatalf("Expected to read past the bad lines, got %v, %v", data, err)
+	}
+
+	frames := errorFrames(t, written)
+	if len(frames) != 2 {
+		t.Fatalf("Expected an error frame for each bad line, got %v", frames)
+	}
+	if frames[0].Error != "decode error" || frames[0].Line != 1 || frames[1].Line != 3 {
+		t.Errorf("Unexpected error frames %v", frames)
+	}
+
+	if _, err = conn.ReadJSON(); !errors.Is(err, ErrStreamClosed) {
+		t.Errorf("Expected ErrStreamClosed at the end of input, got %v", err)
+	}
+}
+
+func TestReadJSONGivesUp(t *testing.T) {
+	conn, written := fakeConn("x\ny\n{}\n")
+	conn.MaxConsecutiveErrors = 2
+	if _, err := conn.ReadJSON(); !errors.Is(err, ErrDecode) {
+		t.Errorf("Expected ErrDecode after 2 bad lines, got %v", err)
+	}
+	if frames := errorFrames(t, written); len(frames) != 2 {
+		t.Errorf("Expected 2 error frames, got %v", frames)
+	}
+}
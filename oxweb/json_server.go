@@ -0,0 +1,185 @@
+package oxweb
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// JSONServer serves a line delimited JSON protocol over TCP. Each client's
+// first line is read and given to Handler, with the connection for anything
+// more, and the connection is closed when Handler returns. A panicking
+// Handler only loses its own connection.
+type JSONServer struct {
+	Addr    string
+	Handler func(request JSONData, conn *JSONConn)
+	// ReadTimeout, if set, is how long a client has to send its first line.
+	ReadTimeout time.Duration
+	// MaxConsecutiveErrors replaces DefaultMaxConsecutiveErrors if set.
+	MaxConsecutiveErrors int
+
+	lock      sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	handlers  sync.WaitGroup
+	closed    bool
+}
+
+// ListenAndServeJSON serves handler on addr until it fails, as
+// JSONServer.ListenAndServe does.
+func ListenAndServeJSON(addr string, handler func(request JSONData, conn *JSONConn)) error {
+	server := &JSONServer{Addr: addr, Handler: handler}
+	return server.ListenAndServe()
+}
+
+// ListenAndServe listens on s.Addr and serves connections to it, until
+// Shutdown, when it returns ErrServerClosed.
+func (s *JSONServer) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves connections accepted by listener, until Shutdown, when it
+// returns ErrServerClosed. listener is closed when Serve returns.
+func (s *JSONServer) Serve(listener net.Listener) error {
+	defer listener.Close()
+	if !s.track(listener, true) {
+		return ErrServerClosed
+	}
+	defer s.track(listener, false)
+
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if temporaryError(err) {
+				// e.g. out of file descriptors, so back off and retry.
+				if delay = 2*delay + 5*time.Millisecond; delay > time.Second {
+					delay = time.Second
+				}
+				log.Printf("Failed to accept, retrying in %v: %v", delay, err)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		if !s.track(conn, true) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.handlers.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *JSONServer) serve(conn net.Conn) {
+	defer s.handlers.Done()
+	defer s.track(conn, false)
+	defer conn.Close()
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Serving %v failed: %v\n%s", conn.RemoteAddr(), err, debug.Stack())
+		}
+	}()
+
+	jsonConn := NewJSONConn(conn)
+	if s.MaxConsecutiveErrors > 0 {
+		jsonConn.MaxConsecutiveErrors = s.MaxConsecutiveErrors
+	}
+	if s.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+	}
+	request, err := jsonConn.ReadJSON()
+	if err != nil {
+		log.Printf("Failed to read from %v: %v", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	s.Handler(request, jsonConn)
+}
+
+// track adds or removes a listener or connection from those Shutdown
+// closes, returning false if the server's already shut down.
+func (s *JSONServer) track(closer interface{}, add bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]bool)
+		s.conns = make(map[net.Conn]bool)
+	}
+	switch closer := closer.(type) {
+	case net.Listener:
+		if add {
+			s.listeners[closer] = true
+		} else {
+			delete(s.listeners, closer)
+		}
+	case net.Conn:
+		if add {
+			s.conns[closer] = true
+		} else {
+			delete(s.conns, closer)
+		}
+	}
+	return !s.closed
+}
+
+func (s *JSONServer) isClosed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closed
+}
+
+// Shutdown stops accepting connections and waits for the handlers of those
+// already accepted to return. If ctx is done first, their connections are
+// closed, which ends any handler blocked reading or writing, and ctx's error
+// is returned.
+func (s *JSONServer) Shutdown(ctx context.Context) (err error) {
+	s.lock.Lock()
+	s.closed = true
+	for listener := range s.listeners {
+		listener.Close()
+	}
+	s.lock.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.lock.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+	return ctx.Err()
+}
+
+// temporaryError reports whether an Accept error may clear up by itself, as
+// running out of file descriptors (EMFILE) or a connection aborted before it
+// was accepted do, and as net/http's Server treats them.
+func temporaryError(err error) bool {
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,117 @@
+package oxweb
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestJSONServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &JSONServer{
+		Handler: func(request JSONData, conn *JSONConn) {
+			if request.(map[string]interface{})["panic"] == true {
+				panic("asked to")
+			}
+			conn.WriteResponse(request, map[string]interface{}{"ok": true})
+			// Streams until the client goes away.
+			for {
+				if _, err := conn.ReadJSON(); err != nil {
+					return
+				}
+			}
+		},
+		ReadTimeout: time.Second,
+	}
+	served := make(chan error)
+	go func() { served <- server.Serve(listener) }()
+
+	request := func(line string) (response string, conn net.Conn) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(line + "\n"))
+		response, _ = bufio.NewReader(conn).ReadString('\n')
+		return response, conn
+	}
+
+	// A panicking handler loses its connection, but not the server.
+	if response, conn := request(`{"panic": true}`); response != "" {
+		t.Errorf("Expected the panicking handler's connection to close, got %q", response)
+	} else {
+		conn.Close()
+	}
+	response, conn := request(`{"id": 1}`)
+	if !strings.Contains(response, `"ok":true`) || !strings.Contains(response, `"id":1`) {
+		t.Errorf("Unexpected response %q", response)
+	}
+	defer conn.Close()
+
+	// The handler's still streaming, so Shutdown gives up waiting on it.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Shutdown to time out, got %v", err)
+	}
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Expected ErrServerClosed, got %v", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected Shutdown to close the connection")
+	}
+}
+
+// flakyListener fails its first Accepts with err.
+type flakyListener struct {
+	net.Listener
+	err   error
+	fails int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.fails > 0 {
+		l.fails--
+		return nil, l.err
+	}
+	return l.Listener.Accept()
+}
+
+func TestJSONServerRetriesTemporaryErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	emfile := &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	server := &JSONServer{Handler: func(request JSONData, conn *JSONConn) {
+		conn.WriteResponse(request, map[string]interface{}{"ok": true})
+	}}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(&flakyListener{listener, emfile, 2}) }()
+	defer server.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(`{"id": 1}` + "\n"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if response, _ := bufio.NewReader(conn).ReadString('\n'); !strings.Contains(response, `"ok":true`) {
+		t.Errorf("Expected the server to outlast EMFILE, got %q", response)
+	}
+
+	fatal := &flakyListener{listener, errors.New("broken"), 1}
+	if err := (&JSONServer{Handler: server.Handler}).Serve(fatal); err == nil || err.Error() != "broken" {
+		t.Errorf("Expected a permanent error returned, got %v", err)
+	}
+}
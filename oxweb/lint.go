@@ -0,0 +1,115 @@
+package oxweb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Codes of the Warnings Lint gives.
+const (
+	// A GroupBy without a maxKeys argument may keep GroupByMaxKeys groups.
+	WarnUnboundedGroupBy = "unbounded-group-by"
+	// A window, across all its GroupBy copies, is estimated to need more than
+	// LintMaxWindowBytes.
+	WarnLargeWindow = "large-window"
+	// A function marked with MarkExpensive, such as a regular expression
+	// match, is evaluated for every event.
+	WarnExpensiveFunction = "expensive-function"
+	// A window holds values of another window's aggregate, so the inner
+	// window's work is repeated for every element of the outer one.
+	WarnNestedWindow = "nested-window"
+)
+
+// Limits Lint checks statements against. TimedWindow memory is estimated at
+// LintEventsPerSecond.
+var (
+	LintMaxWindowBytes  int64 = 64 << 20
+	LintEventsPerSecond       = 100.
+)
+
+// A Warning is a pattern Lint found that works but may be expensive.
+type Warning struct {
+	Code string `json:"code"`
+	// The offending call, as Format writes it.
+	Expression string `json:"expression"`
+	Message    string `json:"message"`
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%v: %v", w.Code, w.Message)
+}
+
+var (
+	expensiveLock sync.RWMutex
+	expensive     = make(map[string]string)
+)
+
+// MarkExpensive has Lint warn wherever the function name is used, giving
+// reason, e.g. for a registered function matching a regular expression:
+//
+//	MarkExpensive("Matches", "compiles and runs a regular expression on every event")
+func MarkExpensive(name string, reason string) {
+	expensiveLock.Lock()
+	defer expensiveLock.Unlock()
+	expensive[name] = reason
+}
+
+func expensiveReason(name string) (reason string, ok bool) {
+	expensiveLock.RLock()
+	defer expensiveLock.RUnlock()
+	reason, ok = expensive[name]
+	return reason, ok
+}
+
+// Lint looks for expensive patterns in a statement: GroupBys without a key
+// limit, very large windows, expensive functions and windows nested in
+// windows. Statements that don't parse return the parse error.
+func Lint(statement string) (warnings []Warning, err error) {
+	_, warnings, err = ParseWithWarnings(statement)
+	return warnings, err
+}
+
+// ParseWithWarnings parses a statement as Parse does, also returning any
+// Warnings from Lint.
+func ParseWithWarnings(statement string) (expr Expression, warnings []Warning, err error) {
+	if expr, err = Parse(statement); err != nil {
+		return nil, nil, err
+	}
+	warnings = []Warning{}
+	lintSyntax(parseSyntax(strings.TrimSpace(stripComments(statement))), 1, false, &warnings)
+	return expr, warnings, nil
+}
+
+// lintSyntax checks a node and its arguments. copies is the number of copies
+// GroupBys above will make of the node, and inWindow whether it's inside a
+// window.
+func lintSyntax(syntax *syntaxNode, copies int, inWindow bool, warnings *[]Warning) {
+	if !syntax.call {
+		return
+	}
+	warn := func(code string, format string, args ...interface{}) {
+		*warnings = append(*warnings, Warning{code, syntax.oneLine(), fmt.Sprintf(format, args...)})
+	}
+
+	isWindow := syntax.text == "RollingWindow" || syntax.text == "TimedWindow"
+	if isWindow {
+		if inWindow {
+			warn(WarnNestedWindow, "%v is inside another window, repeating its work for every element", syntax.text)
+		}
+		if plan := explainWindow(syntax, copies, LintEventsPerSecond); plan.EstimatedBytes > LintMaxWindowBytes {
+			warn(WarnLargeWindow, "%v may use about %d MiB across %d copies", syntax.text, plan.EstimatedBytes>>20, copies)
+		}
+	}
+	if syntax.text == "GroupBy" && len(syntax.args) == 2 {
+		warn(WarnUnboundedGroupBy, "GroupBy has no maxKeys, so it may keep up to %d groups", GroupByMaxKeys)
+	}
+	if reason, ok := expensiveReason(syntax.text); ok {
+		warn(WarnExpensiveFunction, "%v %v", syntax.text, reason)
+	}
+
+	for ndx, arg := range syntax.args {
+		// Only the element a window holds is inside it, not its size.
+		lintSyntax(arg, argCopies(syntax, ndx, copies), inWindow || (isWindow && ndx == 0), warnings)
+	}
+}
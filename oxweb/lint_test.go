@@ -0,0 +1,52 @@
+package oxweb
+
+import (
+	"testing"
+)
+
+var lintTests = []struct {
+	statement string
+	codes     []string
+}{
+	{"WindowAve(RollingWindow(latency, 100))", nil},
+	{"GroupBy(host, WindowAve(RollingWindow(latency, 100)), 50)", nil},
+	{"GroupBy(host, ArrayLen(items))", []string{WarnUnboundedGroupBy}},
+	{"GroupBy(host, WindowAve(RollingWindow(latency, 1000)))", []string{WarnUnboundedGroupBy, WarnLargeWindow}},
+	{"WindowAve(TimedWindow(latency, 86400))", []string{WarnLargeWindow}},
+	{"WindowAve(RollingWindow(WindowAve(RollingWindow(latency, 10)), 10))", []string{WarnNestedWindow}},
+	{"RollingWindow(latency, RollingWindow(size, 1))", nil},
+	{"LintSlow(url)", []string{WarnExpensiveFunction}},
+}
+
+func TestLint(t *testing.T) {
+	RegisterFunc("LintSlow", func(s string) string { return s })
+	MarkExpensive("LintSlow", "runs a regular expression")
+
+	for _, test := range lintTests {
+		warnings, err := Lint(test.statement)
+		if err != nil {
+			t.Errorf("For statement %q, unexpected error %v", test.statement, err)
+			continue
+		}
+		codes := []string{}
+		for _, warning := range warnings {
+			codes = append(codes, warning.Code)
+		}
+		if ok, _ := sliceEquals(codes, append([]string{}, test.codes...)); !ok {
+			t.Errorf("For statement %q, expected %v, got %v", test.statement, test.codes, warnings)
+		}
+	}
+}
+
+func TestParseWithWarnings(t *testing.T) {
+	expr, warnings, err := ParseWithWarnings("GroupBy(host, ArrayLen(items))")
+	if err != nil || expr == nil {
+		t.Fatalf("Expected the expression, got %v, %v", expr, err)
+	}
+	if len(warnings) != 1 || warnings[0].Expression != "GroupBy(host, ArrayLen(items))" {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+	if _, _, err := ParseWithWarnings("GroupBy(host)"); err == nil {
+		t.Error("Expected a parse error")
+	}
+}
@@ -0,0 +1,227 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Mergeable is implemented by aggregates whose partial results can be
+// combined, so the same aggregate can be evaluated over parts of a stream, by
+// the nodes of a sharded cluster or by parallel workers, and merged into the
+// result of evaluating it over the whole. State serializes the aggregate's
+// partial result; Merge folds another instance's State into this one; Value
+// is the aggregate's result as it stands, without evaluating another event.
+//
+// Windowed aggregates aren't Mergeable: a merged value couldn't later be
+// evicted from the window it came from. Their cumulative counterparts, Total,
+// CountDistinct and Percentile, are.
+type Mergeable interface {
+	State() ([]byte, error)
+	Merge(other []byte) error
+	Value() (result interface{}, err error)
+}
+
+var _ Mergeable = new(Count)
+var _ Mergeable = new(Total)
+var _ Mergeable = new(CountDistinct)
+var _ Mergeable = new(Percentile)
+var _ Mergeable = new(TimeDecayedAve)
+
+// mergeState is how every State is encoded, so merging mismatched aggregates
+// fails rather than producing garbage.
+type mergeState struct {
+	Kind  string          `json:"kind"`
+	State json.RawMessage `json:"state"`
+}
+
+func encodeState(kind string, state interface{}) (encoded []byte, err error) {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeState{kind, raw})
+}
+
+func decodeState(kind string, encoded []byte, state interface{}) (err error) {
+	var wrapper mergeState
+	if err := json.Unmarshal(encoded, &wrapper); err != nil {
+		return fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+	if wrapper.Kind != kind {
+		return fmt.Errorf("%w: Can't merge %v state into %v", ErrTypeMismatch, wrapper.Kind, kind)
+	}
+	if err := json.Unmarshal(wrapper.State, state); err != nil {
+		return fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+	return nil
+}
+
+// histogramState lists each bucket as [negative (0 or 1), exponent, sub,
+// count].
+type histogramState struct {
+	Buckets [][4]int `json:"buckets"`
+	Zeros   int      `json:"zeros"`
+}
+
+func (c *Count) State() ([]byte, error) {
+	return encodeState("Count", c.count)
+}
+
+func (c *Count) Merge(other []byte) (err error) {
+	var count int
+	if err := decodeState("Count", other, &count); err != nil {
+		return err
+	}
+	c.count += count
+	return nil
+}
+
+func (c *Count) Value() (result interface{}, err error) {
+	return c.count, nil
+}
+
+// totalState keeps the compensation, so a merged Total is as exact as one
+// that saw every value.
+type totalState struct {
+	Sum          float64 `json:"sum"`
+	Compensation float64 `json:"compensation"`
+}
+
+func (t *Total) State() ([]byte, error) {
+	return encodeState("Total", totalState{t.sum.sum, t.sum.compensation})
+}
+
+func (t *Total) Merge(other []byte) (err error) {
+	var state totalState
+	if err := decodeState("Total", other, &state); err != nil {
+		return err
+	}
+	t.sum.Add(state.Sum)
+	t.sum.Add(state.Compensation)
+	return nil
+}
+
+func (t *Total) Value() (result interface{}, err error) {
+	return t.sum.Value(), nil
+}
+
+// CountDistinct's state is its registers; merging keeps the larger of each.
+func (c *CountDistinct) State() ([]byte, error) {
+	return encodeState("CountDistinct", c.registers)
+}
+
+func (c *CountDistinct) Merge(other []byte) (err error) {
+	var registers []uint8
+	if err := decodeState("CountDistinct", other, &registers); err != nil {
+		return err
+	}
+	if len(registers) != len(c.registers) {
+		return fmt.Errorf("%w: Can't merge %d CountDistinct registers into %d", ErrTypeMismatch, len(registers), len(c.registers))
+	}
+	for ndx, rank := range registers {
+		if rank > c.registers[ndx] {
+			c.registers[ndx] = rank
+		}
+	}
+	return nil
+}
+
+func (c *CountDistinct) Value() (result interface{}, err error) {
+	return c.estimate(), nil
+}
+
+// Percentile's state is its histogram's, so it merges with any other hdr
+// histogram.
+func (p *Percentile) State() ([]byte, error) {
+	return p.histogram.State()
+}
+
+func (p *Percentile) Merge(other []byte) (err error) {
+	return p.histogram.Merge(other)
+}
+
+func (p *Percentile) Value() (result interface{}, err error) {
+	return p.quantile(nil)
+}
+
+func (h *histogramPercentiles) State() ([]byte, error) {
+	state := histogramState{Zeros: h.zeros}
+	for bucket, count := range h.buckets {
+		negative := 0
+		if bucket.negative {
+			negative = 1
+		}
+		state.Buckets = append(state.Buckets, [4]int{negative, bucket.exponent, bucket.sub, count})
+	}
+	return encodeState(PercentileHistogram, state)
+}
+
+func (h *histogramPercentiles) Merge(other []byte) (err error) {
+	var state histogramState
+	if err := decodeState(PercentileHistogram, other, &state); err != nil {
+		return err
+	}
+	for _, b := range state.Buckets {
+		h.buckets[histogramBucket{b[0] == 1, b[1], b[2]}] += b[3]
+		h.count += b[3]
+	}
+	h.zeros += state.Zeros
+	h.count += state.Zeros
+	return nil
+}
+
+type timeDecayedState struct {
+	WeightedSum float64   `json:"weightedSum"`
+	Weight      float64   `json:"weight"`
+	Last        time.Time `json:"last"`
+}
+
+func (t *TimeDecayedAve) State() ([]byte, error) {
+	return encodeState("TimeDecayedAve", timeDecayedState{t.weightedSum, t.weight, t.last})
+}
+
+// Merge decays whichever side is older to the other's time before adding
+// them, using the half-life evaluated without an event.
+func (t *TimeDecayedAve) Merge(other []byte) (err error) {
+	var state timeDecayedState
+	if err := decodeState("TimeDecayedAve", other, &state); err != nil {
+		return err
+	}
+	if state.Weight == 0 {
+		return nil
+	}
+	if t.weight == 0 {
+		t.weightedSum, t.weight, t.last = state.WeightedSum, state.Weight, state.Last
+		return nil
+	}
+
+	halfLife, err := evaluateSeconds(t.halfLife, nil)
+	if err != nil {
+		return err
+	}
+	decay := func(age time.Duration) float64 {
+		return math.Pow(0.5, age.Seconds()/halfLife.Seconds())
+	}
+	if state.Last.After(t.last) {
+		d := decay(state.Last.Sub(t.last))
+		t.weightedSum *= d
+		t.weight *= d
+		t.last = state.Last
+	} else {
+		d := decay(t.last.Sub(state.Last))
+		state.WeightedSum *= d
+		state.Weight *= d
+	}
+	t.weightedSum += state.WeightedSum
+	t.weight += state.Weight
+	return nil
+}
+
+func (t *TimeDecayedAve) Value() (result interface{}, err error) {
+	if t.weight == 0 {
+		return nil, fmt.Errorf("%w: TimeDecayedAve has no values", ErrWindowEmpty)
+	}
+	return t.weightedSum / t.weight, nil
+}
@@ -0,0 +1,92 @@
+package oxweb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type mergeTest struct {
+	statement string
+}
+
+var mergeTests = []mergeTest{
+	mergeTest{"Count()"},
+	mergeTest{"Total(v)"},
+	mergeTest{"CountDistinct(id)"},
+	mergeTest{"Percentile(v, 90)"},
+	mergeTest{`As(Total(v), "total")`},
+}
+
+// Evaluating the halves of a stream separately and merging them gives the
+// same result as evaluating the whole. Both then see one last event, so the
+// merged result can be read through Evaluate.
+func TestMergeAggregates(t *testing.T) {
+	event := func(ndx int) JSONData {
+		return map[string]interface{}{"v": float64(ndx%17) * 1.5, "id": fmt.Sprintf("user%d", ndx%300)}
+	}
+	for _, test := range mergeTests {
+		whole, _ := Parse(test.statement)
+		part1, _ := Parse(test.statement)
+		part2, err := Parse(test.statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ndx := 0; ndx < 1000; ndx++ {
+			whole.Evaluate(event(ndx))
+			if ndx%3 == 0 {
+				part1.Evaluate(event(ndx))
+			} else {
+				part2.Evaluate(event(ndx))
+			}
+		}
+
+		merged, ok := mergeableField(part1)
+		if !ok {
+			t.Fatalf("Expected %v to be Mergeable", test.statement)
+		}
+		other, _ := mergeableField(part2)
+		state, err := other.State()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := merged.Merge(state); err != nil {
+			t.Fatal(err)
+		}
+
+		expected, _ := whole.Evaluate(event(1000))
+		result, err := part1.Evaluate(event(1000))
+		if err != nil || result != expected {
+			t.Errorf("%v: expected merged result %v, got %v, %v", test.statement, expected, result, err)
+		}
+	}
+
+	count, _ := Parse("Count()")
+	total, _ := Parse("Total(v)")
+	state, _ := count.(Mergeable).State()
+	if err := total.(Mergeable).Merge(state); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected ErrTypeMismatch merging Count into Total, got %v", err)
+	}
+}
+
+func TestMergeTimeDecayedAve(t *testing.T) {
+	now := time.Unix(1000, 0)
+	newAve := func() *TimeDecayedAve {
+		ave := &TimeDecayedAve{timeSource: func() time.Time { return now }}
+		ave.Setup("TimeDecayedAve", []Expression{&Literal{nil}, &Literal{10}})
+		return ave
+	}
+	a, b := newAve(), newAve()
+	a.weightedSum, a.weight, a.last = 10, 1, now.Add(-10*time.Second)
+	b.weightedSum, b.weight, b.last = 4, 1, now
+
+	state, _ := b.State()
+	if err := a.Merge(state); err != nil {
+		t.Fatal(err)
+	}
+	// a's value has half the weight of b's: (10*0.5 + 4) / 1.5
+	if result, _ := a.Evaluate(nil); result != 6. {
+		t.Errorf("Expected merged average 6, got %v", result)
+	}
+}
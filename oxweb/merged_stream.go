@@ -0,0 +1,146 @@
+package oxweb
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// A Source is anything queries can subscribe to for events.
+type Source interface {
+	Subscribe(request *SubscribeRequest)
+	Unsubscribe(request *SubscribeRequest)
+}
+
+var _ Source = new(DataStream)
+var _ Source = new(MergedStream)
+
+func (stream *DataStream) Subscribe(request *SubscribeRequest) {
+	stream.SubscribeChan <- request
+}
+
+func (stream *DataStream) Unsubscribe(request *SubscribeRequest) {
+	stream.UnsubscribeChan <- request
+}
+
+// MergedStream combines several sources, such as the shards of one log, into
+// one, so a query sees all of their events without a copy of its windows for
+// each. Sources are subscribed to while the MergedStream has subscribers.
+type MergedStream struct {
+	sources []Source
+	reorder *Reorder
+
+	subscribeChan   chan *SubscribeRequest
+	unsubscribeChan chan *SubscribeRequest
+	subscribers     map[*SubscribeRequest]bool
+
+	// Our own subscriptions to the sources, while we have any.
+	merged        chan JSONData
+	subscriptions []*SubscribeRequest
+	// Ticks while subscribed, for reorder to release events from quiet
+	// sources.
+	expiry *time.Ticker
+	expire <-chan time.Time
+
+	dropped atomic.Int64
+}
+
+func NewMergedStream(sources ...Source) (stream *MergedStream) {
+	stream = &MergedStream{
+		sources:         sources,
+		subscribeChan:   make(chan *SubscribeRequest),
+		unsubscribeChan: make(chan *SubscribeRequest),
+		subscribers:     make(map[*SubscribeRequest]bool),
+	}
+	go stream.run()
+	return stream
+}
+
+// SortBy merges events in order of the timestamp at path, holding them for
+// delay so sources running slightly behind each other interleave correctly;
+// see Reorder. Must be called before the first subscriber.
+func (stream *MergedStream) SortBy(path string, delay time.Duration) (err error) {
+	stream.reorder, err = NewReorder(path, delay)
+	return err
+}
+
+// Stats reports "dropped", events dropped because a subscriber wasn't keeping
+// up.
+func (stream *MergedStream) Stats() Stats {
+	return Stats{"dropped": stream.dropped.Load()}
+}
+
+func (stream *MergedStream) countDropped(events int) {
+	stream.dropped.Add(int64(events))
+}
+
+func (stream *MergedStream) Subscribe(request *SubscribeRequest) {
+	stream.subscribeChan <- request
+}
+
+func (stream *MergedStream) Unsubscribe(request *SubscribeRequest) {
+	stream.unsubscribeChan <- request
+}
+
+func (stream *MergedStream) run() {
+	for {
+		select {
+		case request := <-stream.subscribeChan:
+			stream.subscribers[request] = true
+			if stream.merged == nil {
+				stream.subscribeSources()
+			}
+		case request := <-stream.unsubscribeChan:
+			if len(stream.subscribers) == 1 && stream.subscribers[request] && stream.merged != nil {
+				// The last subscriber gets whatever reorder was still holding.
+				stream.unsubscribeSources()
+			}
+			delete(stream.subscribers, request)
+		case event := <-stream.merged:
+			events := []JSONData{event}
+			if stream.reorder != nil {
+				events = stream.reorder.Process(event)
+			}
+			stream.deliver(events)
+		case <-stream.expire:
+			if events := stream.reorder.Expire(); len(events) > 0 {
+				stream.deliver(events)
+			}
+		}
+	}
+}
+
+func (stream *MergedStream) subscribeSources() {
+	stream.merged = make(chan JSONData, 64*len(stream.sources))
+	for _, source := range stream.sources {
+		request := &SubscribeRequest{DataChan: stream.merged}
+		source.Subscribe(request)
+		stream.subscriptions = append(stream.subscriptions, request)
+	}
+	if stream.reorder != nil {
+		stream.expiry = time.NewTicker(max(stream.reorder.delay/4, time.Millisecond))
+		stream.expire = stream.expiry.C
+	}
+}
+
+func (stream *MergedStream) unsubscribeSources() {
+	for ndx, source := range stream.sources {
+		source.Unsubscribe(stream.subscriptions[ndx])
+	}
+	stream.subscriptions = nil
+	// Receiving from a nil channel blocks, so run stops selecting on it.
+	stream.merged = nil
+	if stream.reorder != nil {
+		stream.expiry.Stop()
+		stream.expiry, stream.expire = nil, nil
+		stream.deliver(stream.reorder.Flush())
+	}
+}
+
+func (stream *MergedStream) deliver(events []JSONData) {
+	for subscriber := range stream.subscribers {
+		if subscriber.Paused() {
+			continue
+		}
+		subscriber.deliver(events, stream.countDropped)
+	}
+}
@@ -0,0 +1,127 @@
+package oxweb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeSource hands its subscription to the test to send events through.
+type fakeSource struct {
+	subscribed   chan *SubscribeRequest
+	unsubscribed chan bool
+}
+
+func (s *fakeSource) Subscribe(request *SubscribeRequest) {
+	s.subscribed <- request
+}
+
+func (s *fakeSource) Unsubscribe(request *SubscribeRequest) {
+	s.unsubscribed <- true
+}
+
+func TestMergedStream(t *testing.T) {
+	unsubscribed := make(chan bool, 2)
+	shard1 := &fakeSource{make(chan *SubscribeRequest, 1), unsubscribed}
+	shard2 := &fakeSource{make(chan *SubscribeRequest, 1), unsubscribed}
+
+	merged := NewMergedStream(shard1, shard2)
+	merged.SortBy("t", 10*time.Second)
+	request := &SubscribeRequest{DataChan: make(chan JSONData, 8)}
+	merged.Subscribe(request)
+
+	shard1Chan, shard2Chan := (<-shard1.subscribed).DataChan, (<-shard2.subscribed).DataChan
+	for _, send := range []struct {
+		shard chan JSONData
+		t     float64
+	}{{shard2Chan, 3}, {shard1Chan, 1}, {shard1Chan, 4}, {shard2Chan, 2}, {shard2Chan, 100}} {
+		send.shard <- map[string]interface{}{"t": send.t}
+	}
+
+	for _, expected := range []float64{1, 2, 3, 4} {
+		select {
+		case event := <-request.DataChan:
+			if value, _ := GetDeep("t", event); value != expected {
+				t.Errorf("Expected event %v, got %v", expected, value)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for event %v", expected)
+		}
+	}
+
+	// The last subscriber gets what's still held when it leaves.
+	merged.Unsubscribe(request)
+	select {
+	case event := <-request.DataChan:
+		if value, _ := GetDeep("t", event); value != 100. {
+			t.Errorf("Expected the held event 100, got %v", value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the held event")
+	}
+	for ndx := 0; ndx < 2; ndx++ {
+		select {
+		case <-unsubscribed:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected both sources to be unsubscribed")
+		}
+	}
+}
+
+// Events from sources that have gone quiet are released once the delay has
+// passed.
+func TestMergedStreamExpire(t *testing.T) {
+	shard := &fakeSource{make(chan *SubscribeRequest, 1), make(chan bool, 1)}
+	merged := NewMergedStream(shard)
+	merged.SortBy("t", 20*time.Millisecond)
+	request := &SubscribeRequest{DataChan: make(chan JSONData, 1)}
+	merged.Subscribe(request)
+
+	(<-shard.subscribed).DataChan <- map[string]interface{}{"t": 1.}
+	select {
+	case event := <-request.DataChan:
+		if value, _ := GetDeep("t", event); value != 1. {
+			t.Errorf("Expected event 1, got %v", value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the quiet source's event")
+	}
+}
+
+func TestMergedStreamDelivery(t *testing.T) {
+	shard := &fakeSource{make(chan *SubscribeRequest, 1), make(chan bool, 1)}
+	merged := NewMergedStream(shard)
+
+	acked, err := NewAckedSubscriber(filepath.Join(t.TempDir(), "spill"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acked.Close()
+	merged.Subscribe(acked.Request())
+	batched := &SubscribeRequest{BatchChan: make(chan []JSONData, 1), BatchSize: 2}
+	merged.Subscribe(batched)
+	dropping := &SubscribeRequest{DataChan: make(chan JSONData)}
+	merged.Subscribe(dropping)
+
+	shardChan := (<-shard.subscribed).DataChan
+	shardChan <- 1.
+	shardChan <- 2.
+	if event := receiveAcked(t, acked); event.Data != 1. {
+		t.Errorf("Expected the acked subscriber to get 1, got %v", event.Data)
+	}
+	select {
+	case batch := <-batched.BatchChan:
+		if len(batch) != 2 {
+			t.Errorf("Expected a batch of 2, got %v", batch)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a batch")
+	}
+	deadline := time.Now().Add(time.Second)
+	for merged.Stats()["dropped"] < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if dropped := merged.Stats()["dropped"]; dropped != 2 {
+		t.Errorf("Expected the unread subscriber's 2 events to be counted as dropped, got %d", dropped)
+	}
+}
@@ -0,0 +1,9 @@
+package oxweb
+
+// A nilPropagator is an expression that, parsed with
+// ParseOptions.PropagateNulls, evaluates to nil when given a nil where it
+// expects a value, rather than failing: arithmetic, Sum, Max and Min, and Go
+// functions.
+type nilPropagator interface {
+	propagateNulls()
+}
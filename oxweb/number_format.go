@@ -0,0 +1,108 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// A NumberFormat controls how numbers in records are written as JSON, for
+// consumers that choke on Go's default formatting, such as 1e+21 for a large
+// counter or 0.30000000000000004 for a sum. The zero NumberFormat writes
+// numbers as encoding/json does.
+type NumberFormat struct {
+	// Notation is "fixed", "scientific" or "" for encoding/json's choice:
+	// fixed, unless the exponent is below -6 or above 20.
+	Notation string `json:"notation,omitempty"`
+	// Precision is the number of digits after the decimal point in fixed or
+	// scientific notation, or of significant digits otherwise, with Go's
+	// choice of notation. Unset, numbers have as many digits as they need to
+	// be read back exactly.
+	Precision *int `json:"precision,omitempty"`
+	// Integers writes whole numbers up to 2^53 as integers, e.g. 3 rather
+	// than 3.00 in fixed notation with a Precision of 2.
+	Integers bool `json:"integers,omitempty"`
+}
+
+// Validate checks the format's options.
+func (f *NumberFormat) Validate() error {
+	switch f.Notation {
+	case "", "fixed", "scientific":
+	default:
+		return fmt.Errorf("%v is not a number notation, expected fixed or scientific", f.Notation)
+	}
+	if f.Precision != nil && *f.Precision < 0 {
+		return fmt.Errorf("Number precision must not be negative, got %d", *f.Precision)
+	}
+	return nil
+}
+
+// Apply returns a copy of record with its numbers formatted, as json.Numbers,
+// ready for encoding.
+func (f *NumberFormat) Apply(record JSONData) (formatted JSONData, err error) {
+	normalized, err := normalizeJSON(record)
+	if err != nil {
+		return nil, err
+	}
+	return f.apply(normalized), nil
+}
+
+func (f *NumberFormat) apply(value interface{}) interface{} {
+	switch value := value.(type) {
+	case float64:
+		return f.format(value)
+	case []interface{}:
+		for ndx, element := range value {
+			value[ndx] = f.apply(element)
+		}
+	case map[string]interface{}:
+		for key, element := range value {
+			value[key] = f.apply(element)
+		}
+	}
+	return value
+}
+
+func (f *NumberFormat) format(value float64) json.Number {
+	if f.Integers && value == math.Trunc(value) && math.Abs(value) <= maxExactInteger {
+		return json.Number(strconv.FormatFloat(value, 'f', 0, 64))
+	}
+	if f.Notation == "" && f.Precision == nil {
+		encoded, _ := json.Marshal(value)
+		return json.Number(encoded)
+	}
+	precision := -1
+	if f.Precision != nil {
+		precision = *f.Precision
+	}
+	switch f.Notation {
+	case "fixed":
+		return json.Number(strconv.FormatFloat(value, 'f', precision, 64))
+	case "scientific":
+		return json.Number(strconv.FormatFloat(value, 'e', precision, 64))
+	}
+	return json.Number(strconv.FormatFloat(value, 'g', precision, 64))
+}
+
+type formattedSink struct {
+	Sink
+	format *NumberFormat
+}
+
+// WithNumberFormat wraps sink so the numbers in every record written are
+// formatted by format.
+func WithNumberFormat(sink Sink, format *NumberFormat) Sink {
+	if format == nil {
+		return sink
+	}
+	return &formattedSink{sink, format}
+}
+
+func (s *formattedSink) Write(record JSONData) error {
+	formatted, err := s.format.Apply(record)
+	if err != nil {
+		return err
+	}
+	return s.Sink.Write(formatted)
+}
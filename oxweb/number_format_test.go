@@ -0,0 +1,65 @@
+package oxweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func precision(n int) *int {
+	return &n
+}
+
+var numberFormatTests = []struct {
+	format   NumberFormat
+	value    float64
+	expected string
+}{
+	{NumberFormat{}, 1e6, "1000000"},
+	{NumberFormat{}, 1e21, "1e+21"},
+	{NumberFormat{}, 0.125, "0.125"},
+	{NumberFormat{Integers: true}, 1e21, "1e+21"},
+	{NumberFormat{Integers: true}, 2.5, "2.5"},
+	{NumberFormat{Integers: true}, 1e15, "1000000000000000"},
+	{NumberFormat{Precision: precision(2)}, 1e6, "1e+06"},
+	{NumberFormat{Notation: "fixed"}, 1e21, "1000000000000000000000"},
+	{NumberFormat{Notation: "fixed", Precision: precision(2)}, 3.14159, "3.14"},
+	{NumberFormat{Notation: "fixed", Precision: precision(2), Integers: true}, 3, "3"},
+	{NumberFormat{Notation: "scientific", Precision: precision(3)}, 123456, "1.235e+05"},
+	{NumberFormat{Precision: precision(3)}, 3.14159, "3.14"},
+}
+
+func TestNumberFormat(t *testing.T) {
+	for _, test := range numberFormatTests {
+		if err := test.format.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		formatted, err := test.format.Apply([]interface{}{[]interface{}{"n", test.value}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, _ := json.Marshal(formatted)
+		if expected := `[["n",` + test.expected + `]]`; string(encoded) != expected {
+			t.Errorf("For %v formatted %+v, expected %v, got %s", test.value, test.format, expected, encoded)
+		}
+	}
+
+	if err := (&NumberFormat{Notation: "roman"}).Validate(); err == nil {
+		t.Errorf("Expected an error for an unknown notation")
+	}
+	if err := (&NumberFormat{Precision: precision(-1)}).Validate(); err == nil {
+		t.Errorf("Expected an error for a negative precision")
+	}
+}
+
+func TestWithNumberFormat(t *testing.T) {
+	var out bytes.Buffer
+	sink := WithNumberFormat(NewCSVEncoder(&out), &NumberFormat{Integers: true})
+	groups := GroupResult{"web1": GroupValue{Value: 2e6, Count: 3}}
+	if err := sink.Write([]interface{}{[]interface{}{"bytes", 1e6}, []interface{}{"by_host", groups}}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "bytes,by_host.web1.count,by_host.web1.value\n1000000,3,2000000\n"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,164 @@
+package oxweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// maxExactInteger is 2^53, beyond which float64s can't hold every whole
+// number.
+const maxExactInteger = 1 << 53
+
+// DecodeExact unmarshals a line of JSON like json.Unmarshal, except that
+// integers too big for a float64 to hold exactly, such as request IDs and
+// byte counters beyond 2^53, decode to int64s, or uint64s beyond that, rather
+// than silently losing their low digits. Every other number is a float64 as
+// usual, so expressions expecting one are unaffected.
+func DecodeExact(encoded []byte) (data JSONData, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err = decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("Unexpected data after the JSON value")
+	}
+	return exactNumbers(data)
+}
+
+// decodeEvent decodes a line from a source, with DecodeExact if exact.
+func decodeEvent(line []byte, exact bool) (data JSONData, err error) {
+	if exact {
+		return DecodeExact(line)
+	}
+	err = json.Unmarshal(line, &data)
+	return data, err
+}
+
+// exactNumbers replaces the json.Numbers in value as DecodeExact describes.
+func exactNumbers(value interface{}) (exact interface{}, err error) {
+	switch value := value.(type) {
+	case json.Number:
+		return exactNumber(value)
+	case []interface{}:
+		for ndx, element := range value {
+			if value[ndx], err = exactNumbers(element); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for key, element := range value {
+			if value[key], err = exactNumbers(element); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
+
+func exactNumber(number json.Number) (value interface{}, err error) {
+	text := string(number)
+	if !strings.ContainsAny(text, ".eE") {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			if i > maxExactInteger || i < -maxExactInteger {
+				return i, nil
+			}
+			return float64(i), nil
+		}
+		if u, err := strconv.ParseUint(text, 10, 64); err == nil {
+			return u, nil
+		}
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	return f, nil
+}
+
+// bigInteger converts the integer types expressions produce, and whole
+// float64s, to a big.Int, or returns nil for anything else.
+func bigInteger(value interface{}) *big.Int {
+	switch value := value.(type) {
+	case int:
+		return big.NewInt(int64(value))
+	case int64:
+		return big.NewInt(value)
+	case uint64:
+		return new(big.Int).SetUint64(value)
+	case float64:
+		if integer, accuracy := big.NewFloat(value).Int(nil); accuracy == big.Exact {
+			return integer
+		}
+	}
+	return nil
+}
+
+// isWideInteger reports whether value is one of the integers DecodeExact
+// produces.
+func isWideInteger(value interface{}) bool {
+	switch value.(type) {
+	case int64, uint64:
+		return true
+	}
+	return false
+}
+
+// smallInteger returns integer as an int64 or uint64, or an error if it's too
+// big for either.
+func smallInteger(integer *big.Int) (value interface{}, err error) {
+	switch {
+	case integer.IsInt64():
+		return integer.Int64(), nil
+	case integer.IsUint64():
+		return integer.Uint64(), nil
+	}
+	return nil, fmt.Errorf("%w: %v overflows 64 bits", ErrTypeMismatch, integer)
+}
+
+// floatOperand converts an operand to a float64 like toFloat, failing for
+// anything but a number. If exact, integers beyond 2^53 fail too rather than
+// being rounded.
+func floatOperand(value interface{}, exact bool) (f float64, err error) {
+	f, ok := toFloat(value)
+	if !ok {
+		return 0, fmt.Errorf("%w: Expected a number, got %T, %v", ErrTypeMismatch, value, value)
+	}
+	if exact && isWideInteger(value) && bigInteger(value).CmpAbs(big.NewInt(maxExactInteger)) > 0 {
+		return 0, fmt.Errorf("%w: %v can't be a float64 without losing precision", ErrTypeMismatch, value)
+	}
+	return f, nil
+}
+
+// compensatedSum is a running sum that also keeps the low-order bits each
+// addition rounds off, by Neumaier's variant of Kahan summation. Windows add
+// and subtract values for as long as they run, and a plain float64 sum would
+// drift further from the true sum with every one; this stays within a
+// rounding error of it.
+type compensatedSum struct {
+	sum          float64
+	compensation float64
+}
+
+func (s *compensatedSum) Add(v float64) {
+	t := s.sum + v
+	switch {
+	case math.IsInf(t, 0):
+		// Nothing's left to compensate for, and Inf - Inf would make it NaN.
+	case math.Abs(s.sum) >= math.Abs(v):
+		s.compensation += (s.sum - t) + v
+	default:
+		s.compensation += (v - t) + s.sum
+	}
+	s.sum = t
+}
+
+func (s *compensatedSum) Value() float64 {
+	return s.sum + s.compensation
+}
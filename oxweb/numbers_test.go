@@ -0,0 +1,75 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const bigNumbersJSON = `{"id": 9007199254740993, "small": 5, "huge": 18446744073709551615, "ratio": 1.5, "list": [-9007199254740993]}`
+
+func TestDecodeExact(t *testing.T) {
+	data, err := DecodeExact([]byte(bigNumbersJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]interface{}{
+		"id":     int64(9007199254740993),
+		"small":  5.,
+		"huge":   uint64(18446744073709551615),
+		"ratio":  1.5,
+		"list.0": int64(-9007199254740993),
+	} {
+		if value, _ := GetDeep(path, data); value != expected {
+			t.Errorf("For %v, expected %T %v, got %T %v", path, expected, expected, value, value)
+		}
+	}
+	if id, _, err := GetInt("id", data); err != nil || id != 9007199254740993 {
+		t.Errorf("Expected the exact id, got %v, %v", id, err)
+	}
+	if encoded, _ := json.Marshal(data); string(encoded) != `{"huge":18446744073709551615,"id":9007199254740993,"list":[-9007199254740993],"ratio":1.5,"small":5}` {
+		t.Errorf("Unexpected encoding %s", encoded)
+	}
+
+	for _, bad := range []string{`{"a": 1} {`, `{"a": 1e400}`, `{"a":`} {
+		if _, err := DecodeExact([]byte(bad)); err == nil {
+			t.Errorf("Expected an error decoding %v", bad)
+		}
+	}
+}
+
+var exactArithmeticTests = []struct {
+	statement string
+	result    interface{}
+	ok        bool
+}{
+	{"Add(id, 1)", int64(9007199254740994), true},
+	{"Subtract(id, 1.0)", int64(9007199254740992), true},
+	{"Multiply(small, 2)", 10., true},
+	{"Add(small, 0.5)", 5.5, true},
+	{"Add(id, 0.5)", nil, false},
+	{"Divide(id, 2)", 4503599627370496.5, true},
+	{"Subtract(huge, 1)", uint64(18446744073709551614), true},
+	{"Add(huge, 1)", nil, false},
+	{"Subtract(18446744073709551615, huge)", int64(0), true},
+}
+
+func TestExactArithmetic(t *testing.T) {
+	data, _ := DecodeExact([]byte(bigNumbersJSON))
+	for _, test := range exactArithmeticTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatalf("Couldn't parse %v: %v", test.statement, err)
+		}
+		result, err := expr.Evaluate(data)
+		if test.ok != (err == nil) {
+			t.Errorf("For %v, expected ok = %t, but err was %v", test.statement, test.ok, err)
+		}
+		if !test.ok && !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("For %v, expected a type mismatch, got %v", test.statement, err)
+		}
+		if result != test.result {
+			t.Errorf("For %v, expected %T %v, got %T %v", test.statement, test.result, test.result, result, result)
+		}
+	}
+}
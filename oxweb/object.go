@@ -0,0 +1,56 @@
+package oxweb
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+ * Object(key1 string, expr1, key2 string, expr2, ...) -> map[string]interface{}
+ *
+ * Builds a JSON object from pairs of keys and evaluated values, so structured
+ * records can be emitted rather than flat lists of values.
+ */
+type ObjectExpression struct {
+	keys   []Expression
+	values []Expression
+}
+
+func (o *ObjectExpression) Setup(fname string, args []Expression) (err error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return fmt.Errorf("Object expects pairs of string keys and expressions. Got %d arguments", len(args))
+	}
+	for ndx := 0; ndx < len(args); ndx += 2 {
+		o.keys = append(o.keys, args[ndx])
+		o.values = append(o.values, args[ndx+1])
+	}
+	return nil
+}
+
+func (o *ObjectExpression) Evaluate(data JSONData) (result interface{}, err error) {
+	object := make(map[string]interface{}, len(o.keys))
+	for ndx, keyExpr := range o.keys {
+		evaluated, err := keyExpr.Evaluate(data)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := evaluated.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: Object expects string keys. Key %d was type %T, %v", ErrTypeMismatch, ndx+1, evaluated, evaluated)
+		}
+		value, err := o.values[ndx].Evaluate(data)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't evaluate value for key %v: %w", key, err)
+		}
+		object[key] = value
+	}
+	return object, nil
+}
+
+func (o *ObjectExpression) String() string {
+	pairs := make([]string, 0, len(o.keys)*2)
+	for ndx := range o.keys {
+		pairs = append(pairs, o.keys[ndx].String(), o.values[ndx].String())
+	}
+	return fmt.Sprintf("Object(%v)", strings.Join(pairs, ","))
+}
@@ -0,0 +1,54 @@
+package oxweb
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var objectTests = []struct {
+	statement string
+	result    interface{}
+	ok        bool
+}{
+	{`Object("host", host, "ms", latency)`, map[string]interface{}{"host": "web1", "ms": 12.}, true},
+	{`Object("nested", Object("n", 1))`, map[string]interface{}{"nested": map[string]interface{}{"n": 1}}, true},
+	{`Object("missing", missing)`, map[string]interface{}{"missing": nil}, true},
+	{`Object(host, latency)`, map[string]interface{}{"web1": 12.}, true},
+	// JSON literals evaluate to themselves.
+	{`Object("tags", ["a", "b"], "meta", {"v": 1})`, map[string]interface{}{"tags": []interface{}{"a", "b"}, "meta": map[string]interface{}{"v": 1.}}, true},
+	{`Object(latency, host)`, nil, false},
+	{`Object("ms", Multiply(host, 2))`, nil, false},
+}
+
+func TestObject(t *testing.T) {
+	event := map[string]interface{}{"host": "web1", "latency": 12.}
+	for _, test := range objectTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatalf("Couldn't parse %v: %v", test.statement, err)
+		}
+		result, err := expr.Evaluate(event)
+		if test.ok != (err == nil) {
+			t.Errorf("For %v, expected ok = %t, but err was %v", test.statement, test.ok, err)
+		}
+		if test.ok && !reflect.DeepEqual(result, test.result) {
+			t.Errorf("For %v, expected %v, got %v", test.statement, test.result, result)
+		}
+	}
+
+	for _, statement := range []string{"Object()", `Object("a")`, `Object("a", 1, "b")`} {
+		if _, err := Parse(statement); err == nil {
+			t.Errorf("Expected an error parsing %v", statement)
+		}
+	}
+	expr, _ := Parse("Object(latency, host)")
+	if _, err := expr.Evaluate(event); !errors.Is(err, ErrTypeMismatch) || !strings.Contains(err.Error(), "was type float64, 12") {
+		t.Errorf("Expected the key's own type reported, got %v", err)
+	}
+	expr, _ = Parse(`Object("ms", Multiply(host, 2))`)
+	if _, err := expr.Evaluate(event); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected the value's error wrapped, got %v", err)
+	}
+}
@@ -1,75 +1,141 @@
 package oxweb
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"regexp"
 	"strconv"
+	"strings"
+	"unicode"
 )
 
+// ParseString splits a function call statement such as Foo(a,Bar(b,c)) into
+// its name and the text of each argument. Commas only separate arguments at
+// the top level, outside any nested (), [] or {} and outside quotes, in which
+// a backslash escapes the next character. Whitespace outside quotes is
+// dropped. Foo() has no arguments, but an empty argument, as in Foo(a,,b), is
+// an error.
 func ParseString(statement string) (fname string, args []string, err error) {
-	// Match a word followed by a pair of parens with anything in between them.
-	expressionReString := `[A-Z|a-z|0-9]+\((.+)\)`
-	expressionRe, err := regexp.Compile(expressionReString)
-	if !expressionRe.MatchString(statement) {
-		return "", []string{}, fmt.Errorf("\"%v\" is not an Expression", statement)
-	}
-	// Now pull out the functiona name.
-	fnameRe, err := regexp.Compile(`([A-Z|a-z|0-9]+)\((.+)\)$`)
-	fnameMatches := fnameRe.FindStringSubmatch(statement)
-	fname = fnameMatches[1]
-	argsStr := fnameMatches[2]
-
-	// Scan over the arguments text, keeping track of the level of parentheses
-	// nesting. If we reach a comma at the top-level, end the currentWord
+	statement = strings.TrimSpace(statement)
+
+	nameEnd := strings.IndexFunc(statement, func(c rune) bool { return !isNameRune(c) })
+	if nameEnd <= 0 || statement[nameEnd] != '(' || !strings.HasSuffix(statement, ")") {
+		return "", []string{}, fmt.Errorf("%w: \"%v\" is not an Expression", ErrParse, statement)
+	}
+	fname = statement[:nameEnd]
+	argsStr := statement[nameEnd+1 : len(statement)-1]
+
+	// Scan over the arguments text, keeping track of the brackets we're
+	// nested in. If we reach a comma at the top-level, end the currentWord
 	// and add it to the list of arguments.
-	parenLevel := 0
-	quoted := false
+	closers := []rune{}
+	var quote rune
+	escaped := false
 	currentWord := []rune{}
 	for _, c := range argsStr {
+		if quote != 0 {
+			// Inside quotes everything is kept. The quotes are later
+			// stripped off in ParseLiteral().
+			currentWord = append(currentWord, c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\' && quote != '`':
+				escaped = true
+			case c == quote:
+				quote = 0
+			}
+			continue
+		}
+
 		switch c {
+		case '"', '`', '\'':
+			quote = c
 		case '(':
-			parenLevel++
-			continue
-		case ')':
-			parenLevel--
+			closers = append(closers, ')')
+		case '[':
+			closers = append(closers, ']')
+		case '{':
+			closers = append(closers, '}')
+		case ')', ']', '}':
+			if len(closers) == 0 || closers[len(closers)-1] != c {
+				return "", []string{}, fmt.Errorf("%w: Unbalanced parentheses in \"%v\"", ErrParse, argsStr)
+			}
+			closers = closers[:len(closers)-1]
+		case ',':
+			if len(closers) == 0 {
+				if len(currentWord) == 0 {
+					return "", []string{}, fmt.Errorf("%w: Empty argument in \"%v\"", ErrParse, argsStr)
+				}
+				args = append(args, string(currentWord))
+				currentWord = []rune{}
+				continue
+			}
+		}
+		if unicode.IsSpace(c) {
 			continue
-        case ' ':
-            if !quoted {
-                continue
-            }
-		// We keep track of whether we're inside of quotes, so
-		// we know whether to ignore whitespace. The quotes are
-		// later stripped off in ParseLiteral().
-		case '"', '`', '\'':
-			quoted = !quoted
 		}
+		currentWord = append(currentWord, c)
+	}
 
-		if parenLevel == 0 && !quoted && c == ',' {
-			args = append(args, string(currentWord))
-			currentWord = []rune{}
-		} else {
-			//append(currentWord, c)
-		}
+	if len(closers) != 0 {
+		return "", []string{}, fmt.Errorf("%w: Unbalanced parentheses in \"%v\"", ErrParse, argsStr)
+	}
+	if quote != 0 {
+		return "", []string{}, fmt.Errorf("%w: Unbalanced quote marks in \"%v\"", ErrParse, argsStr)
 	}
 	// Don't forget to add the last word.
-	args = append(args, string(currentWord))
-
-	if parenLevel != 0 {
-		return "", []string{}, fmt.Errorf("Unbalanced parentheses in \"%v\"", argsStr)
+	if len(currentWord) > 0 {
+		args = append(args, string(currentWord))
+	} else if len(args) > 0 {
+		return "", []string{}, fmt.Errorf("%w: Empty argument in \"%v\"", ErrParse, argsStr)
 	}
-	if quoted {
-		return "", []string{}, fmt.Errorf("Unbalanced quote marks in \"%v\"", argsStr)
+	if args == nil {
+		args = []string{}
 	}
 	return fname, args, nil
 }
 
+func isNameRune(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// An Expression is created by Parse for each function call, then Setup with
+// the call's arguments: none at all for a call such as Now(), or any number
+// for a variadic function. Setup should check it got a number it can use,
+// e.g. with checkArity.
 type Expression interface {
 	Setup(fname string, args []Expression) (err error)
 	Evaluate(data JSONData) (result interface{}, err error)
 	String() string
 }
 
+// variadic is the max for checkArity when there's no limit.
+const variadic = -1
+
+// checkArity returns an error unless fname got from min to max arguments.
+func checkArity(fname string, args []Expression, min, max int) error {
+	if len(args) >= min && (max == variadic || len(args) <= max) {
+		return nil
+	}
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 argument"
+		}
+		return fmt.Sprintf("%d arguments", n)
+	}
+	switch {
+	case max == 0:
+		return fmt.Errorf("%v expects no arguments, got %d", fname, len(args))
+	case max == variadic:
+		return fmt.Errorf("%v expects at least %v, got %d", fname, plural(min), len(args))
+	case min == max:
+		return fmt.Errorf("%v expects %v, got %d", fname, plural(min), len(args))
+	}
+	return fmt.Errorf("%v expects %d to %d arguments, got %d", fname, min, max, len(args))
+}
+
 type Function struct {
 	args []Expression
 }
@@ -88,28 +154,130 @@ func (l *Literal) Setup(fname string, args []Expression) (err error) {
 }
 
 func (l *Literal) String() string {
+	switch l.value.(type) {
+	case map[string]interface{}, []interface{}:
+		if encoded, err := json.Marshal(l.value); err == nil {
+			return string(encoded)
+		}
+	}
 	return fmt.Sprintf("%v", l.value)
 }
 
+// ParseLiteral parses a number, duration, size, quoted string or JSON
+// literal. Anything else is a *LiteralError, suggesting what was meant.
 func ParseLiteral(literal string) (l *Literal, err error) {
-	l = new(Literal)
+	value, ok := parseLiteralValue(literal)
+	if !ok {
+		return nil, literalError(literal)
+	}
+	return &Literal{value}, nil
+}
+
+func parseLiteralValue(literal string) (value interface{}, ok bool) {
 	if i, err := strconv.Atoi(literal); err == nil {
-		l.value = i
-		return l, nil
+		return i, true
+	} else if u, err := strconv.ParseUint(literal, 10, 64); err == nil {
+		// Too big for an int, but not for a uint64, which keeps every digit.
+		return u, true
 	} else if f, err := strconv.ParseFloat(literal, 64); err == nil {
-		l.value = f
-		return l, nil
+		return f, true
 	} else if unquoted, err := strconv.Unquote(literal); err == nil {
-		l.value = unquoted
-		return l, nil
+		return unquoted, true
+	} else if quantity, ok := parseQuantity(literal); ok {
+		// Durations, e.g. 250ms or 2h, and sizes, e.g. 10MB or 4KiB
+		return quantity, true
+	} else if strings.HasPrefix(literal, "{") || strings.HasPrefix(literal, "[") {
+		// JSON objects and arrays, e.g. {"a": 1} or [1, 2, 3]
+		if err := json.Unmarshal([]byte(literal), &value); err == nil {
+			return value, true
+		}
 	}
-	return nil, fmt.Errorf("Couldn't parse %s as a literal", literal)
+	return nil, false
 }
 
+// Parse parses a statement into an Expression. Statements may span several
+// lines with any indentation, and comments starting with # or // run to the
+// end of the line, so long queries can be laid out readably:
+//
+//	GroupBy(
+//		GetDeep("status"),    # one group per status code
+//		WindowAve(
+//			RollingWindow(latency, 100)  // last 100 requests
+//		)
+//	)
 func Parse(statement string) (expr Expression, err error) {
+	return ParseWithOptions(statement, ParseOptions{})
+}
+
+// ParseOptions change how a parsed statement evaluates.
+type ParseOptions struct {
+	// PropagateNulls makes arithmetic, Sum, Max and Min, and Go functions
+	// evaluate to nil when given a nil, as when events omit optional fields,
+	// rather than failing. Parse a Query's statements with its PropagateNulls.
+	PropagateNulls bool
+}
+
+// ParseWithOptions parses a statement as Parse does, with options applying to
+// every expression in it, including the bodies of Define()'d functions.
+func ParseWithOptions(statement string, options ParseOptions) (expr Expression, err error) {
+	return parse(strings.TrimSpace(stripComments(statement)), &parseScope{options: options})
+}
+
+// stripComments removes # and // comments from statement, leaving the
+// newlines that end them. Comment markers inside quotes are left alone.
+func stripComments(statement string) string {
+	var stripped strings.Builder
+	var quote rune
+	escaped := false
+	comment := false
+	runes := []rune(statement)
+	for ndx, c := range runes {
+		switch {
+		case comment:
+			if c != '\n' {
+				continue
+			}
+			comment = false
+		case quote != 0:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\' && quote != '`':
+				escaped = true
+			case c == quote:
+				quote = 0
+			}
+		case c == '"' || c == '`' || c == '\'':
+			quote = c
+		case c == '#' || (c == '/' && ndx+1 < len(runes) && runes[ndx+1] == '/'):
+			comment = true
+			continue
+		}
+		stripped.WriteRune(c)
+	}
+	return stripped.String()
+}
+
+// parseScope is what's in scope while parsing a statement, or the body of a
+// Define()'d function in it.
+type parseScope struct {
+	options ParseOptions
+	// The function's arguments, by parameter name.
+	params map[string]Expression
+	// The definitions being expanded, outermost first, so one that calls
+	// itself is caught rather than expanded forever.
+	expanding []string
+}
+
+// parse does the work of Parse. Bare names found in scope resolve to the
+// bound Expression rather than a GetDeep lookup, which is how the parameters
+// of Define()'d functions are substituted.
+func parse(statement string, scope *parseScope) (expr Expression, err error) {
 	// First try to parse literals
 	if expr, err = ParseLiteral(statement); err == nil {
 		return
+	} else if err.(*LiteralError).notPath {
+		return nil, err
 	}
 
 	// Base case: statement is a single expression (e.g. Foo(a,b))
@@ -117,6 +285,11 @@ func Parse(statement string) (expr Expression, err error) {
 
 	// Then treat it as a GetDeep expression if it's not an expression
 	if err != nil {
+		if scope != nil {
+			if expr, ok := scope.params[statement]; ok {
+				return expr, nil
+			}
+		}
 		expr, err := NewGetDeepExpression(statement)
 		if err == nil {
 			log.Printf("found a get deep expr: %v, args: %v", expr, args)
@@ -128,7 +301,7 @@ func Parse(statement string) (expr Expression, err error) {
 	// Now start parsing the rest
 	expressionArgs := []Expression{}
 	for _, arg := range args {
-		argExpr, err := Parse(arg)
+		argExpr, err := parse(arg, scope)
 		if err != nil {
 			return nil, err
 		}
@@ -136,26 +309,124 @@ func Parse(statement string) (expr Expression, err error) {
 	}
 
 	switch {
-	case fname == "RandomSample":
-		expr = new(RandomSample)
-	case fname == "EveryNth":
-		expr = new(EveryNth)
-	case fname == "GetDeep":
-		expr = new(GetDeepExpression)
-	case fname == "Subtract" || fname == "Add" || fname == "Divide" || fname == "Multiply":
-		expr = new(ArithmeticOperator)
-	case fname == "RollingWindow":
-		expr = new(RollingWindow)
-	case fname == "TimedWindow":
-		expr = new(TimedWindow)
-	case fname == "WindowAve":
-		expr = new(WindowAve)
-	case fname == "As":
-		expr = new(AsClause)
-
+	case builtin(fname) != nil:
+		expr = builtin(fname)
+		if groupBy, ok := expr.(*GroupBy); ok && len(args) >= 2 {
+			// Each group needs its own copy of the aggregate, so keep a way of
+			// parsing the aggregate argument afresh.
+			aggregate := args[1]
+			groupBy.newAggregate = func() (Expression, error) { return parse(aggregate, scope) }
+		}
+	case lookupRegistered(fname) != nil:
+		expr = lookupRegistered(fname)()
+	case lookupDefinition(fname) != nil:
+		expr = &DefinedFunction{definition: lookupDefinition(fname), outer: scope}
 	default:
-		return nil, fmt.Errorf("Unrecognized function name '%s'", fname)
+		return nil, fmt.Errorf("%w: Unrecognized function name '%s'", ErrParse, fname)
+	}
+	if nulls, ok := expr.(nilPropagator); ok && scope != nil && scope.options.PropagateNulls {
+		nulls.propagateNulls()
+	}
+	if err = expr.Setup(fname, expressionArgs); err != nil && !errors.Is(err, ErrParse) {
+		err = fmt.Errorf("%w: %w", ErrParse, err)
 	}
-	err = expr.Setup(fname, expressionArgs)
 	return
 }
+
+// builtin returns a new Expression for the built-in function fname, or nil if
+// there isn't one.
+func builtin(fname string) Expression {
+	switch fname {
+	case "RandomSample":
+		return new(RandomSample)
+	case "EveryNth":
+		return new(EveryNth)
+	case "ScaledCount":
+		return new(ScaledCount)
+	case "ScaledSum":
+		return new(ScaledSum)
+	case "Meta":
+		return new(Meta)
+	case "Now":
+		return new(Now)
+	case "Count":
+		return new(Count)
+	case "Total":
+		return new(Total)
+	case "CountDistinct":
+		return new(CountDistinct)
+	case "Percentile":
+		return new(Percentile)
+	case "GetDeep":
+		return new(GetDeepExpression)
+	case "Subtract", "Add", "Divide", "Multiply":
+		return new(ArithmeticOperator)
+	case "RollingWindow":
+		return new(RollingWindow)
+	case "TimedWindow":
+		return new(TimedWindow)
+	case "WindowAve":
+		return new(WindowAve)
+	case "WindowCorrelation", "WindowCovariance":
+		return new(WindowCorrelation)
+	case "Pair":
+		return new(Pair)
+	case "WindowTrend":
+		return new(WindowTrend)
+	case "WindowSample":
+		return new(WindowSample)
+	case "WindowStats":
+		return new(WindowStats)
+	case "WindowPercentile":
+		return new(WindowPercentile)
+	case "Exemplars":
+		return new(Exemplars)
+	case "Forecast":
+		return new(Forecast)
+	case "ChangePoint":
+		return new(ChangePoint)
+	case "TimeDecayedAve":
+		return new(TimeDecayedAve)
+	case "As":
+		return new(AsClause)
+	case "UrlPath", "UrlHost":
+		return new(URLPart)
+	case "UrlQueryParam":
+		return new(URLQueryParam)
+	case "Hash":
+		return new(HashExpression)
+	case "Bucket":
+		return new(Bucket)
+	case "HourOfDay", "DayOfWeek", "IsWeekend":
+		return new(TimeOfDay)
+	case "RoundTo":
+		return new(RoundTo)
+	case "LogBucket":
+		return new(LogBucket)
+	case "Convert":
+		return new(Convert)
+	case "Object":
+		return new(ObjectExpression)
+	case "ArraySum", "ArrayAvg", "ArrayMax", "ArrayMin":
+		return new(ArrayAggregate)
+	case "Sum", "Max", "Min":
+		return new(ArgAggregate)
+	case "ArrayLen":
+		return new(ArrayLen)
+	case "ArrayMap":
+		return new(ArrayMap)
+	case "Funnel":
+		return new(Funnel)
+	case "Sequence":
+		return new(Sequence)
+	case "Suppress":
+		return new(Suppress)
+	case "GroupBy":
+		return new(GroupBy)
+	case "OrderBy":
+		return new(OrderBy)
+	case "Limit":
+		return new(Limit)
+	}
+	return nil
+}
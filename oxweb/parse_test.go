@@ -1,8 +1,15 @@
 package oxweb
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 type parseStringTest struct {
@@ -22,6 +29,28 @@ var parseStringTests = []parseStringTest{
 	parseStringTest{"Foo(Bar(a,b),c,de)", "Foo", []string{"Bar(a,b)", "c", "de"}, true},
 	parseStringTest{"Foo(a,Bar(b,c)", "", []string{}, false}, // Unbalanced parens
 	parseStringTest{"foo", "", []string{}, false},
+	parseStringTest{"Foo(Bar(a),b)", "Foo", []string{"Bar(a)", "b"}, true},
+	parseStringTest{"Foo(Bar(Baz(a)),b)", "Foo", []string{"Bar(Baz(a))", "b"}, true},
+	parseStringTest{"Foo((a),b)", "Foo", []string{"(a)", "b"}, true},
+	parseStringTest{"Foo(a, b)", "Foo", []string{"a", "b"}, true},
+	parseStringTest{" Foo(a) ", "Foo", []string{"a"}, true},
+	parseStringTest{`Foo("a,b",c)`, "Foo", []string{`"a,b"`, "c"}, true},
+	parseStringTest{`Foo("a b")`, "Foo", []string{`"a b"`}, true},
+	parseStringTest{`Foo("(",c)`, "Foo", []string{`"("`, "c"}, true},
+	parseStringTest{`Foo("say \"hi\", ok",c)`, "Foo", []string{`"say \"hi\", ok"`, "c"}, true},
+	parseStringTest{`Foo("a\\",c)`, "Foo", []string{`"a\\"`, "c"}, true},
+	parseStringTest{"Foo(`a\\`,c)", "Foo", []string{"`a\\`", "c"}, true},
+	parseStringTest{`Foo([1,2],{"a":1})`, "Foo", []string{"[1,2]", `{"a":1}`}, true},
+	parseStringTest{"Foo()", "Foo", []string{}, true},
+	parseStringTest{"Foo(a,,b)", "", []string{}, false},
+	parseStringTest{"Foo(a,)", "", []string{}, false},
+	parseStringTest{"Foo(,a)", "", []string{}, false},
+	parseStringTest{"Foo(a))", "", []string{}, false},
+	parseStringTest{"Foo(a)(b)", "", []string{}, false},
+	parseStringTest{"Foo([a)]", "", []string{}, false},
+	parseStringTest{`Foo("a)`, "", []string{}, false},
+	parseStringTest{`Foo("a\")`, "", []string{}, false},
+	parseStringTest{"(a)", "", []string{}, false},
 }
 
 func TestParseFunction(t *testing.T) {
@@ -35,7 +64,7 @@ func TestParseFunction(t *testing.T) {
 			t.Errorf("For statement '%s', expected err, but was nil", test.statement)
 		}
 		if fname != test.fname {
-			t.Errorf("For statement '%s', expected fname = %v, but was %v", test.statement, test.fname, aggregator)
+			t.Errorf("For statement '%s', expected fname = %v, but was %v", test.statement, test.fname, fname)
 		}
 
 		if ok, err := sliceEquals(args, test.args); !ok {
@@ -55,3 +84,245 @@ func sliceEquals(x, y []string) (ok bool, err error) {
 	}
 	return true, nil
 }
+
+func TestParseNested(t *testing.T) {
+	expr, err := Parse(`Add(Multiply(a, 2.5), GetDeep("b"))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := expr.Evaluate(map[string]interface{}{"a": 3.0, "b": 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != 8.5 {
+		t.Errorf("Expected 8.5, got %v", result)
+	}
+}
+
+var stripCommentsTests = []struct {
+	statement string
+	stripped  string
+}{
+	{"a", "a"},
+	{"a # comment", "a "},
+	{"a // comment", "a "},
+	{"Foo(a, # first\n  b) // second\n", "Foo(a, \n  b) \n"},
+	{`Foo("#1", "http://x")`, `Foo("#1", "http://x")`},
+	{`Foo("say \"#\"") # done`, `Foo("say \"#\"") `},
+	{"Divide(a, b)", "Divide(a, b)"},
+}
+
+func TestStripComments(t *testing.T) {
+	for _, test := range stripCommentsTests {
+		if stripped := stripComments(test.statement); stripped != test.stripped {
+			t.Errorf("For statement %q, expected %q, got %q", test.statement, test.stripped, stripped)
+		}
+	}
+}
+
+func TestParseMultiLine(t *testing.T) {
+	expr, err := Parse(`
+		# Average of a and b
+		Divide(
+			Add(
+				a,    // first
+				b     // second
+			),
+			2.0
+		)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expr.String() != "Divide(Add(a,b),2)" {
+		t.Errorf("Unexpected expression %v", expr)
+	}
+}
+
+// Statements seeding the fuzz targets: valid ones of every shape and some
+// known to have tripped the parser up.
+var fuzzStatements = []string{
+	"a",
+	"c.d",
+	"array.1",
+	"Foo()",
+	"Add()",
+	"Add(a)",
+	"Add(a, b, c)",
+	"Divide(Add(a,b),2.0)",
+	`GroupBy(GetDeep("b"), WindowAve(RollingWindow(a, 10)))`,
+	`WindowPercentile(TimedWindow(a, 60), 99)`,
+	`As(Add(a, c.d), "total")`,
+	`Hash(b, "md5")`,
+	`Object("x", a, "y", [1, 2])`,
+	`Foo("a,b", '(', {"c": [1]})`,
+	"Foo(a,,b)",
+	"Foo(a,Bar(b,c)",
+	"Foo(a))",
+	`Foo("unterminated)`,
+	"(",
+	")",
+	"Foo(\n# comment\n)",
+	"1e400",
+	`"\x"`,
+}
+
+func FuzzParse(f *testing.F) {
+	for _, statement := range fuzzStatements {
+		f.Add(statement)
+	}
+	var fixture JSONData
+	json.Unmarshal([]byte(jsonString), &fixture)
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	f.Fuzz(func(t *testing.T, statement string) {
+		expr, err := Parse(statement)
+		if err != nil {
+			return
+		}
+		_ = expr.String()
+		expr.Evaluate(fixture)
+	})
+}
+
+func FuzzParseLiteral(f *testing.F) {
+	for _, literal := range []string{"1", "-2.5", "1e400", `"a"`, "`b`", `'c'`, `{"d": [1, 2]}`, "[", "{", `"\x"`, "nil"} {
+		f.Add(literal)
+	}
+	f.Fuzz(func(t *testing.T, literal string) {
+		if l, err := ParseLiteral(literal); err == nil {
+			_ = l.String()
+		}
+	})
+}
+
+func TestZeroArguments(t *testing.T) {
+	count, err := Parse("Count()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 1; n <= 3; n++ {
+		if result, _ := count.Evaluate(nil); result != n {
+			t.Errorf("Expected a count of %d, got %v", n, result)
+		}
+	}
+	if count.String() != "Count()" {
+		t.Errorf("Unexpected expression %v", count)
+	}
+
+	age, err := Parse("Subtract(Now(), 100.0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := age.Evaluate(nil)
+	if result, ok := result.(float64); err != nil || !ok || result < float64(time.Now().Unix())-101 {
+		t.Errorf("Unexpected age %v, %v", result, err)
+	}
+
+	if _, err := Parse("Now(a)"); err == nil || err.Error() != "parse error: Now expects no arguments, got 1" {
+		t.Errorf("Expected an arity error, got %v", err)
+	}
+	if formatted, _ := Format("Count( )"); formatted != "Count()" {
+		t.Errorf("Unexpected formatting %q", formatted)
+	}
+}
+
+var checkArityTests = []struct {
+	args     int
+	min, max int
+	err      string
+}{
+	{0, 0, 0, ""},
+	{1, 0, 0, "F expects no arguments, got 1"},
+	{1, 1, 1, ""},
+	{2, 1, 1, "F expects 1 argument, got 2"},
+	{0, 2, 2, "F expects 2 arguments, got 0"},
+	{3, 1, 3, ""},
+	{4, 1, 3, "F expects 1 to 3 arguments, got 4"},
+	{5, 1, variadic, ""},
+	{0, 1, variadic, "F expects at least 1 argument, got 0"},
+}
+
+func TestCheckArity(t *testing.T) {
+	for _, test := range checkArityTests {
+		err := checkArity("F", make([]Expression, test.args), test.min, test.max)
+		if (err == nil && test.err != "") || (err != nil && err.Error() != test.err) {
+			t.Errorf("For %d arguments to F(%d, %d), expected %q, got %v", test.args, test.min, test.max, test.err, err)
+		}
+	}
+}
+
+func TestAs(t *testing.T) {
+	total, err := Parse(`As(Add(a, b), "total")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.String() != "total" {
+		t.Errorf("Expected the alias before any evaluation, got %q", total)
+	}
+	query := NewQuery([]Expression{total}, nil)
+	record, _, err := query.Evaluate(map[string]interface{}{"a": 1., "b": 2.})
+	if err != nil || record[0].([]interface{})[0] != "total" {
+		t.Errorf("Unexpected record %v, %v", record, err)
+	}
+
+	for _, statement := range []string{`As(a, b)`, `As(a, "")`, `As(a, 1)`, `As(a)`, `As(a, UrlPath(b))`} {
+		if _, err := Parse(statement); !errors.Is(err, ErrParse) {
+			t.Errorf("For %v, expected a parse error, got %v", statement, err)
+		}
+	}
+}
+
+var suggestionTests = []struct {
+	text         string
+	replacements []string
+}{
+	{"1,5", []string{"1.5"}},
+	{"1,500", []string{"1.500", "1500"}},
+	{"1.000,5", []string{"1000.5"}},
+	{"1 000 000", []string{"1000000"}},
+	{"−5", []string{"-5"}},
+	{"- 5", []string{"-5"}},
+	{"'web1'", []string{`"web1"`}},
+	{`"web1`, []string{`"web1"`}},
+	{`{'a': 1}`, []string{`{"a": 1}`}},
+	{"web1", []string{`"web1"`}},
+	{"a b", nil},
+}
+
+func TestLiteralSuggestions(t *testing.T) {
+	for _, test := range suggestionTests {
+		_, err := ParseLiteral(test.text)
+		var literalErr *LiteralError
+		if !errors.As(err, &literalErr) || !errors.Is(err, ErrParse) {
+			t.Errorf("For %q, expected a LiteralError, got %v", test.text, err)
+			continue
+		}
+		replacements := []string{}
+		for _, s := range literalErr.Suggestions {
+			replacements = append(replacements, s.Replacement)
+		}
+		if fmt.Sprint(replacements) != fmt.Sprint(test.replacements) {
+			t.Errorf("For %q, expected suggestions %q, got %q", test.text, test.replacements, replacements)
+		}
+	}
+}
+
+func TestParseSuggests(t *testing.T) {
+	for statement, expected := range map[string]string{
+		`RoundTo(a, −5)`: "Did you mean -5",
+		`1.000,5`:        "Did you mean 1000.5",
+		`As(a, total)`:   `Did you mean "total" (strings must be quoted)?`,
+		`Meta(source)`:   `Did you mean "source"`,
+	} {
+		_, err := Parse(statement)
+		if !errors.Is(err, ErrParse) || !strings.Contains(fmt.Sprint(err), expected) {
+			t.Errorf("For %v, expected an error suggesting %q, got %v", statement, expected, err)
+		}
+	}
+	// Bare names are still paths where a literal isn't required.
+	if _, err := Parse("Add(web1, 1)"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
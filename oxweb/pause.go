@@ -0,0 +1,151 @@
+package oxweb
+
+import (
+	"time"
+)
+
+// A timeShifter remembers when things happened and can move those times
+// forward, so time spent paused doesn't count towards windows or decay.
+// Aggregates wrapping a window pass the shift on to it.
+type timeShifter interface {
+	shiftTime(d time.Duration)
+}
+
+// shiftTime shifts expr's times, if it keeps any.
+func shiftTime(expr interface{}, d time.Duration) {
+	if shifter, ok := expr.(timeShifter); ok {
+		shifter.shiftTime(d)
+	}
+}
+
+func (tw *TimedWindow) shiftTime(d time.Duration) {
+	for elem := tw.windowList.Front(); elem != nil; elem = elem.Next() {
+		element := elem.Value.(timedWindowElement)
+		element.timestamp = element.timestamp.Add(d)
+		elem.Value = element
+	}
+}
+
+func (t *TimeDecayedAve) shiftTime(d time.Duration) {
+	if !t.last.IsZero() {
+		t.last = t.last.Add(d)
+	}
+}
+
+func (wt *WindowTrend) shiftTime(d time.Duration) {
+	shiftTime(wt.window, d)
+	if !wt.origin.IsZero() {
+		wt.origin = wt.origin.Add(d)
+	}
+	for elem := wt.pushTimes.Front(); elem != nil; elem = elem.Next() {
+		elem.Value = elem.Value.(time.Time).Add(d)
+	}
+}
+
+func (a *AsClause) shiftTime(d time.Duration)           { shiftTime(a.expr, d) }
+func (wa *WindowAve) shiftTime(d time.Duration)         { shiftTime(wa.window, d) }
+func (wc *WindowCorrelation) shiftTime(d time.Duration) { shiftTime(wc.window, d) }
+func (ws *WindowSample) shiftTime(d time.Duration)      { shiftTime(ws.window, d) }
+func (ws *WindowStats) shiftTime(d time.Duration)       { shiftTime(ws.window, d) }
+func (wp *WindowPercentile) shiftTime(d time.Duration)  { shiftTime(wp.window, d) }
+
+func (g *GroupBy) shiftTime(d time.Duration) {
+	for _, group := range g.groups {
+		shiftTime(group.aggregate, d)
+	}
+}
+
+// Pause stops the query evaluating events: until Resume, Evaluate returns
+// without a record. Time-based aggregates, such as TimedWindow and
+// TimeDecayedAve, measure wall clock time, so by default they keep decaying
+// while paused and events from before the pause may have aged out by the
+// time it resumes. With freeze, Resume shifts their times forward by the
+// length of the pause instead, as if it never happened.
+func (q *Query) Pause(freeze bool) {
+	q.pauseLock.Lock()
+	defer q.pauseLock.Unlock()
+
+	if q.paused {
+		return
+	}
+	q.paused = true
+	q.freeze = freeze
+	q.pausedAt = time.Now()
+}
+
+func (q *Query) Resume() {
+	q.pauseLock.Lock()
+	defer q.pauseLock.Unlock()
+
+	if !q.paused {
+		return
+	}
+	q.paused = false
+	if q.freeze {
+		pause := time.Since(q.pausedAt)
+		for _, field := range q.Fields {
+			shiftTime(field, pause)
+		}
+	}
+}
+
+func (q *Query) Paused() bool {
+	q.pauseLock.Lock()
+	defer q.pauseLock.Unlock()
+	return q.paused
+}
+
+// Pause stops events being delivered to the subscriber until Resume. Events
+// that arrive in the meantime are skipped, rather than counted as dropped.
+func (request *SubscribeRequest) Pause() {
+	request.paused.Store(true)
+}
+
+func (request *SubscribeRequest) Resume() {
+	request.paused.Store(false)
+}
+
+func (request *SubscribeRequest) Paused() bool {
+	return request.paused.Load()
+}
+
+// Pause holds records in memory, up to MaxHeld, rather than posting them.
+// Resume posts what was held, in batches of BatchSize.
+func (s *WebhookSink) Pause() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.paused = true
+}
+
+func (s *WebhookSink) Resume() (err error) {
+	s.lock.Lock()
+	s.paused = false
+	held := s.batch
+	s.batch = nil
+	s.lock.Unlock()
+
+	size := s.BatchSize
+	if size < 1 {
+		size = 1
+	}
+	for len(held) > 0 {
+		n := size
+		if n > len(held) {
+			n = len(held)
+		}
+		if s.BatchSize <= 1 {
+			err = s.post(held[0])
+		} else {
+			err = s.post(held[:n])
+		}
+		if err != nil {
+			// Hold on to what's left for next time.
+			s.lock.Lock()
+			s.batch = append(held[n:], s.batch...)
+			s.lock.Unlock()
+			return err
+		}
+		held = held[n:]
+	}
+	return nil
+}
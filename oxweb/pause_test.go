@@ -0,0 +1,104 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueryPause(t *testing.T) {
+	expr, err := Parse("WindowAve(TimedWindow(v, 60))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := NewQuery([]Expression{expr}, nil)
+	query.Evaluate(map[string]interface{}{"v": 1.})
+
+	query.Pause(false)
+	if _, ok, _ := query.Evaluate(map[string]interface{}{"v": 100.}); ok {
+		t.Error("Expected no record while paused")
+	}
+	query.Resume()
+
+	record, ok, _ := query.Evaluate(map[string]interface{}{"v": 3.})
+	if !ok || record[0].([]interface{})[1] != 2. {
+		t.Errorf("Expected the paused event to be ignored, averaging 2, got %v", record)
+	}
+}
+
+func TestQueryPauseFreeze(t *testing.T) {
+	window := new(TimedWindow)
+	value, _ := NewGetDeepExpression("v")
+	window.Setup("TimedWindow", []Expression{value, &Literal{1}})
+	average := new(WindowAve)
+	average.Setup("WindowAve", []Expression{window})
+	query := NewQuery([]Expression{average}, nil)
+	query.Evaluate(map[string]interface{}{"v": 1.})
+
+	query.Pause(true)
+	time.Sleep(1100 * time.Millisecond)
+	query.Resume()
+
+	// Without the freeze, 1 would have left the 1 second window.
+	record, _, _ := query.Evaluate(map[string]interface{}{"v": 3.})
+	if record[0].([]interface{})[1] != 2. {
+		t.Errorf("Expected the window to keep its value over the pause, averaging 2, got %v", record)
+	}
+}
+
+func TestSubscribeRequestPause(t *testing.T) {
+	request := &SubscribeRequest{DataChan: make(chan JSONData, 1)}
+	stream := &MergedStream{subscribers: map[*SubscribeRequest]bool{request: true}}
+	request.Pause()
+	stream.deliver([]JSONData{1.})
+	if len(request.DataChan) != 0 {
+		t.Error("Expected no delivery while paused")
+	}
+	request.Resume()
+	stream.deliver([]JSONData{2.})
+	if len(request.DataChan) != 1 {
+		t.Error("Expected delivery once resumed")
+	}
+}
+
+func TestWebhookSinkPause(t *testing.T) {
+	var lock sync.Mutex
+	posts := [][]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []interface{}
+		json.NewDecoder(r.Body).Decode(&batch)
+		lock.Lock()
+		posts = append(posts, batch)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL)
+	sink.BatchSize = 2
+	sink.Pause()
+	for n := 0; n < 5; n++ {
+		sink.Write(map[string]interface{}{"n": n})
+	}
+	sink.Flush()
+	if len(posts) != 0 {
+		t.Fatalf("Expected nothing posted while paused, got %v", posts)
+	}
+	if err := sink.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 3 || len(posts[0]) != 2 || len(posts[2]) != 1 {
+		t.Errorf("Expected the held records in batches of 2, got %v", posts)
+	}
+
+	sink.MaxHeld = 3
+	sink.Pause()
+	for n := 0; n < 5; n++ {
+		sink.Write(map[string]interface{}{"n": n})
+	}
+	if dropped := sink.Stats()["dropped"]; dropped != 2 {
+		t.Errorf("Expected 2 records dropped beyond MaxHeld, got %v", dropped)
+	}
+}
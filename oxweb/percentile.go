@@ -0,0 +1,255 @@
+package oxweb
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// A PercentileEstimator tracks a multiset of values and answers quantile
+// queries about it. Values are removed again as windows evict them.
+type PercentileEstimator interface {
+	Add(v float64)
+	Remove(v float64)
+	Count() int
+	// Quantile returns the value at q, between 0 and 1.
+	Quantile(q float64) float64
+}
+
+// Percentile strategies, given as WindowPercentile's optional third argument.
+const (
+	// PercentileExact keeps every value sorted. Exact, but memory and update
+	// cost grow with the window. This is the default.
+	PercentileExact = "exact"
+	// PercentileHistogram counts values in HDR-style log-linear buckets,
+	// using memory proportional to the range of values rather than their
+	// number, with a relative error under 1%.
+	PercentileHistogram = "hdr"
+)
+
+// NewPercentileEstimator returns an estimator for the named strategy.
+//
+// There's deliberately no t-digest strategy: digests can't forget values once
+// merged, so they can't follow a sliding window.
+func NewPercentileEstimator(strategy string) (estimator PercentileEstimator, err error) {
+	switch strategy {
+	case PercentileExact:
+		return new(exactPercentiles), nil
+	case PercentileHistogram:
+		return newHistogramPercentiles(), nil
+	}
+	return nil, fmt.Errorf("%v is not a percentile strategy, expected exact or hdr", strategy)
+}
+
+type exactPercentiles struct {
+	sorted []float64
+}
+
+func (e *exactPercentiles) Add(v float64) {
+	ndx := sort.SearchFloat64s(e.sorted, v)
+	e.sorted = append(e.sorted, 0)
+	copy(e.sorted[ndx+1:], e.sorted[ndx:])
+	e.sorted[ndx] = v
+}
+
+func (e *exactPercentiles) Remove(v float64) {
+	ndx := sort.SearchFloat64s(e.sorted, v)
+	if ndx < len(e.sorted) && e.sorted[ndx] == v {
+		e.sorted = append(e.sorted[:ndx], e.sorted[ndx+1:]...)
+	}
+}
+
+func (e *exactPercentiles) Count() int {
+	return len(e.sorted)
+}
+
+// Quantile interpolates linearly between the closest ranks.
+func (e *exactPercentiles) Quantile(q float64) float64 {
+	rank := q * float64(len(e.sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return e.sorted[lower] + (rank-float64(lower))*(e.sorted[upper]-e.sorted[lower])
+}
+
+// histogramSubBuckets is the number of linear buckets per power of two,
+// bounding the relative error at 1/128.
+const histogramSubBuckets = 128
+
+type histogramBucket struct {
+	negative bool
+	exponent int
+	sub      int
+}
+
+// value is the midpoint of the bucket.
+func (b histogramBucket) value() float64 {
+	v := math.Ldexp(1+(float64(b.sub)+0.5)/histogramSubBuckets, b.exponent)
+	if b.negative {
+		return -v
+	}
+	return v
+}
+
+type histogramPercentiles struct {
+	buckets map[histogramBucket]int
+	zeros   int
+	count   int
+}
+
+func newHistogramPercentiles() *histogramPercentiles {
+	return &histogramPercentiles{buckets: make(map[histogramBucket]int)}
+}
+
+func bucketFor(v float64) histogramBucket {
+	frac, exp := math.Frexp(math.Abs(v)) // |v| = frac * 2^exp, frac in [0.5, 1)
+	sub := int((frac*2 - 1) * histogramSubBuckets)
+	return histogramBucket{v < 0, exp - 1, sub}
+}
+
+func (h *histogramPercentiles) Add(v float64) {
+	h.count++
+	if v == 0 {
+		h.zeros++
+		return
+	}
+	h.buckets[bucketFor(v)]++
+}
+
+func (h *histogramPercentiles) Remove(v float64) {
+	if v == 0 {
+		if h.zeros > 0 {
+			h.zeros--
+			h.count--
+		}
+		return
+	}
+	bucket := bucketFor(v)
+	if h.buckets[bucket] == 0 {
+		return
+	}
+	h.count--
+	if h.buckets[bucket]--; h.buckets[bucket] == 0 {
+		delete(h.buckets, bucket)
+	}
+}
+
+func (h *histogramPercentiles) Count() int {
+	return h.count
+}
+
+func (h *histogramPercentiles) Quantile(q float64) float64 {
+	type bucketCount struct {
+		value float64
+		count int
+	}
+	counts := make([]bucketCount, 0, len(h.buckets)+1)
+	for bucket, count := range h.buckets {
+		counts = append(counts, bucketCount{bucket.value(), count})
+	}
+	if h.zeros > 0 {
+		counts = append(counts, bucketCount{0, h.zeros})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].value < counts[j].value })
+
+	rank := int(math.Round(q * float64(h.count-1)))
+	seen := 0
+	for _, bucket := range counts {
+		seen += bucket.count
+		if seen > rank {
+			return bucket.value
+		}
+	}
+	return counts[len(counts)-1].value
+}
+
+/*
+ * WindowPercentile(window, p [, "exact"|"hdr" [, emptyPolicy]]) -> float64
+ *
+ * The pth percentile (0 to 100) of a window of float64 values, e.g.
+ * WindowPercentile(TimedWindow(latency, 60), 99). Short windows can afford the
+ * default exact strategy; long, busy windows should use "hdr". emptyPolicy is
+ * as for the other window aggregates, e.g. WindowPercentile(w, 99, "hdr", "nil").
+ */
+type WindowPercentile struct {
+	window    Window
+	p         Expression
+	strategy  Expression
+	estimator PercentileEstimator
+	empty     emptyWindow
+}
+
+var _ WindowListener = new(WindowPercentile)
+
+func (wp *WindowPercentile) Setup(fname string, args []Expression) (err error) {
+	if len(args) < 2 || len(args) > 4 {
+		return fmt.Errorf("WindowPercentile expects a Window, a percentile and optionally a strategy (exact or hdr) and empty window policy")
+	}
+	window, ok := args[0].(Window)
+	if !ok {
+		return fmt.Errorf("WindowPercentile expects a Window, a percentile and optionally a strategy (exact or hdr) and empty window policy")
+	}
+	wp.window = window
+	wp.p = args[1]
+	strategy := interface{}(PercentileExact)
+	if len(args) == 4 {
+		wp.empty.policy = args[3]
+	}
+	if len(args) >= 3 {
+		wp.strategy = args[2]
+		if strategy, err = wp.strategy.Evaluate(nil); err != nil {
+			return err
+		}
+	}
+	name, _ := strategy.(string)
+	if wp.estimator, err = NewPercentileEstimator(name); err != nil {
+		return err
+	}
+	wp.window.SetListener(wp)
+	return nil
+}
+
+func (wp *WindowPercentile) Evaluate(data JSONData) (result interface{}, err error) {
+	if _, err = wp.window.Evaluate(data); err != nil {
+		return nil, err
+	}
+	p, err := wp.p.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	percentile, ok := toFloat(p)
+	if !ok || percentile < 0 || percentile > 100 {
+		return nil, fmt.Errorf("%w: WindowPercentile expects a percentile between 0 and 100. Got a %T, %v", ErrTypeMismatch, p, p)
+	}
+	if wp.estimator.Count() == 0 {
+		return wp.empty.apply(data, nil, fmt.Errorf("%w: Empty window", ErrWindowEmpty))
+	}
+	return wp.empty.apply(data, wp.estimator.Quantile(percentile/100), nil)
+}
+
+func (wp *WindowPercentile) Push(val interface{}) (err error) {
+	v, ok := val.(float64)
+	if !ok {
+		return fmt.Errorf("%w: Window expected a float64, got %v (%T)", ErrTypeMismatch, val, val)
+	}
+	wp.estimator.Add(v)
+	return nil
+}
+
+func (wp *WindowPercentile) Pop(val interface{}) (err error) {
+	v, ok := val.(float64)
+	if !ok {
+		return fmt.Errorf("%w: Window expected a float64, got %v (%T)", ErrTypeMismatch, val, val)
+	}
+	wp.estimator.Remove(v)
+	return nil
+}
+
+func (wp *WindowPercentile) String() string {
+	if wp.empty.policy != nil {
+		return fmt.Sprintf("WindowPercentile(%v,%v,%v,%v)", wp.window, wp.p, wp.strategy, wp.empty.policy)
+	}
+	if wp.strategy != nil {
+		return fmt.Sprintf("WindowPercentile(%v,%v,%v)", wp.window, wp.p, wp.strategy)
+	}
+	return fmt.Sprintf("WindowPercentile(%v,%v)", wp.window, wp.p)
+}
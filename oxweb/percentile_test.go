@@ -0,0 +1,40 @@
+package oxweb
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestPercentileEstimators(t *testing.T) {
+	exact, _ := NewPercentileEstimator(PercentileExact)
+	histogram, _ := NewPercentileEstimator(PercentileHistogram)
+
+	values := make([]float64, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		v := rand.ExpFloat64() * 100
+		values = append(values, v)
+		exact.Add(v)
+		histogram.Add(v)
+	}
+	// Evict the first half, as a window would.
+	for _, v := range values[:500] {
+		exact.Remove(v)
+		histogram.Remove(v)
+	}
+	if exact.Count() != 500 || histogram.Count() != 500 {
+		t.Fatalf("Expected 500 values, exact has %d, histogram has %d", exact.Count(), histogram.Count())
+	}
+
+	for _, q := range []float64{0, 0.5, 0.9, 0.99, 1} {
+		want := exact.Quantile(q)
+		got := histogram.Quantile(q)
+		if math.Abs(got-want)/want > 0.02 {
+			t.Errorf("For q = %v, expected about %v, but histogram gave %v", q, want, got)
+		}
+	}
+
+	if median := (&exactPercentiles{[]float64{1, 2, 3, 4}}).Quantile(0.5); median != 2.5 {
+		t.Errorf("Expected median of 2.5, got %v", median)
+	}
+}
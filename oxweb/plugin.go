@@ -0,0 +1,20 @@
+package oxweb
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// LoadPlugin opens a Go plugin (.so built with -buildmode=plugin) containing
+// additional expressions. Plugins register themselves from an init() function
+// via Register or RegisterFunc, so heavy optional dependencies such as geoip
+// or user-agent parsing only get linked into the plugin, not the core binary.
+//
+// The plugin must be built against the same version of this package as the
+// binary loading it.
+func LoadPlugin(path string) (err error) {
+	if _, err = plugin.Open(path); err != nil {
+		return fmt.Errorf("Couldn't load plugin %v: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package oxweb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type loadPluginTest struct {
+	name     string
+	contents string
+}
+
+var loadPluginTests = []loadPluginTest{
+	// Missing.
+	loadPluginTest{"missing.so", ""},
+	// Not a shared object.
+	loadPluginTest{"geoip.so", "not an ELF file"},
+}
+
+func TestLoadPlugin(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range loadPluginTests {
+		path := filepath.Join(dir, test.name)
+		if test.contents != "" {
+			if err := os.WriteFile(path, []byte(test.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := LoadPlugin(path)
+		if err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("Expected an error naming %v, got %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package oxweb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrorPolicy decides what a Query does when evaluating an event fails.
+type ErrorPolicy int
+
+const (
+	// AbortOnError stops the query; Evaluate returns the error. This is the
+	// default, as queries have always stopped at the first error.
+	AbortOnError ErrorPolicy = iota
+	// EmitErrorRecord emits the event's record anyway, with nil for values
+	// that failed and an extra ["error", message] pair.
+	EmitErrorRecord
+	// SkipOnError drops the event's record and carries on with the next event.
+	SkipOnError
+)
+
+var errorPolicyNames = map[string]ErrorPolicy{
+	"emit":  EmitErrorRecord,
+	"skip":  SkipOnError,
+	"abort": AbortOnError,
+}
+
+// ParseErrorPolicy looks up a policy by name: "emit", "skip" or "abort".
+func ParseErrorPolicy(name string) (policy ErrorPolicy, err error) {
+	policy, ok := errorPolicyNames[name]
+	if !ok {
+		return policy, fmt.Errorf("%v is not an error policy, expected emit, skip or abort", name)
+	}
+	return policy, nil
+}
+
+// EvaluationError describes an expression that failed on a particular event.
+type EvaluationError struct {
+	Event      JSONData
+	Expression Expression
+	Err        error
+	// The Labels of the query it happened in.
+	Labels map[string]string
+}
+
+func (e *EvaluationError) Error() string {
+	if len(e.Labels) > 0 {
+		return fmt.Sprintf("Evaluating %v [%v]: %v", e.Expression, FormatLabels(e.Labels), e.Err)
+	}
+	return fmt.Sprintf("Evaluating %v: %v", e.Expression, e.Err)
+}
+
+// ChangeThreshold configures emit-on-change: a record is only emitted when
+// one of its values differs from the last emitted record by more than both
+// thresholds. Non-numeric values count as changed whenever they differ. Zero
+// thresholds emit on any change at all.
+type ChangeThreshold struct {
+	Absolute float64
+	Relative float64
+}
+
+func (c *ChangeThreshold) changed(previous, current []interface{}) bool {
+	if previous == nil || len(previous) != len(current) {
+		return true
+	}
+	for ndx := range current {
+		if c.valueChanged(previous[ndx].([]interface{})[1], current[ndx].([]interface{})[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ChangeThreshold) valueChanged(previous, current interface{}) bool {
+	prev, prevOk := toFloat(previous)
+	cur, curOk := toFloat(current)
+	if !prevOk || !curOk {
+		return !reflect.DeepEqual(previous, current)
+	}
+	delta := math.Abs(cur - prev)
+	return delta > 0 && delta > c.Absolute && delta > c.Relative*math.Abs(prev)
+}
+
+// A Query evaluates a set of field expressions for each event passing all of
+// its filters, producing a record of [name, value] pairs.
+type Query struct {
+	Fields      []Expression
+	Filters     []Expression
+	ErrorPolicy ErrorPolicy
+
+	// EmitOnChange, if set, suppresses records that haven't changed enough
+	// since the last one emitted.
+	EmitOnChange *ChangeThreshold
+	lastEmitted  []interface{}
+
+	// Errors, if set, receives every EvaluationError regardless of policy, for
+	// tracking down data quality problems. Sends never block; errors are
+	// dropped if the channel is full.
+	Errors chan *EvaluationError
+
+	// PropagateNulls has filters evaluating to nil filter the event out. Set
+	// it when the query's statements were parsed with
+	// ParseOptions.PropagateNulls, so nils from missing fields flow through
+	// them rather than failing.
+	PropagateNulls bool
+
+	// Labels describe the query for operators, e.g. its owner, team or
+	// dashboard. They're reported with its errors and Stats.
+	Labels map[string]string
+
+	events  atomic.Int64
+	records atomic.Int64
+	errors  atomic.Int64
+
+	pauseLock sync.Mutex
+	paused    bool
+	freeze    bool
+	pausedAt  time.Time
+}
+
+func NewQuery(fields []Expression, filters []Expression) *Query {
+	return &Query{Fields: fields, Filters: filters}
+}
+
+// Stats reports how much work the query has done:
+//
+//	events    events evaluated
+//	records   records emitted
+//	errors    evaluation errors, whatever the ErrorPolicy
+func (q *Query) Stats() Stats {
+	return Stats{
+		"events":  q.events.Load(),
+		"records": q.records.Load(),
+		"errors":  q.errors.Load(),
+	}
+}
+
+func (q *Query) reportError(evalErr *EvaluationError) {
+	q.errors.Add(1)
+	evalErr.Labels = q.Labels
+	if q.Errors == nil {
+		return
+	}
+	select {
+	case q.Errors <- evalErr:
+	default:
+	}
+}
+
+// recordSampling notes the rate of any sampling filters with the event, for
+// ScaledCount and ScaledSum.
+func (q *Query) recordSampling(data JSONData) JSONData {
+	rate := 1.
+	for _, filter := range q.Filters {
+		if sampling, ok := filter.(SamplingFilter); ok {
+			if filterRate, ok := sampling.SampleRate(data); ok {
+				rate *= filterRate
+			}
+		}
+	}
+	if rate == 1 {
+		return data
+	}
+	if sampled, ok := setDeep(SampleRateKey, data, eventSampleRate(data)*rate); ok {
+		return sampled
+	}
+	return data
+}
+
+// Evaluate runs the query against a single event. ok is false when the event
+// doesn't produce a record: because it was filtered out, because of an error
+// under SkipOnError, because the record hasn't changed under EmitOnChange or
+// because the query is paused. err is only returned under AbortOnError.
+func (q *Query) Evaluate(data JSONData) (record []interface{}, ok bool, err error) {
+	if q.Paused() {
+		return nil, false, nil
+	}
+	q.events.Add(1)
+	var firstErr *EvaluationError
+	for _, filter := range q.Filters {
+		passes, err := filter.Evaluate(data)
+		if err == nil && passes == nil && q.PropagateNulls {
+			return nil, false, nil
+		}
+		if err == nil {
+			if _, isBool := passes.(bool); !isBool {
+				err = fmt.Errorf("%w: Expected a boolean for %v, got %T", ErrTypeMismatch, filter, passes)
+			}
+		}
+		if err != nil {
+			firstErr = &EvaluationError{Event: data, Expression: filter, Err: err}
+			q.reportError(firstErr)
+			break
+		}
+		if !passes.(bool) {
+			return nil, false, nil
+		}
+	}
+
+	if firstErr == nil {
+		data = q.recordSampling(data)
+	}
+
+	record = make([]interface{}, 0, len(q.Fields)+1)
+	if firstErr == nil {
+		for _, field := range q.Fields {
+			result, err := field.Evaluate(data)
+			if err != nil {
+				evalErr := &EvaluationError{Event: data, Expression: field, Err: err}
+				q.reportError(evalErr)
+				if firstErr == nil {
+					firstErr = evalErr
+				}
+				result = nil
+			}
+			record = append(record, []interface{}{field.String(), result})
+		}
+	}
+
+	if firstErr != nil {
+		switch q.ErrorPolicy {
+		case AbortOnError:
+			return nil, false, firstErr
+		case SkipOnError:
+			return nil, false, nil
+		}
+		record = append(record, []interface{}{"error", firstErr.Error()})
+	}
+
+	if q.EmitOnChange != nil {
+		if !q.EmitOnChange.changed(q.lastEmitted, record) {
+			return nil, false, nil
+		}
+		q.lastEmitted = record
+	}
+	q.records.Add(1)
+	return record, true, nil
+}
+
+// EvaluateBatch runs the query against a batch of events in order, as
+// Evaluate does each, returning the records produced. Under AbortOnError it
+// stops at the first error, returning the records produced before it.
+func (q *Query) EvaluateBatch(events []JSONData) (records [][]interface{}, err error) {
+	for _, data := range events {
+		record, ok, err := q.Evaluate(data)
+		if err != nil {
+			return records, err
+		}
+		if ok {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// Backfill replays historical events, one JSON object per line, through
+// stages and then the query before it goes live, so long windows are
+// meaningful straight away. Records produced along the way are passed to
+// emit, if it isn't nil. Lines that can't be decoded are skipped. Returns the
+// number of events replayed.
+//
+// Queries using functions that keep time by the clock rather than by their
+// events, such as TimedWindow, can't be backfilled, as the whole history
+// would land in the current instant; see clockFunctions.
+func (q *Query) Backfill(source io.Reader, stages []Stage, emit func(record []interface{})) (events int, err error) {
+	for _, expr := range append(q.Filters[:len(q.Filters):len(q.Filters)], q.Fields...) {
+		if name, ok := keepsClockTime(parseSyntax(expr.String()), nil); ok {
+			return 0, fmt.Errorf("Can't backfill %v, %v keeps time by the clock rather than by event", expr, name)
+		}
+	}
+
+	scanner := bufio.NewScanner(source)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var data JSONData
+		if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
+			continue
+		}
+		events++
+
+		records, err := q.EvaluateBatch(ApplyStages([]JSONData{data}, stages))
+		if emit != nil {
+			for _, record := range records {
+				emit(record)
+			}
+		}
+		if err != nil {
+			return events, err
+		}
+	}
+	return events, scanner.Err()
+}
+
+// clockFunctions are the builtins that read the clock as events arrive.
+var clockFunctions = map[string]bool{
+	"Now":            true,
+	"TimedWindow":    true,
+	"WindowTrend":    true,
+	"TimeDecayedAve": true,
+	"Funnel":         true,
+	"Sequence":       true,
+	"Suppress":       true,
+}
+
+// keepsClockTime finds a call to one of clockFunctions in syntax, including
+// in the bodies of the Define()'d functions it calls. expanding lists the
+// definitions already being looked through.
+func keepsClockTime(syntax *syntaxNode, expanding []string) (name string, ok bool) {
+	if !syntax.call {
+		return "", false
+	}
+	if clockFunctions[syntax.text] {
+		return syntax.text, true
+	}
+	if definition := lookupDefinition(syntax.text); definition != nil {
+		for _, name := range expanding {
+			if name == syntax.text {
+				return "", false
+			}
+		}
+		expanding = append(expanding[:len(expanding):len(expanding)], syntax.text)
+		if name, ok := keepsClockTime(parseSyntax(definition.body), expanding); ok {
+			return name, true
+		}
+	}
+	for _, arg := range syntax.args {
+		if name, ok := keepsClockTime(arg, expanding); ok {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,233 @@
+package oxweb
+
+import (
+	"io"
+	"log"
+	"sync"
+)
+
+// QueryHub runs each distinct query once, however many clients subscribe to
+// it, fanning its records out to all of them. A query starts with its first
+// subscriber and stops, unsubscribing from its source, when the last leaves.
+// Identify queries with QuerySpec.ID, including anything else that changes
+// their results.
+type QueryHub struct {
+	lock    sync.Mutex
+	running map[string]*sharedQuery
+}
+
+type sharedQuery struct {
+	id          string
+	query       *Query
+	source      Source
+	request     *SubscribeRequest
+	subscribers map[chan []interface{}]bool
+	done        chan struct{}
+	// For JoinBackfilled, the history to replay and the stages the hub
+	// applies itself, to backfill and live events alike.
+	history io.Reader
+	stages  []Stage
+}
+
+func NewQueryHub() *QueryHub {
+	return &QueryHub{running: make(map[string]*sharedQuery)}
+}
+
+// Join subscribes to the query identified by id, calling newQuery to create
+// it, reading from source through stages, if it isn't already running. Since
+// stages aren't part of a QuerySpec, include them in id. An empty id is never
+// shared. Records arrive on the returned channel, which is closed if the
+// query aborts; a subscriber not keeping up misses records rather than
+// holding up the others. Call leave once done.
+//
+// The hub owns the query once started: it closes query.Errors, if set, when
+// the query stops.
+func (h *QueryHub) Join(id string, source Source, stages []Stage, newQuery func() (*Query, error)) (records <-chan []interface{}, leave func(), err error) {
+	return h.join(id, source, stages, nil, newQuery)
+}
+
+// JoinBackfilled starts a query as Join does, unshared, first replaying
+// history through stages and the query with Query.Backfill. Events arriving
+// from source meanwhile are held, and evaluated once the backfill is done, so
+// none are missed between the history and going live. The backfill's records
+// aren't delivered. Keep history open until the query stops.
+func (h *QueryHub) JoinBackfilled(source Source, stages []Stage, history io.Reader, newQuery func() (*Query, error)) (records <-chan []interface{}, leave func(), err error) {
+	return h.join("", source, stages, history, newQuery)
+}
+
+func (h *QueryHub) join(id string, source Source, stages []Stage, history io.Reader, newQuery func() (*Query, error)) (records <-chan []interface{}, leave func(), err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	shared, running := h.running[id]
+	if !running || id == "" {
+		query, err := newQuery()
+		if err != nil {
+			return nil, nil, err
+		}
+		shared = &sharedQuery{
+			id:          id,
+			query:       query,
+			source:      source,
+			request:     &SubscribeRequest{DataChan: make(chan JSONData, 16), BatchChan: make(chan []JSONData, 16), Stages: stages},
+			subscribers: make(map[chan []interface{}]bool),
+			done:        make(chan struct{}),
+		}
+		if history != nil {
+			// Backfill and live events go through the same stages, on the
+			// query's goroutine, rather than the source's.
+			shared.request.Stages = nil
+			shared.history = history
+			shared.stages = stages
+		}
+		if id != "" {
+			h.running[id] = shared
+		}
+		source.Subscribe(shared.request)
+		go h.run(shared)
+	}
+
+	subscriber := make(chan []interface{}, 16)
+	shared.subscribers[subscriber] = true
+	var once sync.Once
+	leave = func() { once.Do(func() { h.leave(shared, subscriber) }) }
+	return subscriber, leave, nil
+}
+
+// Stats reports the number of running queries and of their subscribers:
+//
+//	queries       queries running
+//	subscribers   subscribers across all of them
+func (h *QueryHub) Stats() Stats {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	stats := Stats{"queries": int64(len(h.running))}
+	for _, shared := range h.running {
+		stats["subscribers"] += int64(len(shared.subscribers))
+	}
+	return stats
+}
+
+func (h *QueryHub) leave(shared *sharedQuery, subscriber chan []interface{}) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if _, ok := shared.subscribers[subscriber]; !ok {
+		// Already closed by an abort.
+		return
+	}
+	delete(shared.subscribers, subscriber)
+	close(subscriber)
+	if len(shared.subscribers) == 0 {
+		h.stop(shared)
+	}
+}
+
+// stop closes the query down. The hub must be locked.
+func (h *QueryHub) stop(shared *sharedQuery) {
+	select {
+	case <-shared.done:
+		return
+	default:
+	}
+	close(shared.done)
+	if h.running[shared.id] == shared {
+		delete(h.running, shared.id)
+	}
+	for subscriber := range shared.subscribers {
+		close(subscriber)
+		delete(shared.subscribers, subscriber)
+	}
+}
+
+func (h *QueryHub) run(shared *sharedQuery) {
+	defer func() {
+		shared.source.Unsubscribe(shared.request)
+		if shared.query.Errors != nil {
+			close(shared.query.Errors)
+		}
+	}()
+
+	if shared.history != nil && !h.backfill(shared) {
+		return
+	}
+	for {
+		select {
+		case data := <-shared.request.DataChan:
+			if !h.evaluate(shared, ApplyStages([]JSONData{data}, shared.stages)) {
+				return
+			}
+		case events := <-shared.request.BatchChan:
+			if !h.evaluate(shared, ApplyStages(events, shared.stages)) {
+				return
+			}
+		case <-shared.done:
+			return
+		}
+	}
+}
+
+// backfill replays the query's history, holding the events that arrive from
+// its source meanwhile, then evaluates those. Returns false if the backfill
+// failed or the query aborted.
+func (h *QueryHub) backfill(shared *sharedQuery) bool {
+	done := make(chan struct{})
+	heldChan := make(chan []JSONData)
+	go func() {
+		var held []JSONData
+		for {
+			select {
+			case data := <-shared.request.DataChan:
+				held = append(held, data)
+			case events := <-shared.request.BatchChan:
+				held = append(held, events...)
+			case <-done:
+				heldChan <- held
+				return
+			}
+		}
+	}()
+	events, err := shared.query.Backfill(shared.history, shared.stages, nil)
+	close(done)
+	held := <-heldChan
+	if err != nil {
+		log.Printf("Backfill failed: %v", err)
+		h.lock.Lock()
+		h.stop(shared)
+		h.lock.Unlock()
+		return false
+	}
+	log.Printf("Backfilled %d events, then %d held meanwhile", events, len(held))
+	return h.evaluate(shared, ApplyStages(held, shared.stages))
+}
+
+// evaluate runs the query against events, delivering the records produced.
+// Returns false if the query aborted.
+func (h *QueryHub) evaluate(shared *sharedQuery, events []JSONData) bool {
+	records, err := shared.query.EvaluateBatch(events)
+	for _, record := range records {
+		h.deliver(shared, record)
+	}
+	if err != nil {
+		log.Printf("Aborting query: %v", err)
+		h.lock.Lock()
+		h.stop(shared)
+		h.lock.Unlock()
+		return false
+	}
+	return true
+}
+
+func (h *QueryHub) deliver(shared *sharedQuery, record []interface{}) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for subscriber := range shared.subscribers {
+		select {
+		case subscriber <- record:
+		default:
+			log.Println("Dropping query record for a slow subscriber")
+		}
+	}
+}
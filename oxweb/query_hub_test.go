@@ -0,0 +1,126 @@
+package oxweb
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func receiveRecord(t *testing.T, records <-chan []interface{}) []interface{} {
+	select {
+	case record := <-records:
+		return record
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a record")
+	}
+	return nil
+}
+
+func TestQueryHubShares(t *testing.T) {
+	source := &fakeSource{make(chan *SubscribeRequest, 2), make(chan bool, 2)}
+	hub := NewQueryHub()
+
+	created := 0
+	newQuery := func() (*Query, error) {
+		created++
+		expr, err := Parse("TimeDecayedAve(latency, 60)")
+		return NewQuery([]Expression{expr}, nil), err
+	}
+	first, leaveFirst, err := hub.Join("latency", source, nil, newQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, leaveSecond, err := hub.Join("latency", source, nil, newQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 1 {
+		t.Errorf("Expected the query to be created once, was %d times", created)
+	}
+	if stats := hub.Stats(); stats["queries"] != 1 || stats["subscribers"] != 2 {
+		t.Errorf("Unexpected stats %v", stats)
+	}
+
+	request := <-source.subscribed
+	request.DataChan <- map[string]interface{}{"latency": 10.}
+	if a, b := receiveRecord(t, first), receiveRecord(t, second); a[0].([]interface{})[1] != b[0].([]interface{})[1] {
+		t.Errorf("Expected both subscribers to get the same record, got %v and %v", a, b)
+	}
+
+	leaveFirst()
+	leaveFirst()
+	select {
+	case <-source.unsubscribed:
+		t.Fatal("Expected the query to keep running for the second subscriber")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	leaveSecond()
+	select {
+	case <-source.unsubscribed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the query to stop with its last subscriber")
+	}
+	if _, ok := <-second; ok {
+		t.Error("Expected the subscriber's channel to be closed")
+	}
+	if stats := hub.Stats(); stats["queries"] != 0 {
+		t.Errorf("Expected no running queries, got %v", stats)
+	}
+}
+
+func TestQueryHubAbort(t *testing.T) {
+	source := &fakeSource{make(chan *SubscribeRequest, 1), make(chan bool, 1)}
+	hub := NewQueryHub()
+
+	records, leave, err := hub.Join("", source, nil, func() (*Query, error) {
+		host, err := Parse("UrlHost(url)")
+		query := NewQuery([]Expression{host}, nil)
+		query.ErrorPolicy = AbortOnError
+		query.Errors = make(chan *EvaluationError, 1)
+		return query, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leave()
+
+	(<-source.subscribed).DataChan <- map[string]interface{}{"url": 5.}
+	select {
+	case _, ok := <-records:
+		if ok {
+			t.Error("Expected the channel to close when the query aborts")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the abort")
+	}
+}
+
+func TestQueryHubBackfill(t *testing.T) {
+	source := &fakeSource{make(chan *SubscribeRequest, 1), make(chan bool, 1)}
+	hub := NewQueryHub()
+	history, writer := io.Pipe()
+	explode, _ := NewExplode("v")
+
+	records, leave, err := hub.JoinBackfilled(source, []Stage{explode}, history, func() (*Query, error) {
+		expr, err := Parse("WindowAve(RollingWindow(v, 3))")
+		return NewQuery([]Expression{expr}, nil), err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leave()
+
+	// Subscribed before the backfill is done, so this event is held, not lost.
+	request := <-source.subscribed
+	if request.Stages != nil {
+		t.Errorf("Expected the hub to apply the stages itself, the source was given %v", request.Stages)
+	}
+	request.DataChan <- map[string]interface{}{"v": []interface{}{9.}}
+	writer.Write([]byte("{\"v\": [1, 2]}\n"))
+	writer.Close()
+
+	if record := receiveRecord(t, records); record[0].([]interface{})[1] != 4. {
+		t.Errorf("Expected the live event averaged with the backfill, got %v", record)
+	}
+}
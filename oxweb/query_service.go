@@ -0,0 +1,261 @@
+package oxweb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// QueryService manages named queries on behalf of other backend services, as
+// the QueryService of proto/oxweb.proto describes. Created queries run in
+// Engine against their source until they're deleted, whether or not anyone
+// is streaming their results. ServeJSON serves it to clients over a
+// JSONServer; there is no gRPC server for it yet.
+type QueryService struct {
+	Engine *Engine
+	// Source, if set, finds the sources of queries on sources Engine doesn't
+	// have, which are then added to it.
+	Source func(name string) (Source, error)
+
+	lock sync.Mutex
+	// Sources being read, by name.
+	feeds map[string]*serviceFeed
+	// Clients streaming results, by query name.
+	streams map[string]map[chan []interface{}]bool
+}
+
+type serviceFeed struct {
+	source  Source
+	request *SubscribeRequest
+	done    chan struct{}
+}
+
+func NewQueryService(engine *Engine) *QueryService {
+	return &QueryService{
+		Engine:  engine,
+		feeds:   make(map[string]*serviceFeed),
+		streams: make(map[string]map[chan []interface{}]bool),
+	}
+}
+
+// CreateQuery starts a named query, replacing any of the same name; see
+// Engine.AddContext.
+func (s *QueryService) CreateQuery(ctx context.Context, spec QuerySpec) (err error) {
+	if spec.Name == "" {
+		return fmt.Errorf("%w: Queries need a name", ErrParse)
+	}
+	source := s.Engine.source(spec.Source)
+	if source == nil {
+		if s.Source == nil {
+			return fmt.Errorf("No source named %v", spec.Source)
+		}
+		if source, err = s.Source(spec.Source); err != nil {
+			return err
+		}
+		s.Engine.AddSource(spec.Source, source)
+	}
+	if _, err = s.Engine.AddContext(ctx, spec); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.feeds[spec.Source]; !ok {
+		feed := &serviceFeed{
+			source:  source,
+			request: &SubscribeRequest{DataChan: make(chan JSONData, 64), BatchChan: make(chan []JSONData, 16)},
+			done:    make(chan struct{}),
+		}
+		s.feeds[spec.Source] = feed
+		go s.read(spec.Source, feed)
+		source.Subscribe(feed.request)
+	}
+	// It may have replaced the last query on another source.
+	s.stopIdleFeeds()
+	return nil
+}
+
+// ListQueries lists the queries, by name.
+func (s *QueryService) ListQueries(ctx context.Context) []QuerySpec {
+	return s.Engine.Specs()
+}
+
+// DeleteQuery stops the named query, ending any streams of its results.
+func (s *QueryService) DeleteQuery(ctx context.Context, name string) error {
+	if _, ok := s.Engine.Spec(name); !ok {
+		return fmt.Errorf("No query named %v", name)
+	}
+	s.Engine.RemoveContext(ctx, name)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for stream := range s.streams[name] {
+		close(stream)
+	}
+	delete(s.streams, name)
+	s.stopIdleFeeds()
+	return nil
+}
+
+// StreamResults calls send with each record the named query emits, until ctx
+// is done, send fails or the query is deleted. Records are dropped rather
+// than holding up the query if send can't keep up.
+func (s *QueryService) StreamResults(ctx context.Context, name string, send func(record []interface{}) error) error {
+	stream := make(chan []interface{}, 64)
+	s.lock.Lock()
+	if _, ok := s.Engine.Spec(name); !ok {
+		s.lock.Unlock()
+		return fmt.Errorf("No query named %v", name)
+	}
+	if s.streams[name] == nil {
+		s.streams[name] = make(map[chan []interface{}]bool)
+	}
+	s.streams[name][stream] = true
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.streams[name], stream)
+		s.lock.Unlock()
+	}()
+
+	for {
+		select {
+		case record, ok := <-stream:
+			if !ok {
+				return fmt.Errorf("Query %v was deleted", name)
+			}
+			if err := send(record); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// stopIdleFeeds stops reading sources no query reads from. s must be locked.
+func (s *QueryService) stopIdleFeeds() {
+	used := make(map[string]bool)
+	for _, spec := range s.Engine.Specs() {
+		used[spec.Source] = true
+	}
+	for name, feed := range s.feeds {
+		if !used[name] {
+			close(feed.done)
+			feed.source.Unsubscribe(feed.request)
+			delete(s.feeds, name)
+		}
+	}
+}
+
+func (s *QueryService) read(source string, feed *serviceFeed) {
+	for {
+		select {
+		case data := <-feed.request.DataChan:
+			s.dispatch(source, data)
+		case events := <-feed.request.BatchChan:
+			for _, data := range events {
+				s.dispatch(source, data)
+			}
+		case <-feed.done:
+			return
+		}
+	}
+}
+
+func (s *QueryService) dispatch(source string, data JSONData) {
+	records, err := s.Engine.Dispatch(source, data)
+	if err != nil {
+		log.Printf("Query on %v failed: %v", source, err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for name, record := range records {
+		for stream := range s.streams[name] {
+			select {
+			case stream <- record:
+			default:
+				log.Printf("Dropping a record of %v for a slow client", name)
+			}
+		}
+	}
+}
+
+// ServeJSON is a JSONServer Handler for the service. Each request is an
+// object naming its "method", one of the QueryService's, answered as
+// JSONConn.WriteResponse does:
+//
+//	{"id": 1, "method": "CreateQuery", "query": <QuerySpec>}  -> {"id": 1}
+//	{"id": 2, "method": "ListQueries"}                       -> {"id": 2, "queries": [<QuerySpec>...]}
+//	{"id": 3, "method": "DeleteQuery", "name": "errors"}     -> {"id": 3}
+//	{"id": 4, "method": "StreamResults", "name": "errors"}   -> {"id": 4, "record": [...]}...
+//
+// Failures are answered with "error" and "message", as in an ErrorFrame.
+// StreamResults answers with a response for each record, until the query's
+// deleted or the client hangs up, so it must be the connection's last
+// request.
+func (s *QueryService) ServeJSON(request JSONData, conn *JSONConn) {
+	for {
+		if !s.serveRequest(request, conn) {
+			return
+		}
+		var err error
+		if request, err = conn.ReadJSON(); err != nil {
+			return
+		}
+	}
+}
+
+// serveRequest answers a request, returning false once the connection's done
+// with.
+func (s *QueryService) serveRequest(request JSONData, conn *JSONConn) bool {
+	asked, _ := request.(map[string]interface{})
+	method, _ := asked["method"].(string)
+	name, _ := asked["name"].(string)
+	ctx := context.Background()
+
+	var err error
+	response := map[string]interface{}{}
+	switch method {
+	case "CreateQuery":
+		var spec QuerySpec
+		encoded, _ := json.Marshal(asked["query"])
+		if err = json.Unmarshal(encoded, &spec); err != nil {
+			err = fmt.Errorf("%w: %w", ErrDecode, err)
+			break
+		}
+		err = s.CreateQuery(ctx, spec)
+	case "ListQueries":
+		response["queries"] = s.ListQueries(ctx)
+	case "DeleteQuery":
+		err = s.DeleteQuery(ctx, name)
+	case "StreamResults":
+		// Reading is only done to notice the client hanging up.
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			defer cancel()
+			for {
+				if _, err := conn.ReadJSON(); err != nil {
+					return
+				}
+			}
+		}()
+		err = s.StreamResults(ctx, name, func(record []interface{}) error {
+			return conn.WriteResponse(request, map[string]interface{}{"record": record})
+		})
+		if err != nil && ctx.Err() == nil {
+			conn.WriteResponse(request, map[string]interface{}{"error": errorClass(err), "message": err.Error()})
+		}
+		return false
+	default:
+		err = fmt.Errorf("%w: Unknown method %q", ErrParse, method)
+	}
+	if err != nil {
+		response = map[string]interface{}{"error": errorClass(err), "message": err.Error()}
+	}
+	return conn.WriteResponse(request, response) == nil
+}
@@ -0,0 +1,105 @@
+package oxweb
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryService(t *testing.T) {
+	engine := NewEngine()
+	source := &fakeSource{make(chan *SubscribeRequest, 1), make(chan bool, 1)}
+	engine.AddSource("web", source)
+	service := NewQueryService(engine)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &JSONServer{Handler: service.ServeJSON}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewRPCConn(NewJSONConn(conn))
+	ctx := context.Background()
+
+	if _, err := client.Call(ctx, map[string]interface{}{"method": "CreateQuery", "query": map[string]interface{}{"source": "web"}}); err == nil {
+		t.Error("Expected a query without a name to fail")
+	}
+	create := map[string]interface{}{"method": "CreateQuery", "query": map[string]interface{}{"name": "latency", "source": "web", "fields": []string{"latency"}}}
+	if _, err := client.Call(ctx, create); err != nil {
+		t.Fatal(err)
+	}
+	request := <-source.subscribed
+
+	streamConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer streamConn.Close()
+	streamConn.Write([]byte(`{"id": 7, "method": "StreamResults", "name": "latency"}` + "\n"))
+	lines := make(chan string)
+	go func() {
+		reader := bufio.NewReader(streamConn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+
+	// Events are only streamed once the client's registered, so keep sending
+	// until one arrives.
+	var line string
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(time.Second)
+	for line == "" {
+		select {
+		case line = <-lines:
+		case <-ticker.C:
+			request.DataChan <- map[string]interface{}{"latency": 12.}
+		case <-timeout:
+			t.Fatal("Timed out waiting for a record")
+		}
+	}
+	if !strings.Contains(line, `"id":7`) || !strings.Contains(line, `["latency",12]`) {
+		t.Errorf("Unexpected record %q", line)
+	}
+
+	response, err := client.Call(ctx, map[string]interface{}{"method": "ListQueries"})
+	if queries, _ := response["queries"].([]interface{}); err != nil || len(queries) != 1 {
+		t.Errorf("Expected 1 query, got %v, %v", response, err)
+	}
+
+	if _, err := client.Call(ctx, map[string]interface{}{"method": "DeleteQuery", "name": "latency"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-source.unsubscribed:
+	case <-time.After(time.Second):
+		t.Error("Expected the source to be unsubscribed with its last query gone")
+	}
+	for line = range lines {
+		if strings.Contains(line, "was deleted") {
+			break
+		}
+	}
+	if !strings.Contains(line, "was deleted") {
+		t.Errorf("Expected the stream to end with the query, got %q", line)
+	}
+	if _, err := client.Call(ctx, map[string]interface{}{"method": "DeleteQuery", "name": "latency"}); err == nil {
+		t.Error("Expected deleting a missing query to fail")
+	}
+}
@@ -0,0 +1,238 @@
+package oxweb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type errorPolicyTest struct {
+	policy ErrorPolicy
+	record int
+	ok     bool
+	err    bool
+}
+
+var errorPolicyTests = []errorPolicyTest{
+	errorPolicyTest{EmitErrorRecord, 2, true, false},
+	errorPolicyTest{SkipOnError, 0, false, false},
+	errorPolicyTest{AbortOnError, 0, false, true},
+}
+
+func TestQueryErrorPolicy(t *testing.T) {
+	url, _ := NewGetDeepExpression("url")
+	host := new(URLPart)
+	host.Setup("UrlHost", []Expression{url})
+
+	for _, test := range errorPolicyTests {
+		query := NewQuery([]Expression{host}, nil)
+		query.ErrorPolicy = test.policy
+		query.Errors = make(chan *EvaluationError, 1)
+
+		record, ok, err := query.Evaluate(map[string]interface{}{"url": 5.})
+		if len(record) != test.record || ok != test.ok || (err != nil) != test.err {
+			t.Errorf("For policy %v, expected %d pairs, ok = %t, err = %t, but was %v, %t, %v", test.policy, test.record, test.ok, test.err, record, ok, err)
+		}
+		select {
+		case evalErr := <-query.Errors:
+			if evalErr.Expression != host {
+				t.Errorf("For policy %v, expected the error to name the failing field, got %v", test.policy, evalErr.Expression)
+			}
+			if !errors.Is(evalErr.Err, ErrTypeMismatch) {
+				t.Errorf("For policy %v, expected ErrTypeMismatch, got %v", test.policy, evalErr.Err)
+			}
+		default:
+			t.Errorf("For policy %v, expected an error on the side channel", test.policy)
+		}
+	}
+
+	// Queries have always stopped at their first error unless told otherwise.
+	query := NewQuery([]Expression{host}, nil)
+	if _, _, err := query.Evaluate(map[string]interface{}{"url": 5.}); err == nil {
+		t.Errorf("Expected a query without a policy to abort")
+	}
+}
+
+func TestQueryEvaluateBatch(t *testing.T) {
+	url, _ := NewGetDeepExpression("url")
+	host := new(URLPart)
+	host.Setup("UrlHost", []Expression{url})
+	query := NewQuery([]Expression{host}, nil)
+	query.ErrorPolicy = AbortOnError
+
+	events := []JSONData{
+		map[string]interface{}{"url": "http://a.example.com/"},
+		map[string]interface{}{"url": "http://b.example.com/"},
+		map[string]interface{}{"url": 5.},
+		map[string]interface{}{"url": "http://c.example.com/"},
+	}
+	records, err := query.EvaluateBatch(events)
+	if len(records) != 2 || err == nil {
+		t.Errorf("Expected the 2 records before the error, and the error, got %v, %v", records, err)
+	}
+	if events := query.Stats()["events"]; events != 3 {
+		t.Errorf("Expected evaluation to stop at the error, but %d events were evaluated", events)
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	query := NewQuery([]Expression{&Literal{1}}, []Expression{&Literal{false}})
+	if _, ok, _ := query.Evaluate(nil); ok {
+		t.Errorf("Expected event to be filtered out")
+	}
+}
+
+func TestQueryEmitOnChange(t *testing.T) {
+	value, _ := NewGetDeepExpression("v")
+	query := NewQuery([]Expression{value}, nil)
+	query.EmitOnChange = &ChangeThreshold{Absolute: 1, Relative: 0.1}
+
+	for _, step := range []struct {
+		v    float64
+		emit bool
+	}{{100, true}, {100.5, false}, {105, false}, {111, true}, {110, false}, {99, true}} {
+		if _, ok, _ := query.Evaluate(map[string]interface{}{"v": step.v}); ok != step.emit {
+			t.Errorf("For %v, expected emit = %t, but was %t", step.v, step.emit, ok)
+		}
+	}
+}
+
+func TestQueryBackfill(t *testing.T) {
+	value, _ := NewGetDeepExpression("v")
+	window := new(RollingWindow)
+	window.Setup("RollingWindow", []Expression{value, &Literal{3}})
+	average := new(WindowAve)
+	average.Setup("WindowAve", []Expression{window})
+	query := NewQuery([]Expression{average}, nil)
+
+	history := "{\"v\": 1}\nnot json\n{\"v\": 2}\n{\"v\": 3}\n"
+	emitted := 0
+	events, err := query.Backfill(strings.NewReader(history), nil, func([]interface{}) { emitted++ })
+	if events != 3 || emitted != 3 || err != nil {
+		t.Fatalf("Expected 3 events replayed and emitted, got %d, %d, %v", events, emitted, err)
+	}
+
+	record, _, _ := query.Evaluate(map[string]interface{}{"v": 7.})
+	if value := record[0].([]interface{})[1]; value != 4. {
+		t.Errorf("Expected the window to be warmed by the backfill, average was %v", value)
+	}
+}
+
+func TestQueryBackfillStages(t *testing.T) {
+	average, _ := Parse("WindowAve(RollingWindow(v, 3))")
+	query := NewQuery([]Expression{average}, nil)
+	explode, _ := NewExplode("v")
+
+	events, err := query.Backfill(strings.NewReader("{\"v\": [1, 2, 3]}\n"), []Stage{explode}, nil)
+	if events != 1 || err != nil {
+		t.Fatalf("Expected 1 event replayed, got %d, %v", events, err)
+	}
+	if stats := query.Stats(); stats["events"] != 3 {
+		t.Errorf("Expected the query to see the 3 exploded events, saw %d", stats["events"])
+	}
+}
+
+func TestQueryBackfillClock(t *testing.T) {
+	if err := Define("RecentAve", []string{"x"}, "WindowAve(TimedWindow(x, 60))"); err != nil {
+		t.Fatal(err)
+	}
+	defer Undefine("RecentAve")
+
+	for _, statement := range []string{"WindowAve(TimedWindow(v, 3600))", "RecentAve(v)", "Subtract(Now(), v)"} {
+		expr, err := Parse(statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := NewQuery([]Expression{expr}, nil)
+		if _, err := query.Backfill(strings.NewReader("{\"v\": 1}\n"), nil, nil); err == nil {
+			t.Errorf("Expected %v to refuse a backfill", statement)
+		}
+		if stats := query.Stats(); stats["events"] != 0 {
+			t.Errorf("Expected %v to replay nothing, saw %d events", statement, stats["events"])
+		}
+	}
+}
+
+func TestQueryScaledBySampling(t *testing.T) {
+	value, _ := NewGetDeepExpression("v")
+	sum := new(ScaledSum)
+	sum.Setup("ScaledSum", []Expression{value})
+	count := new(ScaledCount)
+	count.Setup("ScaledCount", []Expression{value})
+	everyNth := new(EveryNth)
+	everyNth.Setup("EveryNth", []Expression{&Literal{2}})
+	query := NewQuery([]Expression{sum, count}, []Expression{everyNth})
+
+	// The stream already sampled at 1 in 5; the query keeps 1 in 2 of those.
+	event := map[string]interface{}{"v": 3., SampleRateKey: 0.2}
+	query.Evaluate(event)
+	record, ok, _ := query.Evaluate(event)
+	if !ok {
+		t.Fatalf("Expected the second event through EveryNth")
+	}
+	if scaledSum := record[0].([]interface{})[1]; scaledSum != 30. {
+		t.Errorf("Expected ScaledSum 30, got %v", scaledSum)
+	}
+	if scaledCount := record[1].([]interface{})[1]; scaledCount != 10. {
+		t.Errorf("Expected ScaledCount 10, got %v", scaledCount)
+	}
+	if event[SampleRateKey] != 0.2 {
+		t.Errorf("Expected the event not to be modified")
+	}
+}
+
+func TestQueryLabelsAndStats(t *testing.T) {
+	url, _ := NewGetDeepExpression("url")
+	host := new(URLPart)
+	host.Setup("UrlHost", []Expression{url})
+	query := NewQuery([]Expression{host}, nil)
+	query.Labels = map[string]string{"team": "web", "owner": "alice"}
+	query.ErrorPolicy = EmitErrorRecord
+	query.Errors = make(chan *EvaluationError, 1)
+
+	query.Evaluate(map[string]interface{}{"url": "http://example.com/"})
+	query.Evaluate(map[string]interface{}{"url": 5.})
+
+	evalErr := <-query.Errors
+	if !strings.Contains(evalErr.Error(), "[owner=alice,team=web]") {
+		t.Errorf("Expected the error to carry the query's labels, got %v", evalErr)
+	}
+	if stats := query.Stats(); stats["events"] != 2 || stats["records"] != 2 || stats["errors"] != 1 {
+		t.Errorf("Unexpected stats %v", stats)
+	}
+}
+
+func TestQueryPropagateNulls(t *testing.T) {
+	RegisterFunc("IsPositive", func(v float64) bool { return v > 0 })
+	propagating := ParseOptions{PropagateNulls: true}
+	sum, _ := Parse("Add(a, b)")
+	query := NewQuery([]Expression{sum}, nil)
+	query.ErrorPolicy = EmitErrorRecord
+
+	if record, _, _ := query.Evaluate(map[string]interface{}{"a": 1.}); len(record) != 2 {
+		t.Errorf("Expected an error record without PropagateNulls, got %v", record)
+	}
+
+	sum, _ = ParseWithOptions("Add(a, b)", propagating)
+	query = NewQuery([]Expression{sum}, nil)
+	query.PropagateNulls = true
+	record, ok, err := query.Evaluate(map[string]interface{}{"a": 1.})
+	if !ok || err != nil || len(record) != 1 || record[0].([]interface{})[1] != nil {
+		t.Errorf("Expected a nil sum, got %v, %v", record, err)
+	}
+
+	// Elements ArrayMap evaluates against propagate nils too.
+	mapped, _ := ParseWithOptions("ArrayMap(items, Multiply(price, quantity))", propagating)
+	items := []interface{}{map[string]interface{}{"price": 2.}, map[string]interface{}{"price": 2., "quantity": 3.}}
+	result, err := mapped.Evaluate(map[string]interface{}{"items": items})
+	if elements, _ := result.([]interface{}); err != nil || len(elements) != 2 || elements[0] != nil || elements[1] != 6. {
+		t.Errorf("Expected [<nil> 6], got %v, %v", result, err)
+	}
+
+	positive, _ := ParseWithOptions("IsPositive(a)", propagating)
+	query = NewQuery([]Expression{sum}, []Expression{positive})
+	query.PropagateNulls = true
+	if _, ok, err := query.Evaluate(map[string]interface{}{"b": 1.}); ok || err != nil {
+		t.Errorf("Expected a nil filter to filter the event out, got %t, %v", ok, err)
+	}
+}
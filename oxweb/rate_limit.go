@@ -0,0 +1,85 @@
+package oxweb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimiter caps the events and bytes per second let through, so a source
+// suddenly sending far more than usual can't swamp everything downstream.
+// Either limit may be 0 for none. Short bursts over the limits are let
+// through, as long as they average out within Burst: each limit is a token
+// bucket holding Burst's worth of the rate, so an event larger than that
+// many bytes is never let through.
+type RateLimiter struct {
+	EventsPerSecond float64
+	BytesPerSecond  float64
+	Burst           time.Duration
+
+	lock       sync.Mutex
+	events     float64
+	bytes      float64
+	last       time.Time
+	throttled  int64
+	timeSource func() time.Time
+}
+
+// NewRateLimiter creates a RateLimiter, starting with a full Burst allowance.
+func NewRateLimiter(eventsPerSecond, bytesPerSecond float64, burst time.Duration) (l *RateLimiter, err error) {
+	if eventsPerSecond < 0 || bytesPerSecond < 0 {
+		return nil, fmt.Errorf("Rate limits can't be negative, got %v events and %v bytes per second", eventsPerSecond, bytesPerSecond)
+	}
+	if burst <= 0 {
+		return nil, fmt.Errorf("Burst must be positive, got %v", burst)
+	}
+	l = &RateLimiter{
+		EventsPerSecond: eventsPerSecond,
+		BytesPerSecond:  bytesPerSecond,
+		Burst:           burst,
+		timeSource:      time.Now,
+	}
+	l.events = l.EventsPerSecond * burst.Seconds()
+	l.bytes = l.BytesPerSecond * burst.Seconds()
+	l.last = l.timeSource()
+	return l, nil
+}
+
+// Allow reports whether an event of size bytes is within the limits, taking
+// it from the allowance if it is. Events that aren't are counted in Throttled.
+func (l *RateLimiter) Allow(size int) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.refill()
+
+	if (l.EventsPerSecond > 0 && l.events < 1) || (l.BytesPerSecond > 0 && l.bytes < float64(size)) {
+		l.throttled++
+		return false
+	}
+	l.events--
+	l.bytes -= float64(size)
+	return true
+}
+
+func (l *RateLimiter) refill() {
+	now := l.timeSource()
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	burst := l.Burst.Seconds()
+
+	l.events += elapsed * l.EventsPerSecond
+	if l.events > l.EventsPerSecond*burst {
+		l.events = l.EventsPerSecond * burst
+	}
+	l.bytes += elapsed * l.BytesPerSecond
+	if l.bytes > l.BytesPerSecond*burst {
+		l.bytes = l.BytesPerSecond * burst
+	}
+}
+
+// Throttled returns how many events haven't been allowed.
+func (l *RateLimiter) Throttled() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.throttled
+}
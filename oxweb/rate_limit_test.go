@@ -0,0 +1,67 @@
+package oxweb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter, err := NewRateLimiter(10, 100, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	limiter.timeSource = func() time.Time { return now }
+	limiter.last = now
+
+	// A burst of 2 seconds' worth of events gets through, then no more.
+	allowed := 0
+	for i := 0; i < 30; i++ {
+		if limiter.Allow(10) {
+			allowed++
+		}
+	}
+	if allowed != 20 || limiter.Throttled() != 10 {
+		t.Errorf("Expected 20 allowed and 10 throttled, got %d and %d", allowed, limiter.Throttled())
+	}
+
+	// Half a second later, 5 more events' allowance has built up, but only
+	// 50 bytes.
+	now = now.Add(500 * time.Millisecond)
+	if !limiter.Allow(40) {
+		t.Error("Expected 40 bytes to be allowed")
+	}
+	if limiter.Allow(20) {
+		t.Error("Expected to be over the byte limit")
+	}
+	if !limiter.Allow(10) {
+		t.Error("Expected the last 10 bytes to be allowed")
+	}
+
+	// The allowance is capped at Burst's worth, however long it's been.
+	now = now.Add(time.Hour)
+	allowed = 0
+	for i := 0; i < 30; i++ {
+		if limiter.Allow(1) {
+			allowed++
+		}
+	}
+	if allowed != 20 {
+		t.Errorf("Expected a burst of 20 after a long pause, got %d", allowed)
+	}
+}
+
+func TestRateLimiterNoLimit(t *testing.T) {
+	limiter, err := NewRateLimiter(0, 100, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if !limiter.Allow(0) {
+			t.Fatalf("Expected no limit on events, throttled after %d", i)
+		}
+	}
+	if _, err := NewRateLimiter(-1, 0, time.Second); err == nil {
+		t.Error("Expected an error for a negative limit")
+	}
+}
@@ -0,0 +1,67 @@
+package oxweb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// RedactedValue replaces values Redact masks without hashing.
+const RedactedValue = "[redacted]"
+
+// redactKeyBytes is the shortest Key Redact accepts. A short key could be
+// guessed, and then the hashes reversed by hashing candidate values.
+const redactKeyBytes = 16
+
+// Redact is a Stage masking sensitive fields, such as email addresses, at the
+// GetDeep paths given, so they never reach subscribers or sinks. Values are
+// replaced by RedactedValue or, with a secret Key, by the hex HMAC-SHA256 of
+// the value under Key, which still lets queries count or group by the field
+// without letting anyone who lacks the key check a guessed value against it.
+// Events are copied rather than modified, as other subscribers may share
+// them. Events with a value Redact can't mask are dropped.
+type Redact struct {
+	Paths []string
+	Key   []byte
+}
+
+// NewRedact creates a Redact stage, masking values outright if key is empty.
+func NewRedact(paths []string, key []byte) (r *Redact, err error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("Redact expects at least one path")
+	}
+	if len(key) > 0 && len(key) < redactKeyBytes {
+		return nil, fmt.Errorf("Redact's hash key must be at least %d bytes, got %d", redactKeyBytes, len(key))
+	}
+	return &Redact{Paths: paths, Key: key}, nil
+}
+
+func (r *Redact) Process(data JSONData) []JSONData {
+	event := cowEvent{data: data}
+	for _, path := range r.Paths {
+		value, ok := GetDeep(path, event.data)
+		if !ok || value == nil {
+			continue
+		}
+		if !event.set(path, r.mask(value)) {
+			// e.g. a path through an array, which can't be copied on
+			// write. Drop the event rather than let the value through.
+			return nil
+		}
+	}
+	return []JSONData{event.data}
+}
+
+func (r *Redact) mask(value interface{}) interface{} {
+	if len(r.Key) == 0 {
+		return RedactedValue
+	}
+	mac := hmac.New(sha256.New, r.Key)
+	mac.Write([]byte(hashKey(value)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (r *Redact) String() string {
+	return fmt.Sprintf("Redact(%v)", r.Paths)
+}
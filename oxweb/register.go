@@ -0,0 +1,238 @@
+package oxweb
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]func() Expression)
+)
+
+// Register makes an Expression implementation available to Parse under name.
+// The factory is called once per occurrence in a statement, and Setup() is
+// then called with the parsed arguments as usual. Built-in functions take
+// precedence over registered ones.
+func Register(name string, factory func() Expression) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[name] = factory
+}
+
+func lookupRegistered(name string) func() Expression {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return registry[name]
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// RegisterFunc wraps a plain Go function as an Expression and registers it
+// under name. The function may take any number of bool, string, numeric or
+// interface{} arguments (including a trailing variadic one) and must return a
+// single value, optionally followed by an error:
+//
+//	RegisterFunc("Ratio", func(a, b float64) (float64, error) { ... })
+//
+// Arguments are evaluated and converted to the declared types before each
+// call; the number of arguments is checked when the statement is parsed.
+func RegisterFunc(name string, fn interface{}) (err error) {
+	fnValue := reflect.ValueOf(fn)
+	fnType := fnValue.Type()
+	if fnType.Kind() != reflect.Func {
+		return fmt.Errorf("RegisterFunc expects a function, got %T", fn)
+	}
+	if fnType.NumOut() < 1 || fnType.NumOut() > 2 || (fnType.NumOut() == 2 && fnType.Out(1) != errorType) {
+		return fmt.Errorf("%v must return a single value, optionally followed by an error", name)
+	}
+	for ndx := 0; ndx < fnType.NumIn(); ndx++ {
+		argType := fnType.In(ndx)
+		if fnType.IsVariadic() && ndx == fnType.NumIn()-1 {
+			argType = argType.Elem()
+		}
+		if !convertibleKind(argType) {
+			return fmt.Errorf("%v argument %d has unsupported type %v", name, ndx+1, argType)
+		}
+	}
+
+	Register(name, func() Expression {
+		return &GoFunction{fn: fnValue}
+	})
+	return nil
+}
+
+func convertibleKind(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Interface:
+		return t.NumMethod() == 0
+	}
+	return false
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// convertArg converts an evaluated value to the type a Go function expects.
+// Durations are numbers of seconds, as everywhere else, whether given to a
+// numeric parameter or taken by a time.Duration one. Numbers out of the
+// parameter type's range are type mismatches, as are non-integral numbers
+// for integer parameters.
+func convertArg(value interface{}, t reflect.Type) (arg reflect.Value, err error) {
+	if t.Kind() == reflect.Interface {
+		if value == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(value), nil
+	}
+	if value == nil {
+		return arg, fmt.Errorf("%w: Expected a %v, got nil", ErrTypeMismatch, t)
+	}
+	if t == durationType {
+		if _, ok := value.(time.Duration); ok {
+			return reflect.ValueOf(value), nil
+		}
+		seconds, ok := toFloat(value)
+		if !ok {
+			return arg, fmt.Errorf("%w: Expected a %v, got %T, %v", ErrTypeMismatch, t, value, value)
+		}
+		if nanoseconds := seconds * float64(time.Second); nanoseconds >= -math.MaxInt64 && nanoseconds < math.MaxInt64 {
+			return reflect.ValueOf(time.Duration(nanoseconds)), nil
+		}
+		return arg, fmt.Errorf("%w: %v seconds is out of range for a %v", ErrTypeMismatch, value, t)
+	}
+	if d, ok := value.(time.Duration); ok {
+		value = d.Seconds()
+	}
+
+	v := reflect.ValueOf(value)
+	switch t.Kind() {
+	case reflect.Bool, reflect.String:
+		if v.Kind() != t.Kind() {
+			return arg, fmt.Errorf("%w: Expected a %v, got %T, %v", ErrTypeMismatch, t, value, value)
+		}
+		return v.Convert(t), nil
+	}
+	if !isNumericKind(v.Kind()) {
+		return arg, fmt.Errorf("%w: Expected a %v, got %T, %v", ErrTypeMismatch, t, value, value)
+	}
+	if overflows(v, t) {
+		return arg, fmt.Errorf("%w: %v is out of range for a %v", ErrTypeMismatch, value, t)
+	}
+	return v.Convert(t), nil
+}
+
+// overflows reports whether the number v doesn't fit in the numeric type t.
+// Integer types accept floats only when they have no fractional part, since
+// that's how JSON numbers arrive.
+func overflows(v reflect.Value, t reflect.Type) bool {
+	zero := reflect.Zero(t)
+	switch {
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		if v.CanFloat() {
+			return zero.OverflowFloat(v.Float())
+		}
+		return false
+	case v.CanFloat():
+		f := v.Float()
+		if f != math.Trunc(f) {
+			return true
+		}
+		// Beyond 2^63 (or 2^64), conversion to an integer isn't defined.
+		if zero.CanInt() {
+			return f < math.MinInt64 || f >= -math.MinInt64 || zero.OverflowInt(int64(f))
+		}
+		return f < 0 || f >= 2*-float64(math.MinInt64) || zero.OverflowUint(uint64(f))
+	case v.CanInt():
+		if zero.CanInt() {
+			return zero.OverflowInt(v.Int())
+		}
+		return v.Int() < 0 || zero.OverflowUint(uint64(v.Int()))
+	default:
+		if zero.CanInt() {
+			return v.Uint() > math.MaxInt64 || zero.OverflowInt(int64(v.Uint()))
+		}
+		return zero.OverflowUint(v.Uint())
+	}
+}
+
+func isNumericKind(k reflect.Kind) bool {
+	return (k >= reflect.Int && k <= reflect.Uint64) || k == reflect.Float32 || k == reflect.Float64
+}
+
+/*
+ * <name>(args...) -> interface{}
+ *
+ * A Go function registered with RegisterFunc().
+ */
+type GoFunction struct {
+	fn    reflect.Value
+	fname string
+	args  []Expression
+	nulls bool
+}
+
+func (f *GoFunction) Setup(fname string, args []Expression) (err error) {
+	fnType := f.fn.Type()
+	if fnType.IsVariadic() {
+		err = checkArity(fname, args, fnType.NumIn()-1, variadic)
+	} else {
+		err = checkArity(fname, args, fnType.NumIn(), fnType.NumIn())
+	}
+	if err != nil {
+		return err
+	}
+	f.fname = fname
+	f.args = args
+	return nil
+}
+
+func (f *GoFunction) propagateNulls() {
+	f.nulls = true
+}
+
+func (f *GoFunction) Evaluate(data JSONData) (result interface{}, err error) {
+	fnType := f.fn.Type()
+	in := make([]reflect.Value, len(f.args))
+	for ndx, argExpr := range f.args {
+		value, err := argExpr.Evaluate(data)
+		if err != nil {
+			return nil, err
+		}
+		var argType reflect.Type
+		if fnType.IsVariadic() && ndx >= fnType.NumIn()-1 {
+			argType = fnType.In(fnType.NumIn() - 1).Elem()
+		} else {
+			argType = fnType.In(ndx)
+		}
+		if value == nil && argType.Kind() != reflect.Interface && f.nulls {
+			return nil, nil
+		}
+		in[ndx], err = convertArg(value, argType)
+		if err != nil {
+			return nil, fmt.Errorf("%v argument %d: %w", f.fname, ndx+1, err)
+		}
+	}
+
+	out := f.fn.Call(in)
+	if len(out) == 2 && !out[1].IsNil() {
+		return nil, out[1].Interface().(error)
+	}
+	return out[0].Interface(), nil
+}
+
+func (f *GoFunction) String() string {
+	args := make([]string, len(f.args))
+	for ndx, arg := range f.args {
+		args[ndx] = arg.String()
+	}
+	return fmt.Sprintf("%v(%v)", f.fname, strings.Join(args, ","))
+}
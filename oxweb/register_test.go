@@ -0,0 +1,127 @@
+package oxweb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type registerFuncTest struct {
+	args   []interface{}
+	result interface{}
+	ok     bool
+}
+
+var registerFuncTests = []registerFuncTest{
+	registerFuncTest{[]interface{}{"ab", 3.}, "ababab", true},
+	registerFuncTest{[]interface{}{"ab", 2}, "abab", true},
+	registerFuncTest{[]interface{}{"ab", 1.5}, nil, false},
+	registerFuncTest{[]interface{}{1., 2}, nil, false},
+	registerFuncTest{[]interface{}{"ab", -1}, nil, false},
+}
+
+func TestRegisterFunc(t *testing.T) {
+	err := RegisterFunc("TestRepeat", func(s string, n int) (string, error) {
+		if n < 0 {
+			return "", fmt.Errorf("negative count")
+		}
+		return strings.Repeat(s, n), nil
+	})
+	if err != nil {
+		t.Fatalf("Couldn't register function: %v", err)
+	}
+
+	for _, test := range registerFuncTests {
+		args := []Expression{}
+		for _, arg := range test.args {
+			args = append(args, &Literal{arg})
+		}
+		expr := lookupRegistered("TestRepeat")()
+		if err := expr.Setup("TestRepeat", args); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		result, err := expr.Evaluate(nil)
+		if test.ok != (err == nil) {
+			t.Errorf("For args %v, expected ok = %t, but err was %v", test.args, test.ok, err)
+		}
+		if test.ok && result != test.result {
+			t.Errorf("For args %v, expected %v, but was %v", test.args, test.result, result)
+		}
+	}
+
+	if err := lookupRegistered("TestRepeat")().Setup("TestRepeat", []Expression{&Literal{"a"}}); err == nil {
+		t.Errorf("Expected an arity error")
+	}
+	RegisterFunc("TestAnswer", func() int { return 42 })
+	if answer, err := Parse("TestAnswer()"); err != nil {
+		t.Errorf("Couldn't parse a zero argument function: %v", err)
+	} else if result, _ := answer.Evaluate(nil); result != 42 {
+		t.Errorf("Expected 42, got %v", result)
+	}
+	if err := RegisterFunc("TestBad", func(m map[string]int) int { return 0 }); err == nil {
+		t.Errorf("Expected an unsupported argument type error")
+	}
+}
+
+// unregisterAfter removes a function registered for the test once it's done.
+func unregisterAfter(t *testing.T, name string) {
+	t.Cleanup(func() {
+		registryLock.Lock()
+		defer registryLock.Unlock()
+		delete(registry, name)
+	})
+}
+
+type convertArgTest struct {
+	value    interface{}
+	t        reflect.Type
+	expected interface{}
+	ok       bool
+}
+
+var convertArgTests = []convertArgTest{
+	convertArgTest{3., reflect.TypeOf(uint(0)), uint(3), true},
+	convertArgTest{-1., reflect.TypeOf(uint(0)), nil, false},
+	convertArgTest{-1, reflect.TypeOf(uint(0)), nil, false},
+	convertArgTest{300., reflect.TypeOf(int8(0)), nil, false},
+	convertArgTest{int64(300), reflect.TypeOf(int8(0)), nil, false},
+	convertArgTest{uint64(1 << 63), reflect.TypeOf(int64(0)), nil, false},
+	convertArgTest{1e300, reflect.TypeOf(int64(0)), nil, false},
+	convertArgTest{1e19, reflect.TypeOf(uint64(0)), uint64(1e19), true},
+	convertArgTest{1e20, reflect.TypeOf(uint64(0)), nil, false},
+	convertArgTest{1e300, reflect.TypeOf(float32(0)), nil, false},
+	convertArgTest{1e300, reflect.TypeOf(float64(0)), 1e300, true},
+	// Durations are seconds, whatever the parameter.
+	convertArgTest{90 * time.Second, reflect.TypeOf(0.), 90., true},
+	convertArgTest{90 * time.Second, reflect.TypeOf(0), 90, true},
+	convertArgTest{1500 * time.Millisecond, reflect.TypeOf(0), nil, false},
+	convertArgTest{90., reflect.TypeOf(time.Duration(0)), 90 * time.Second, true},
+	convertArgTest{time.Minute, reflect.TypeOf(time.Duration(0)), time.Minute, true},
+	convertArgTest{1e300, reflect.TypeOf(time.Duration(0)), nil, false},
+}
+
+func TestConvertArg(t *testing.T) {
+	for _, test := range convertArgTests {
+		arg, err := convertArg(test.value, test.t)
+		if test.ok != (err == nil) {
+			t.Errorf("For %v as %v, expected ok = %t, but err was %v", test.value, test.t, test.ok, err)
+			continue
+		}
+		if !test.ok && !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("For %v as %v, expected ErrTypeMismatch, got %v", test.value, test.t, err)
+		}
+		if test.ok && arg.Interface() != test.expected {
+			t.Errorf("For %v as %v, expected %v, got %v", test.value, test.t, test.expected, arg.Interface())
+		}
+	}
+
+	RegisterFunc("TestSmall", func(n int8) int8 { return n })
+	unregisterAfter(t, "TestSmall")
+	small, _ := Parse("TestSmall(300)")
+	if _, err := small.Evaluate(nil); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected the argument's ErrTypeMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package oxweb
+
+import (
+	"container/heap"
+	"fmt"
+	"time"
+)
+
+// Reorder is a Stage that buffers events and releases them in event-time
+// order. Events are held until an event at least delay newer has been seen,
+// so sources that are out of order by less than delay come out sorted. When
+// the source goes quiet, Expire releases them once delay has passed by the
+// clock instead, so the last events before a lull aren't held indefinitely.
+//
+// Events arriving after newer events have already been released are passed
+// through immediately and counted in Stats as "late". Events without a
+// timestamp at path are passed through untouched.
+type Reorder struct {
+	path     string
+	delay    time.Duration
+	buffer   reorderHeap
+	seq      int64
+	latest   time.Time
+	released time.Time
+	late     int64
+	// When the latest event arrived, by timeSource.
+	arrived    time.Time
+	timeSource func() time.Time
+}
+
+func NewReorder(path string, delay time.Duration) (r *Reorder, err error) {
+	if path == "" {
+		return nil, fmt.Errorf("Reorder expects a non-empty timestamp path")
+	}
+	if delay <= 0 {
+		return nil, fmt.Errorf("Reorder delay must be positive, got %v", delay)
+	}
+	return &Reorder{path: path, delay: delay, timeSource: time.Now}, nil
+}
+
+func (r *Reorder) Process(data JSONData) []JSONData {
+	value, ok := GetDeep(r.path, data)
+	if !ok {
+		return []JSONData{data}
+	}
+	eventTime, ok := toTime(value)
+	if !ok {
+		return []JSONData{data}
+	}
+	if eventTime.Before(r.released) {
+		r.late++
+		return []JSONData{data}
+	}
+
+	heap.Push(&r.buffer, reorderEntry{eventTime, r.seq, data})
+	r.seq++
+	if eventTime.After(r.latest) {
+		r.latest = eventTime
+	}
+	r.arrived = r.timeSource()
+	return r.release(r.latest.Add(-r.delay))
+}
+
+// Expire releases the events that would have been released had event time
+// carried on passing with the clock since the latest event arrived. Call it
+// periodically, as MergedStream and DataStream do, for sources that may go
+// quiet.
+func (r *Reorder) Expire() []JSONData {
+	if len(r.buffer) == 0 {
+		return nil
+	}
+	idle := r.timeSource().Sub(r.arrived)
+	return r.release(r.latest.Add(idle - r.delay))
+}
+
+// release pops the buffered events up to watermark, in order.
+func (r *Reorder) release(watermark time.Time) []JSONData {
+	events := []JSONData{}
+	for len(r.buffer) > 0 && !r.buffer[0].time.After(watermark) {
+		entry := heap.Pop(&r.buffer).(reorderEntry)
+		r.released = entry.time
+		events = append(events, entry.data)
+	}
+	return events
+}
+
+// Flush releases every buffered event in order, e.g. when the source ends.
+func (r *Reorder) Flush() []JSONData {
+	events := make([]JSONData, 0, len(r.buffer))
+	for len(r.buffer) > 0 {
+		entry := heap.Pop(&r.buffer).(reorderEntry)
+		r.released = entry.time
+		events = append(events, entry.data)
+	}
+	return events
+}
+
+// Stats reports the number of events currently buffered and of events that
+// arrived too late to be put in order.
+func (r *Reorder) Stats() Stats {
+	return Stats{"buffered": int64(len(r.buffer)), "late": r.late}
+}
+
+func (r *Reorder) String() string {
+	return fmt.Sprintf("Reorder(%v, %v)", r.path, r.delay)
+}
+
+type reorderEntry struct {
+	time time.Time
+	seq  int64
+	data JSONData
+}
+
+// reorderHeap orders entries by time, then by arrival so events with equal
+// timestamps keep their original order.
+type reorderHeap []reorderEntry
+
+func (h reorderHeap) Len() int { return len(h) }
+func (h reorderHeap) Less(i, j int) bool {
+	if h[i].time.Equal(h[j].time) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].time.Before(h[j].time)
+}
+func (h reorderHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *reorderHeap) Push(x interface{}) { *h = append(*h, x.(reorderEntry)) }
+func (h *reorderHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
@@ -0,0 +1,31 @@
+package oxweb
+
+// A ResultField describes one field of a query's records.
+type ResultField struct {
+	// The field's name in records: its As alias or its expression.
+	Name string `json:"name"`
+	// One of the Schema types, or "" when it's not known until the query
+	// runs, as for event values read without a schema.
+	Type string `json:"type,omitempty"`
+}
+
+// ResultSchema lists the name and type of each field of the query's records,
+// in order, so consumers such as CSVEncoder and the Dashboard needn't wait for
+// the first record to lay out their output.
+func (q *Query) ResultSchema() []ResultField {
+	fields := make([]ResultField, len(q.Fields))
+	for ndx, field := range q.Fields {
+		fields[ndx] = ResultField{Name: field.String(), Type: resultType(field)}
+	}
+	return fields
+}
+
+// resultType is the type of expr's results as far as the function signatures
+// tell.
+func resultType(expr Expression) string {
+	if as, ok := expr.(*AsClause); ok {
+		return resultType(as.expr)
+	}
+	typ, _ := new(Schema).typeOf(parseSyntax(expr.String()))
+	return typ
+}
@@ -0,0 +1,55 @@
+package oxweb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestResultSchema(t *testing.T) {
+	var fields []Expression
+	for _, statement := range []string{`As(Add(a, b), "total")`, "host", `GroupBy(host, WindowAve(RollingWindow(latency, 10)))`, "UrlPath(url)", "IsWeekend(ts)"} {
+		expr, err := Parse(statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields = append(fields, expr)
+	}
+	expected := []ResultField{
+		{"total", TypeNumber},
+		{"host", ""},
+		{"GroupBy(host,WindowAve(RollingWindow(latency,10)))", TypeObject},
+		{"UrlPath(url)", TypeString},
+		{"IsWeekend(ts)", TypeBool},
+	}
+	if schema := NewQuery(fields, nil).ResultSchema(); !reflect.DeepEqual(schema, expected) {
+		t.Errorf("Expected %v, got %v", expected, schema)
+	}
+
+	plan, err := Explain(QuerySpec{Fields: []string{`As(Add(a, b), "total")`}}, 1)
+	if err != nil || !reflect.DeepEqual(plan.Results, expected[:1]) {
+		t.Errorf("Unexpected plan results %v, %v", plan.Results, err)
+	}
+}
+
+func TestCSVEncoderSchema(t *testing.T) {
+	var out bytes.Buffer
+	encoder := NewCSVEncoder(&out)
+	if err := encoder.SetSchema([]ResultField{{"total", TypeNumber}, {"path", TypeString}}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "total,path\n" {
+		t.Errorf("Expected a header before any record, got %q", out.String())
+	}
+	encoder.Write([]interface{}{[]interface{}{"total", 2.}, []interface{}{"path", "/"}})
+	if out.String() != "total,path\n2,/\n" {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+
+	out.Reset()
+	encoder = NewCSVEncoder(&out)
+	encoder.SetSchema([]ResultField{{"total", TypeNumber}, {"latency", ""}})
+	if out.Len() != 0 {
+		t.Errorf("Expected no header while columns are unknown, got %q", out.String())
+	}
+}
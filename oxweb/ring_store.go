@@ -0,0 +1,170 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A StoredRecord is a query record and when it was emitted.
+type StoredRecord struct {
+	Time   time.Time `json:"time"`
+	Record JSONData  `json:"record"`
+}
+
+// RingStore keeps each named query's recent records in memory, so the last
+// hour or so of a metric can be charted without an external time-series
+// database. Records older than Retention are discarded, as are the oldest
+// records beyond MaxRecords per query.
+type RingStore struct {
+	Retention  time.Duration
+	MaxRecords int
+
+	lock       sync.RWMutex
+	queries    map[string]*recordRing
+	timeSource func() time.Time
+}
+
+func NewRingStore(retention time.Duration, maxRecords int) *RingStore {
+	return &RingStore{
+		Retention:  retention,
+		MaxRecords: maxRecords,
+		queries:    make(map[string]*recordRing),
+		timeSource: time.Now,
+	}
+}
+
+// recordRing is a fixed size circular buffer of records, oldest first.
+type recordRing struct {
+	records []StoredRecord
+	head    int
+	size    int
+}
+
+func (r *recordRing) push(record StoredRecord) {
+	r.records[(r.head+r.size)%len(r.records)] = record
+	if r.size < len(r.records) {
+		r.size++
+	} else {
+		r.head = (r.head + 1) % len(r.records)
+	}
+}
+
+func (r *recordRing) at(ndx int) StoredRecord {
+	return r.records[(r.head+ndx)%len(r.records)]
+}
+
+// expire drops records from before cutoff.
+func (r *recordRing) expire(cutoff time.Time) {
+	for r.size > 0 && r.at(0).Time.Before(cutoff) {
+		r.records[r.head] = StoredRecord{}
+		r.head = (r.head + 1) % len(r.records)
+		r.size--
+	}
+}
+
+// Add stores a record for the named query.
+func (s *RingStore) Add(query string, record JSONData) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ring, ok := s.queries[query]
+	if !ok {
+		size := s.MaxRecords
+		if size < 1 {
+			size = 1
+		}
+		ring = &recordRing{records: make([]StoredRecord, size)}
+		s.queries[query] = ring
+	}
+	now := s.timeSource()
+	ring.expire(now.Add(-s.Retention))
+	ring.push(StoredRecord{now, record})
+}
+
+// Range returns the named query's records emitted from from up to but not
+// including to, oldest first.
+func (s *RingStore) Range(query string, from, to time.Time) []StoredRecord {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	records := []StoredRecord{}
+	ring, ok := s.queries[query]
+	if !ok {
+		return records
+	}
+	cutoff := s.timeSource().Add(-s.Retention)
+	for ndx := 0; ndx < ring.size; ndx++ {
+		record := ring.at(ndx)
+		if record.Time.Before(cutoff) || record.Time.Before(from) || !record.Time.Before(to) {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// Queries lists the names of queries with stored records.
+func (s *RingStore) Queries() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	names := make([]string, 0, len(s.queries))
+	for name := range s.queries {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Sink returns a Sink adding records to the store under the query's name.
+func (s *RingStore) Sink(query string) Sink {
+	return &ringStoreSink{s, query}
+}
+
+type ringStoreSink struct {
+	store *RingStore
+	query string
+}
+
+func (s *ringStoreSink) Write(record JSONData) error {
+	s.store.Add(s.query, record)
+	return nil
+}
+
+func (s *ringStoreSink) Close() error {
+	return nil
+}
+
+// ServeHTTP answers range queries as a JSON array of StoredRecords. The query
+// parameter names the query; from and to are optional Unix times in seconds,
+// defaulting to the whole retention period.
+func (s *RingStore) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	query := request.FormValue("query")
+	if query == "" {
+		http.Error(writer, "Expected a query parameter", http.StatusBadRequest)
+		return
+	}
+
+	from := time.Time{}
+	to := s.timeSource().Add(time.Second)
+	for _, param := range []struct {
+		name string
+		time *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		value := request.FormValue(param.name)
+		if value == "" {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			http.Error(writer, "Expected "+param.name+" in Unix seconds", http.StatusBadRequest)
+			return
+		}
+		*param.time, _ = toTime(seconds)
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(s.Range(query, from, to))
+}
@@ -0,0 +1,36 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRingStore(t *testing.T) {
+	store := NewRingStore(time.Hour, 3)
+	now := time.Unix(10000, 0)
+	store.timeSource = func() time.Time { return now }
+
+	for ndx, offset := range []time.Duration{0, 59 * time.Minute, 60 * time.Minute, 61 * time.Minute, 62 * time.Minute} {
+		now = time.Unix(10000, 0).Add(offset)
+		store.Add("p95", float64(ndx))
+	}
+
+	// The first record has expired; the second was pushed out by MaxRecords.
+	records := store.Range("p95", time.Time{}, now.Add(time.Second))
+	if len(records) != 3 || records[0].Record != 2. || records[2].Record != 4. {
+		t.Errorf("Expected records 2 to 4, got %v", records)
+	}
+
+	request := httptest.NewRequest("GET", "/results?query=p95&from=13600&to=13720", nil)
+	response := httptest.NewRecorder()
+	store.ServeHTTP(response, request)
+	var served []StoredRecord
+	if err := json.NewDecoder(response.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 2 || served[0].Record != 2. || served[1].Record != 3. {
+		t.Errorf("Expected records 2 and 3 over HTTP, got %v", served)
+	}
+}
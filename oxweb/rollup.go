@@ -0,0 +1,117 @@
+package oxweb
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A RollupPoint summarises one numeric field of a query's records over an
+// interval.
+type RollupPoint struct {
+	Query    string
+	Field    string
+	Start    time.Time
+	Interval time.Duration
+	Count    int64
+	Sum      float64
+	Min      float64
+	Max      float64
+	Last     float64
+}
+
+func (p *RollupPoint) add(value float64) {
+	if p.Count == 0 || value < p.Min {
+		p.Min = value
+	}
+	if p.Count == 0 || value > p.Max {
+		p.Max = value
+	}
+	p.Count++
+	p.Sum += value
+	p.Last = value
+}
+
+// A RollupStore keeps rollup points for the long term.
+type RollupStore interface {
+	WriteRollup(point RollupPoint) error
+}
+
+// Rollup is a Sink downsampling a query's records: every numeric field is
+// summarised per Interval (by wall clock), and each interval's points are
+// written to Store once the next interval starts, or on Close.
+type Rollup struct {
+	Query    string
+	Interval time.Duration
+	Store    RollupStore
+
+	lock       sync.Mutex
+	start      time.Time
+	points     map[string]*RollupPoint
+	timeSource func() time.Time
+}
+
+func NewRollup(query string, interval time.Duration, store RollupStore) *Rollup {
+	return &Rollup{
+		Query:      query,
+		Interval:   interval,
+		Store:      store,
+		points:     make(map[string]*RollupPoint),
+		timeSource: time.Now,
+	}
+}
+
+func (r *Rollup) Write(record JSONData) (err error) {
+	fields, err := recordFields(record)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	start := r.timeSource().Truncate(r.Interval)
+	if !start.Equal(r.start) {
+		if err := r.flush(); err != nil {
+			return err
+		}
+		r.start = start
+	}
+
+	for name, value := range fields {
+		number, ok := toFloat(value)
+		if !ok {
+			continue
+		}
+		point, ok := r.points[name]
+		if !ok {
+			point = &RollupPoint{Query: r.Query, Field: name, Start: r.start, Interval: r.Interval}
+			r.points[name] = point
+		}
+		point.add(number)
+	}
+	return nil
+}
+
+func (r *Rollup) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.flush()
+}
+
+// flush writes the current interval's points, in field order.
+func (r *Rollup) flush() (err error) {
+	names := make([]string, 0, len(r.points))
+	for name := range r.points {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := r.Store.WriteRollup(*r.points[name]); err != nil {
+			return err
+		}
+		delete(r.points, name)
+	}
+	return nil
+}
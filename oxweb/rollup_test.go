@@ -0,0 +1,43 @@
+package oxweb
+
+import (
+	"testing"
+	"time"
+)
+
+type rollupRecorder []RollupPoint
+
+func (r *rollupRecorder) WriteRollup(point RollupPoint) error {
+	*r = append(*r, point)
+	return nil
+}
+
+func TestRollup(t *testing.T) {
+	store := new(rollupRecorder)
+	rollup := NewRollup("latency", time.Minute, store)
+	now := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	rollup.timeSource = func() time.Time { return now }
+
+	for _, step := range []struct {
+		offset time.Duration
+		value  float64
+	}{{0, 5}, {20 * time.Second, 1}, {50 * time.Second, 3}, {70 * time.Second, 10}} {
+		now = time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC).Add(step.offset)
+		rollup.Write([]interface{}{
+			[]interface{}{"host", "web1"},
+			[]interface{}{"p95", step.value},
+		})
+	}
+	rollup.Close()
+
+	if len(*store) != 2 {
+		t.Fatalf("Expected 2 points, got %v", *store)
+	}
+	first := (*store)[0]
+	if first.Field != "p95" || first.Count != 3 || first.Sum != 9 || first.Min != 1 || first.Max != 5 || first.Last != 3 {
+		t.Errorf("Unexpected first point %+v", first)
+	}
+	if second := (*store)[1]; second.Start.Sub(first.Start) != time.Minute || second.Count != 1 {
+		t.Errorf("Unexpected second point %+v", second)
+	}
+}
@@ -0,0 +1,113 @@
+package oxweb
+
+import (
+	"fmt"
+	"math"
+)
+
+func evaluateNumber(expr Expression, data JSONData, what string) (f float64, err error) {
+	value, err := expr.Evaluate(data)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := toFloat(value)
+	if !ok {
+		return 0, fmt.Errorf("%w: Expected a number %v. Got a %T, %v", ErrTypeMismatch, what, value, value)
+	}
+	return f, nil
+}
+
+/*
+ * RoundTo(expr, step) -> float64
+ *
+ * Rounds the value to the nearest multiple of step, so continuous values can
+ * be used as group keys, e.g. GroupBy(RoundTo(latency, 50), ...) groups
+ * latencies into 50ms bands.
+ */
+type RoundTo struct {
+	expr Expression
+	step Expression
+}
+
+func (r *RoundTo) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 {
+		return fmt.Errorf("RoundTo expects an expression and a positive step to round to")
+	}
+	r.expr, r.step = args[0], args[1]
+	return nil
+}
+
+func (r *RoundTo) Evaluate(data JSONData) (result interface{}, err error) {
+	value, err := r.expr.Evaluate(data)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	v, ok := toFloat(value)
+	if !ok {
+		return nil, fmt.Errorf("%w: RoundTo expects a number, got %T, %v", ErrTypeMismatch, value, value)
+	}
+	step, err := evaluateNumber(r.step, data, "step")
+	if err != nil {
+		return nil, err
+	}
+	if step <= 0 {
+		return nil, fmt.Errorf("RoundTo expects a positive step, got %v", step)
+	}
+	return math.Round(v/step) * step, nil
+}
+
+func (r *RoundTo) String() string {
+	return fmt.Sprintf("RoundTo(%v,%v)", r.expr, r.step)
+}
+
+/*
+ * LogBucket(expr, base) -> float64
+ *
+ * Returns the power of base at the bottom of the value's bucket, e.g. with
+ * base 10, 1 to 9.99 give 1, 10 to 99.9 give 10 and so on. Useful for keys
+ * spanning orders of magnitude, like response sizes. Zero is its own bucket
+ * and negative values are bucketed like their absolute value, but negated.
+ */
+type LogBucket struct {
+	expr Expression
+	base Expression
+}
+
+func (l *LogBucket) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 {
+		return fmt.Errorf("LogBucket expects an expression and a base greater than 1")
+	}
+	l.expr, l.base = args[0], args[1]
+	return nil
+}
+
+func (l *LogBucket) Evaluate(data JSONData) (result interface{}, err error) {
+	value, err := l.expr.Evaluate(data)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	v, ok := toFloat(value)
+	if !ok {
+		return nil, fmt.Errorf("%w: LogBucket expects a number, got %T, %v", ErrTypeMismatch, value, value)
+	}
+	base, err := evaluateNumber(l.base, data, "base")
+	if err != nil {
+		return nil, err
+	}
+	if base <= 1 {
+		return nil, fmt.Errorf("LogBucket expects a base greater than 1, got %v", base)
+	}
+	if v == 0 {
+		return 0., nil
+	}
+	bucket := math.Pow(base, math.Floor(math.Log(math.Abs(v))/math.Log(base)))
+	// Floating point error can put exact powers in the bucket below.
+	if next := bucket * base; next <= math.Abs(v) {
+		bucket = next
+	}
+	return math.Copysign(bucket, v), nil
+}
+
+func (l *LogBucket) String() string {
+	return fmt.Sprintf("LogBucket(%v,%v)", l.expr, l.base)
+}
@@ -0,0 +1,41 @@
+package oxweb
+
+import (
+	"testing"
+)
+
+var roundTests = []struct {
+	statement string
+	expected  interface{}
+	ok        bool
+}{
+	{"RoundTo(latency, 50)", 150., true},
+	{"RoundTo(latency, 0.5)", 137., true},
+	{"RoundTo(missing, 50)", nil, true},
+	{"RoundTo(latency, 0)", nil, false},
+	{"LogBucket(latency, 10)", 100., true},
+	{"LogBucket(size, 2)", 1024., true},
+	{"LogBucket(1000, 10)", 1000., true},
+	{"LogBucket(-42, 10)", -10., true},
+	{"LogBucket(0, 10)", 0., true},
+	{"LogBucket(latency, 1)", nil, false},
+	{"LogBucket(host, 10)", nil, false},
+}
+
+func TestRoundAndLogBucket(t *testing.T) {
+	data := map[string]interface{}{"latency": 137.1, "size": 2047., "host": "web1"}
+	for _, test := range roundTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatalf("For statement %q, unexpected parse error %v", test.statement, err)
+		}
+		result, err := expr.Evaluate(data)
+		if test.ok != (err == nil) {
+			t.Errorf("For statement %q, unexpected error %v", test.statement, err)
+			continue
+		}
+		if test.ok && result != test.expected {
+			t.Errorf("For statement %q, expected %v, got %v", test.statement, test.expected, result)
+		}
+	}
+}
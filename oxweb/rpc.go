@@ -0,0 +1,174 @@
+package oxweb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultRPCTimeout is how long ReadResponse waits for a response when its
+// context has no deadline.
+const DefaultRPCTimeout = 30 * time.Second
+
+// RPCConn sends requests over a JSONConn and matches up their responses, so
+// any number of goroutines can have requests outstanding at once. Requests
+// and responses are JSON objects: WriteRequest gives each request an "id",
+// and the peer answers with an object carrying the same id, in any order, as
+// JSONConn.WriteResponse does. Lines without a known id are logged and
+// dropped.
+type RPCConn struct {
+	conn *JSONConn
+	// Timeout replaces DefaultRPCTimeout if set.
+	Timeout time.Duration
+
+	lock    sync.Mutex
+	nextID  int64
+	pending map[int64]chan map[string]interface{}
+	reading bool
+	done    chan struct{}
+	err     error
+}
+
+func NewRPCConn(conn *JSONConn) *RPCConn {
+	return &RPCConn{
+		conn:    conn,
+		pending: make(map[int64]chan map[string]interface{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// WriteRequest sends request with a new id, which is returned for
+// ReadResponse. request is left unchanged.
+func (r *RPCConn) WriteRequest(request map[string]interface{}) (id int64, err error) {
+	r.lock.Lock()
+	if r.err != nil {
+		r.lock.Unlock()
+		return 0, r.err
+	}
+	r.nextID++
+	id = r.nextID
+	// Registered before it's sent, so a quick response isn't missed.
+	r.pending[id] = make(chan map[string]interface{}, 1)
+	if !r.reading {
+		r.reading = true
+		go r.read()
+	}
+	r.lock.Unlock()
+
+	withID := make(map[string]interface{}, len(request)+1)
+	for key, value := range request {
+		withID[key] = value
+	}
+	withID["id"] = id
+
+	if err = r.conn.WriteJSON(withID); err != nil {
+		r.forget(id)
+		return 0, err
+	}
+	return id, nil
+}
+
+// ReadResponse waits for the response to the request with id, until ctx is
+// done or the timeout passes.
+func (r *RPCConn) ReadResponse(ctx context.Context, id int64) (response map[string]interface{}, err error) {
+	r.lock.Lock()
+	responses, ok := r.pending[id]
+	r.lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("No request %d is waiting for a response", id)
+	}
+	defer r.forget(id)
+
+	if _, ok := ctx.Deadline(); !ok {
+		timeout := r.Timeout
+		if timeout == 0 {
+			timeout = DefaultRPCTimeout
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	select {
+	case response = <-responses:
+		return response, nil
+	case <-r.done:
+		// A response may have arrived just before the connection ended.
+		select {
+		case response = <-responses:
+			return response, nil
+		default:
+		}
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return nil, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("No response to request %d: %w", id, ctx.Err())
+	}
+}
+
+// Call sends request and waits for its response. A response with an "error"
+// is returned as an error.
+func (r *RPCConn) Call(ctx context.Context, request map[string]interface{}) (response map[string]interface{}, err error) {
+	id, err := r.WriteRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	if response, err = r.ReadResponse(ctx, id); err != nil {
+		return nil, err
+	}
+	if class, ok := response["error"]; ok && class != nil {
+		return response, fmt.Errorf("Request %d failed: %v: %v", id, class, response["message"])
+	}
+	return response, nil
+}
+
+func (r *RPCConn) forget(id int64) {
+	r.lock.Lock()
+	delete(r.pending, id)
+	r.lock.Unlock()
+}
+
+// read delivers responses to whoever's waiting for them, until the
+// connection fails.
+func (r *RPCConn) read() {
+	for {
+		data, err := r.conn.ReadJSON()
+		if err != nil {
+			r.lock.Lock()
+			r.err = err
+			r.lock.Unlock()
+			close(r.done)
+			return
+		}
+
+		response, _ := data.(map[string]interface{})
+		id, ok := toFloat(response["id"])
+		r.lock.Lock()
+		responses, waiting := r.pending[int64(id)]
+		r.lock.Unlock()
+		if !ok || !waiting {
+			log.Printf("Dropping unexpected response %v", data)
+			continue
+		}
+		select {
+		case responses <- response:
+		default:
+			log.Printf("Dropping duplicate response %v", data)
+		}
+	}
+}
+
+// WriteResponse answers request with response, giving it the request's id.
+// response is left unchanged.
+func (jsonConn *JSONConn) WriteResponse(request JSONData, response map[string]interface{}) (err error) {
+	asked, _ := request.(map[string]interface{})
+	withID := make(map[string]interface{}, len(response)+1)
+	for key, value := range response {
+		withID[key] = value
+	}
+	withID["id"] = asked["id"]
+	return jsonConn.WriteJSON(withID)
+}
@@ -0,0 +1,82 @@
+package oxweb
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoServer answers each request with its "n" doubled, holding back answers
+// to requests with "n" of 0 until another request arrives, so responses come
+// out of order. Requests with "n" of -1 get no answer at all.
+func echoServer(conn net.Conn) {
+	server := NewJSONConn(conn)
+	var held JSONData
+	for {
+		request, err := server.ReadJSON()
+		if err != nil {
+			return
+		}
+		n := request.(map[string]interface{})["n"].(float64)
+		switch {
+		case n == 0:
+			held = request
+			continue
+		case n == -1:
+			continue
+		case n == -2:
+			server.WriteResponse(request, map[string]interface{}{"error": "parse error", "message": "bad"})
+			continue
+		}
+		server.WriteResponse(request, map[string]interface{}{"doubled": n * 2})
+		if held != nil {
+			server.WriteResponse(held, map[string]interface{}{"doubled": 0.})
+			held = nil
+		}
+	}
+}
+
+func TestRPCConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go echoServer(server)
+	rpc := NewRPCConn(NewJSONConn(client))
+
+	// The response to the first request arrives after the second's.
+	held, err := rpc.WriteRequest(map[string]interface{}{"n": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wait sync.WaitGroup
+	for n := 1; n <= 10; n++ {
+		wait.Add(1)
+		go func(n int) {
+			defer wait.Done()
+			response, err := rpc.Call(context.Background(), map[string]interface{}{"n": n})
+			if err != nil || response["doubled"] != float64(n*2) {
+				t.Errorf("For %d, unexpected response %v, %v", n, response, err)
+			}
+		}(n)
+	}
+	wait.Wait()
+	if response, err := rpc.ReadResponse(context.Background(), held); err != nil || response["doubled"] != 0. {
+		t.Errorf("Unexpected response to the held request %v, %v", response, err)
+	}
+
+	if _, err := rpc.Call(context.Background(), map[string]interface{}{"n": -2}); err == nil {
+		t.Errorf("Expected an error response to be returned as an error")
+	}
+
+	rpc.Timeout = 10 * time.Millisecond
+	if _, err := rpc.Call(context.Background(), map[string]interface{}{"n": -1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+
+	server.Close()
+	if _, err := rpc.Call(context.Background(), map[string]interface{}{"n": 1}); err == nil {
+		t.Errorf("Expected an error once the connection's closed")
+	}
+}
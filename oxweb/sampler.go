@@ -0,0 +1,202 @@
+package oxweb
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SampleRateKey is where Sampler records, in each event it keeps, the
+// fraction of events it was sampled at, so aggregates can be scaled back up.
+const SampleRateKey = "_sample_rate"
+
+// Sampler is a Stage keeping a random fraction of events. Upstream is the
+// rate the relay already sampled at, if any; the rate recorded with each event
+// is Upstream times the Sampler's own.
+//
+// An adaptive Sampler halves its rate, down to MinRate, whenever an
+// AdjustInterval passes with subscribers dropping events (see Dropped), and
+// raises it again by half as much each interval without drops.
+type Sampler struct {
+	Adaptive       bool
+	MinRate        float64
+	AdjustInterval time.Duration
+
+	lock       sync.Mutex
+	rate       float64
+	upstream   float64
+	drops      int64
+	lastAdjust time.Time
+	timeSource func() time.Time
+}
+
+func NewSampler(rate float64) (s *Sampler, err error) {
+	if rate <= 0 || rate > 1 {
+		return nil, fmt.Errorf("Sample rate must be in (0, 1], got %v", rate)
+	}
+	return &Sampler{
+		MinRate:        0.001,
+		AdjustInterval: time.Second,
+		rate:           rate,
+		upstream:       1,
+		timeSource:     time.Now,
+	}, nil
+}
+
+func (s *Sampler) Process(data JSONData) []JSONData {
+	s.lock.Lock()
+	s.adjust()
+	rate := s.rate
+	effective := s.upstream * s.rate
+	s.lock.Unlock()
+
+	if rate < 1 && rand.Float64() >= rate {
+		return nil
+	}
+	if effective < 1 {
+		if sampled, ok := setDeep(SampleRateKey, data, effective); ok {
+			data = sampled
+		}
+	}
+	return []JSONData{data}
+}
+
+// Dropped tells the Sampler an event it kept was dropped for lack of a
+// subscriber keeping up.
+func (s *Sampler) Dropped() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.drops++
+}
+
+// Rate returns the effective sampling rate, including Upstream.
+func (s *Sampler) Rate() float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.upstream * s.rate
+}
+
+// SetUpstream records the rate the relay samples at, when it's taken over
+// sampling. The Sampler then keeps everything, unless adapting to load.
+func (s *Sampler) SetUpstream(rate float64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.upstream = rate
+	s.rate = 1
+}
+
+func (s *Sampler) adjust() {
+	if !s.Adaptive {
+		return
+	}
+	now := s.timeSource()
+	if s.lastAdjust.IsZero() {
+		s.lastAdjust = now
+	}
+	if now.Sub(s.lastAdjust) < s.AdjustInterval {
+		return
+	}
+
+	if s.drops > 0 {
+		s.rate /= 2
+		if s.rate < s.MinRate {
+			s.rate = s.MinRate
+		}
+	} else if s.rate < 1 {
+		s.rate *= 1.25
+		if s.rate > 1 {
+			s.rate = 1
+		}
+	}
+	s.drops = 0
+	s.lastAdjust = now
+}
+
+func (s *Sampler) String() string {
+	return fmt.Sprintf("Sampler(%v)", s.Rate())
+}
+
+// A SamplingFilter is a filter keeping a known fraction of events. Queries
+// record the rate with each event that passes, under SampleRateKey.
+type SamplingFilter interface {
+	SampleRate(data JSONData) (rate float64, ok bool)
+}
+
+// eventSampleRate returns the fraction of events data was sampled at, or 1.
+func eventSampleRate(data JSONData) float64 {
+	if value, ok := GetDeep(SampleRateKey, data); ok {
+		if rate, ok := toFloat(value); ok && rate > 0 {
+			return rate
+		}
+	}
+	return 1
+}
+
+/*
+ * ScaledCount(expr) -> float64
+ *
+ * How many events this event stands for given the sampling recorded with it:
+ * 1 divided by the sample rate, or 0 when expr is nil. Sum it over a window to
+ * estimate the true count, e.g. WindowStats(RollingWindow(ScaledCount(x), 100)).
+ */
+type ScaledCount struct {
+	expr Expression
+}
+
+func (s *ScaledCount) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("ScaledCount expects a single expression")
+	}
+	s.expr = args[0]
+	return nil
+}
+
+func (s *ScaledCount) Evaluate(data JSONData) (result interface{}, err error) {
+	value, err := s.expr.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return 0., nil
+	}
+	return 1 / eventSampleRate(data), nil
+}
+
+func (s *ScaledCount) String() string {
+	return fmt.Sprintf("ScaledCount(%v)", s.expr)
+}
+
+/*
+ * ScaledSum(expr) -> float64
+ *
+ * The event's value divided by the sample rate recorded with it, so summing it
+ * over a window estimates the true sum. Nil values stay nil.
+ */
+type ScaledSum struct {
+	expr Expression
+}
+
+func (s *ScaledSum) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("ScaledSum expects a single expression")
+	}
+	s.expr = args[0]
+	return nil
+}
+
+func (s *ScaledSum) Evaluate(data JSONData) (result interface{}, err error) {
+	value, err := s.expr.Evaluate(data)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	number, ok := toFloat(value)
+	if !ok {
+		return nil, fmt.Errorf("%w: ScaledSum expects a number, got %T, %v", ErrTypeMismatch, value, value)
+	}
+	return number / eventSampleRate(data), nil
+}
+
+func (s *ScaledSum) String() string {
+	return fmt.Sprintf("ScaledSum(%v)", s.expr)
+}
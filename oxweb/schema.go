@@ -0,0 +1,207 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Types a Schema can declare for a path.
+const (
+	TypeNumber = "number"
+	TypeString = "string"
+	TypeBool   = "bool"
+	TypeObject = "object"
+	TypeArray  = "array"
+)
+
+// typeTimestamp is what functions taking a timestamp expect: a number of Unix
+// seconds or an RFC 3339 string.
+const typeTimestamp = "timestamp"
+
+// A Schema declares the types of the values at GetDeep paths in a stream's
+// events, e.g.
+//
+//	{"types": {"latency": "number", "request.url": "string"}, "strict": true}
+//
+// Check type checks a query against any schema, but only a Strict schema has
+// queries on its stream checked when they're registered, so a query adding a
+// string to a number fails straight away rather than on every event. Reading
+// a path a Strict schema doesn't declare is an error too.
+type Schema struct {
+	Types  map[string]string `json:"types"`
+	Strict bool              `json:"strict,omitempty"`
+}
+
+// LoadSchemas reads a JSON file of Schemas keyed by stream name.
+func LoadSchemas(path string) (schemas map[string]*Schema, err error) {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(encoded, &schemas); err != nil {
+		return nil, err
+	}
+	for name, schema := range schemas {
+		for path, typ := range schema.Types {
+			switch typ {
+			case TypeNumber, TypeString, TypeBool, TypeObject, TypeArray:
+			default:
+				return nil, fmt.Errorf("Schema for %v declares %v as %v, expected number, string, bool, object or array", name, path, typ)
+			}
+		}
+	}
+	return schemas, nil
+}
+
+// A signature is the argument and result types of a function, "" for any.
+type signature struct {
+	args   []string
+	result string
+}
+
+var signatures = map[string]signature{
+	"Add":              {[]string{TypeNumber, TypeNumber}, TypeNumber},
+	"Subtract":         {[]string{TypeNumber, TypeNumber}, TypeNumber},
+	"Multiply":         {[]string{TypeNumber, TypeNumber}, TypeNumber},
+	"Divide":           {[]string{TypeNumber, TypeNumber}, TypeNumber},
+	"Convert":          {[]string{TypeNumber, TypeString, TypeString}, TypeNumber},
+	"Now":              {[]string{}, TypeNumber},
+	"Count":            {[]string{}, TypeNumber},
+	"RoundTo":          {[]string{TypeNumber, TypeNumber}, TypeNumber},
+	"LogBucket":        {[]string{TypeNumber, TypeNumber}, TypeNumber},
+	"HourOfDay":        {[]string{typeTimestamp, TypeString}, TypeNumber},
+	"DayOfWeek":        {[]string{typeTimestamp, TypeString}, TypeNumber},
+	"IsWeekend":        {[]string{typeTimestamp, TypeString}, TypeBool},
+	"UrlPath":          {[]string{TypeString}, TypeString},
+	"UrlHost":          {[]string{TypeString}, TypeString},
+	"UrlQueryParam":    {[]string{TypeString, TypeString}, TypeString},
+	"Hash":             {[]string{"", TypeString}, TypeString},
+	"Bucket":           {[]string{"", TypeNumber}, TypeNumber},
+	"ArraySum":         {[]string{TypeArray}, TypeNumber},
+	"ArrayAvg":         {[]string{TypeArray}, TypeNumber},
+	"ArrayMax":         {[]string{TypeArray}, TypeNumber},
+	"ArrayMin":         {[]string{TypeArray}, TypeNumber},
+	"ArrayLen":         {[]string{TypeArray}, TypeNumber},
+	"Sum":              {[]string{TypeNumber}, TypeNumber},
+	"Max":              {[]string{TypeNumber}, TypeNumber},
+	"Min":              {[]string{TypeNumber}, TypeNumber},
+	"RandomSample":     {[]string{TypeNumber}, TypeBool},
+	"EveryNth":         {[]string{TypeNumber}, TypeBool},
+	"Suppress":         {[]string{TypeBool, TypeNumber}, TypeBool},
+	"ScaledCount":      {nil, TypeNumber},
+	"ScaledSum":        {[]string{TypeNumber}, TypeNumber},
+	"WindowAve":        {nil, TypeNumber},
+	"WindowPercentile": {nil, TypeNumber},
+	"TimeDecayedAve":   {[]string{TypeNumber, TypeNumber}, TypeNumber},
+	"Total":            {[]string{TypeNumber}, TypeNumber},
+	"CountDistinct":    {[]string{""}, TypeNumber},
+	"Percentile":       {[]string{TypeNumber, TypeNumber}, TypeNumber},
+	"Object":           {nil, TypeObject},
+	"GroupBy":          {nil, TypeObject},
+	"WindowStats":      {nil, TypeObject},
+}
+
+// The last argument type of these functions' signatures applies to any
+// further arguments.
+var variadicSignatures = map[string]bool{"Sum": true, "Max": true, "Min": true}
+
+func acceptsType(expected, actual string) bool {
+	switch {
+	case expected == "" || actual == "" || expected == actual:
+		return true
+	case expected == typeTimestamp:
+		return actual == TypeNumber || actual == TypeString
+	}
+	return false
+}
+
+func literalType(value interface{}) string {
+	switch value.(type) {
+	case int, int64, uint64, float64, time.Duration, ByteSize:
+		return TypeNumber
+	case string:
+		return TypeString
+	case bool:
+		return TypeBool
+	case map[string]interface{}:
+		return TypeObject
+	case []interface{}:
+		return TypeArray
+	}
+	return ""
+}
+
+// Check type checks a query's statements against the schema, returning an
+// ErrTypeMismatch for the first argument of the wrong type, or for a filter
+// that can't be a boolean. In a Strict schema, reading an undeclared path is
+// also an error.
+func (s *Schema) Check(spec QuerySpec) (err error) {
+	check := func(statement string, expected string) error {
+		if _, err := Parse(statement); err != nil {
+			return err
+		}
+		typ, err := s.typeOf(parseSyntax(strings.TrimSpace(stripComments(statement))))
+		if err != nil {
+			return err
+		}
+		if !acceptsType(expected, typ) {
+			return fmt.Errorf("%w: Filter %v is a %v, expected a bool", ErrTypeMismatch, statement, typ)
+		}
+		return nil
+	}
+	for _, statement := range spec.Fields {
+		if err = check(statement, ""); err != nil {
+			return err
+		}
+	}
+	for _, statement := range spec.Filters {
+		if err = check(statement, TypeBool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// typeOf infers the type of a node, "" if it can't be known, checking the
+// types of the arguments of any calls along the way.
+func (s *Schema) typeOf(syntax *syntaxNode) (typ string, err error) {
+	switch {
+	case syntax.literal:
+		return literalType(syntax.value), nil
+	case !syntax.call:
+		return s.pathType(syntax.text)
+	case syntax.text == "GetDeep" && len(syntax.args) == 1:
+		if path, ok := syntax.args[0].value.(string); ok {
+			return s.pathType(path)
+		}
+	}
+
+	sig := signatures[syntax.text]
+	for ndx, arg := range syntax.args {
+		argType, err := s.typeOf(arg)
+		if err != nil {
+			return "", err
+		}
+		expected := ""
+		if ndx < len(sig.args) {
+			expected = sig.args[ndx]
+		} else if variadicSignatures[syntax.text] {
+			expected = sig.args[len(sig.args)-1]
+		}
+		if !acceptsType(expected, argType) {
+			return "", fmt.Errorf("%w: %v argument %d is a %v, expected a %v", ErrTypeMismatch, syntax.oneLine(), ndx+1, argType, expected)
+		}
+	}
+	return sig.result, nil
+}
+
+func (s *Schema) pathType(path string) (typ string, err error) {
+	typ, ok := s.Types[path]
+	if !ok && s.Strict {
+		return "", fmt.Errorf("%w: %v isn't declared in the schema", ErrTypeMismatch, path)
+	}
+	return typ, nil
+}
@@ -0,0 +1,54 @@
+package oxweb
+
+import (
+	"errors"
+	"testing"
+)
+
+var schemaTests = []struct {
+	fields  []string
+	filters []string
+	strict  bool
+	ok      bool
+}{
+	{[]string{"Add(latency, 1.0)"}, nil, true, true},
+	{[]string{"Add(latency, host)"}, nil, false, false},
+	{[]string{`Add(GetDeep("latency"), 1.0)`}, nil, true, true},
+	{[]string{"WindowAve(RollingWindow(Convert(latency, \"ms\", \"s\"), 100))"}, nil, true, true},
+	{[]string{"UrlPath(latency)"}, nil, false, false},
+	{[]string{"HourOfDay(ts)"}, nil, true, true},
+	{[]string{"Add(missing, 1.0)"}, nil, false, true},
+	{[]string{"Add(missing, 1.0)"}, nil, true, false},
+	{[]string{"host"}, []string{"IsWeekend(ts)"}, true, true},
+	{[]string{"host"}, []string{"RoundTo(latency, 10)"}, true, false},
+	{[]string{"Max(latency, 1.0, latency)"}, nil, true, true},
+	{[]string{"Sum(latency, 1.0, host)"}, nil, false, false},
+}
+
+func TestSchemaCheck(t *testing.T) {
+	for _, test := range schemaTests {
+		schema := &Schema{Types: map[string]string{"latency": TypeNumber, "host": TypeString, "ts": TypeNumber}, Strict: test.strict}
+		err := schema.Check(QuerySpec{Fields: test.fields, Filters: test.filters})
+		if test.ok && err != nil {
+			t.Errorf("For %v %v, unexpected error %v", test.fields, test.filters, err)
+		}
+		if !test.ok && !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("For %v %v, expected a type mismatch, got %v", test.fields, test.filters, err)
+		}
+	}
+}
+
+func TestEngineStrictSchema(t *testing.T) {
+	engine := NewEngine()
+	engine.SetSchema("web", &Schema{Types: map[string]string{"latency": TypeNumber, "host": TypeString}, Strict: true})
+
+	if _, err := engine.Add(QuerySpec{Name: "bad", Source: "web", Fields: []string{"Add(latency, host)"}}); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected the query to be rejected, got %v", err)
+	}
+	if _, err := engine.Add(QuerySpec{Name: "good", Source: "web", Fields: []string{"Add(latency, 1.0)"}}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, err := engine.Add(QuerySpec{Name: "other", Source: "api", Fields: []string{"Add(latency, host)"}}); err != nil {
+		t.Errorf("Expected sources without a schema to be unchecked, got %v", err)
+	}
+}
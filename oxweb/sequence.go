@@ -0,0 +1,102 @@
+package oxweb
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+ * Sequence(keyExpr, windowSeconds, filterA, filterB, ...) -> bool
+ *
+ * Returns true on the event completing the sequence: events for the same key
+ * matching filterA, then filterB, and so on, in order, with the last one
+ * arriving within windowSeconds of the first. The key's progress is reset once
+ * the sequence fires or the window runs out. Useful for spotting retry storms
+ * (the same filter repeated) or login-failure-then-success patterns.
+ */
+type Sequence struct {
+	key      Expression
+	window   Expression
+	filters  []Expression
+	sessions map[interface{}]*list.Element
+	order    list.List
+	// Replaceable in tests.
+	timeSource func() time.Time
+}
+
+func (s *Sequence) Setup(fname string, args []Expression) (err error) {
+	if len(args) < 3 {
+		return fmt.Errorf("Sequence expects a key expression, a window size in seconds and at least one filter")
+	}
+	s.key = args[0]
+	s.window = args[1]
+	s.filters = args[2:]
+	s.sessions = make(map[interface{}]*list.Element)
+	s.order.Init()
+	if s.timeSource == nil {
+		s.timeSource = time.Now
+	}
+	return nil
+}
+
+func (s *Sequence) Evaluate(data JSONData) (result interface{}, err error) {
+	window, err := evaluateSeconds(s.window, data)
+	if err != nil {
+		return false, err
+	}
+	now := s.timeSource()
+	s.expire(now.Add(-window))
+
+	key, err := s.key.Evaluate(data)
+	if err != nil || key == nil {
+		return false, err
+	}
+	key = mapKey(key)
+
+	step := 0
+	elem, inProgress := s.sessions[key]
+	if inProgress {
+		step = elem.Value.(*funnelSession).step
+	}
+	matched, err := evaluateBool(s.filters[step], data)
+	if err != nil || !matched {
+		return false, err
+	}
+
+	step++
+	if step == len(s.filters) {
+		if inProgress {
+			delete(s.sessions, key)
+			s.order.Remove(elem)
+		}
+		return true, nil
+	}
+	if inProgress {
+		elem.Value.(*funnelSession).step = step
+	} else {
+		s.sessions[key] = s.order.PushBack(&funnelSession{key, now, step})
+	}
+	return false, nil
+}
+
+// expire drops keys whose sequence started before windowStart.
+func (s *Sequence) expire(windowStart time.Time) {
+	for {
+		front := s.order.Front()
+		if front == nil || !front.Value.(*funnelSession).start.Before(windowStart) {
+			return
+		}
+		delete(s.sessions, front.Value.(*funnelSession).key)
+		s.order.Remove(front)
+	}
+}
+
+func (s *Sequence) String() string {
+	filters := make([]string, len(s.filters))
+	for ndx, filter := range s.filters {
+		filters[ndx] = filter.String()
+	}
+	return fmt.Sprintf("Sequence(%v,%v,%v)", s.key, s.window, strings.Join(filters, ","))
+}
@@ -0,0 +1,68 @@
+package oxweb
+
+import (
+	"testing"
+	"time"
+)
+
+type sequenceTest struct {
+	events   []timedEvent
+	expected []bool
+}
+
+var sequenceTests = []sequenceTest{
+	// Fail, fail, succeed fires on the success.
+	sequenceTest{[]timedEvent{
+		{0, `{"user": "a", "fail": true, "ok": false}`},
+		{1, `{"user": "a", "fail": true, "ok": false}`},
+		{2, `{"user": "a", "fail": false, "ok": true}`},
+	}, []bool{false, false, true}},
+	// Keys progress separately.
+	sequenceTest{[]timedEvent{
+		{0, `{"user": "a", "fail": true, "ok": false}`},
+		{1, `{"user": "b", "fail": true, "ok": false}`},
+		{2, `{"user": "b", "fail": false, "ok": true}`},
+		{3, `{"user": "a", "fail": true, "ok": false}`},
+		{4, `{"user": "a", "fail": false, "ok": true}`},
+	}, []bool{false, false, false, false, true}},
+	// An event not matching the next filter doesn't reset progress, and the
+	// sequence starts over once it has fired.
+	sequenceTest{[]timedEvent{
+		{0, `{"user": "a", "fail": true, "ok": false}`},
+		{1, `{"user": "a", "fail": false, "ok": true}`},
+		{2, `{"user": "a", "fail": true, "ok": false}`},
+		{3, `{"user": "a", "fail": false, "ok": true}`},
+		{4, `{"user": "a", "fail": false, "ok": true}`},
+		{5, `{"user": "a", "fail": true, "ok": false}`},
+		{6, `{"user": "a", "fail": true, "ok": false}`},
+		{7, `{"user": "a", "fail": false, "ok": true}`},
+	}, []bool{false, false, false, true, false, false, false, true}},
+	// The window runs out before the success.
+	sequenceTest{[]timedEvent{
+		{0, `{"user": "a", "fail": true, "ok": false}`},
+		{5, `{"user": "a", "fail": true, "ok": false}`},
+		{61, `{"user": "a", "fail": false, "ok": true}`},
+	}, []bool{false, false, false}},
+	// Events without a key never match.
+	sequenceTest{[]timedEvent{
+		{0, `{"fail": true, "ok": false}`},
+		{1, `{"fail": true, "ok": false}`},
+		{2, `{"fail": false, "ok": true}`},
+	}, []bool{false, false, false}},
+}
+
+func TestSequence(t *testing.T) {
+	for _, test := range sequenceTests {
+		expr, err := Parse("Sequence(user, 60, fail, fail, ok)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sequence := expr.(*Sequence)
+		for ndx, expected := range test.expected {
+			result, err := evaluateTimed(t, expr, func(now func() time.Time) { sequence.timeSource = now }, test.events[ndx:ndx+1])
+			if err != nil || result != expected {
+				t.Errorf("For %v, expected event %d to give %v, got %v, %v", test.events, ndx, expected, result, err)
+			}
+		}
+	}
+}
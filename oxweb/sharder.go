@@ -0,0 +1,296 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// HashRing assigns keys to nodes by consistent hashing, so adding or removing
+// a node only moves the keys that node gains or loses.
+type HashRing struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+// NewHashRing places each node on the ring replicas times; more replicas
+// spread keys more evenly.
+func NewHashRing(nodes []string, replicas int) *HashRing {
+	ring := &HashRing{owners: make(map[uint32]string)}
+	for _, node := range nodes {
+		for replica := 0; replica < replicas; replica++ {
+			point := hash32(node + "#" + strconv.Itoa(replica))
+			ring.points = append(ring.points, point)
+			ring.owners[point] = node
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+// Owner returns the node responsible for key.
+func (r *HashRing) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	point := hash32(key)
+	ndx := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= point })
+	if ndx == len(r.points) {
+		ndx = 0
+	}
+	return r.owners[r.points[ndx]]
+}
+
+func hash32(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// Sharder is a Stage keeping only the events one node of a cluster is
+// responsible for, by hashing the value at KeyPath. Run the same queries on
+// every node, each with its own Sharder, and combine their records with a
+// Coordinator. Sharding on the GroupBy key means each group lives on exactly
+// one node, so the partial results merge exactly.
+type Sharder struct {
+	Node    string
+	KeyPath string
+	ring    *HashRing
+}
+
+func NewSharder(node string, nodes []string, keyPath string) (s *Sharder, err error) {
+	found := false
+	for _, n := range nodes {
+		found = found || n == node
+	}
+	if !found {
+		return nil, fmt.Errorf("Sharder node %v isn't one of %v", node, nodes)
+	}
+	return &Sharder{Node: node, KeyPath: keyPath, ring: NewHashRing(nodes, 64)}, nil
+}
+
+// Process keeps events this node owns. Events without a key are all owned by
+// the node the empty key hashes to.
+func (s *Sharder) Process(data JSONData) []JSONData {
+	key := ""
+	if value, ok := GetDeep(s.KeyPath, data); ok {
+		key = hashKey(value)
+	}
+	if s.ring.Owner(key) != s.Node {
+		return nil
+	}
+	return []JSONData{data}
+}
+
+func (s *Sharder) String() string {
+	return fmt.Sprintf("Sharder(%v, %v)", s.Node, s.KeyPath)
+}
+
+// A Partial is one node's latest record for a sharded query, with the state
+// of its Mergeable fields by field name.
+type Partial struct {
+	Node   string                  `json:"node"`
+	Record JSONData                `json:"record"`
+	States map[string]PartialState `json:"states,omitempty"`
+}
+
+// A PartialState is a Mergeable field's State, with the statement of the
+// aggregate that produced it so the Coordinator can rebuild it.
+type PartialState struct {
+	Statement string          `json:"statement"`
+	State     json.RawMessage `json:"state"`
+}
+
+// NewPartial returns a node's Partial for the record query just produced,
+// including the State of each of its Mergeable fields. Call it before the
+// query evaluates another event, so the states match the record.
+func NewPartial(node string, query *Query, record []interface{}) (partial Partial, err error) {
+	partial = Partial{Node: node, Record: record, States: make(map[string]PartialState)}
+	for _, field := range query.Fields {
+		m, ok := mergeableField(field)
+		if !ok {
+			continue
+		}
+		state, err := m.State()
+		if err != nil {
+			return partial, fmt.Errorf("%v: %w", field, err)
+		}
+		partial.States[field.String()] = PartialState{unwrapAs(field).String(), state}
+	}
+	return partial, nil
+}
+
+// A CombineFunc combines two nodes' values of a numeric field.
+type CombineFunc func(a, b float64) float64
+
+var (
+	CombineSum CombineFunc = func(a, b float64) float64 { return a + b }
+	CombineMin CombineFunc = func(a, b float64) float64 { return math.Min(a, b) }
+	CombineMax CombineFunc = func(a, b float64) float64 { return math.Max(a, b) }
+)
+
+// Coordinator combines the partial records of a sharded query into the
+// record a single node would have produced. It keeps each node's latest
+// partial and recombines them all on every update.
+//
+// Fields every node sent a State for are merged exactly, by merging the
+// states into a fresh aggregate. Otherwise, numeric fields are combined with
+// the field's CombineFunc; there's no default, as no one function is right
+// for averages, percentiles and sums alike, so a numeric field reported by
+// several nodes without one is an error. Objects, such as GroupBy results,
+// are merged key by key, combining values under keys present on several
+// nodes the same way, except "count" which is always summed. Anything else
+// takes the value from the last node in name order.
+type Coordinator struct {
+	Combine map[string]CombineFunc
+
+	lock     sync.Mutex
+	partials map[string]Partial
+}
+
+func NewCoordinator() *Coordinator {
+	return &Coordinator{Combine: make(map[string]CombineFunc), partials: make(map[string]Partial)}
+}
+
+// Add records a node's partial and returns the combined record, with fields
+// in the order of the partial's record.
+func (c *Coordinator) Add(partial Partial) (record []interface{}, err error) {
+	order, err := recordFields(partial.Record)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.partials[partial.Node] = partial
+
+	nodes := make([]string, 0, len(c.partials))
+	for node := range c.partials {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	combined := make(map[string]interface{})
+	states := make(map[string][]PartialState)
+	// exact is whether every node reporting a field sent its State, and
+	// uncombined whether its values couldn't be combined otherwise.
+	exact := make(map[string]bool)
+	uncombined := make(map[string]bool)
+	for _, node := range nodes {
+		fields, err := recordFields(c.partials[node].Record)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range fields {
+			state, hasState := c.partials[node].States[name]
+			previous, seen := combined[name]
+			exact[name] = hasState && (exact[name] || !seen)
+			states[name] = append(states[name], state)
+
+			value, err = normalizeJSON(value)
+			if err != nil {
+				return nil, err
+			}
+			if seen {
+				var ok bool
+				value, ok = combineValues(c.Combine[name], previous, value)
+				uncombined[name] = uncombined[name] || !ok
+			}
+			combined[name] = value
+		}
+	}
+
+	for name := range combined {
+		switch {
+		case exact[name]:
+			if combined[name], err = mergeStates(states[name]); err != nil {
+				return nil, fmt.Errorf("Merging %v: %w", name, err)
+			}
+		case uncombined[name]:
+			return nil, fmt.Errorf("%v is reported by several nodes, but isn't Mergeable and has no CombineFunc", name)
+		}
+	}
+
+	for _, pair := range partial.Record.([]interface{}) {
+		name := fmt.Sprint(pair.([]interface{})[0])
+		if _, ok := order[name]; ok {
+			record = append(record, []interface{}{name, combined[name]})
+		}
+	}
+	return record, nil
+}
+
+// mergeStates merges every node's state of a field into a fresh aggregate
+// and returns its value.
+func mergeStates(states []PartialState) (value interface{}, err error) {
+	expr, err := Parse(states[0].Statement)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := mergeableField(expr)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v isn't Mergeable", ErrTypeMismatch, states[0].Statement)
+	}
+	for _, state := range states {
+		if state.Statement != states[0].Statement {
+			return nil, fmt.Errorf("%w: Nodes disagree on the statement, %v and %v", ErrTypeMismatch, states[0].Statement, state.Statement)
+		}
+		if err := m.Merge(state.State); err != nil {
+			return nil, err
+		}
+	}
+	value, err = m.Value()
+	if errors.Is(err, ErrWindowEmpty) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return normalizeJSON(value)
+}
+
+// combineValues combines two nodes' values, returning false if they're
+// numbers, or objects with numbers under the same key, and there's no
+// CombineFunc to combine them with.
+func combineValues(combine CombineFunc, a, b interface{}) (combined interface{}, ok bool) {
+	switch b := b.(type) {
+	case float64:
+		if a, isFloat := a.(float64); isFloat {
+			if combine == nil {
+				return b, false
+			}
+			return combine(a, b), true
+		}
+	case map[string]interface{}:
+		a, isMap := a.(map[string]interface{})
+		if !isMap {
+			break
+		}
+		ok = true
+		merged := make(map[string]interface{}, len(a)+len(b))
+		for key, value := range a {
+			merged[key] = value
+		}
+		for key, value := range b {
+			if previous, found := merged[key]; found {
+				combineKey := combine
+				if key == "count" {
+					combineKey = CombineSum
+				}
+				var keyOK bool
+				value, keyOK = combineValues(combineKey, previous, value)
+				ok = ok && keyOK
+			}
+			merged[key] = value
+		}
+		return merged, ok
+	case nil:
+		return a, true
+	}
+	return b, true
+}
@@ -0,0 +1,115 @@
+package oxweb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSharder(t *testing.T) {
+	nodes := []string{"a", "b", "c"}
+	owners := map[string]int{}
+	for _, node := range nodes {
+		sharder, err := NewSharder(node, nodes, "host")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ndx := 0; ndx < 300; ndx++ {
+			event := map[string]interface{}{"host": fmt.Sprintf("web%d", ndx)}
+			if len(sharder.Process(event)) == 1 {
+				owners[event["host"].(string)]++
+			}
+		}
+	}
+	if len(owners) != 300 {
+		t.Errorf("Expected every key to have an owner, got %d", len(owners))
+	}
+	for key, count := range owners {
+		if count != 1 {
+			t.Errorf("Expected %v to have exactly one owner, got %d", key, count)
+		}
+	}
+
+	if _, err := NewSharder("d", nodes, "host"); err == nil {
+		t.Errorf("Expected an error for a node outside the cluster")
+	}
+}
+
+func TestCoordinator(t *testing.T) {
+	coordinator := NewCoordinator()
+	coordinator.Combine["total"] = CombineSum
+	coordinator.Combine["peak"] = CombineMax
+
+	coordinator.Add(Partial{Node: "a", Record: []interface{}{
+		[]interface{}{"total", 5.},
+		[]interface{}{"peak", 9.},
+		[]interface{}{"byHost", GroupResult{"web1": {Value: 1., Count: 2}}},
+	}})
+	record, err := coordinator.Add(Partial{Node: "b", Record: []interface{}{
+		[]interface{}{"total", 3.},
+		[]interface{}{"peak", 4.},
+		[]interface{}{"byHost", GroupResult{"web2": {Value: 7., Count: 1}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []interface{}{
+		[]interface{}{"total", 8.},
+		[]interface{}{"peak", 9.},
+		[]interface{}{"byHost", map[string]interface{}{
+			"web1": map[string]interface{}{"value": 1., "count": 2.},
+			"web2": map[string]interface{}{"value": 7., "count": 1.},
+		}},
+	}
+	if !reflect.DeepEqual(record, expected) {
+		t.Errorf("Expected %v, got %v", expected, record)
+	}
+}
+
+// Mergeable fields are merged exactly, whatever their CombineFunc; other
+// numeric fields need one.
+func TestCoordinatorMerge(t *testing.T) {
+	fields := []string{"Count()", `As(CountDistinct(user), "users")`, "WindowAve(RollingWindow(v,10))"}
+	newQuery := func() *Query {
+		exprs := make([]Expression, len(fields))
+		for ndx, statement := range fields {
+			exprs[ndx], _ = Parse(statement)
+		}
+		return NewQuery(exprs, nil)
+	}
+	// Both nodes see the same users, which a sum would count twice.
+	partial := func(node string, query *Query, v float64) Partial {
+		var record []interface{}
+		for ndx := 0; ndx < 50; ndx++ {
+			record, _, _ = query.Evaluate(map[string]interface{}{"user": fmt.Sprintf("user%d", ndx), "v": v})
+		}
+		p, err := NewPartial(node, query, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	a := partial("a", newQuery(), 1)
+	b := partial("b", newQuery(), 3)
+
+	coordinator := NewCoordinator()
+	coordinator.Add(a)
+	if _, err := coordinator.Add(b); err == nil {
+		t.Errorf("Expected an error combining WindowAve without a CombineFunc")
+	}
+
+	coordinator.Combine[fields[2]] = CombineMax
+	record, err := coordinator.Add(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		[]interface{}{"Count()", 100.},
+		[]interface{}{"users", 50.},
+		[]interface{}{fields[2], 3.},
+	}
+	if !reflect.DeepEqual(record, expected) {
+		t.Errorf("Expected %v, got %v", expected, record)
+	}
+}
@@ -0,0 +1,74 @@
+package oxweb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Sink receives query emissions for delivery somewhere outside oxweb.
+// Write may buffer; Close flushes anything buffered and releases resources.
+type Sink interface {
+	Write(record JSONData) error
+	Close() error
+}
+
+// recordFields turns a record of [name, value] pairs into a map.
+func recordFields(record JSONData) (fields map[string]interface{}, err error) {
+	pairs, ok := record.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: Expected a record of [name, value] pairs, got %T", ErrTypeMismatch, record)
+	}
+	fields = make(map[string]interface{}, len(pairs))
+	for _, pair := range pairs {
+		nameValue, ok := pair.([]interface{})
+		if !ok || len(nameValue) != 2 {
+			return nil, fmt.Errorf("%w: Expected a [name, value] pair, got %v", ErrTypeMismatch, pair)
+		}
+		fields[fmt.Sprint(nameValue[0])] = nameValue[1]
+	}
+	return fields, nil
+}
+
+// LabelsField is the name of the pair WithLabels adds to records.
+const LabelsField = "labels"
+
+type labeledSink struct {
+	Sink
+	labels map[string]interface{}
+}
+
+// WithLabels wraps sink so every record written carries labels, as a
+// ["labels", {key: value}] pair at the end, letting whatever reads the sink
+// attribute or route records by owner, team and so on.
+func WithLabels(sink Sink, labels map[string]string) Sink {
+	if len(labels) == 0 {
+		return sink
+	}
+	labelValues := make(map[string]interface{}, len(labels))
+	for key, value := range labels {
+		labelValues[key] = value
+	}
+	return &labeledSink{sink, labelValues}
+}
+
+func (s *labeledSink) Write(record JSONData) error {
+	pairs, ok := record.([]interface{})
+	if !ok {
+		return fmt.Errorf("%w: Expected a record of [name, value] pairs, got %T", ErrTypeMismatch, record)
+	}
+	labeled := make([]interface{}, len(pairs), len(pairs)+1)
+	copy(labeled, pairs)
+	return s.Sink.Write(append(labeled, []interface{}{LabelsField, s.labels}))
+}
+
+// FormatLabels writes labels as key=value pairs, sorted by key and separated
+// by commas, for logs.
+func FormatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
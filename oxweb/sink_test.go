@@ -0,0 +1,44 @@
+package oxweb
+
+import (
+	"reflect"
+	"testing"
+)
+
+type recordingSink struct {
+	records []JSONData
+}
+
+func (s *recordingSink) Write(record JSONData) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func TestWithLabels(t *testing.T) {
+	sink := new(recordingSink)
+	labeled := WithLabels(sink, map[string]string{"team": "web"})
+
+	record := []interface{}{[]interface{}{"host", "web1"}}
+	if err := labeled.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	fields, err := recordFields(sink.records[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"host": "web1", "labels": map[string]interface{}{"team": "web"}}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected %v, got %v", expected, fields)
+	}
+	if len(record) != 1 {
+		t.Errorf("Expected the original record to be left alone, got %v", record)
+	}
+
+	if WithLabels(sink, nil) != Sink(sink) {
+		t.Error("Expected no wrapping without labels")
+	}
+}
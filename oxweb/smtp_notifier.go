@@ -0,0 +1,136 @@
+package oxweb
+
+import (
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+)
+
+// SMTPNotifier emails alerts, for low urgency alerts and digests. Subject and
+// body are text/templates over the Alert, e.g. "{{.Summary}}" or
+// "{{.Fields.host}}". With ImplicitTLS the connection is TLS from the start
+// (usually port 465); otherwise STARTTLS is used whenever the server offers
+// it. Auth is only attempted when Username is set.
+type SMTPNotifier struct {
+	Addr        string
+	Username    string
+	Password    string
+	From        string
+	To          []string
+	ImplicitTLS bool
+	TLSConfig   *tls.Config
+
+	subject *template.Template
+	body    *template.Template
+}
+
+func NewSMTPNotifier(addr, from string, to []string, subject, body string) (n *SMTPNotifier, err error) {
+	n = &SMTPNotifier{Addr: addr, From: from, To: to}
+	if n.subject, err = template.New("subject").Parse(subject); err != nil {
+		return nil, err
+	}
+	if n.body, err = template.New("body").Parse(body); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *SMTPNotifier) Notify(alert *Alert) (err error) {
+	message, err := n.message(alert)
+	if err != nil {
+		return err
+	}
+
+	client, err := n.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if n.Username != "" {
+		host, _, _ := net.SplitHostPort(n.Addr)
+		if err := client.Auth(smtp.PlainAuth("", n.Username, n.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(n.From); err != nil {
+		return err
+	}
+	for _, to := range n.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (n *SMTPNotifier) dial() (client *smtp.Client, err error) {
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		return nil, err
+	}
+	config := n.TLSConfig
+	if config == nil {
+		config = &tls.Config{ServerName: host}
+	}
+
+	if n.ImplicitTLS {
+		conn, err := tls.Dial("tcp", n.Addr, config)
+		if err != nil {
+			return nil, err
+		}
+		return smtp.NewClient(conn, host)
+	}
+
+	client, err = smtp.Dial(n.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(config); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// message renders the alert as an RFC 5322 message.
+func (n *SMTPNotifier) message(alert *Alert) (message []byte, err error) {
+	subject, err := executeTemplate(n.subject, alert)
+	if err != nil {
+		return nil, err
+	}
+	body, err := executeTemplate(n.body, alert)
+	if err != nil {
+		return nil, err
+	}
+	if alert.Resolved {
+		subject = "Resolved: " + subject
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "From: %s\r\n", n.From)
+	fmt.Fprintf(&out, "To: %s\r\n", strings.Join(n.To, ", "))
+	// Folding every line break and space run, \r included, keeps a field
+	// value from adding headers of its own; Q encoding covers non-ASCII.
+	subject = strings.Join(strings.Fields(subject), " ")
+	fmt.Fprintf(&out, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&out, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	out.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(out.String()), nil
+}
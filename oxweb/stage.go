@@ -0,0 +1,144 @@
+package oxweb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Stage transforms events on their way from a DataStream to subscribers.
+// Process may return any number of events for each one it's given, including
+// none to drop it.
+type Stage interface {
+	Process(data JSONData) []JSONData
+}
+
+// ApplyStages runs events through each stage in turn.
+func ApplyStages(events []JSONData, stages []Stage) []JSONData {
+	for _, stage := range stages {
+		next := make([]JSONData, 0, len(events))
+		for _, event := range events {
+			next = append(next, stage.Process(event)...)
+		}
+		events = next
+	}
+	return events
+}
+
+// An ExpiringStage holds events back, as Reorder does. Expire releases those
+// it has held long enough by the clock, for when its source goes quiet, and
+// Flush releases all of them, for when its source ends.
+type ExpiringStage interface {
+	Stage
+	Expire() []JSONData
+	Flush() []JSONData
+}
+
+var _ ExpiringStage = new(Reorder)
+
+// ExpireStages collects what the ExpiringStages among stages release on
+// Expire, each run through the stages after it.
+func ExpireStages(stages []Stage) []JSONData {
+	return releaseStages(stages, ExpiringStage.Expire)
+}
+
+// FlushStages collects everything the ExpiringStages among stages are holding,
+// each run through the stages after it, which may themselves be holding more.
+func FlushStages(stages []Stage) []JSONData {
+	return releaseStages(stages, ExpiringStage.Flush)
+}
+
+func releaseStages(stages []Stage, release func(ExpiringStage) []JSONData) []JSONData {
+	var events []JSONData
+	for ndx, stage := range stages {
+		events = ApplyStages(events, stages[ndx:ndx+1])
+		if expiring, ok := stage.(ExpiringStage); ok {
+			events = append(events, release(expiring)...)
+		}
+	}
+	return events
+}
+
+// setDeep returns a copy of data with the GetDeep path key set to value. Only
+// the maps along the path are copied; everything else is shared with data.
+func setDeep(key string, data JSONData, value interface{}) (result JSONData, ok bool) {
+	return setDeepKeys(strings.Split(key, "."), data, value)
+}
+
+func setDeepKeys(keys []string, data JSONData, value interface{}) (result JSONData, ok bool) {
+	object, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	copied := make(map[string]interface{}, len(object))
+	for k, v := range object {
+		copied[k] = v
+	}
+	if len(keys) == 1 {
+		copied[keys[0]] = value
+		return copied, true
+	}
+	copied[keys[0]], ok = setDeepKeys(keys[1:], object[keys[0]], value)
+	if !ok {
+		return nil, false
+	}
+	return copied, true
+}
+
+// Explode is a Stage emitting one event per element of the array at path. Each
+// derived event is a copy of the parent with the array replaced by a single
+// element, so "items.price" addresses the price of each item in turn. Events
+// without an array at path are dropped.
+type Explode struct {
+	path string
+}
+
+func NewExplode(path string) (e *Explode, err error) {
+	if path == "" {
+		return nil, fmt.Errorf("Explode expects a non-empty path")
+	}
+	return &Explode{path}, nil
+}
+
+func (e *Explode) Process(data JSONData) []JSONData {
+	value, ok := GetDeep(e.path, data)
+	if !ok {
+		return nil
+	}
+	array, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	events := make([]JSONData, 0, len(array))
+	for _, element := range array {
+		event, ok := setDeep(e.path, data, element)
+		if !ok {
+			return nil
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func (e *Explode) String() string {
+	return fmt.Sprintf("Explode(%v)", e.path)
+}
+
+// FilterStage is a Stage dropping events that don't pass all of its filters,
+// or whose filters fail to evaluate.
+type FilterStage struct {
+	Filters []Expression
+}
+
+func (f *FilterStage) Process(data JSONData) []JSONData {
+	for _, filter := range f.Filters {
+		if passes, err := filter.Evaluate(data); err != nil || passes != true {
+			return nil
+		}
+	}
+	return []JSONData{data}
+}
+
+func (f *FilterStage) String() string {
+	return fmt.Sprintf("FilterStage(%v)", f.Filters)
+}
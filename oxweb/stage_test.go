@@ -0,0 +1,180 @@
+package oxweb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var explodeJSON = `{
+	"order": {
+		"id": "abc",
+		"items": [{"price": 1.5}, {"price": 2}]
+	}
+}`
+
+func TestExplode(t *testing.T) {
+	var fixture JSONData
+	if err := json.Unmarshal([]byte(explodeJSON), &fixture); err != nil {
+		t.Fatalf("Couldn't read explodeJSON")
+	}
+
+	explode, _ := NewExplode("order.items")
+	events := ApplyStages([]JSONData{fixture}, []Stage{explode})
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	for ndx, price := range []float64{1.5, 2} {
+		if value, _ := GetDeep("order.items.price", events[ndx]); value != price {
+			t.Errorf("Event %d: expected price %v, but was %v", ndx, price, value)
+		}
+		if value, _ := GetDeep("order.id", events[ndx]); value != "abc" {
+			t.Errorf("Event %d: expected parent fields to be kept, but order.id was %v", ndx, value)
+		}
+	}
+
+	// The original event must not be modified.
+	if items, _ := GetDeep("order.items", fixture); reflect.TypeOf(items).Kind() != reflect.Slice {
+		t.Errorf("Explode modified the original event: %v", fixture)
+	}
+
+	if events := explode.Process(map[string]interface{}{"order": 1.}); len(events) != 0 {
+		t.Errorf("Expected events without the array to be dropped, got %v", events)
+	}
+}
+
+func TestReorder(t *testing.T) {
+	reorder, err := NewReorder("t", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	released := []float64{}
+	for _, ts := range []float64{100, 105, 103, 112, 108, 120, 101, 130} {
+		for _, event := range reorder.Process(map[string]interface{}{"t": ts}) {
+			value, _ := GetDeep("t", event)
+			released = append(released, value.(float64))
+		}
+	}
+	for _, event := range reorder.Flush() {
+		value, _ := GetDeep("t", event)
+		released = append(released, value.(float64))
+	}
+
+	// 101 arrives after 108 was released, so it's passed through late.
+	expected := []float64{100, 103, 105, 108, 101, 112, 120, 130}
+	if !reflect.DeepEqual(released, expected) {
+		t.Errorf("Expected %v, got %v", expected, released)
+	}
+	if stats := reorder.Stats(); stats["late"] != 1 || stats["buffered"] != 0 {
+		t.Errorf("Unexpected stats %v", stats)
+	}
+}
+
+func TestReorderExpire(t *testing.T) {
+	reorder, _ := NewReorder("t", 10*time.Second)
+	now := time.Unix(0, 0)
+	reorder.timeSource = func() time.Time { return now }
+
+	reorder.Process(map[string]interface{}{"t": 100.})
+	reorder.Process(map[string]interface{}{"t": 104.})
+	now = now.Add(5 * time.Second)
+	if events := reorder.Expire(); len(events) != 0 {
+		t.Errorf("Expected events held until delay has passed, got %v", events)
+	}
+	now = now.Add(2 * time.Second)
+	if events := reorder.Expire(); len(events) != 1 || events[0].(map[string]interface{})["t"] != 100. {
+		t.Errorf("Expected 100 released after a 7s lull, got %v", events)
+	}
+	now = now.Add(time.Hour)
+	if events := reorder.Expire(); len(events) != 1 || reorder.Stats()["buffered"] != 0 {
+		t.Errorf("Expected everything released after a long lull, got %v", events)
+	}
+	if events := reorder.Expire(); events != nil {
+		t.Errorf("Expected nothing left to expire, got %v", events)
+	}
+}
+
+func TestAdaptiveSampler(t *testing.T) {
+	sampler, err := NewSampler(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sampler.Adaptive = true
+	now := time.Unix(0, 0)
+	sampler.timeSource = func() time.Time { return now }
+
+	event := map[string]interface{}{"n": 1.}
+	if events := sampler.Process(event); len(events) != 1 || events[0].(map[string]interface{})[SampleRateKey] != nil {
+		t.Errorf("Expected unsampled events to pass untouched, got %v", events)
+	}
+
+	// Drops halve the rate each interval.
+	for _, expected := range []float64{0.5, 0.25} {
+		sampler.Dropped()
+		now = now.Add(time.Second)
+		sampler.Process(event)
+		if rate := sampler.Rate(); rate != expected {
+			t.Errorf("Expected rate %v after drops, got %v", expected, rate)
+		}
+	}
+
+	// A quiet interval raises it again.
+	now = now.Add(time.Second)
+	sampler.Process(event)
+	if rate := sampler.Rate(); rate != 0.3125 {
+		t.Errorf("Expected rate to recover to 0.3125, got %v", rate)
+	}
+
+	sampler.SetUpstream(0.1)
+	sampler.Adaptive = false
+	events := sampler.Process(event)
+	if rate, _ := GetDeep(SampleRateKey, events[0]); rate != 0.1 {
+		t.Errorf("Expected the upstream rate to be recorded, got %v", rate)
+	}
+	if _, err := NewSampler(0); err == nil {
+		t.Errorf("Expected an error for a zero rate")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	event := map[string]interface{}{
+		"user":   map[string]interface{}{"email": "a@example.com", "id": 7.},
+		"status": 200.,
+	}
+	redact, _ := NewRedact([]string{"user.email", "missing"}, nil)
+	events := redact.Process(event)
+	if len(events) != 1 {
+		t.Fatalf("Expected the event through, got %v", events)
+	}
+	if email, _ := GetDeep("user.email", events[0]); email != RedactedValue {
+		t.Errorf("Expected the email to be masked, got %v", email)
+	}
+	if _, ok := GetDeep("missing", events[0]); ok {
+		t.Errorf("Expected missing paths to stay missing")
+	}
+	if email, _ := GetDeep("user.email", event); email != "a@example.com" {
+		t.Errorf("Redact modified the original event: %v", event)
+	}
+
+	key := []byte("0123456789abcdef")
+	hashed, _ := NewRedact([]string{"user.email"}, key)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("a@example.com"))
+	if email, _ := GetDeep("user.email", hashed.Process(event)[0]); email != hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("Expected the email's HMAC, got %v", email)
+	}
+
+	inArray, _ := NewRedact([]string{"users.0.email"}, nil)
+	if events := inArray.Process(map[string]interface{}{"users": []interface{}{event["user"]}}); len(events) != 0 {
+		t.Errorf("Expected an event that can't be redacted to be dropped, got %v", events)
+	}
+
+	if _, err := NewRedact([]string{"user.email"}, []byte("salt")); err == nil {
+		t.Errorf("Expected a short key to be rejected")
+	}
+}
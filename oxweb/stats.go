@@ -0,0 +1,5 @@
+package oxweb
+
+// Stats are named counters describing the work done by a component, for
+// monitoring. Each component documents the counters it reports.
+type Stats map[string]int64
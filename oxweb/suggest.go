@@ -0,0 +1,148 @@
+package oxweb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Suggestion is a likely fix for text that didn't parse as a literal.
+type Suggestion struct {
+	// What was probably meant, e.g. "strings must be quoted".
+	Reason string
+	// The text with the fix applied, which does parse.
+	Replacement string
+}
+
+// LiteralError is the ErrParse returned for text that isn't a literal, with
+// suggestions for what was meant.
+type LiteralError struct {
+	Text        string
+	Suggestions []Suggestion
+	// Whether the text can't be a path either, so Parse reports it rather
+	// than reading it with GetDeep.
+	notPath bool
+}
+
+func (e *LiteralError) Error() string {
+	var message strings.Builder
+	message.WriteString(ErrParse.Error() + ": Couldn't parse " + e.Text + " as a literal")
+	for ndx, s := range e.Suggestions {
+		if ndx == 0 {
+			message.WriteString(". Did you mean ")
+		} else {
+			message.WriteString(", or ")
+		}
+		message.WriteString(s.Replacement + " (" + s.Reason + ")")
+	}
+	if len(e.Suggestions) > 0 {
+		message.WriteString("?")
+	}
+	return message.String()
+}
+
+func (e *LiteralError) Unwrap() error {
+	return ErrParse
+}
+
+// literalRule recognises a mistake commonly made writing a literal. Numbers
+// are written the same whatever the locale, so most are about numbers
+// written the way a locale would.
+type literalRule struct {
+	reason  string
+	notPath bool
+	fix     func(text string) (replacement string, ok bool)
+}
+
+var (
+	decimalCommaPattern = regexp.MustCompile(`^-?[0-9]+,[0-9]+$`)
+	groupedPattern      = regexp.MustCompile(`^-?[0-9]{1,3}([ ,'’_][0-9]{3})+(\.[0-9]+)?$`)
+	europeanPattern     = regexp.MustCompile(`^-?[0-9]{1,3}(\.[0-9]{3})+,[0-9]+$`)
+	spacedSignPattern   = regexp.MustCompile(`^[-+]\s+[0-9.]`)
+	bareWordPattern     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.\-]*$`)
+)
+
+var literalRules = []literalRule{
+	{"negative numbers take an ASCII '-'", true, func(text string) (string, bool) {
+		for _, minus := range []string{"−", "–", "—"} {
+			if strings.HasPrefix(text, minus) {
+				return "-" + strings.TrimPrefix(text, minus), true
+			}
+		}
+		return "", false
+	}},
+	{"negative numbers take a '-' directly before the digits", true, func(text string) (string, bool) {
+		if !spacedSignPattern.MatchString(text) {
+			return "", false
+		}
+		return strings.TrimPrefix(text[:1], "+") + strings.TrimSpace(text[1:]), true
+	}},
+	{"numbers use '.' as the decimal point", true, func(text string) (string, bool) {
+		if europeanPattern.MatchString(text) {
+			return strings.Replace(strings.ReplaceAll(text, ".", ""), ",", ".", 1), true
+		}
+		if decimalCommaPattern.MatchString(text) {
+			return strings.Replace(text, ",", ".", 1), true
+		}
+		return "", false
+	}},
+	{"numbers have no thousands separators", true, func(text string) (string, bool) {
+		if !groupedPattern.MatchString(text) {
+			return "", false
+		}
+		return strings.NewReplacer(" ", "", ",", "", "'", "", "’", "", "_", "").Replace(text), true
+	}},
+	{"strings are quoted with \" or `", true, func(text string) (string, bool) {
+		if len(text) < 2 || text[0] != '\'' || text[len(text)-1] != '\'' {
+			return "", false
+		}
+		return strconv.Quote(text[1 : len(text)-1]), true
+	}},
+	{"the closing quote is missing", true, func(text string) (string, bool) {
+		if text == "" || (text[0] != '"' && text[0] != '`') {
+			return "", false
+		}
+		return text + text[:1], true
+	}},
+	{"JSON strings take double quotes", true, func(text string) (string, bool) {
+		if !strings.HasPrefix(text, "{") && !strings.HasPrefix(text, "[") {
+			return "", false
+		}
+		return strings.ReplaceAll(text, "'", `"`), true
+	}},
+	{"strings must be quoted", false, func(text string) (string, bool) {
+		if !bareWordPattern.MatchString(text) {
+			return "", false
+		}
+		return strconv.Quote(text), true
+	}},
+}
+
+// literalError returns the error for text that isn't a literal, suggesting
+// what it might have been meant as. Only fixes that parse are suggested.
+func literalError(text string) *LiteralError {
+	e := &LiteralError{Text: text}
+	for _, rule := range literalRules {
+		replacement, ok := rule.fix(text)
+		if !ok || replacement == text {
+			continue
+		}
+		e.notPath = e.notPath || rule.notPath
+		if _, ok := parseLiteralValue(replacement); ok {
+			e.Suggestions = append(e.Suggestions, Suggestion{rule.reason, replacement})
+		}
+	}
+	return e
+}
+
+// literalArgError returns the error for an argument that had to be a literal
+// and wasn't. A bare name parses as a path, so for those, suggest quoting it.
+func literalArgError(expects string, arg Expression) error {
+	if gd, ok := arg.(*GetDeepExpression); ok {
+		if _, ok := gd.expr.(*Literal); ok {
+			return fmt.Errorf("%v: %w", expects, literalError(gd.String()))
+		}
+	}
+	return fmt.Errorf("%v, got %v", expects, arg)
+}
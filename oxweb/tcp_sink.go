@@ -0,0 +1,258 @@
+package oxweb
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// healthCheckIdle is how long a connection sits idle before it's health
+// checked again on reuse.
+var healthCheckIdle = time.Second
+
+// JSONPool keeps connections to a downstream service speaking newline
+// delimited JSON, so many goroutines can write to it at once without queuing
+// behind one connection or dialing for every message. Connections are
+// dialed as needed, up to MaxConns, and closed after IdleTimeout unused.
+type JSONPool struct {
+	Addr        string
+	MaxConns    int
+	IdleTimeout time.Duration
+	DialTimeout time.Duration
+	// HealthCheck, if set, checks a connection idle for a second or more
+	// before it's reused; one that fails is closed and another used. The
+	// default checks the service hasn't hung up.
+	HealthCheck func(conn *JSONConn) error
+
+	lock      sync.Mutex
+	idle      []*pooledConn
+	slots     chan struct{}
+	reaper    *time.Timer
+	closed    bool
+	dials     int64
+	reused    int64
+	unhealthy int64
+	retries   int64
+}
+
+type pooledConn struct {
+	raw      net.Conn
+	json     *JSONConn
+	lastUsed time.Time
+	reused   bool
+}
+
+// NewJSONPool creates a pool of up to 8 connections to addr, closed after 90
+// seconds idle, with a 5 second dial timeout.
+func NewJSONPool(addr string) *JSONPool {
+	return &JSONPool{
+		Addr:        addr,
+		MaxConns:    8,
+		IdleTimeout: 90 * time.Second,
+		DialTimeout: 5 * time.Second,
+	}
+}
+
+// Stats reports "dials", connections opened; "reused", uses of an idle
+// connection; "unhealthy", idle connections that failed their health check;
+// and "retries", uses retried on a new connection after an idle one failed.
+func (p *JSONPool) Stats() Stats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return Stats{"dials": p.dials, "reused": p.reused, "unhealthy": p.unhealthy, "retries": p.retries}
+}
+
+// Do calls use with a connection from the pool, waiting for one to be free
+// if MaxConns are in use. If use fails on a connection that had been idle,
+// which may have been closed by the service since, it's retried once on a
+// new connection. Connections use fails on aren't reused.
+func (p *JSONPool) Do(ctx context.Context, use func(conn *JSONConn) error) (err error) {
+	if err = p.acquire(ctx); err != nil {
+		return err
+	}
+	defer p.release()
+
+	conn, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	if err = use(conn.json); err != nil && conn.reused {
+		conn.raw.Close()
+		p.lock.Lock()
+		p.retries++
+		p.lock.Unlock()
+		if conn, err = p.dial(ctx); err != nil {
+			return err
+		}
+		err = use(conn.json)
+	}
+	if err != nil {
+		conn.raw.Close()
+		return err
+	}
+	p.put(conn)
+	return nil
+}
+
+func (p *JSONPool) acquire(ctx context.Context) error {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return ErrStreamClosed
+	}
+	if p.slots == nil {
+		maxConns := p.MaxConns
+		if maxConns <= 0 {
+			maxConns = 1
+		}
+		p.slots = make(chan struct{}, maxConns)
+	}
+	slots := p.slots
+	p.lock.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *JSONPool) release() {
+	<-p.slots
+}
+
+// get returns the most recently used healthy idle connection, or a new one.
+func (p *JSONPool) get(ctx context.Context) (conn *pooledConn, err error) {
+	for {
+		p.lock.Lock()
+		if len(p.idle) == 0 {
+			p.lock.Unlock()
+			return p.dial(ctx)
+		}
+		conn = p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.lock.Unlock()
+
+		idle := time.Since(conn.lastUsed)
+		healthy := p.IdleTimeout <= 0 || idle < p.IdleTimeout
+		if healthy && idle >= healthCheckIdle {
+			healthy = p.check(conn) == nil
+			if !healthy {
+				p.lock.Lock()
+				p.unhealthy++
+				p.lock.Unlock()
+			}
+		}
+		if healthy {
+			conn.reused = true
+			p.lock.Lock()
+			p.reused++
+			p.lock.Unlock()
+			return conn, nil
+		}
+		conn.raw.Close()
+	}
+}
+
+func (p *JSONPool) check(conn *pooledConn) error {
+	if p.HealthCheck != nil {
+		return p.HealthCheck(conn.json)
+	}
+	// Nothing's expected from the service, so a read that doesn't time out
+	// means it's hung up, or is saying something we don't understand.
+	conn.raw.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.raw.SetReadDeadline(time.Time{})
+	_, err := conn.json.bufConn.Peek(1)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return nil
+	}
+	if err == nil {
+		return errors.New("Unexpected data from the service")
+	}
+	return err
+}
+
+func (p *JSONPool) dial(ctx context.Context) (conn *pooledConn, err error) {
+	dialer := net.Dialer{Timeout: p.DialTimeout}
+	raw, err := dialer.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	p.dials++
+	p.lock.Unlock()
+	return &pooledConn{raw: raw, json: NewJSONConn(raw)}, nil
+}
+
+func (p *JSONPool) put(conn *pooledConn) {
+	conn.lastUsed = time.Now()
+	conn.reused = false
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		conn.raw.Close()
+		return
+	}
+	p.idle = append(p.idle, conn)
+	if p.reaper == nil && p.IdleTimeout > 0 {
+		p.reaper = time.AfterFunc(p.IdleTimeout, p.reap)
+	}
+}
+
+// reap closes connections idle for IdleTimeout, running again while any are
+// left.
+func (p *JSONPool) reap() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.reaper = nil
+	// The oldest are first.
+	expired := 0
+	for expired < len(p.idle) && time.Since(p.idle[expired].lastUsed) >= p.IdleTimeout {
+		p.idle[expired].raw.Close()
+		expired++
+	}
+	p.idle = append(p.idle[:0], p.idle[expired:]...)
+	if len(p.idle) > 0 && !p.closed {
+		p.reaper = time.AfterFunc(p.IdleTimeout-time.Since(p.idle[0].lastUsed), p.reap)
+	}
+}
+
+// Close closes idle connections, and those in use once they're done with.
+func (p *JSONPool) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.closed = true
+	if p.reaper != nil {
+		p.reaper.Stop()
+	}
+	for _, conn := range p.idle {
+		conn.raw.Close()
+	}
+	p.idle = nil
+	return nil
+}
+
+// TCPSink writes each record as a line of JSON to a downstream TCP service,
+// over a JSONPool.
+type TCPSink struct {
+	Pool *JSONPool
+}
+
+// NewTCPSink creates a sink writing to addr over a NewJSONPool.
+func NewTCPSink(addr string) *TCPSink {
+	return &TCPSink{NewJSONPool(addr)}
+}
+
+func (s *TCPSink) Write(record JSONData) error {
+	return s.Pool.Do(context.Background(), func(conn *JSONConn) error {
+		return conn.WriteJSON(record)
+	})
+}
+
+func (s *TCPSink) Close() error {
+	return s.Pool.Close()
+}
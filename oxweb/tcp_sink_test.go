@@ -0,0 +1,133 @@
+package oxweb
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lineServer collects the lines written to it, and can hang up on everyone.
+type lineServer struct {
+	listener net.Listener
+	lock     sync.Mutex
+	lines    []string
+	conns    []net.Conn
+}
+
+func newLineServer(t *testing.T) *lineServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &lineServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.conns = append(s.conns, conn)
+			s.lock.Unlock()
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					s.lock.Lock()
+					s.lines = append(s.lines, scanner.Text())
+					s.lock.Unlock()
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *lineServer) hangUp() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *lineServer) received() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.lines)
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	for deadline := time.Now().Add(time.Second); !done(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %v", what)
+		}
+	}
+}
+
+func TestTCPSink(t *testing.T) {
+	server := newLineServer(t)
+	defer server.listener.Close()
+	sink := NewTCPSink(server.listener.Addr().String())
+	sink.Pool.MaxConns = 2
+	defer sink.Close()
+
+	var wait sync.WaitGroup
+	for writer := 0; writer < 10; writer++ {
+		wait.Add(1)
+		go func(writer int) {
+			defer wait.Done()
+			for n := 0; n < 10; n++ {
+				if err := sink.Write([]interface{}{[]interface{}{"n", fmt.Sprint(writer, n)}}); err != nil {
+					t.Errorf("Write failed: %v", err)
+				}
+			}
+		}(writer)
+	}
+	wait.Wait()
+	waitFor(t, "100 lines", func() bool { return server.received() == 100 })
+	if stats := sink.Pool.Stats(); stats["dials"] > 2 {
+		t.Errorf("Expected at most 2 connections, got %v", stats)
+	}
+}
+
+func TestJSONPoolReconnects(t *testing.T) {
+	defer func(idle time.Duration) { healthCheckIdle = idle }(healthCheckIdle)
+	healthCheckIdle = 0
+
+	server := newLineServer(t)
+	defer server.listener.Close()
+	sink := NewTCPSink(server.listener.Addr().String())
+	defer sink.Close()
+
+	sink.Write([]interface{}{})
+	waitFor(t, "the first line", func() bool { return server.received() == 1 })
+	server.hangUp()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := sink.Write([]interface{}{}); err != nil {
+		t.Fatalf("Expected the pool to reconnect, got %v", err)
+	}
+	waitFor(t, "the second line", func() bool { return server.received() == 2 })
+	if stats := sink.Pool.Stats(); stats["dials"] != 2 || stats["unhealthy"] != 1 {
+		t.Errorf("Expected the hung up connection to fail its health check, got %v", stats)
+	}
+}
+
+func TestJSONPoolIdleTimeout(t *testing.T) {
+	server := newLineServer(t)
+	defer server.listener.Close()
+	pool := NewJSONPool(server.listener.Addr().String())
+	pool.IdleTimeout = 10 * time.Millisecond
+	defer pool.Close()
+
+	pool.Do(context.Background(), func(conn *JSONConn) error { return nil })
+	waitFor(t, "the idle connection to close", func() bool {
+		pool.lock.Lock()
+		defer pool.lock.Unlock()
+		return len(pool.idle) == 0
+	})
+}
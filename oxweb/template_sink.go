@@ -0,0 +1,44 @@
+package oxweb
+
+import (
+	"io"
+	"sync"
+	"text/template"
+)
+
+// TemplateSink writes each emission through a text/template, one line per
+// record, for people tailing results. The template sees the record as a map
+// from field name (or As alias) to value, so "p95={{.p95}}ms on {{.host}}"
+// works as expected; use {{index . "name with spaces"}} for other names.
+type TemplateSink struct {
+	lock     sync.Mutex
+	writer   io.Writer
+	template *template.Template
+}
+
+func NewTemplateSink(w io.Writer, text string) (s *TemplateSink, err error) {
+	tmpl, err := template.New("record").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateSink{writer: w, template: tmpl}, nil
+}
+
+func (s *TemplateSink) Write(record JSONData) (err error) {
+	fields, err := recordFields(record)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.template.Execute(s.writer, fields); err != nil {
+		return err
+	}
+	_, err = io.WriteString(s.writer, "\n")
+	return err
+}
+
+func (s *TemplateSink) Close() error {
+	return nil
+}
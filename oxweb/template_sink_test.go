@@ -0,0 +1,26 @@
+package oxweb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTemplateSink(t *testing.T) {
+	var out bytes.Buffer
+	sink, err := NewTemplateSink(&out, "p95={{.p95}}ms on {{.host}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink.Write([]interface{}{
+		[]interface{}{"host", "web1"},
+		[]interface{}{"p95", 12.5},
+	})
+	if out.String() != "p95=12.5ms on web1\n" {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+
+	if _, err := NewTemplateSink(&out, "{{.p95"); err == nil {
+		t.Errorf("Expected an error for a bad template")
+	}
+}
@@ -0,0 +1,97 @@
+package oxweb
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+ * HourOfDay(tsExpr [, location]) -> int
+ * DayOfWeek(tsExpr [, location]) -> int
+ * IsWeekend(tsExpr [, location]) -> bool
+ *
+ * Extracts the hour (0-23) or the day of the week (0 for Sunday to 6 for
+ * Saturday) from a timestamp, or whether it falls on a Saturday or Sunday, so
+ * traffic can be segmented by time patterns in filters and group keys.
+ * Timestamps are Unix seconds or RFC 3339 strings, and are taken in UTC unless
+ * an IANA location name such as "America/Los_Angeles" is given.
+ */
+type TimeOfDay struct {
+	ts       Expression
+	location Expression
+	fname    string
+}
+
+var timeOfDayParts = map[string](func(t time.Time) interface{}){
+	"HourOfDay": func(t time.Time) interface{} { return t.Hour() },
+	"DayOfWeek": func(t time.Time) interface{} { return int(t.Weekday()) },
+	"IsWeekend": func(t time.Time) interface{} { return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday },
+}
+
+func (t *TimeOfDay) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("%v expects a timestamp expression and optionally a location name", fname)
+	}
+	if _, ok := timeOfDayParts[fname]; !ok {
+		return fmt.Errorf("%v is not a supported time of day function", fname)
+	}
+	t.ts, t.fname = args[0], fname
+	if len(args) == 2 {
+		t.location = args[1]
+	}
+	return nil
+}
+
+func (t *TimeOfDay) Evaluate(data JSONData) (result interface{}, err error) {
+	value, err := t.ts.Evaluate(data)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	ts, ok := toTime(value)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v expects a timestamp, got %T, %v", ErrTypeMismatch, t.fname, value, value)
+	}
+
+	location := time.UTC
+	if t.location != nil {
+		name, err := t.location.Evaluate(data)
+		if err != nil {
+			return nil, err
+		}
+		nameStr, ok := name.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: %v expects a location name, got %T, %v", ErrTypeMismatch, t.fname, name, name)
+		}
+		if location, err = time.LoadLocation(nameStr); err != nil {
+			return nil, err
+		}
+	}
+	return timeOfDayParts[t.fname](ts.In(location)), nil
+}
+
+func (t *TimeOfDay) String() string {
+	if t.location != nil {
+		return fmt.Sprintf("%v(%v,%v)", t.fname, t.ts, t.location)
+	}
+	return fmt.Sprintf("%v(%v)", t.fname, t.ts)
+}
+
+/*
+ * Now() -> float64
+ *
+ * The current time in Unix seconds, e.g. for the age of an event with
+ * Subtract(Now(), time).
+ */
+type Now struct{}
+
+func (n *Now) Setup(fname string, args []Expression) (err error) {
+	return checkArity(fname, args, 0, 0)
+}
+
+func (n *Now) Evaluate(data JSONData) (result interface{}, err error) {
+	return float64(time.Now().UnixNano()) / float64(time.Second), nil
+}
+
+func (n *Now) String() string {
+	return "Now()"
+}
@@ -0,0 +1,44 @@
+package oxweb
+
+import (
+	"testing"
+)
+
+var timeOfDayTests = []struct {
+	statement string
+	expected  interface{}
+	ok        bool
+}{
+	{"HourOfDay(ts)", 22, true},
+	{"DayOfWeek(ts)", 2, true},
+	{"IsWeekend(ts)", false, true},
+	{"HourOfDay(stamp)", 1, true},
+	{"DayOfWeek(stamp)", 6, true},
+	{"IsWeekend(stamp)", true, true},
+	{`HourOfDay(ts, "UTC")`, 22, true},
+	{"HourOfDay(missing)", nil, true},
+	{"HourOfDay(host)", nil, false},
+	{`HourOfDay(ts, "Nowhere/Special")`, nil, false},
+}
+
+func TestTimeOfDay(t *testing.T) {
+	data := map[string]interface{}{
+		"ts":    1700000000., // Tuesday 2023-11-14 22:13:20 UTC
+		"stamp": "2023-11-18T10:00:00+09:00",
+		"host":  "web1",
+	}
+	for _, test := range timeOfDayTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatalf("For statement %q, unexpected parse error %v", test.statement, err)
+		}
+		result, err := expr.Evaluate(data)
+		if test.ok != (err == nil) {
+			t.Errorf("For statement %q, unexpected error %v", test.statement, err)
+			continue
+		}
+		if test.ok && result != test.expected {
+			t.Errorf("For statement %q, expected %v, got %v", test.statement, test.expected, result)
+		}
+	}
+}
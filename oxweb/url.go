@@ -0,0 +1,100 @@
+package oxweb
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// evaluateURL evaluates expr to a parsed URL, or nil if it evaluates to nil.
+func evaluateURL(expr Expression, data JSONData) (u *url.URL, err error) {
+	value, err := expr.Evaluate(data)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	rawURL, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: Expected a URL string, got %T, %v", ErrTypeMismatch, value, value)
+	}
+	if u, err = url.Parse(rawURL); err != nil {
+		return nil, fmt.Errorf("%w: Couldn't parse URL %q: %v", ErrTypeMismatch, rawURL, err)
+	}
+	return u, nil
+}
+
+/*
+ * UrlPath(string) -> string
+ * UrlHost(string) -> string
+ *
+ * Returns the path or host name (without port) of a URL, or nil for a nil URL.
+ */
+type URLPart struct {
+	expr  Expression
+	fname string
+}
+
+func (u *URLPart) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("%v expects one argument, an expression evaluating to a URL", fname)
+	}
+	u.expr = args[0]
+	u.fname = fname
+	return nil
+}
+
+func (u *URLPart) Evaluate(data JSONData) (result interface{}, err error) {
+	parsed, err := evaluateURL(u.expr, data)
+	if parsed == nil {
+		return nil, err
+	}
+	if u.fname == "UrlHost" {
+		return parsed.Hostname(), nil
+	}
+	return parsed.Path, nil
+}
+
+func (u *URLPart) String() string {
+	return fmt.Sprintf("%v(%v)", u.fname, u.expr)
+}
+
+/*
+ * UrlQueryParam(string, string) -> string
+ *
+ * Returns the first value of the named query parameter of a URL, or nil if
+ * the parameter or the URL isn't present.
+ */
+type URLQueryParam struct {
+	expr Expression
+	name Expression
+}
+
+func (u *URLQueryParam) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 {
+		return fmt.Errorf("UrlQueryParam expects a URL expression and a parameter name")
+	}
+	u.expr, u.name = args[0], args[1]
+	return nil
+}
+
+func (u *URLQueryParam) Evaluate(data JSONData) (result interface{}, err error) {
+	parsed, err := evaluateURL(u.expr, data)
+	if parsed == nil {
+		return nil, err
+	}
+	value, err := u.name.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	name, ok := value.(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("%w: UrlQueryParam expects a non-empty string parameter name. Was type %T \"%v\"", ErrTypeMismatch, value, value)
+	}
+	values, ok := parsed.Query()[name]
+	if !ok || len(values) == 0 {
+		return nil, nil
+	}
+	return values[0], nil
+}
+
+func (u *URLQueryParam) String() string {
+	return fmt.Sprintf("UrlQueryParam(%v,%v)", u.expr, u.name)
+}
@@ -0,0 +1,58 @@
+package oxweb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var urlTests = []struct {
+	statement string
+	result    interface{}
+	err       error
+}{
+	{"UrlPath(url)", "/search/results", nil},
+	{"UrlHost(url)", "example.com", nil},
+	{`UrlQueryParam(url, "q")`, "cats dogs", nil},
+	{`UrlQueryParam(url, "page")`, "2", nil},
+	{`UrlQueryParam(url, "missing")`, nil, nil},
+	{"UrlPath(relative)", "/about", nil},
+	{"UrlHost(relative)", "", nil},
+	{`UrlQueryParam(relative, "q")`, nil, nil},
+	{"UrlPath(status)", nil, ErrTypeMismatch},
+	{"UrlHost(missing)", nil, nil},
+	{`UrlQueryParam(missing, "q")`, nil, nil},
+	{"UrlHost(bad)", nil, ErrTypeMismatch},
+	{`UrlQueryParam(url, "")`, nil, ErrTypeMismatch},
+	{"UrlQueryParam(url, 1)", nil, ErrTypeMismatch},
+}
+
+func TestURLExpressions(t *testing.T) {
+	event := map[string]interface{}{
+		"url":      "https://example.com:8443/search/results?q=cats+dogs&page=2&page=3",
+		"relative": "/about",
+		"status":   200.,
+		"bad":      "http://[::1",
+	}
+	for _, test := range urlTests {
+		expr, err := Parse(test.statement)
+		if err != nil {
+			t.Fatalf("Couldn't parse %v: %v", test.statement, err)
+		}
+		result, err := expr.Evaluate(event)
+		if test.err != nil && !errors.Is(err, test.err) || test.err == nil && err != nil {
+			t.Errorf("For %v, expected error %v, got %v", test.statement, test.err, err)
+		}
+		if result != test.result {
+			t.Errorf("For %v, expected %v, got %v", test.statement, test.result, result)
+		}
+	}
+
+	expr, _ := Parse("UrlQueryParam(url, 1)")
+	if _, err := expr.Evaluate(event); err == nil || !strings.Contains(err.Error(), `Was type int "1"`) {
+		t.Errorf("Expected the name's own type reported, got %v", err)
+	}
+	if _, err := Parse("UrlPath(url, path)"); err == nil {
+		t.Error("Expected an arity error")
+	}
+}
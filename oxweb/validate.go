@@ -0,0 +1,257 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// A DeadLetter is an event quarantined rather than delivered, with the
+// reason it was rejected.
+type DeadLetter struct {
+	Time  time.Time `json:"time"`
+	Event JSONData  `json:"event"`
+	Error string    `json:"error"`
+}
+
+// JSONSchema is the subset of JSON Schema that Validate checks: type (a name
+// or a list of names), enum, properties, required, additionalProperties
+// (true or false only), items, minimum, maximum, minLength, maxLength and
+// pattern. Other keywords are ignored.
+type JSONSchema struct {
+	Type                 interface{}            `json:"type,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// ParseJSONSchema decodes a JSON Schema document, compiling its patterns.
+func ParseJSONSchema(encoded []byte) (schema *JSONSchema, err error) {
+	if err = json.Unmarshal(encoded, &schema); err != nil {
+		return nil, err
+	}
+	if err = schema.compile(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// LoadJSONSchema reads a JSON Schema document from a file.
+func LoadJSONSchema(path string) (schema *JSONSchema, err error) {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJSONSchema(encoded)
+}
+
+func (s *JSONSchema) compile() (err error) {
+	if s == nil {
+		return nil
+	}
+	if s.Pattern != "" {
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return err
+		}
+	}
+	for _, property := range s.Properties {
+		if err = property.compile(); err != nil {
+			return err
+		}
+	}
+	return s.Items.compile()
+}
+
+// jsonType names the JSON Schema type of a decoded JSON value.
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == float64(int64(value)) {
+			return "integer"
+		}
+		return "number"
+	case int, int64, uint64:
+		return "integer"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func (s *JSONSchema) allowsType(value interface{}) bool {
+	var types []interface{}
+	switch t := s.Type.(type) {
+	case nil:
+		return true
+	case string:
+		types = []interface{}{t}
+	case []interface{}:
+		types = t
+	}
+	actual := jsonType(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks value against the schema, returning the first violation
+// found, prefixed with the GetDeep path where it was found.
+func (s *JSONSchema) Validate(value interface{}) (err error) {
+	return s.validate("", value)
+}
+
+func (s *JSONSchema) validate(path string, value interface{}) (err error) {
+	fail := func(format string, args ...interface{}) error {
+		message := fmt.Sprintf(format, args...)
+		if path == "" {
+			return fmt.Errorf("%w: %v", ErrTypeMismatch, message)
+		}
+		return fmt.Errorf("%w: %v: %v", ErrTypeMismatch, path, message)
+	}
+
+	if !s.allowsType(value) {
+		return fail("expected %v, got %v", s.Type, jsonType(value))
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			found = found || reflect.DeepEqual(allowed, value)
+		}
+		if !found {
+			return fail("%v is not one of %v", value, s.Enum)
+		}
+	}
+
+	switch value := value.(type) {
+	case float64, int64, uint64:
+		number, _ := toFloat(value)
+		if s.Minimum != nil && number < *s.Minimum {
+			return fail("%v is less than the minimum %v", value, *s.Minimum)
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			return fail("%v is more than the maximum %v", value, *s.Maximum)
+		}
+	case string:
+		length := len([]rune(value))
+		if s.MinLength != nil && length < *s.MinLength {
+			return fail("shorter than %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fail("longer than %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			return fail("%q doesn't match %v", value, s.Pattern)
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for ndx, item := range value {
+				if err := s.Items.validate(joinPath(path, fmt.Sprint(ndx)), item); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				return fail("missing required property %v", name)
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				// The stream's Envelope isn't part of the producer's event.
+				_, isEnvelope := value[name].(*Envelope)
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties && !(path == "" && isEnvelope) {
+					return fail("unexpected property %v", name)
+				}
+				continue
+			}
+			if err := property.validate(joinPath(path, name), value[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// Validate is a Stage passing only events valid against a JSONSchema, so
+// malformed producer output is quarantined before it reaches queries.
+// Invalid events are sent to DeadLetters, if it's set, with the validation
+// error. Sends never block; dead letters are dropped if the channel is full.
+type Validate struct {
+	Schema      *JSONSchema
+	DeadLetters chan *DeadLetter
+
+	valid   atomic.Int64
+	invalid atomic.Int64
+}
+
+func NewValidate(schema *JSONSchema) (v *Validate, err error) {
+	if schema == nil {
+		return nil, fmt.Errorf("Validate expects a schema")
+	}
+	return &Validate{Schema: schema}, nil
+}
+
+func (v *Validate) Process(data JSONData) []JSONData {
+	err := v.Schema.Validate(data)
+	if err == nil {
+		v.valid.Add(1)
+		return []JSONData{data}
+	}
+
+	v.invalid.Add(1)
+	if v.DeadLetters != nil {
+		select {
+		case v.DeadLetters <- &DeadLetter{time.Now(), data, err.Error()}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Stats reports the number of valid and invalid events seen.
+func (v *Validate) Stats() Stats {
+	return Stats{"valid": v.valid.Load(), "invalid": v.invalid.Load()}
+}
+
+func (v *Validate) String() string {
+	return "Validate()"
+}
@@ -0,0 +1,73 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testJSONSchema = `{
+	"type": "object",
+	"required": ["status", "latency"],
+	"additionalProperties": false,
+	"properties": {
+		"status": {"type": "integer", "enum": [200, 404, 500]},
+		"latency": {"type": "number", "minimum": 0},
+		"host": {"type": "string", "pattern": "^web[0-9]+$"},
+		"tags": {"type": "array", "items": {"type": "string", "maxLength": 8}}
+	}
+}`
+
+var validateTests = []struct {
+	event string
+	err   string
+}{
+	{`{"status": 200, "latency": 1.5, "host": "web1", "tags": ["a"]}`, ""},
+	{`{"status": 201, "latency": 1.5}`, "not one of"},
+	{`{"status": 200}`, "missing required property latency"},
+	{`{"status": 200, "latency": -1}`, "latency: -1 is less than the minimum 0"},
+	{`{"status": 200, "latency": "fast"}`, "latency: expected number, got string"},
+	{`{"status": 200, "latency": 1, "host": "db1"}`, "doesn't match"},
+	{`{"status": 200, "latency": 1, "tags": ["ok", "much too long"]}`, "tags.1: longer than 8"},
+	{`{"status": 200, "latency": 1, "extra": true}`, "unexpected property extra"},
+	// Only an Envelope the stream attached is exempt.
+	{`{"status": 200, "latency": 1, "_meta": {"team": "web"}}`, "unexpected property _meta"},
+}
+
+func TestValidate(t *testing.T) {
+	schema, err := ParseJSONSchema([]byte(testJSONSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage, _ := NewValidate(schema)
+	stage.DeadLetters = make(chan *DeadLetter, len(validateTests))
+
+	for _, test := range validateTests {
+		var event JSONData
+		if err := json.Unmarshal([]byte(test.event), &event); err != nil {
+			t.Fatal(err)
+		}
+		events := stage.Process(event)
+		if test.err == "" {
+			if len(events) != 1 {
+				t.Errorf("For %v, expected the event to pass", test.event)
+			}
+			continue
+		}
+		if len(events) != 0 {
+			t.Errorf("For %v, expected the event to be dropped", test.event)
+			continue
+		}
+		deadLetter := <-stage.DeadLetters
+		if !strings.Contains(deadLetter.Error, test.err) {
+			t.Errorf("For %v, expected an error containing %q, got %q", test.event, test.err, deadLetter.Error)
+		}
+	}
+	if stats := stage.Stats(); stats["valid"] != 1 || stats["invalid"] != int64(len(validateTests)-1) {
+		t.Errorf("Unexpected stats %v", stats)
+	}
+	if err := schema.Validate("nope"); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected a type mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package oxweb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// A WAL is a write-ahead log of query emissions for sinks that mustn't lose
+// any. Records are appended and synced to disk before delivery, and
+// acknowledged once the sink has them; on restart, Pending lists those never
+// acknowledged so they can be delivered again. Delivery is at-least-once:
+// sinks may see a record twice if a crash lands between delivery and Ack.
+type WAL struct {
+	lock    sync.Mutex
+	path    string
+	file    *os.File
+	nextSeq uint64
+	pending map[uint64]JSONData
+	// lines is the number of lines in the log since it was last compacted.
+	lines int
+}
+
+// walCompactLines is how long the log may grow before it's compacted while
+// records are still pending, as long as most of it has been acknowledged. A
+// sink that's always a record or two behind would otherwise never let it
+// shrink.
+var walCompactLines = 10000
+
+// WALEntry is a record awaiting acknowledgement.
+type WALEntry struct {
+	Seq    uint64
+	Record JSONData
+}
+
+// walLine is how appends and acks are written, one per line.
+type walLine struct {
+	Seq    uint64   `json:"seq"`
+	Record JSONData `json:"record,omitempty"`
+	Ack    bool     `json:"ack,omitempty"`
+}
+
+// OpenWAL opens the log at path, creating it if necessary, and reads back any
+// records still pending from a previous run. A torn final line, as left by a
+// crash mid-write, is ignored. Any other line that can't be read is an
+// ErrDecode, and the log is left as it was, rather than losing the records
+// after it.
+func OpenWAL(path string) (w *WAL, err error) {
+	w = &WAL{path: path, pending: make(map[uint64]JSONData)}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	// A bad line is only an error if there's another after it.
+	var torn error
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if torn != nil {
+			file.Close()
+			return nil, torn
+		}
+		var line walLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			torn = fmt.Errorf("%w: %v line %d: %v", ErrDecode, path, lineNo, err)
+			continue
+		}
+		if line.Ack {
+			delete(w.pending, line.Seq)
+		} else {
+			w.pending[line.Seq] = line.Record
+		}
+		if line.Seq >= w.nextSeq {
+			w.nextSeq = line.Seq + 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	file.Close()
+
+	// Rewrite the log with just the pending records, which also drops any
+	// torn line.
+	if err := w.compact(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Append durably logs record and returns the sequence number to Ack it with.
+func (w *WAL) Append(record JSONData) (seq uint64, err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	seq = w.nextSeq
+	if err := w.write(walLine{Seq: seq, Record: record}); err != nil {
+		return 0, err
+	}
+	w.nextSeq++
+	w.pending[seq] = record
+	return seq, nil
+}
+
+// Ack marks a record as delivered. Once nothing is pending, or the log has
+// grown long with acknowledged records, it's compacted.
+func (w *WAL) Ack(seq uint64) (err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if _, ok := w.pending[seq]; !ok {
+		return fmt.Errorf("WAL has no pending record %d", seq)
+	}
+	if err := w.write(walLine{Seq: seq, Ack: true}); err != nil {
+		return err
+	}
+	delete(w.pending, seq)
+	if len(w.pending) == 0 || (w.lines >= walCompactLines && w.lines > 2*len(w.pending)) {
+		return w.compact()
+	}
+	return nil
+}
+
+// Pending returns unacknowledged records, oldest first.
+func (w *WAL) Pending() []WALEntry {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.pendingEntries()
+}
+
+// Replay delivers every pending record in order, acknowledging each as
+// deliver succeeds. It stops at the first failure, leaving the rest pending.
+func (w *WAL) Replay(deliver func(record JSONData) error) (err error) {
+	for _, entry := range w.Pending() {
+		if err := deliver(entry.Record); err != nil {
+			return err
+		}
+		if err := w.Ack(entry.Seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WAL) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.file.Close()
+}
+
+func (w *WAL) pendingEntries() []WALEntry {
+	entries := make([]WALEntry, 0, len(w.pending))
+	for seq, record := range w.pending {
+		entries = append(entries, WALEntry{seq, record})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	return entries
+}
+
+func (w *WAL) write(line walLine) (err error) {
+	encoded, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Write(append(encoded, '\n')); err != nil {
+		return err
+	}
+	w.lines++
+	return w.file.Sync()
+}
+
+// compact rewrites the log to hold only pending records, via a temporary file
+// so a crash part way leaves the old log intact.
+func (w *WAL) compact() (err error) {
+	tmpPath := w.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(tmp)
+	for _, entry := range w.pendingEntries() {
+		if err := encoder.Encode(walLine{Seq: entry.Seq, Record: entry.Record}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		return err
+	}
+
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.lines = len(w.pending)
+	w.file, err = os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+type walSink struct {
+	Sink
+	wal  *WAL
+	lock sync.Mutex
+}
+
+// WithWAL wraps sink so each record is logged to the WAL at path before it's
+// written, and acknowledged once the sink's Write returns. Records left
+// pending, by a failed Write or a previous run, are written again before the
+// next new one, in order. A record only counts as delivered when Write
+// returns, so wrap sinks that deliver before returning, like a TCPSink or an
+// unbatched WebhookSink.
+func WithWAL(sink Sink, path string) (Sink, error) {
+	wal, err := OpenWAL(path)
+	if err != nil {
+		return nil, err
+	}
+	// If the sink's still down, these are tried again on the next Write.
+	wal.Replay(sink.Write)
+	return &walSink{Sink: sink, wal: wal}, nil
+}
+
+func (s *walSink) Write(record JSONData) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.wal.Append(record); err != nil {
+		return err
+	}
+	return s.wal.Replay(s.Sink.Write)
+}
+
+func (s *walSink) Close() (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err = s.Sink.Close()
+	if walErr := s.wal.Close(); err == nil {
+		err = walErr
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package oxweb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWALReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "emissions.wal")
+
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []float64{1, 2, 3} {
+		if _, err := wal.Append(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wal.Ack(0)
+	wal.Close()
+
+	// Reopening, as after a crash, finds the unacknowledged records.
+	wal, err = OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	delivered := []JSONData{}
+	failure := errors.New("Sink down")
+	err = wal.Replay(func(record JSONData) error {
+		if record == 3. {
+			return failure
+		}
+		delivered = append(delivered, record)
+		return nil
+	})
+	if err != failure || len(delivered) != 1 || delivered[0] != 2. {
+		t.Errorf("Expected to deliver 2 then fail, got %v, %v", delivered, err)
+	}
+	if pending := wal.Pending(); len(pending) != 1 || pending[0].Seq != 2 {
+		t.Errorf("Expected record 2 to still be pending, got %v", pending)
+	}
+
+	// Sequence numbers carry on from before the restart.
+	if seq, _ := wal.Append(4.); seq != 3 {
+		t.Errorf("Expected sequence 3, got %d", seq)
+	}
+}
+
+func TestWALBadLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "emissions.wal")
+	good := "{\"seq\":0,\"record\":1}\n{\"seq\":1,\"record\":2}\n"
+
+	// A torn final line is dropped.
+	os.WriteFile(path, []byte(good+"{\"seq\":2,\"rec"), 0644)
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := wal.Pending(); len(pending) != 2 {
+		t.Errorf("Expected the 2 whole records pending, got %v", pending)
+	}
+	wal.Close()
+
+	// A bad line anywhere else is an error, and the records after it are kept.
+	corrupt := "{\"seq\":0,\"record\":1}\ngarbage\n{\"seq\":1,\"record\":2}\n"
+	os.WriteFile(path, []byte(corrupt), 0644)
+	if _, err := OpenWAL(path); !errors.Is(err, ErrDecode) {
+		t.Errorf("Expected ErrDecode, got %v", err)
+	}
+	if contents, _ := os.ReadFile(path); string(contents) != corrupt {
+		t.Errorf("Expected the log left alone, got %q", contents)
+	}
+}
+
+func TestWALCompactsAckedPrefix(t *testing.T) {
+	defer func(lines int) { walCompactLines = lines }(walCompactLines)
+	walCompactLines = 10
+
+	path := filepath.Join(t.TempDir(), "emissions.wal")
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	// One record stays pending throughout, as behind a sink that lags.
+	wal.Append(0.)
+	for v := 1; v <= 20; v++ {
+		seq, _ := wal.Append(float64(v))
+		wal.Ack(seq)
+	}
+	if wal.lines >= walCompactLines {
+		t.Errorf("Expected the log to be compacted around the pending record, has %d lines", wal.lines)
+	}
+	if pending := wal.Pending(); len(pending) != 1 || pending[0].Seq != 0 {
+		t.Errorf("Expected record 0 to still be pending, got %v", pending)
+	}
+}
+
+type flakySink struct {
+	down    bool
+	written []JSONData
+}
+
+func (s *flakySink) Write(record JSONData) error {
+	if s.down {
+		return errors.New("Sink down")
+	}
+	s.written = append(s.written, record)
+	return nil
+}
+
+func (s *flakySink) Close() error {
+	return nil
+}
+
+func TestWithWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "emissions.wal")
+	sink := &flakySink{down: true}
+	durable, err := WithWAL(sink, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := durable.Write(1.); err == nil {
+		t.Errorf("Expected the sink's error")
+	}
+	durable.Close()
+
+	// After a restart, the record the sink missed is delivered first.
+	sink.down = false
+	durable, err = WithWAL(sink, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer durable.Close()
+	if err := durable.Write(2.); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.written) != 2 || sink.written[0] != 1. || sink.written[1] != 2. {
+		t.Errorf("Expected 1 then 2, got %v", sink.written)
+	}
+}
@@ -0,0 +1,142 @@
+package oxweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WebhookSink POSTs emissions as JSON to a URL. With BatchSize above 1,
+// records are collected and sent as a JSON array once enough have been
+// written, or on Flush or Close. Failed POSTs, including non-2xx responses,
+// are retried with exponential backoff.
+type WebhookSink struct {
+	URL        string
+	BatchSize  int
+	MaxRetries int
+	Backoff    time.Duration
+	Client     *http.Client
+	// MaxHeld is the most records held while paused. Writes beyond it fail
+	// and are counted as dropped. 0 holds any number.
+	MaxHeld int
+
+	lock    sync.Mutex
+	batch   []JSONData
+	paused  bool
+	dropped atomic.Int64
+
+	// Limits the number of POSTs in flight across all writers.
+	inFlight chan struct{}
+}
+
+// NewWebhookSink creates a sink posting each record on its own, with a 10
+// second timeout, at most 4 concurrent requests, 3 retries starting at 100ms
+// and up to 10000 records held while paused.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		URL:        url,
+		BatchSize:  1,
+		MaxRetries: 3,
+		Backoff:    100 * time.Millisecond,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		MaxHeld:    10000,
+		inFlight:   make(chan struct{}, 4),
+	}
+}
+
+// SetConcurrency changes how many POSTs may be in flight at once. It must be
+// called before the sink is used.
+func (s *WebhookSink) SetConcurrency(limit int) {
+	s.inFlight = make(chan struct{}, limit)
+}
+
+func (s *WebhookSink) Write(record JSONData) (err error) {
+	s.lock.Lock()
+	if s.BatchSize <= 1 && !s.paused {
+		s.lock.Unlock()
+		return s.post(record)
+	}
+
+	if s.paused && s.MaxHeld > 0 && len(s.batch) >= s.MaxHeld {
+		s.lock.Unlock()
+		s.dropped.Add(1)
+		return fmt.Errorf("WebhookSink %v is paused and already holding %d records", s.URL, s.MaxHeld)
+	}
+	s.batch = append(s.batch, record)
+	if len(s.batch) < s.BatchSize || s.paused {
+		s.lock.Unlock()
+		return nil
+	}
+	batch := s.batch
+	s.batch = nil
+	s.lock.Unlock()
+
+	return s.post(batch)
+}
+
+// Stats reports "dropped", the records refused while paused because MaxHeld
+// were already held.
+func (s *WebhookSink) Stats() Stats {
+	return Stats{"dropped": s.dropped.Load()}
+}
+
+// Flush sends any partial batch, unless the sink is paused.
+func (s *WebhookSink) Flush() (err error) {
+	s.lock.Lock()
+	if s.paused {
+		s.lock.Unlock()
+		return nil
+	}
+	batch := s.batch
+	s.batch = nil
+	s.lock.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.post(batch)
+}
+
+// Close sends everything written, resuming the sink if it's paused.
+func (s *WebhookSink) Close() error {
+	if err := s.Resume(); err != nil {
+		return err
+	}
+	return s.Flush()
+}
+
+func (s *WebhookSink) post(payload interface{}) (err error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	s.inFlight <- struct{}{}
+	defer func() { <-s.inFlight }()
+
+	backoff := s.Backoff
+	for attempt := 0; ; attempt++ {
+		err = s.postOnce(body)
+		if err == nil || attempt >= s.MaxRetries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *WebhookSink) postOnce(body []byte) (err error) {
+	response, err := s.Client.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("Webhook %v returned %v", s.URL, response.Status)
+	}
+	return nil
+}
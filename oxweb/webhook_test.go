@@ -0,0 +1,59 @@
+package oxweb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookSinkBatchingAndRetry(t *testing.T) {
+	var lock sync.Mutex
+	requests := 0
+	batches := [][]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []interface{}
+		json.NewDecoder(r.Body).Decode(&batch)
+		batches = append(batches, batch)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL)
+	sink.BatchSize = 2
+	sink.Backoff = time.Millisecond
+	for _, v := range []float64{1, 2, 3} {
+		if err := sink.Write(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 3 || len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Errorf("Expected a retried batch of 2 then a batch of 1, got %d requests: %v", requests, batches)
+	}
+}
+
+func TestWebhookSinkGivesUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL)
+	sink.MaxRetries = 1
+	sink.Backoff = time.Millisecond
+	if err := sink.Write(1.); err == nil {
+		t.Errorf("Expected an error once retries ran out")
+	}
+}
@@ -2,7 +2,11 @@ package oxweb
 
 import (
 	"container/list"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"sort"
 	"time"
 )
 
@@ -11,24 +15,128 @@ type Window interface {
 	Push(element interface{}, wSize int) (err error)
 	Len() int
 	SetListener(l WindowListener)
+	// Stats reports "pushed", the number of values pushed, and "skipped_nils",
+	// the number of nil values skipped under the "skip" nil policy.
+	Stats() Stats
+}
+
+// How windows treat an expression evaluating to nil, given as an optional
+// last argument, e.g. RollingWindow(latency, 100, "zero").
+const (
+	// NilSkip ignores nil values. This is the default.
+	NilSkip = "skip"
+	// NilZero pushes 0 in place of nil.
+	NilZero = "zero"
+	// NilError returns an error for nil values.
+	NilError = "error"
+)
+
+// nilPolicy evaluates a window's nil policy and applies it to value,
+// returning the value to push, if any.
+func nilPolicy(policy Expression, value interface{}, data JSONData) (push interface{}, ok bool, err error) {
+	name := interface{}(NilSkip)
+	if policy != nil {
+		if name, err = policy.Evaluate(data); err != nil {
+			return nil, false, err
+		}
+	}
+	switch {
+	case name != NilSkip && name != NilZero && name != NilError:
+		// Checked for every value, so a bad policy shows up before the
+		// first nil does.
+		return nil, false, fmt.Errorf("%v is not a nil policy, expected skip, zero or error", name)
+	case value != nil:
+		return value, true, nil
+	case name == NilZero:
+		return 0., true, nil
+	case name == NilError:
+		return nil, false, fmt.Errorf("%w: Window value was nil", ErrTypeMismatch)
+	}
+	return nil, false, nil
 }
 
 type windowCallback func(val interface{}) (err error)
 
+// WindowListener is told of each element a window pushes and evicts, so it
+// can keep a running aggregate. Pushes are transactional: a listener that
+// returns an error from Push must leave itself unchanged, and the window then
+// drops the element, so the two never disagree about what's in the window.
+// Pop is only called with elements the listener accepted.
 type WindowListener interface {
 	Push(element interface{}) (err error)
 	Pop(element interface{}) (err error)
 }
 
+// A Recomputer is a WindowListener that can start over from the values in
+// its window, oldest first. Windows ask it to after their size shrinks, after
+// a Pop fails and so may have left it out of step, and every so often anyway,
+// so float error in running sums doesn't build up forever.
+type Recomputer interface {
+	WindowListener
+	Recompute(values []interface{}) (err error)
+}
+
+// recomputeEvery is the fewest pushes between a window's routine
+// recomputes. Windows longer than this go their length between recomputes,
+// which keeps the cost to a constant per push.
+var recomputeEvery = 100000
+
+func recomputeDue(pushes, length int) bool {
+	return pushes >= recomputeEvery && pushes >= length
+}
+
+// recompute asks listener to recompute from values, if it's a Recomputer.
+// cause is the error that may have put it out of step, which is returned if
+// it can't.
+func recompute(listener WindowListener, values func() []interface{}, cause error) (err error) {
+	r, ok := listener.(Recomputer)
+	if !ok {
+		return cause
+	}
+	return r.Recompute(values())
+}
+
 type SingleWindowListenerStruct struct {
 	window Window
 }
 
+// evaluateWindowSize evaluates a window size, accepting any integral number
+// since sizes taken from events arrive as float64.
+func evaluateWindowSize(expr Expression, data JSONData, expects string) (size int, err error) {
+	value, err := expr.Evaluate(data)
+	if err != nil {
+		return 0, err
+	}
+	switch value := value.(type) {
+	case int:
+		size = value
+	case int64:
+		size = int(value)
+	case float64:
+		if value != math.Trunc(value) {
+			return 0, fmt.Errorf("%w: %v. Got non-integral %v", ErrTypeMismatch, expects, value)
+		}
+		size = int(value)
+	default:
+		return 0, fmt.Errorf("%w: %v. Got a %T, %v", ErrTypeMismatch, expects, value, value)
+	}
+	if size <= 0 {
+		return 0, fmt.Errorf("%w: %v. Got %v", ErrTypeMismatch, expects, size)
+	}
+	return size, nil
+}
+
 type RollingWindow struct {
-	expr       Expression
-	windowList list.List
-	windowSize Expression
-	listener   WindowListener
+	expr        Expression
+	windowList  list.List
+	windowSize  Expression
+	nilPolicy   Expression
+	listener    WindowListener
+	pushed      int64
+	skippedNils int64
+	// The size last trimmed to, and pushes since the listener recomputed.
+	lastSize     int
+	unrecomputed int
 }
 
 var _ Window = new(RollingWindow)
@@ -37,16 +145,26 @@ func (rw *RollingWindow) Len() int {
 	return rw.windowList.Len()
 }
 
+func (rw *RollingWindow) Stats() Stats {
+	return Stats{"pushed": rw.pushed, "skipped_nils": rw.skippedNils}
+}
+
 func (rw *RollingWindow) String() string {
+	if rw.nilPolicy != nil {
+		return fmt.Sprintf("RollingWindow(%v,%v,%v)", rw.expr, rw.windowSize, rw.nilPolicy)
+	}
 	return fmt.Sprintf("RollingWindow(%v,%v)", rw.expr, rw.windowSize)
 }
 
 func (rw *RollingWindow) Setup(fname string, args []Expression) (err error) {
-	if len(args) != 2 {
-		return fmt.Errorf("RollingWindow must have 2 args, the element and a positive int window size. Got %v", args)
+	if len(args) != 2 && len(args) != 3 {
+		return fmt.Errorf("RollingWindow must have 2 args, the element and a positive int window size, and optionally a nil policy. Got %v", args)
 	}
 	rw.expr = args[0]
 	rw.windowSize = args[1]
+	if len(args) == 3 {
+		rw.nilPolicy = args[2]
+	}
 
 	return nil
 }
@@ -61,43 +179,80 @@ func (rw *RollingWindow) Evaluate(data JSONData) (result interface{}, err error)
 		return nil, err
 	}
 
-	wSize, err := rw.windowSize.Evaluate(data)
+	wSize, err := evaluateWindowSize(rw.windowSize, data, "RollingWindow expects a positive int window size")
 	if err != nil {
 		return nil, err
 	}
-	wSize, ok := wSize.(int)
-	if !ok {
-		return nil, fmt.Errorf("RollingWindow expects an int window size. Got a %T, %v", wSize, wSize)
+	value, push, err := nilPolicy(rw.nilPolicy, value, data)
+	if err != nil {
+		return nil, err
 	}
-	if value != nil {
-		err = rw.Push(value, wSize.(int))
+	if push {
+		err = rw.Push(value, wSize)
+	} else {
+		rw.skippedNils++
+		err = rw.trim(wSize)
 	}
 	return rw.windowList.Front(), err
 }
 
+// Push pushes element onto a window of wSize elements, unless the listener
+// refuses it, in which case the window is left as it was.
 func (rw *RollingWindow) Push(element interface{}, wSize int) (err error) {
-	rw.windowList.PushFront(element)
 	if rw.listener != nil {
-		err = rw.listener.Push(element)
-	}
-	if err != nil {
-		return
+		if err = rw.listener.Push(element); err != nil {
+			return err
+		}
 	}
+	rw.pushed++
+	rw.unrecomputed++
+	rw.windowList.PushFront(element)
+	return rw.trim(wSize)
+}
+
+// trim evicts the oldest elements until at most wSize remain. When the
+// window size shrinks, the excess is evicted immediately, with a Pop for each
+// element, even if nothing is being pushed. When it grows, the window simply
+// fills up with new elements.
+func (rw *RollingWindow) trim(wSize int) (err error) {
+	shrunk := wSize < rw.lastSize && rw.windowList.Len() > wSize
+	rw.lastSize = wSize
 	for rw.windowList.Len() > wSize {
 		lastElem := rw.windowList.Back()
 		rw.windowList.Remove(lastElem)
 		if rw.listener != nil {
-			err = rw.listener.Pop(lastElem.Value)
+			if popErr := rw.listener.Pop(lastElem.Value); popErr != nil && err == nil {
+				err = popErr
+			}
 		}
 	}
+	if rw.listener != nil && (shrunk || err != nil || recomputeDue(rw.unrecomputed, wSize)) {
+		rw.unrecomputed = 0
+		err = recompute(rw.listener, rw.values, err)
+	}
 	return
 }
 
+// values returns the window's elements, oldest first.
+func (rw *RollingWindow) values() []interface{} {
+	values := make([]interface{}, 0, rw.windowList.Len())
+	for elem := rw.windowList.Back(); elem != nil; elem = elem.Prev() {
+		values = append(values, elem.Value)
+	}
+	return values
+}
+
 type TimedWindow struct {
 	expr         Expression
 	windowList   list.List
 	windowLength Expression
+	nilPolicy    Expression
 	listener     WindowListener
+	pushed       int64
+	skippedNils  int64
+	// The length last trimmed to, and pushes since the listener recomputed.
+	lastLength   time.Duration
+	unrecomputed int
 }
 
 type timedWindowElement struct {
@@ -111,16 +266,26 @@ func (tw *TimedWindow) Len() int {
 	return tw.windowList.Len()
 }
 
+func (tw *TimedWindow) Stats() Stats {
+	return Stats{"pushed": tw.pushed, "skipped_nils": tw.skippedNils}
+}
+
 func (tw *TimedWindow) String() string {
+	if tw.nilPolicy != nil {
+		return fmt.Sprintf("TimedWindow(%v,%v,%v)", tw.expr, tw.windowLength, tw.nilPolicy)
+	}
 	return fmt.Sprintf("TimedWindow(%v,%v)", tw.expr, tw.windowLength)
 }
 
 func (tw *TimedWindow) Setup(fname string, args []Expression) (err error) {
-	if len(args) != 2 {
-		return fmt.Errorf("RollingWindow must have 2 args, the element and a positive int window size. Got %v", args)
+	if len(args) != 2 && len(args) != 3 {
+		return fmt.Errorf("TimedWindow must have 2 args, the element and a positive window length, in seconds or as a duration such as 5m, and optionally a nil policy. Got %v", args)
 	}
 	tw.expr = args[0]
 	tw.windowLength = args[1]
+	if len(args) == 3 {
+		tw.nilPolicy = args[2]
+	}
 
 	return nil
 }
@@ -135,61 +300,221 @@ func (tw *TimedWindow) Evaluate(data JSONData) (result interface{}, err error) {
 		return nil, err
 	}
 
-	wSize, err := tw.windowLength.Evaluate(data)
+	length, err := evaluateSeconds(tw.windowLength, data)
 	if err != nil {
 		return nil, err
 	}
-	wSize, ok := wSize.(int)
-	if !ok {
-		return nil, fmt.Errorf("RollingWindow expects an int (number of seconds) window size. Got a %T, %v", wSize, wSize)
+	value, push, err := nilPolicy(tw.nilPolicy, value, data)
+	if err != nil {
+		return nil, err
 	}
-	if value != nil {
-		err = tw.Push(value, wSize.(int))
+	if push {
+		err = tw.push(value, length)
+	} else {
+		tw.skippedNils++
+		err = tw.trim(length, time.Now())
 	}
 	return tw.windowList.Front(), err
 }
 
+// Push pushes element onto a window wSize seconds long, unless the listener
+// refuses it, in which case the window is left as it was.
 func (tw *TimedWindow) Push(element interface{}, wSize int) (err error) {
-	now := time.Now()
-	tw.windowList.PushFront(timedWindowElement{element, now})
+	return tw.push(element, time.Duration(wSize)*time.Second)
+}
+
+func (tw *TimedWindow) push(element interface{}, length time.Duration) (err error) {
 	if tw.listener != nil {
-		err = tw.listener.Push(element)
-	}
-	if err != nil {
-		return
+		if err = tw.listener.Push(element); err != nil {
+			return err
+		}
 	}
+	tw.pushed++
+	tw.unrecomputed++
+	now := time.Now()
+	tw.windowList.PushFront(timedWindowElement{element, now})
+	return tw.trim(length, now)
+}
 
-	// Now trim off any elements that occured before the beginning of the window.
-	windowStart := now.Add(time.Duration(-wSize))
+// trim evicts any elements that occured before the beginning of the window.
+// As with RollingWindow, a shrinking window is trimmed immediately and a
+// growing one fills up with new elements; elements already evicted aren't
+// brought back.
+func (tw *TimedWindow) trim(length time.Duration, now time.Time) (err error) {
+	windowStart := now.Add(-length)
+	shrunk := false
 	for {
 		backElem := tw.windowList.Back()
 		if backElem == nil {
-			return
+			break
 		}
 		backVal := backElem.Value.(timedWindowElement)
-		if backVal.timestamp.Before(windowStart) {
-			tw.windowList.Remove(backElem)
-			if tw.listener != nil {
-				err = tw.listener.Pop(backVal.value)
+		if !backVal.timestamp.Before(windowStart) {
+			break
+		}
+		tw.windowList.Remove(backElem)
+		shrunk = shrunk || length < tw.lastLength
+		if tw.listener != nil {
+			if popErr := tw.listener.Pop(backVal.value); popErr != nil && err == nil {
+				err = popErr
 			}
-		} else {
-			return
 		}
 	}
+	tw.lastLength = length
+	if tw.listener != nil && (shrunk || err != nil || recomputeDue(tw.unrecomputed, tw.windowList.Len())) {
+		tw.unrecomputed = 0
+		err = recompute(tw.listener, tw.values, err)
+	}
 	return
 }
 
+// values returns the window's elements, oldest first.
+func (tw *TimedWindow) values() []interface{} {
+	values := make([]interface{}, 0, tw.windowList.Len())
+	for elem := tw.windowList.Back(); elem != nil; elem = elem.Prev() {
+		values = append(values, elem.Value.(timedWindowElement).value)
+	}
+	return values
+}
+
+// windowState is a window's elements, oldest first, as Checkpoint saves
+// them, with the time each was pushed for a TimedWindow.
+type windowState struct {
+	Values []interface{} `json:"values"`
+	Times  []time.Time   `json:"times,omitempty"`
+}
+
+// A checkpointedWindow can save its elements and push them back into a fresh
+// window, through its listener, on Restore.
+type checkpointedWindow interface {
+	saveState() windowState
+	restoreState(state windowState) (err error)
+}
+
+var _ checkpointedWindow = new(RollingWindow)
+var _ checkpointedWindow = new(TimedWindow)
+
+func (rw *RollingWindow) saveState() windowState {
+	return windowState{Values: rw.values()}
+}
+
+// restoreState pushes the saved elements without trimming; the window is
+// trimmed to its size as usual on the next event.
+func (rw *RollingWindow) restoreState(state windowState) (err error) {
+	for _, value := range state.Values {
+		if rw.listener != nil {
+			if err = rw.listener.Push(value); err != nil {
+				return err
+			}
+		}
+		rw.windowList.PushFront(value)
+	}
+	return nil
+}
+
+func (tw *TimedWindow) saveState() windowState {
+	state := windowState{}
+	for elem := tw.windowList.Back(); elem != nil; elem = elem.Prev() {
+		element := elem.Value.(timedWindowElement)
+		state.Values = append(state.Values, element.value)
+		state.Times = append(state.Times, element.timestamp)
+	}
+	return state
+}
+
+// restoreState keeps each element's original push time, so elements that
+// have aged out while the engine was down are evicted on the next event.
+func (tw *TimedWindow) restoreState(state windowState) (err error) {
+	if len(state.Times) != len(state.Values) {
+		return fmt.Errorf("%w: TimedWindow state has %d values but %d times", ErrDecode, len(state.Values), len(state.Times))
+	}
+	for ndx, value := range state.Values {
+		if tw.listener != nil {
+			if err = tw.listener.Push(value); err != nil {
+				return err
+			}
+		}
+		tw.windowList.PushFront(timedWindowElement{value, state.Times[ndx]})
+	}
+	return nil
+}
+
+// A windowAggregate aggregates a single window, which Checkpoint saves.
+// WindowTrend isn't one: it fits values against the time its listener saw
+// them, which a restored window can't give back.
+type windowAggregate interface {
+	aggregatedWindow() Window
+}
+
+func (wa *WindowAve) aggregatedWindow() Window         { return wa.window }
+func (wc *WindowCorrelation) aggregatedWindow() Window { return wc.window }
+func (ws *WindowSample) aggregatedWindow() Window      { return ws.window }
+func (ws *WindowStats) aggregatedWindow() Window       { return ws.window }
+func (wp *WindowPercentile) aggregatedWindow() Window  { return wp.window }
+
+// What window aggregates return when their window doesn't have enough values
+// for a result, given as an optional last argument, e.g. WindowAve(w, "nil").
+const (
+	// EmptyError returns ErrWindowEmpty. This is the default.
+	EmptyError = "error"
+	// EmptyNil returns nil.
+	EmptyNil = "nil"
+	// EmptyNaN returns NaN. Note NaN can't be represented in JSON.
+	EmptyNaN = "nan"
+	// EmptyLast returns the aggregate's last result, or nil if it has none.
+	EmptyLast = "last"
+)
+
+// emptyWindow applies a window aggregate's empty-window policy.
+type emptyWindow struct {
+	policy Expression
+	last   interface{}
+}
+
+func (e *emptyWindow) apply(data JSONData, result interface{}, err error) (interface{}, error) {
+	if err == nil {
+		e.last = result
+		return result, nil
+	}
+	if !errors.Is(err, ErrWindowEmpty) {
+		return nil, err
+	}
+
+	name := interface{}(EmptyError)
+	if e.policy != nil {
+		var policyErr error
+		if name, policyErr = e.policy.Evaluate(data); policyErr != nil {
+			return nil, policyErr
+		}
+	}
+	switch name {
+	case EmptyError:
+		return nil, err
+	case EmptyNil:
+		return nil, nil
+	case EmptyNaN:
+		return math.NaN(), nil
+	case EmptyLast:
+		return e.last, nil
+	}
+	return nil, fmt.Errorf("%v is not an empty window policy, expected error, nil, nan or last", name)
+}
+
 type WindowAve struct {
 	window Window
-	sum    float64
+	sum    compensatedSum
+	empty  emptyWindow
 }
 
-var _ WindowListener = new(WindowAve)
+var _ Recomputer = new(WindowAve)
 
 func (wa *WindowAve) Setup(fname string, args []Expression) (err error) {
-	if len(args) != 1 {
+	if len(args) != 1 && len(args) != 2 {
 		return fmt.Errorf("WindowAve expects a single Window argument.")
 	}
+	if len(args) == 2 {
+		wa.empty.policy = args[1]
+	}
 	window, ok := args[0].(Window)
 	if !ok {
 		return fmt.Errorf("WindowAve expects a single Window argument.")
@@ -200,29 +525,534 @@ func (wa *WindowAve) Setup(fname string, args []Expression) (err error) {
 }
 
 func (wa *WindowAve) Evaluate(data JSONData) (result interface{}, err error) {
-	wa.window.Evaluate(data)
+	if _, err = wa.window.Evaluate(data); err != nil {
+		return nil, err
+	}
 	if wa.window.Len() == 0 {
-		return 0., fmt.Errorf("Empty window")
+		return wa.empty.apply(data, nil, fmt.Errorf("%w: Empty window", ErrWindowEmpty))
 	}
-	return wa.sum / float64(wa.window.Len()), nil
+	return wa.empty.apply(data, wa.sum.Value()/float64(wa.window.Len()), nil)
 }
 
 func (wa *WindowAve) Push(val interface{}) (err error) {
 	if val, ok := val.(float64); !ok {
-		return fmt.Errorf("Window expected a float64, got %v (%T)", val, val)
+		return fmt.Errorf("%w: Window expected a float64, got %v (%T)", ErrTypeMismatch, val, val)
 	}
-	wa.sum += val.(float64)
+	wa.sum.Add(val.(float64))
 	return nil
 }
 
 func (wa *WindowAve) Pop(val interface{}) (err error) {
 	if val, ok := val.(float64); !ok {
-		return fmt.Errorf("Window expected a float64, got %v (%T)", val, val)
+		return fmt.Errorf("%w: Window expected a float64, got %v (%T)", ErrTypeMismatch, val, val)
+	}
+	wa.sum.Add(-val.(float64))
+	return nil
+}
+
+func (wa *WindowAve) Recompute(values []interface{}) (err error) {
+	wa.sum = compensatedSum{}
+	for _, val := range values {
+		if err = wa.Push(val); err != nil {
+			return err
+		}
 	}
-	wa.sum -= val.(float64)
 	return nil
 }
 
 func (wa *WindowAve) String() string {
+	if wa.empty.policy != nil {
+		return fmt.Sprintf("WindowAve(%v,%v)", wa.window, wa.empty.policy)
+	}
 	return fmt.Sprintf("WindowAve(%v)", wa.window)
 }
+
+/*
+ * Pair(x, y) -> []interface{}
+ *
+ * Evaluates to [x, y], or nil if either is nil so windows skip the event. Used
+ * to push aligned samples of two expressions into a single window.
+ */
+type Pair struct {
+	x Expression
+	y Expression
+}
+
+func (p *Pair) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 {
+		return fmt.Errorf("Pair expects two expressions")
+	}
+	p.x, p.y = args[0], args[1]
+	return nil
+}
+
+func (p *Pair) Evaluate(data JSONData) (result interface{}, err error) {
+	x, err := p.x.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	y, err := p.y.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	if x == nil || y == nil {
+		return nil, nil
+	}
+	return []interface{}{x, y}, nil
+}
+
+func (p *Pair) String() string {
+	return fmt.Sprintf("Pair(%v,%v)", p.x, p.y)
+}
+
+/*
+ * WindowCorrelation(window of Pair(x, y) [, emptyPolicy]) -> float64
+ * WindowCovariance(window of Pair(x, y) [, emptyPolicy]) -> float64
+ *
+ * Pearson correlation coefficient, or sample covariance, of the pairs in the
+ * window, e.g. WindowCorrelation(RollingWindow(Pair(latency, queue_depth), 500)).
+ */
+type WindowCorrelation struct {
+	window Window
+	fname  string
+	n      int
+	sumX   float64
+	sumY   float64
+	sumXY  float64
+	sumXX  float64
+	sumYY  float64
+	empty  emptyWindow
+}
+
+var _ Recomputer = new(WindowCorrelation)
+
+func (wc *WindowCorrelation) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("%v expects a single Window argument.", fname)
+	}
+	if len(args) == 2 {
+		wc.empty.policy = args[1]
+	}
+	window, ok := args[0].(Window)
+	if !ok {
+		return fmt.Errorf("%v expects a single Window argument.", fname)
+	}
+	wc.window = window
+	wc.window.SetListener(wc)
+	wc.fname = fname
+	return
+}
+
+func (wc *WindowCorrelation) Evaluate(data JSONData) (result interface{}, err error) {
+	if _, err = wc.window.Evaluate(data); err != nil {
+		return nil, err
+	}
+	result, err = wc.correlate()
+	return wc.empty.apply(data, result, err)
+}
+
+func (wc *WindowCorrelation) correlate() (result interface{}, err error) {
+	if wc.n < 2 {
+		return nil, fmt.Errorf("%w: %v needs at least 2 samples, window has %d", ErrWindowEmpty, wc.fname, wc.n)
+	}
+	n := float64(wc.n)
+	covariance := (wc.sumXY - wc.sumX*wc.sumY/n) / (n - 1)
+	if wc.fname == "WindowCovariance" {
+		return covariance, nil
+	}
+
+	varianceX := (wc.sumXX - wc.sumX*wc.sumX/n) / (n - 1)
+	varianceY := (wc.sumYY - wc.sumY*wc.sumY/n) / (n - 1)
+	if varianceX <= 0 || varianceY <= 0 {
+		return nil, fmt.Errorf("WindowCorrelation is undefined when a series is constant")
+	}
+	return covariance / math.Sqrt(varianceX*varianceY), nil
+}
+
+func windowPair(val interface{}) (x, y float64, err error) {
+	pair, ok := val.([]interface{})
+	if ok && len(pair) == 2 {
+		x, xok := pair[0].(float64)
+		y, yok := pair[1].(float64)
+		if xok && yok {
+			return x, y, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("%w: Window expected a Pair of float64, got %v (%T)", ErrTypeMismatch, val, val)
+}
+
+func (wc *WindowCorrelation) Push(val interface{}) (err error) {
+	x, y, err := windowPair(val)
+	if err != nil {
+		return err
+	}
+	wc.n++
+	wc.sumX += x
+	wc.sumY += y
+	wc.sumXY += x * y
+	wc.sumXX += x * x
+	wc.sumYY += y * y
+	return nil
+}
+
+func (wc *WindowCorrelation) Pop(val interface{}) (err error) {
+	x, y, err := windowPair(val)
+	if err != nil {
+		return err
+	}
+	wc.n--
+	wc.sumX -= x
+	wc.sumY -= y
+	wc.sumXY -= x * y
+	wc.sumXX -= x * x
+	wc.sumYY -= y * y
+	return nil
+}
+
+func (wc *WindowCorrelation) Recompute(values []interface{}) (err error) {
+	wc.n, wc.sumX, wc.sumY, wc.sumXY, wc.sumXX, wc.sumYY = 0, 0, 0, 0, 0, 0
+	for _, val := range values {
+		if err = wc.Push(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (wc *WindowCorrelation) String() string {
+	if wc.empty.policy != nil {
+		return fmt.Sprintf("%v(%v,%v)", wc.fname, wc.window, wc.empty.policy)
+	}
+	return fmt.Sprintf("%v(%v)", wc.fname, wc.window)
+}
+
+/*
+ * WindowTrend(window [, emptyPolicy]) -> {"slope": float64, "intercept": float64}
+ *
+ * Least-squares line through the window's values against the time each was
+ * pushed. Slope is in units per second; intercept is the fitted value at the
+ * Unix epoch, so value(t) = intercept + slope * t for t in Unix seconds.
+ */
+type WindowTrend struct {
+	window     Window
+	origin     time.Time
+	pushTimes  list.List
+	n          int
+	sumT       float64
+	sumV       float64
+	sumTV      float64
+	sumTT      float64
+	timeSource func() time.Time
+	empty      emptyWindow
+}
+
+var _ WindowListener = new(WindowTrend)
+
+func (wt *WindowTrend) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("WindowTrend expects a single Window argument.")
+	}
+	if len(args) == 2 {
+		wt.empty.policy = args[1]
+	}
+	window, ok := args[0].(Window)
+	if !ok {
+		return fmt.Errorf("WindowTrend expects a single Window argument.")
+	}
+	wt.window = window
+	wt.window.SetListener(wt)
+	wt.pushTimes.Init()
+	if wt.timeSource == nil {
+		wt.timeSource = time.Now
+	}
+	return
+}
+
+func (wt *WindowTrend) Evaluate(data JSONData) (result interface{}, err error) {
+	if _, err = wt.window.Evaluate(data); err != nil {
+		return nil, err
+	}
+	result, err = wt.fit()
+	return wt.empty.apply(data, result, err)
+}
+
+func (wt *WindowTrend) fit() (result interface{}, err error) {
+	if wt.n < 2 {
+		return nil, fmt.Errorf("%w: WindowTrend needs at least 2 samples, window has %d", ErrWindowEmpty, wt.n)
+	}
+	n := float64(wt.n)
+	denominator := n*wt.sumTT - wt.sumT*wt.sumT
+	if denominator == 0 {
+		return nil, fmt.Errorf("WindowTrend is undefined when all samples have the same time")
+	}
+	slope := (n*wt.sumTV - wt.sumT*wt.sumV) / denominator
+	intercept := (wt.sumV - slope*wt.sumT) / n
+
+	// Times are relative to origin internally to keep the sums well conditioned.
+	originSeconds := float64(wt.origin.UnixNano()) / float64(time.Second)
+	return map[string]interface{}{
+		"slope":     slope,
+		"intercept": intercept - slope*originSeconds,
+	}, nil
+}
+
+func (wt *WindowTrend) Push(val interface{}) (err error) {
+	v, ok := val.(float64)
+	if !ok {
+		return fmt.Errorf("%w: Window expected a float64, got %v (%T)", ErrTypeMismatch, val, val)
+	}
+	now := wt.timeSource()
+	if wt.origin.IsZero() {
+		wt.origin = now
+	}
+	wt.pushTimes.PushBack(now)
+	t := now.Sub(wt.origin).Seconds()
+	wt.n++
+	wt.sumT += t
+	wt.sumV += v
+	wt.sumTV += t * v
+	wt.sumTT += t * t
+	return nil
+}
+
+func (wt *WindowTrend) Pop(val interface{}) (err error) {
+	v, ok := val.(float64)
+	if !ok {
+		return fmt.Errorf("%w: Window expected a float64, got %v (%T)", ErrTypeMismatch, val, val)
+	}
+	// Windows evict their oldest element first, so it's the oldest push time.
+	front := wt.pushTimes.Front()
+	if front == nil {
+		return fmt.Errorf("WindowTrend popped more values than were pushed")
+	}
+	wt.pushTimes.Remove(front)
+	t := front.Value.(time.Time).Sub(wt.origin).Seconds()
+	wt.n--
+	wt.sumT -= t
+	wt.sumV -= v
+	wt.sumTV -= t * v
+	wt.sumTT -= t * t
+	return nil
+}
+
+func (wt *WindowTrend) String() string {
+	if wt.empty.policy != nil {
+		return fmt.Sprintf("WindowTrend(%v,%v)", wt.window, wt.empty.policy)
+	}
+	return fmt.Sprintf("WindowTrend(%v)", wt.window)
+}
+
+type sampledElement struct {
+	value    interface{}
+	priority float64
+}
+
+/*
+ * WindowSample(window, k int) -> []interface{}
+ *
+ * A uniform random sample of up to k elements currently in the window, e.g. to
+ * attach exemplar events to an aggregate alert. Each element gets a random
+ * priority when pushed and the k lowest priorities are kept, so the sample
+ * stays stable as long as its elements remain in the window.
+ */
+type WindowSample struct {
+	window   Window
+	k        Expression
+	elements list.List
+	// Priorities for pushed elements; replaceable in tests.
+	random func() float64
+}
+
+var _ WindowListener = new(WindowSample)
+
+func (ws *WindowSample) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 2 {
+		return fmt.Errorf("WindowSample expects a Window and a positive int sample size.")
+	}
+	window, ok := args[0].(Window)
+	if !ok {
+		return fmt.Errorf("WindowSample expects a Window and a positive int sample size.")
+	}
+	ws.window = window
+	ws.window.SetListener(ws)
+	ws.k = args[1]
+	ws.elements.Init()
+	if ws.random == nil {
+		ws.random = rand.Float64
+	}
+	return
+}
+
+func (ws *WindowSample) Evaluate(data JSONData) (result interface{}, err error) {
+	k, err := evaluatePositiveInt(ws.k, data, "sample size")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = ws.window.Evaluate(data); err != nil {
+		return nil, err
+	}
+
+	// Find the priority of the kth lowest element, then take everything at or
+	// below it, preserving window order.
+	priorities := make([]float64, 0, ws.elements.Len())
+	for elem := ws.elements.Front(); elem != nil; elem = elem.Next() {
+		priorities = append(priorities, elem.Value.(sampledElement).priority)
+	}
+	sort.Float64s(priorities)
+	if len(priorities) > k {
+		priorities = priorities[:k]
+	}
+	sample := make([]interface{}, 0, len(priorities))
+	for elem := ws.elements.Front(); elem != nil && len(priorities) > 0; elem = elem.Next() {
+		element := elem.Value.(sampledElement)
+		if element.priority <= priorities[len(priorities)-1] && len(sample) < k {
+			sample = append(sample, element.value)
+		}
+	}
+	return sample, nil
+}
+
+func (ws *WindowSample) Push(val interface{}) (err error) {
+	ws.elements.PushBack(sampledElement{val, ws.random()})
+	return nil
+}
+
+func (ws *WindowSample) Pop(val interface{}) (err error) {
+	// Windows evict their oldest element first.
+	if front := ws.elements.Front(); front != nil {
+		ws.elements.Remove(front)
+	}
+	return nil
+}
+
+func (ws *WindowSample) String() string {
+	return fmt.Sprintf("WindowSample(%v,%v)", ws.window, ws.k)
+}
+
+/*
+ * WindowStats(window [, emptyPolicy]) -> {"count", "sum", "min", "max", "avg", "stddev"}
+ *
+ * Computes the common summary statistics of a window of float64 values in one
+ * listener. Min and max are kept in monotonic queues so they're updated in
+ * constant amortized time as elements are evicted. Stddev is the sample
+ * standard deviation, and nil for fewer than 2 elements.
+ *
+ * Sums are kept relative to the first value pushed into the empty window, so
+ * stddev stays accurate for values far from zero, such as timestamps.
+ */
+type WindowStats struct {
+	window Window
+	count  int
+	shift  float64
+	sum    compensatedSum
+	sumSq  compensatedSum
+	mins   list.List
+	maxes  list.List
+	empty  emptyWindow
+}
+
+var _ Recomputer = new(WindowStats)
+
+func (ws *WindowStats) Setup(fname string, args []Expression) (err error) {
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("WindowStats expects a single Window argument.")
+	}
+	window, ok := args[0].(Window)
+	if !ok {
+		return fmt.Errorf("WindowStats expects a single Window argument.")
+	}
+	if len(args) == 2 {
+		ws.empty.policy = args[1]
+	}
+	ws.window = window
+	ws.window.SetListener(ws)
+	ws.mins.Init()
+	ws.maxes.Init()
+	return
+}
+
+func (ws *WindowStats) Evaluate(data JSONData) (result interface{}, err error) {
+	if _, err = ws.window.Evaluate(data); err != nil {
+		return nil, err
+	}
+	if ws.count == 0 {
+		return ws.empty.apply(data, nil, fmt.Errorf("%w: Empty window", ErrWindowEmpty))
+	}
+
+	n := float64(ws.count)
+	sum, sumSq := ws.sum.Value(), ws.sumSq.Value()
+	var stddev interface{}
+	if ws.count > 1 {
+		stddev = math.Sqrt(math.Max(sumSq-sum*sum/n, 0) / (n - 1))
+	}
+	return ws.empty.apply(data, map[string]interface{}{
+		"count":  ws.count,
+		"sum":    ws.shift*n + sum,
+		"min":    ws.mins.Front().Value,
+		"max":    ws.maxes.Front().Value,
+		"avg":    ws.shift + sum/n,
+		"stddev": stddev,
+	}, nil)
+}
+
+// pushMonotonic adds v to the back of a queue kept in order so its front is
+// the extreme value, discarding elements that can never be the extreme again.
+func pushMonotonic(queue *list.List, v float64, before func(a, b float64) bool) {
+	for back := queue.Back(); back != nil && before(v, back.Value.(float64)); back = queue.Back() {
+		queue.Remove(back)
+	}
+	queue.PushBack(v)
+}
+
+func (ws *WindowStats) Push(val interface{}) (err error) {
+	v, ok := val.(float64)
+	if !ok {
+		return fmt.Errorf("%w: Window expected a float64, got %v (%T)", ErrTypeMismatch, val, val)
+	}
+	if ws.count == 0 {
+		ws.shift, ws.sum, ws.sumSq = v, compensatedSum{}, compensatedSum{}
+	}
+	ws.count++
+	ws.sum.Add(v - ws.shift)
+	ws.sumSq.Add((v - ws.shift) * (v - ws.shift))
+	pushMonotonic(&ws.mins, v, func(a, b float64) bool { return a < b })
+	pushMonotonic(&ws.maxes, v, func(a, b float64) bool { return a > b })
+	return nil
+}
+
+func (ws *WindowStats) Pop(val interface{}) (err error) {
+	v, ok := val.(float64)
+	if !ok {
+		return fmt.Errorf("%w: Window expected a float64, got %v (%T)", ErrTypeMismatch, val, val)
+	}
+	ws.count--
+	ws.sum.Add(-(v - ws.shift))
+	ws.sumSq.Add(-(v - ws.shift) * (v - ws.shift))
+	// The evicted element is the oldest, so it's only still queued if it's
+	// at the front.
+	for _, queue := range []*list.List{&ws.mins, &ws.maxes} {
+		if front := queue.Front(); front != nil && front.Value.(float64) == v {
+			queue.Remove(front)
+		}
+	}
+	return nil
+}
+
+func (ws *WindowStats) Recompute(values []interface{}) (err error) {
+	ws.count = 0
+	ws.mins.Init()
+	ws.maxes.Init()
+	for _, val := range values {
+		if err = ws.Push(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ws *WindowStats) String() string {
+	if ws.empty.policy != nil {
+		return fmt.Sprintf("WindowStats(%v,%v)", ws.window, ws.empty.policy)
+	}
+	return fmt.Sprintf("WindowStats(%v)", ws.window)
+}
@@ -0,0 +1,525 @@
+package oxweb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWindowCorrelation(t *testing.T) {
+	x, _ := NewGetDeepExpression("x")
+	y, _ := NewGetDeepExpression("y")
+	pair := new(Pair)
+	pair.Setup("Pair", []Expression{x, y})
+	window := new(RollingWindow)
+	window.Setup("RollingWindow", []Expression{pair, &Literal{3}})
+	correlation := new(WindowCorrelation)
+	if err := correlation.Setup("WindowCorrelation", []Expression{window}); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// The first sample is pushed out of the window, leaving y = -2x.
+	var result interface{}
+	var err error
+	for _, sample := range [][2]float64{{1, 10}, {1, -2}, {2, -4}, {3, -6}} {
+		result, err = correlation.Evaluate(map[string]interface{}{"x": sample[0], "y": sample[1]})
+	}
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if r := result.(float64); math.Abs(r+1) > 1e-9 {
+		t.Errorf("Expected correlation of -1, got %v", r)
+	}
+}
+
+func TestWindowTrend(t *testing.T) {
+	now := time.Unix(1000, 0)
+	value, _ := NewGetDeepExpression("v")
+	window := new(RollingWindow)
+	window.Setup("RollingWindow", []Expression{value, &Literal{10}})
+	trend := &WindowTrend{timeSource: func() time.Time { return now }}
+	if err := trend.Setup("WindowTrend", []Expression{window}); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	var result interface{}
+	var err error
+	for _, v := range []float64{5, 7, 9} {
+		result, err = trend.Evaluate(map[string]interface{}{"v": v})
+		now = now.Add(time.Second)
+	}
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	line := result.(map[string]interface{})
+	if slope := line["slope"].(float64); math.Abs(slope-2) > 1e-9 {
+		t.Errorf("Expected slope 2, got %v", slope)
+	}
+	if intercept := line["intercept"].(float64); math.Abs(intercept-(5-2*1000)) > 1e-6 {
+		t.Errorf("Expected intercept %v, got %v", 5-2*1000, intercept)
+	}
+}
+
+type windowSizeTest struct {
+	size interface{}
+	len  int
+	ok   bool
+}
+
+var windowSizeTests = []windowSizeTest{
+	windowSizeTest{2, 2, true},
+	windowSizeTest{int64(2), 2, true},
+	windowSizeTest{2., 2, true},
+	windowSizeTest{2.5, 0, false},
+	windowSizeTest{0, 0, false},
+	windowSizeTest{-1., 0, false},
+	windowSizeTest{"2", 0, false},
+}
+
+func TestWindowSize(t *testing.T) {
+	for _, test := range windowSizeTests {
+		window := new(RollingWindow)
+		window.Setup("RollingWindow", []Expression{&Literal{1.}, &Literal{test.size}})
+		var err error
+		for i := 0; i < 3; i++ {
+			_, err = window.Evaluate(nil)
+		}
+		if test.ok != (err == nil) {
+			t.Errorf("For size %v (%T), expected ok = %t, but err was %v", test.size, test.size, test.ok, err)
+		}
+		if window.Len() != test.len {
+			t.Errorf("For size %v (%T), expected %d elements, but was %d", test.size, test.size, test.len, window.Len())
+		}
+	}
+}
+
+// recordingListener keeps its own copy of the window contents from Push and
+// Pop notifications.
+type recordingListener struct {
+	values []interface{}
+}
+
+func (l *recordingListener) Push(element interface{}) (err error) {
+	l.values = append(l.values, element)
+	return nil
+}
+
+func (l *recordingListener) Pop(element interface{}) (err error) {
+	l.values = l.values[1:]
+	return nil
+}
+
+// refusingListener is a recordingListener that refuses strings.
+type refusingListener struct {
+	recordingListener
+}
+
+func (l *refusingListener) Push(element interface{}) (err error) {
+	if _, ok := element.(string); ok {
+		return fmt.Errorf("%w: refused %v", ErrTypeMismatch, element)
+	}
+	return l.recordingListener.Push(element)
+}
+
+func TestWindowRefusedPush(t *testing.T) {
+	for _, statement := range []string{"RollingWindow(v, 2)", "TimedWindow(v, 60)"} {
+		expr, err := Parse(statement)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		window := expr.(Window)
+		listener := new(refusingListener)
+		window.SetListener(listener)
+
+		for _, v := range []interface{}{1., "two", 3., 4.} {
+			_, err := window.Evaluate(map[string]interface{}{"v": v})
+			if _, refused := v.(string); refused != errors.Is(err, ErrTypeMismatch) {
+				t.Errorf("For %v, pushing %v, unexpected error %v", statement, v, err)
+			}
+			if len(listener.values) != window.Len() {
+				t.Errorf("For %v, after pushing %v, listener saw %v, but window has %d elements", statement, v, listener.values, window.Len())
+			}
+		}
+		if window.Stats()["pushed"] != 3 {
+			t.Errorf("For %v, expected the refused element not to count as pushed, got %v", statement, window.Stats())
+		}
+	}
+}
+
+// failingPopListener is a recordingListener whose Pops fail, but which can
+// recompute.
+type failingPopListener struct {
+	recordingListener
+	recomputes int
+}
+
+func (l *failingPopListener) Pop(element interface{}) (err error) {
+	return fmt.Errorf("lost track of %v", element)
+}
+
+func (l *failingPopListener) Recompute(values []interface{}) (err error) {
+	l.recomputes++
+	l.values = values
+	return nil
+}
+
+func TestWindowRecompute(t *testing.T) {
+	defer func(every int) { recomputeEvery = every }(recomputeEvery)
+	recomputeEvery = 2
+
+	// Drift: 1e20 + 1 - 1e20 is 0 in a running sum.
+	ave, _ := Parse("WindowAve(RollingWindow(v, 1))")
+	var result interface{}
+	for _, v := range []float64{1e20, 1} {
+		result, _ = ave.Evaluate(map[string]interface{}{"v": v})
+	}
+	if result != 1. {
+		t.Errorf("Expected a recompute to correct the average to 1, got %v", result)
+	}
+
+	// A failed Pop is put right by recomputing.
+	window, _ := Parse("RollingWindow(v, 1)")
+	listener := new(failingPopListener)
+	window.(Window).SetListener(listener)
+	for _, v := range []float64{1, 2} {
+		if _, err := window.Evaluate(map[string]interface{}{"v": v}); err != nil {
+			t.Errorf("Expected the recompute to recover from the failed Pop, got %v", err)
+		}
+	}
+	if len(listener.values) != 1 || listener.values[0] != 2. {
+		t.Errorf("Expected the listener to see [2], got %v", listener.values)
+	}
+}
+
+func TestWindowRecomputeOnShrink(t *testing.T) {
+	ave, _ := Parse("WindowAve(RollingWindow(v, size))")
+	var result interface{}
+	var err error
+	for _, event := range []map[string]interface{}{
+		{"v": 1e20, "size": 3.},
+		{"v": 1., "size": 3.},
+		{"v": 2., "size": 3.},
+		{"v": 3., "size": 1.},
+	} {
+		result, err = ave.Evaluate(event)
+	}
+	if err != nil || result != 3. {
+		t.Errorf("Expected the shrunk window to average 3, got %v, %v", result, err)
+	}
+}
+
+func TestRollingWindowResize(t *testing.T) {
+	value, _ := NewGetDeepExpression("v")
+	size, _ := NewGetDeepExpression("size")
+	window := new(RollingWindow)
+	window.Setup("RollingWindow", []Expression{value, size})
+	listener := new(recordingListener)
+	window.SetListener(listener)
+
+	steps := []struct {
+		value interface{}
+		size  float64
+		len   int
+	}{
+		{1., 3, 1},
+		{2., 3, 2},
+		{3., 3, 3},
+		{4., 3, 3},
+		{nil, 1, 1}, // shrinks immediately, even without a push
+		{5., 1, 1},
+		{6., 4, 2}, // grows lazily
+		{7., 4, 3},
+	}
+	for ndx, step := range steps {
+		if _, err := window.Evaluate(map[string]interface{}{"v": step.value, "size": step.size}); err != nil {
+			t.Fatalf("Step %d: Evaluate failed: %v", ndx, err)
+		}
+		if window.Len() != step.len {
+			t.Errorf("Step %d: expected %d elements, but was %d", ndx, step.len, window.Len())
+		}
+		if len(listener.values) != window.Len() {
+			t.Errorf("Step %d: listener saw %v, but window has %d elements", ndx, listener.values, window.Len())
+		}
+	}
+	if listener.values[0] != 5. {
+		t.Errorf("Expected oldest element to be 5, listener saw %v", listener.values)
+	}
+}
+
+func TestTimedWindowExpires(t *testing.T) {
+	value, _ := NewGetDeepExpression("v")
+	window := new(TimedWindow)
+	window.Setup("TimedWindow", []Expression{value, &Literal{60}})
+	listener := new(recordingListener)
+	window.SetListener(listener)
+
+	window.Evaluate(map[string]interface{}{"v": 1.})
+	window.windowList.Front().Value = timedWindowElement{1., time.Now().Add(-2 * time.Minute)}
+
+	// An expired element is evicted even when nothing new is pushed.
+	if _, err := window.Evaluate(map[string]interface{}{}); err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if window.Len() != 0 || len(listener.values) != 0 {
+		t.Errorf("Expected the window to be empty, has %d elements, listener saw %v", window.Len(), listener.values)
+	}
+}
+
+func TestTimedWindowDuration(t *testing.T) {
+	expr, err := Parse("TimedWindow(v, 250ms)")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	window := expr.(*TimedWindow)
+
+	window.Evaluate(map[string]interface{}{"v": 1.})
+	window.windowList.Front().Value = timedWindowElement{1., time.Now().Add(-time.Second)}
+	window.Evaluate(map[string]interface{}{"v": 2.})
+	if window.Len() != 1 {
+		t.Errorf("Expected elements older than 250ms to be evicted, window has %d elements", window.Len())
+	}
+}
+
+func TestWindowStats(t *testing.T) {
+	value, _ := NewGetDeepExpression("v")
+	window := new(RollingWindow)
+	window.Setup("RollingWindow", []Expression{value, &Literal{3}})
+	stats := new(WindowStats)
+	if err := stats.Setup("WindowStats", []Expression{window}); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	var result interface{}
+	var err error
+	for _, v := range []float64{9, 1, 5, 3, 4} {
+		result, err = stats.Evaluate(map[string]interface{}{"v": v})
+	}
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+
+	// The window holds 5, 3 and 4.
+	summary := result.(map[string]interface{})
+	expected := map[string]interface{}{"count": 3, "sum": 12., "min": 3., "max": 5., "avg": 4., "stddev": 1.}
+	for key, value := range expected {
+		if summary[key] != value {
+			t.Errorf("Expected %v = %v, but was %v", key, value, summary[key])
+		}
+	}
+}
+
+func TestWindowAveBoundedError(t *testing.T) {
+	defer func(every int) { recomputeEvery = every }(recomputeEvery)
+	recomputeEvery = math.MaxInt
+
+	value, _ := NewGetDeepExpression("v")
+	window := new(RollingWindow)
+	window.Setup("RollingWindow", []Expression{value, &Literal{10}})
+	ave := new(WindowAve)
+	ave.Setup("WindowAve", []Expression{window})
+
+	r := rand.New(rand.NewSource(1))
+	values := make([]float64, 1000000)
+	for ndx := range values {
+		values[ndx] = 1e6 + r.Float64()*1000
+		window.Push(values[ndx], 10)
+	}
+	var exact float64
+	for _, v := range values[len(values)-10:] {
+		exact += v
+	}
+	// A plain running sum is off by about 2e-7 by now.
+	if drift := math.Abs(ave.sum.Value() - exact); drift > 1e-8 {
+		t.Errorf("Expected the sum to stay within a rounding error, drifted by %v", drift)
+	}
+}
+
+func TestWindowStatsLargeValues(t *testing.T) {
+	expr, _ := Parse("WindowStats(RollingWindow(v, 3))")
+	var result interface{}
+	for _, v := range []float64{1e9, 1e9 + 1, 1e9 + 2, 1e9 + 3} {
+		result, _ = expr.Evaluate(map[string]interface{}{"v": v})
+	}
+	stats := result.(map[string]interface{})
+	if stats["stddev"] != 1. || stats["avg"] != 1e9+2 || stats["sum"] != 3e9+6 {
+		t.Errorf("Unexpected stats %v", stats)
+	}
+}
+
+type windowSampleTest struct {
+	values     []float64
+	priorities []float64
+	k          int
+	expected   []interface{}
+}
+
+var windowSampleTests = []windowSampleTest{
+	// Fewer elements than k are all kept.
+	windowSampleTest{[]float64{1, 2}, []float64{0.5, 0.1}, 3, []interface{}{1., 2.}},
+	// The k lowest priorities are kept, in window order.
+	windowSampleTest{[]float64{1, 2, 3, 4}, []float64{0.9, 0.2, 0.5, 0.1}, 2, []interface{}{2., 4.}},
+	windowSampleTest{[]float64{1, 2, 3, 4}, []float64{0.1, 0.2, 0.3, 0.4}, 3, []interface{}{1., 2., 3.}},
+	// Evicted elements leave the sample; the window holds the last 4.
+	windowSampleTest{[]float64{1, 2, 3, 4, 5, 6}, []float64{0.1, 0.2, 0.9, 0.8, 0.7, 0.6}, 2, []interface{}{5., 6.}},
+	// Equal priorities don't push the sample past k.
+	windowSampleTest{[]float64{1, 2, 3}, []float64{0.5, 0.5, 0.5}, 2, []interface{}{1., 2.}},
+}
+
+func TestWindowSample(t *testing.T) {
+	for _, test := range windowSampleTests {
+		window, err := Parse("RollingWindow(v, 4)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sample := &WindowSample{random: func() float64 { return 0 }}
+		if err = sample.Setup("WindowSample", []Expression{window, &Literal{test.k}}); err != nil {
+			t.Fatal(err)
+		}
+		var result interface{}
+		for ndx, value := range test.values {
+			priority := test.priorities[ndx]
+			sample.random = func() float64 { return priority }
+			if result, err = sample.Evaluate(map[string]interface{}{"v": value}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("For %v with priorities %v, expected %v, got %v", test.values, test.priorities, test.expected, result)
+		}
+	}
+
+	expr, _ := Parse("WindowSample(RollingWindow(v, 4), 0)")
+	if _, err := expr.Evaluate(map[string]interface{}{"v": 1.}); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected a type mismatch for a zero sample size, got %v", err)
+	}
+}
+
+type nilPolicyTest struct {
+	policy string
+	values []interface{}
+	// The window's contents, newest first, and its Stats.
+	contents []interface{}
+	stats    Stats
+	ok       bool
+}
+
+var nilPolicyTests = []nilPolicyTest{
+	nilPolicyTest{"", []interface{}{1., nil, 2.}, []interface{}{2., 1.}, Stats{"pushed": 2, "skipped_nils": 1}, true},
+	nilPolicyTest{NilSkip, []interface{}{nil, nil}, []interface{}{}, Stats{"pushed": 0, "skipped_nils": 2}, true},
+	nilPolicyTest{NilZero, []interface{}{1., nil, 2.}, []interface{}{2., 0., 1.}, Stats{"pushed": 3, "skipped_nils": 0}, true},
+	nilPolicyTest{NilError, []interface{}{1., nil}, []interface{}{1.}, Stats{"pushed": 1, "skipped_nils": 0}, false},
+	nilPolicyTest{"drop", []interface{}{nil}, []interface{}{}, Stats{"pushed": 0, "skipped_nils": 0}, false},
+	// A bad policy is reported whether or not there are nils.
+	nilPolicyTest{"drop", []interface{}{1.}, []interface{}{}, Stats{"pushed": 0, "skipped_nils": 0}, false},
+}
+
+func TestWindowNilPolicy(t *testing.T) {
+	for _, kind := range []string{"RollingWindow(v, 10", "TimedWindow(v, 60"} {
+		for _, test := range nilPolicyTests {
+			statement := kind + ")"
+			if test.policy != "" {
+				statement = fmt.Sprintf("%v, %q)", kind, test.policy)
+			}
+			expr, err := Parse(statement)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var lastErr error
+			for _, value := range test.values {
+				if _, err := expr.Evaluate(map[string]interface{}{"v": value}); err != nil {
+					lastErr = err
+				}
+			}
+			if test.ok != (lastErr == nil) {
+				t.Errorf("For %v of %v, expected ok = %t, but err was %v", statement, test.values, test.ok, lastErr)
+			}
+			if test.policy == NilError && !errors.Is(lastErr, ErrTypeMismatch) {
+				t.Errorf("For %v, expected a type mismatch, got %v", statement, lastErr)
+			}
+
+			window := expr.(Window)
+			contents := []interface{}{}
+			switch window := window.(type) {
+			case *RollingWindow:
+				for elem := window.windowList.Front(); elem != nil; elem = elem.Next() {
+					contents = append(contents, elem.Value)
+				}
+			case *TimedWindow:
+				for elem := window.windowList.Front(); elem != nil; elem = elem.Next() {
+					contents = append(contents, elem.Value.(timedWindowElement).value)
+				}
+			}
+			if !reflect.DeepEqual(contents, test.contents) {
+				t.Errorf("For %v of %v, expected the window to hold %v, got %v", statement, test.values, test.contents, contents)
+			}
+			if stats := window.Stats(); !reflect.DeepEqual(stats, test.stats) {
+				t.Errorf("For %v of %v, expected stats %v, got %v", statement, test.values, test.stats, stats)
+			}
+		}
+	}
+}
+
+type emptyWindowTest struct {
+	statement string
+	values    []interface{}
+	// What the aggregate gives once its window has emptied.
+	expected interface{}
+	err      error
+}
+
+var emptyWindowTests = []emptyWindowTest{
+	emptyWindowTest{"WindowAve(%v)", []interface{}{1., 3.}, nil, ErrWindowEmpty},
+	emptyWindowTest{`WindowAve(%v, "error")`, []interface{}{1., 3.}, nil, ErrWindowEmpty},
+	emptyWindowTest{`WindowAve(%v, "nil")`, []interface{}{1., 3.}, nil, nil},
+	emptyWindowTest{`WindowAve(%v, "nan")`, []interface{}{1., 3.}, math.NaN(), nil},
+	emptyWindowTest{`WindowAve(%v, "last")`, []interface{}{1., 3.}, 2., nil},
+	// With no last result, "last" is nil.
+	emptyWindowTest{`WindowAve(%v, "last")`, []interface{}{}, nil, nil},
+	emptyWindowTest{"WindowPercentile(%v, 50)", []interface{}{2.}, nil, ErrWindowEmpty},
+	emptyWindowTest{`WindowPercentile(%v, 50, "hdr", "nil")`, []interface{}{2.}, nil, nil},
+	emptyWindowTest{`WindowPercentile(%v, 50, "exact", "last")`, []interface{}{2.}, 2., nil},
+	emptyWindowTest{"WindowStats(%v)", []interface{}{2.}, nil, ErrWindowEmpty},
+	emptyWindowTest{`WindowStats(%v, "nil")`, []interface{}{2.}, nil, nil},
+}
+
+func TestEmptyWindowPolicy(t *testing.T) {
+	for _, test := range emptyWindowTests {
+		statement := fmt.Sprintf(test.statement, "TimedWindow(v, 60)")
+		expr, err := Parse(statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, value := range test.values {
+			if _, err := expr.Evaluate(map[string]interface{}{"v": value}); err != nil {
+				t.Fatalf("%v: %v", statement, err)
+			}
+		}
+		// Age everything out of the window.
+		window := expr.(windowAggregate).aggregatedWindow().(*TimedWindow)
+		for elem := window.windowList.Front(); elem != nil; elem = elem.Next() {
+			elem.Value = timedWindowElement{elem.Value.(timedWindowElement).value, time.Now().Add(-time.Hour)}
+		}
+
+		result, err := expr.Evaluate(map[string]interface{}{"v": nil})
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v: expected error %v, got %v", statement, test.err, err)
+		}
+		if expected, ok := test.expected.(float64); ok && math.IsNaN(expected) {
+			if result, ok := result.(float64); !ok || !math.IsNaN(result) {
+				t.Errorf("%v: expected NaN, got %v", statement, result)
+			}
+		} else if result != test.expected {
+			t.Errorf("%v: expected %v, got %v", statement, test.expected, result)
+		}
+	}
+
+	expr, _ := Parse(`WindowAve(RollingWindow(v, 2), "zero")`)
+	if _, err := expr.Evaluate(map[string]interface{}{"v": nil}); err == nil || !strings.Contains(err.Error(), "not an empty window policy") {
+		t.Errorf("Expected a bad policy reported, got %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+// Package oxwebclient runs queries against an oxweb server over its line
+// delimited JSON protocol: the client sends one query object, and the server
+// answers with a record per line until either side hangs up.
+package oxwebclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// DefaultAddr is the server's TCP query port.
+const DefaultAddr = "127.0.0.1:3535"
+
+// Query describes a query to run. Only LogName and Fields are required.
+type Query struct {
+	LogName string   `json:"logName"`
+	Fields  []string `json:"fields"`
+	Filters []string `json:"filters"`
+
+	// Name, if set, keeps the query's results in the server's result store.
+	Name string `json:"name,omitempty"`
+	// OnError is one of "emit", "skip" or "abort".
+	OnError      string           `json:"onError,omitempty"`
+	Explode      string           `json:"explode,omitempty"`
+	EmitOnChange *ChangeThreshold `json:"emitOnChange,omitempty"`
+	Backfill     string           `json:"backfill,omitempty"`
+	Webhook      string           `json:"webhook,omitempty"`
+	// TCPSink is the address of a service to also write each record to, as
+	// a line of JSON.
+	TCPSink string `json:"tcpSink,omitempty"`
+	// Labels, such as owner or team, are attached to the query's logs and
+	// to records sent to its sinks.
+	Labels map[string]string `json:"labels,omitempty"`
+	// PropagateNulls evaluates arithmetic on missing fields to nil rather
+	// than failing.
+	PropagateNulls bool `json:"propagateNulls,omitempty"`
+	// NumberFormat controls how numbers in records are written.
+	NumberFormat *NumberFormat `json:"numberFormat,omitempty"`
+}
+
+type ChangeThreshold struct {
+	Absolute float64 `json:"absolute,omitempty"`
+	Relative float64 `json:"relative,omitempty"`
+}
+
+// NumberFormat mirrors the server's number formatting options: Notation is
+// "fixed", "scientific" or "" for the shorter; Precision is digits after the
+// decimal point in those notations, or significant digits otherwise; and
+// Integers writes whole numbers as integers.
+type NumberFormat struct {
+	Notation  string `json:"notation,omitempty"`
+	Precision *int   `json:"precision,omitempty"`
+	Integers  bool   `json:"integers,omitempty"`
+}
+
+// Field is one named value of a Record.
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// A Record is one emission of a query, with a Field per query field in order.
+type Record []Field
+
+// Get returns the value of the named field.
+func (r Record) Get(name string) (value interface{}, ok bool) {
+	for _, field := range r {
+		if field.Name == name {
+			return field.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (r *Record) UnmarshalJSON(data []byte) (err error) {
+	var pairs [][2]interface{}
+	if err := json.Unmarshal(data, &pairs); err != nil {
+		return err
+	}
+	*r = make(Record, 0, len(pairs))
+	for _, pair := range pairs {
+		name, ok := pair[0].(string)
+		if !ok {
+			return fmt.Errorf("Expected a field name, got %v", pair[0])
+		}
+		*r = append(*r, Field{name, pair[1]})
+	}
+	return nil
+}
+
+// A ServerError is an error the server sent in place of a record, such as
+// for a query that doesn't parse. The subscription ends with it.
+type ServerError struct {
+	// Class is the kind of error: "parse error", "type mismatch", "decode
+	// error" or just "error".
+	Class   string `json:"error"`
+	Message string `json:"message"`
+	// Line is the line of the client's the error is about, if any.
+	Line int64 `json:"line,omitempty"`
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("Server %v: %v", e.Class, e.Message)
+}
+
+type Client struct {
+	Addr   string
+	Dialer net.Dialer
+}
+
+func New(addr string) *Client {
+	return &Client{Addr: addr}
+}
+
+// A Subscription delivers a running query's records on Results, which is
+// closed when the query ends. Err then reports why: a *ServerError if the
+// server rejected or aborted the query, or nil if it was Closed.
+type Subscription struct {
+	Results <-chan Record
+
+	conn      net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+	lock      sync.Mutex
+	err       error
+}
+
+// Subscribe starts query on the server. Cancelling ctx ends it like Close.
+func (c *Client) Subscribe(ctx context.Context, query *Query) (sub *Subscription, err error) {
+	conn, err := c.Dialer.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(query)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := conn.Write(append(encoded, '\n')); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	results := make(chan Record, 16)
+	sub = &Subscription{Results: results, conn: conn, closed: make(chan struct{})}
+	go sub.read(results)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.Close()
+		case <-sub.closed:
+		}
+	}()
+	return sub, nil
+}
+
+func (s *Subscription) read(results chan<- Record) {
+	defer close(results)
+	defer s.Close()
+
+	scanner := bufio.NewScanner(s.conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if bytes.HasPrefix(line, []byte("{")) {
+			serverErr := new(ServerError)
+			if err := json.Unmarshal(line, serverErr); err != nil || serverErr.Class == "" {
+				s.setErr(fmt.Errorf("Bad record from server: %s", line))
+			} else {
+				s.setErr(serverErr)
+			}
+			return
+		}
+
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			s.setErr(fmt.Errorf("Bad record from server: %w", err))
+			return
+		}
+		select {
+		case results <- record:
+		case <-s.closed:
+			return
+		}
+	}
+	s.setErr(scanner.Err())
+}
+
+func (s *Subscription) setErr(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	select {
+	case <-s.closed:
+		// Errors caused by Close aren't interesting.
+		return
+	default:
+	}
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// Err reports why the subscription ended, once Results is closed.
+func (s *Subscription) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// Close ends the query.
+func (s *Subscription) Close() (err error) {
+	s.closeOnce.Do(func() {
+		s.lock.Lock()
+		close(s.closed)
+		s.lock.Unlock()
+		err = s.conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,91 @@
+package oxwebclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan map[string]interface{}, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var query map[string]interface{}
+		line, _ := bufio.NewReader(conn).ReadBytes('\n')
+		json.Unmarshal(line, &query)
+		received <- query
+		conn.Write([]byte(`[["host", "web1"], ["count", 3]]` + "\n"))
+		conn.Write([]byte(`[["host", "web2"], ["count", 4]]` + "\n"))
+	}()
+
+	sub, err := New(listener.Addr().String()).Subscribe(context.Background(), &Query{
+		LogName: "ranger",
+		Fields:  []string{"host", "count"},
+		OnError: "skip",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := []interface{}{}
+	for record := range sub.Results {
+		count, _ := record.Get("count")
+		counts = append(counts, count)
+	}
+	if len(counts) != 2 || counts[0] != 3. || counts[1] != 4. {
+		t.Errorf("Expected counts 3 and 4, got %v", counts)
+	}
+	if err := sub.Err(); err != nil {
+		t.Errorf("Expected a clean end, got %v", err)
+	}
+
+	query := <-received
+	if query["logName"] != "ranger" || query["onError"] != "skip" || query["name"] != nil {
+		t.Errorf("Unexpected query sent %v", query)
+	}
+}
+
+func TestSubscribeServerError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bufio.NewReader(conn).ReadBytes('\n')
+		conn.Write([]byte(`[["host", "web1"]]` + "\n"))
+		conn.Write([]byte(`{"error": "parse error", "message": "parse error: Unknown function Bogus"}` + "\n"))
+	}()
+
+	sub, err := New(listener.Addr().String()).Subscribe(context.Background(), &Query{LogName: "ranger", Fields: []string{"host"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := 0
+	for range sub.Results {
+		records++
+	}
+	var serverErr *ServerError
+	if records != 1 || !errors.As(sub.Err(), &serverErr) || serverErr.Class != "parse error" {
+		t.Errorf("Expected 1 record then a parse error, got %d and %v", records, sub.Err())
+	}
+}